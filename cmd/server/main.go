@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/dnsoftware/go-metrics/internal/server/app"
 )
@@ -17,6 +18,19 @@ func main() {
 	fmt.Printf("Build date: %s\n", buildDate)
 	fmt.Printf("Build commit: %s\n", buildCommit)
 
+	// подкоманда migrate управляет схемой БД, флаги после нее - как у сервера
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+
+		err := app.MigrateRun()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	err := app.ServerRun()
 	if err != nil {
 		panic(err)
@@ -18,6 +18,7 @@ type JSONConfig struct {
 	AsymCryptoKey     string `json:"crypto_key"`
 	GrpcAddress       string `json:"grpc_address"`
 	ServerAPI         string `json:"server_api"` // по какому протоколу клиент будет общаться с сервером (http || grpc) (флаг запуска -server-api, переменная окружения SERVER_API)
+	Labels            string `json:"labels"`     // метки, добавляемые ко всем метрикам агента (флаг запуска -labels, переменная окружения LABELS)
 }
 
 func newJSONConfig(configFile string) (*JSONConfig, error) {
@@ -96,6 +97,8 @@ func consolidateConfig(jsonConf *JSONConfig, cfg Config, flags AgentFlags, cfgEn
 			cfg.ServerAPI = constants.ServerAPI
 		}
 
+		cfg.Labels = jsonConf.Labels
+
 		if flags.flagRunAddr == "" {
 			flags.flagRunAddr = cfg.RunAddr
 		}
@@ -114,6 +117,9 @@ func consolidateConfig(jsonConf *JSONConfig, cfg Config, flags AgentFlags, cfgEn
 		if flags.flagServerAPI == "" {
 			flags.flagServerAPI = cfg.ServerAPI
 		}
+		if flags.flagLabels == "" {
+			flags.flagLabels = cfg.Labels
+		}
 
 	} else {
 		if flags.flagRunAddr == "" {
@@ -169,5 +175,9 @@ func consolidateConfig(jsonConf *JSONConfig, cfg Config, flags AgentFlags, cfgEn
 		flags.flagServerAPI = cfgEnv.ServerAPI
 	}
 
+	if cfgEnv.Labels != "" {
+		flags.flagLabels = cfgEnv.Labels
+	}
+
 	return flags
 }
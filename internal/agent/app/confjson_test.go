@@ -118,6 +118,10 @@ func TestConsolidate(t *testing.T) {
 	newFlags = consolidateConfig(jsonConf, cfg, flags, cfgEnv)
 	assert.Equal(t, "grpc", newFlags.flagServerAPI)
 
+	jsonConf.Labels = "host=web-1"
+	newFlags = consolidateConfig(jsonConf, cfg, flags, cfgEnv)
+	assert.Equal(t, map[string]string{"host": "web-1"}, newFlags.Labels())
+
 	jsonConf = nil
 	flags.flagGrpcAddress = ""
 	newFlags = consolidateConfig(jsonConf, cfg, flags, cfgEnv)
@@ -136,6 +140,7 @@ func TestConsolidate(t *testing.T) {
 		AsymPubKeyPath: "/path",
 		GrpcAddress:    ":8090",
 		ServerAPI:      "grpc",
+		Labels:         "env=prod",
 	}
 
 	newFlags = consolidateConfig(jsonConf, cfg, flags, cfgEnv)
@@ -147,5 +152,6 @@ func TestConsolidate(t *testing.T) {
 	assert.Equal(t, "/path", newFlags.flagAsymPubKeyPath)
 	assert.Equal(t, ":8090", newFlags.flagGrpcAddress)
 	assert.Equal(t, "grpc", newFlags.flagServerAPI)
+	assert.Equal(t, map[string]string{"env": "prod"}, newFlags.Labels())
 
 }
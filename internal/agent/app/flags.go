@@ -6,6 +6,7 @@ import (
 
 	"github.com/caarlos0/env/v6"
 	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
 type AgentFlags struct {
@@ -17,6 +18,7 @@ type AgentFlags struct {
 	flagAsymPubKeyPath string // путь к файлу с публичным асимметричным ключом
 	flagGrpcAddress    string // адрес:порт на котором работает gRPC сервер
 	flagServerAPI      string // по какому протоколу клиент будет общаться с сервером (http || grpc) (флаг запуска -server-api, переменная окружения SERVER_API)
+	flagLabels         string // метки, добавляемые ко всем метрикам агента, вида "host=web-1,env=prod"
 }
 
 type Config struct {
@@ -28,6 +30,7 @@ type Config struct {
 	AsymPubKeyPath string `env:"CRYPTO_KEY"`   // путь к файлу с публичным асимметричным ключом
 	GrpcAddress    string `env:"GRPC_ADDRESS"` // адрес:порт на котором работает gRPC сервер
 	ServerAPI      string `env:"SERVER_API"`   // "http" или "grpc"
+	Labels         string `env:"LABELS"`       // метки, добавляемые ко всем метрикам агента
 }
 
 // NewAgentFlags обрабатывает аргументы командной строки
@@ -60,6 +63,7 @@ func NewAgentFlags() AgentFlags {
 	flag.StringVar(&flags.flagAsymPubKeyPath, "crypto-key", "", "asymmetric crypto key")
 	flag.StringVar(&flags.flagGrpcAddress, "g", constants.GRPCDefault, "grpc address")
 	flag.StringVar(&flags.flagServerAPI, "server-api", constants.ServerAPI, "server protocol")
+	flag.StringVar(&flags.flagLabels, "labels", "", "labels added to all metrics (host=web-1,env=prod)")
 
 	flag.Parse()
 
@@ -72,6 +76,11 @@ func NewAgentFlags() AgentFlags {
 	// объединение конфигураций json, флаги, константы, переменные окружения
 	flags = consolidateConfig(jsonConf, cfg, flags, cfgEnv)
 
+	_, err = storage.ParseLabels(flags.flagLabels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	return flags
 }
 
@@ -102,3 +111,10 @@ func (f *AgentFlags) AsymPubKeyPath() string {
 func (f *AgentFlags) GrpcRunAddr() string {
 	return f.flagGrpcAddress
 }
+
+// Labels метки, добавляемые ко всем метрикам агента
+func (f *AgentFlags) Labels() map[string]string {
+	labels, _ := storage.ParseLabels(f.flagLabels)
+
+	return labels
+}
@@ -97,6 +97,10 @@ func (f *fl) RateLimit() int {
 	return 10
 }
 
+func (f *fl) Labels() map[string]string {
+	return nil
+}
+
 func (f *fl) AsymPubKeyPath() string {
 	return ""
 }
@@ -23,6 +23,7 @@ import (
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
 	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
 // AgentStorage интерфейс хранилаща для агента.
@@ -59,6 +60,7 @@ type Flags interface {
 	ReportInterval() int64
 	PollInterval() int64
 	RateLimit() int
+	Labels() map[string]string
 }
 
 // Metrics основная структура агента. Получение, промежуточное сохранение, отправка данных на сервер.
@@ -71,15 +73,19 @@ type Metrics struct {
 	gopcMetricsList []string
 	rateLimit       int
 	mutex           sync.Mutex
-	messageWriter   io.Writer // для вывода сообщения в консоль (и для тестирования)
+	messageWriter   io.Writer      // для вывода сообщения в консоль (и для тестирования)
+	labels          storage.Labels // метки, добавляемые ко всем отправляемым пакетом метрикам
 }
 
 // MetricsItem структура для отправки json данных на сервер
 type MetricsItem struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string             `json:"id"`                  // имя метрики
+	MType     string             `json:"type"`                // параметр, принимающий значение gauge, counter, histogram или summary
+	Labels    storage.Labels     `json:"labels,omitempty"`    // метки, вместе с именем определяют серию
+	Delta     *int64             `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64           `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *storage.Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *storage.Summary   `json:"summary,omitempty"`   // скетч в случае передачи summary
 }
 
 // gaugeMetricsList названия всех доступных gauge метрик
@@ -102,6 +108,7 @@ func NewMetrics(storage AgentStorage, sender MetricsSender, flags Flags) Metrics
 		rateLimit:       flags.RateLimit(),
 		gopcMetricsList: gopcMetricsList,
 		messageWriter:   os.Stdout,
+		labels:          flags.Labels(),
 	}
 }
 
@@ -395,9 +402,10 @@ func (m *Metrics) sendMetricsBatch(ctx context.Context, jobsCh chan []byte) {
 		}
 
 		batch = append(batch, MetricsItem{
-			ID:    metricName,
-			MType: constants.Gauge,
-			Value: &val,
+			ID:     metricName,
+			MType:  constants.Gauge,
+			Labels: m.labels,
+			Value:  &val,
 		})
 	}
 
@@ -410,9 +418,10 @@ func (m *Metrics) sendMetricsBatch(ctx context.Context, jobsCh chan []byte) {
 	}
 
 	batch = append(batch, MetricsItem{
-		ID:    constants.PollCount,
-		MType: constants.Counter,
-		Delta: &pollCount,
+		ID:     constants.PollCount,
+		MType:  constants.Counter,
+		Labels: m.labels,
+		Delta:  &pollCount,
 	})
 
 	// отправляем задачи, упакованные в мелкие пакеты, воркерам
@@ -144,6 +144,7 @@ func TestSendMetricsBatch(t *testing.T) {
 	ctx := context.Background()
 	jobsCh := make(chan []byte, constants.ChannelCap)
 	metrics := updateMetricsSetup()
+	metrics.labels = storage.Labels{"host": "web-1"}
 	metrics.UpdateMetrics()
 	go metrics.sendMetricsBatch(ctx, jobsCh)
 	time.Sleep(1 * time.Second)
@@ -155,6 +156,7 @@ func TestSendMetricsBatch(t *testing.T) {
 	err := json.Unmarshal(batchByte, &batch)
 	assert.NoError(t, err)
 	assert.Equal(t, batch[0].ID, "Alloc")
+	assert.Equal(t, storage.Labels{"host": "web-1"}, batch[0].Labels)
 }
 
 func TestWorker(t *testing.T) {
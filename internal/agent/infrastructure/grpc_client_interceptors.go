@@ -17,8 +17,7 @@ func setIPInterceptor(ctx context.Context, method string, req interface{},
 
 	// ip клиента
 	ip := GetLocalIP()
-	md := metadata.New(map[string]string{constants.XRealIPName: ip})
-	ctx = metadata.NewOutgoingContext(ctx, md)
+	ctx = metadata.AppendToOutgoingContext(ctx, constants.XRealIPName, ip)
 
 	// вызываем RPC-метод
 	err := invoker(ctx, method, req, reply, cc, opts...)
@@ -51,8 +50,7 @@ func hashSignInterceptor(hashKeyVal string) func(context.Context, string, interf
 
 		serialized, _ := json.Marshal(req)
 		h := hash(serialized, hashKeyVal)
-		md := metadata.New(map[string]string{constants.HashHeaderName: h})
-		ctx = metadata.NewOutgoingContext(ctx, md)
+		ctx = metadata.AppendToOutgoingContext(ctx, constants.HashHeaderName, h)
 
 		// вызываем RPC-метод
 		err := invoker(ctx, method, req, reply, cc, opts...)
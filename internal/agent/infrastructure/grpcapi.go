@@ -9,9 +9,11 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
 	pb "github.com/dnsoftware/go-metrics/internal/proto"
+	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
 // WebSender отправляет данные на сервер.
@@ -27,10 +29,13 @@ type GRPCSender struct {
 // как вариант можно тупо править сгенерированный metric.pb.go файл
 // или использовать какой-то плагин (с теми что нашел - успеха не добился)
 type MetricForUnmarshal struct {
-	ID    string  `json:"id"`              // имя метрики
-	MType string  `json:"type"`            // структурный тег ЭТОГО поле не совпадает со сгенерированным proto файлом mtype != type
-	Delta int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string             `json:"id"`                  // имя метрики
+	MType     string             `json:"type"`                // структурный тег ЭТОГО поле не совпадает со сгенерированным proto файлом mtype != type
+	Labels    map[string]string  `json:"labels,omitempty"`    // метки серии
+	Delta     int64              `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     float64            `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *storage.Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *storage.Summary   `json:"summary,omitempty"`   // скетч в случае передачи summary
 }
 
 func NewGRPCSender(flags Flags, publicKeyPath string) (*GRPCSender, error) {
@@ -82,7 +87,8 @@ func (w *GRPCSender) SendData(ctx context.Context, mType string, name string, va
 	}
 
 	switch mType {
-	case constants.Gauge:
+	case constants.Gauge, constants.Histogram, constants.Summary:
+		// для histogram и summary value - одиночное наблюдение
 		v, _ := strconv.ParseFloat(value, 64)
 		sendItem.Value = v
 	case constants.Counter:
@@ -110,19 +116,64 @@ func (w *GRPCSender) SendDataBatch(ctx context.Context, data []byte) error {
 	metricsToSend := &pb.UpdateMetricBatchRequest{}
 	metricsToSend.Metrics = make([]*pb.UpdateMetricExtRequest, 0, len(metrics))
 	for _, m := range metrics {
-		metricsToSend.Metrics = append(metricsToSend.Metrics, &pb.UpdateMetricExtRequest{
-			Id:    m.ID,
-			Mtype: m.MType,
-			Delta: m.Delta,
-			Value: m.Value,
-		})
+		item := &pb.UpdateMetricExtRequest{
+			Id:     m.ID,
+			Mtype:  m.MType,
+			Labels: m.Labels,
+			Delta:  m.Delta,
+			Value:  m.Value,
+		}
+
+		if m.Histogram != nil {
+			item.Histogram = &pb.HistogramData{
+				Bounds: m.Histogram.Bounds,
+				Counts: m.Histogram.Counts,
+				Sum:    m.Histogram.Sum,
+				Count:  m.Histogram.Count,
+			}
+		}
+
+		if m.Summary != nil {
+			item.Summary = summaryToPb(*m.Summary)
+		}
+
+		metricsToSend.Metrics = append(metricsToSend.Metrics, item)
 	}
 
+	batchID, err := newBatchID()
+	if err != nil {
+		return err
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, constants.BatchIDHeader, batchID)
+
 	_, err = client.UpdateMetricsBatch(ctx, metricsToSend)
 
 	return err
 }
 
+// summaryToPb конвертация скетча summary в proto сообщение
+func summaryToPb(s storage.Summary) *pb.SummaryData {
+	data := &pb.SummaryData{
+		Alpha:    s.Alpha,
+		Positive: make(map[int32]uint64, len(s.Positive)),
+		Negative: make(map[int32]uint64, len(s.Negative)),
+		Zero:     s.Zero,
+		Sum:      s.Sum,
+		Count:    s.Count,
+		Min:      s.Min,
+		Max:      s.Max,
+	}
+
+	for i, c := range s.Positive {
+		data.Positive[int32(i)] = c
+	}
+	for i, c := range s.Negative {
+		data.Negative[int32(i)] = c
+	}
+
+	return data
+}
+
 /*
 // SendDataBatchStream отправка данных потоком
 func (w *GRPCSender) SendDataBatchStream(ctx context.Context, data []byte) error {
@@ -106,6 +106,31 @@ func TestSendData(t *testing.T) {
 	err = sender.SendDataBatch(ctx, []byte(batch))
 
 	require.NoError(t, err)
+
+	// отправка гистограммы пакетом и одиночного наблюдения
+	batch = `[{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,0,0],"sum":0.05,"count":1}}]`
+	err = sender.SendDataBatch(ctx, []byte(batch))
+	require.NoError(t, err)
+
+	err = sender.SendData(ctx, constants.Histogram, "Latency", "0.5")
+	require.NoError(t, err)
+
+	h, err := collect.GetHistogramMetric(ctx, "Latency")
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 1, 0}, h.Counts)
+
+	// отправка скетча summary пакетом и одиночного наблюдения
+	batch = `[{"id":"Duration","type":"summary","summary":{"alpha":0.01,"positive":{"0":1,"35":1},"sum":3,"count":2,"min":1,"max":2}}]`
+	err = sender.SendDataBatch(ctx, []byte(batch))
+	require.NoError(t, err)
+
+	err = sender.SendData(ctx, constants.Summary, "Duration", "-1")
+	require.NoError(t, err)
+
+	sm, err := collect.GetSummaryMetric(ctx, "Duration")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), sm.Count)
+	require.Equal(t, float64(-1), sm.Min)
 }
 
 func TestGetLocalIP(t *testing.T) {
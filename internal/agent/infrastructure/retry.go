@@ -21,6 +21,15 @@ func retryRequest(r *http.Request) error {
 			d, _ := time.ParseDuration(duration)
 			time.Sleep(d)
 
+			// тело запроса уже прочитано предыдущей попыткой
+			if r.GetBody != nil {
+				body, errBody := r.GetBody()
+				if errBody != nil {
+					return errBody
+				}
+				r.Body = body
+			}
+
 			respRetry, errRetry := client.Do(r)
 			if errRetry == nil {
 				respRetry.Body.Close()
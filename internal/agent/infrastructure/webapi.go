@@ -75,9 +75,16 @@ func (w *WebSender) SendDataBatch(ctx context.Context, data []byte) error {
 		return err
 	}
 
+	batchID, err := newBatchID()
+	if err != nil {
+		return err
+	}
+
 	request.Header.Set("Content-Type", w.contentType)
 	request.Header.Add("Content-Encoding", constants.EncodingGzip)
+	request.Header.Set(constants.BatchIDHeader, batchID)
 
+	// повторы отправляются с тем же идентификатором пакета и не применяются сервером дважды
 	err = retryRequest(request)
 
 	return err
@@ -176,6 +183,18 @@ func hash(value []byte, key string) string {
 	return hex.EncodeToString(h[:])
 }
 
+// newBatchID случайный идентификатор пакета, по которому сервер отбрасывает повторно отправленные пакеты
+func newBatchID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
 func NewAgentRequest(ctx context.Context, method, url string, data []byte, cryptoKey string, publicKey *rsa.PublicKey) (*http.Request, error) {
 	buf := &bytes.Buffer{}
 	var err error
@@ -2,6 +2,7 @@ package infrastructure
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
 	"github.com/dnsoftware/go-metrics/internal/crypto"
@@ -65,6 +67,46 @@ func TestWebapi(t *testing.T) {
 
 }
 
+// Повтор после сетевой ошибки отправляет то же тело с тем же идентификатором пакета
+func TestSendDataBatchRetry(t *testing.T) {
+	var (
+		attempts int
+		ids      []string
+		bodies   []int
+	)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		ids = append(ids, r.Header.Get(constants.BatchIDHeader))
+		bodies = append(bodies, len(body))
+
+		// первую попытку обрываем без ответа
+		if attempts == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	flg := fl{
+		runAddress: strings.ReplaceAll(svr.URL, "http://", ""),
+	}
+	sender := NewWebSender("http", &flg, constants.ApplicationJSON, nil)
+
+	err := sender.SendDataBatch(context.Background(), []byte(`[{"id":"PollCount","type":"counter","delta":1}]`))
+	require.NoError(t, err)
+
+	require.Equal(t, 2, attempts)
+	assert.NotEmpty(t, ids[0])
+	assert.Equal(t, ids[0], ids[1])
+	assert.NotZero(t, bodies[0])
+	assert.Equal(t, bodies[0], bodies[1])
+}
+
 func (f *fl) RunAddr() string {
 	return f.runAddress
 }
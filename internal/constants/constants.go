@@ -19,16 +19,136 @@ const (
 
 // Действия. Используются для построения url.
 const (
-	UpdateAction  string = "update"  // сохранить метрику
-	ValueAction   string = "value"   // получить метрику
-	UpdatesAction string = "updates" // получить список метрик
-	PprofAction   string = "/debug/pprof/"
+	UpdateAction      string = "update"   // сохранить метрику
+	ValueAction       string = "value"    // получить метрику
+	UpdatesAction     string = "updates"  // получить список метрик
+	AlertsAction      string = "alerts"   // получить список активных алертов
+	SilencesAction    string = "silences" // заглушки алертов
+	AckAction         string = "ack"      // подтвердить алерт
+	SeriesAction      string = "series"   // поиск серий по селектору меток
+	RangeAction       string = "query_range"
+	MetricsAction     string = "metrics"      // экспозиция метрик для Prometheus
+	RemoteWriteAction string = "api/v1/write" // прием Prometheus remote_write
+	InfluxWriteAction string = "write"        // прием InfluxDB line protocol
+	PprofAction       string = "/debug/pprof/"
 )
 
 // Типы метрик.
 const (
-	Gauge   string = "gauge"
-	Counter string = "counter"
+	Gauge     string = "gauge"
+	Counter   string = "counter"
+	Histogram string = "histogram"
+	Summary   string = "summary"
+)
+
+// Гистограммы и summary.
+const (
+	HistogramBounds  string  = "0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10" // границы корзин по умолчанию (для одиночных наблюдений)
+	SummaryAccuracy  float64 = 0.01                                            // относительная точность оценки квантилей summary
+	SummaryMaxBins   int     = 2048                                            // максимальное количество корзин скетча summary
+	SummaryQuantiles string  = "0.5,0.9,0.99"                                  // квантили, возвращаемые по умолчанию
+)
+
+// История значений.
+const (
+	HistorySize         int           = 1000                         // количество хранимых в памяти значений каждой серии
+	QueryRangeDefault   time.Duration = time.Duration(1) * time.Hour // период запроса истории по умолчанию (до текущего момента)
+	QueryRangeMaxPoints int64         = 11000                        // максимальное количество точек в ответе на запрос истории с шагом
+)
+
+// Миграции схемы БД.
+const MigrationLockID int64 = 7301042017 // ключ advisory блокировки, под которой применяются миграции
+
+// Названия метрик.
+const MetricNameMaxLen int = 64 // наибольшая длина названия метрики без меток, как у поля name в таблицах БД
+
+// Хранилище в памяти.
+const MemStorageShards int = 64 // количество сегментов со своими блокировками, серии распределяются по хешу ключа
+
+// Пул соединений и загрузка пакетов в Postgresql.
+const (
+	DBMaxConns         int           = 0    // размер пула соединений, 0 - pool_max_conns из DSN или значение pgx по умолчанию
+	DBStatementTimeout time.Duration = 0    // ограничение времени выполнения запроса на стороне БД, 0 - без ограничения
+	DBCopyThreshold    int           = 1000 // с этого кол-ва строк gauge и counter пакет загружается через COPY
+	DBSamplesPerInsert int           = 5000 // наибольшее кол-во значений истории в одном INSERT (по два параметра на значение)
+)
+
+// Гибридное хранилище: память с отложенной записью в Postgresql.
+const (
+	HybridFlushInterval time.Duration = time.Duration(1) * time.Second // период записи накопленных обновлений в БД
+	HybridRetryInterval time.Duration = time.Duration(5) * time.Second // период проверки доступности БД в режиме без БД
+)
+
+// Кеш чтения перед Postgresql для нескольких экземпляров сервера с общей БД.
+const (
+	CacheSize           int           = 0                               // кол-во серий в кеше, 0 - без кеша
+	CacheTTL            time.Duration = time.Duration(30) * time.Second // срок жизни значения в кеше
+	CacheChannel        string        = "metrics_cache"                 // канал LISTEN/NOTIFY для сброса кеша на других экземплярах
+	CacheListenRetry    time.Duration = time.Duration(5) * time.Second  // пауза перед повторной подпиской на канал после потери соединения
+	CacheNotifySize     int           = 7000                            // предельный размер уведомления, байт (лимит Postgresql 8000)
+	CacheHitsMetric     string        = "CacheHits"                     // счетчик попаданий в кеш среди метрик сервера
+	CacheMissesMetric   string        = "CacheMisses"                   // счетчик промахов кеша среди метрик сервера
+	SelfMetricsInterval time.Duration = time.Duration(10) * time.Second // период записи метрик самого сервера
+)
+
+// Состояние хранилища, отдается на /ping.
+const (
+	HealthOK          string = "ok"          // БД доступна, обновления записаны или записываются
+	HealthDegraded    string = "degraded"    // БД недоступна, обновления копятся в памяти до ее восстановления
+	HealthUnavailable string = "unavailable" // БД недоступна или не используется
+)
+
+// Уровни хранения истории и агрегаты значений.
+const (
+	HistoryTiers   string        = "raw:24h,1m:30d,1h:365d"       // разрешение:срок хранения, первый уровень - исходные значения
+	RollupInterval time.Duration = time.Duration(1) * time.Minute // интервал расчета агрегатов и удаления устаревшей истории
+
+	AggLast  string = "last"  // последнее значение
+	AggAvg   string = "avg"   // среднее
+	AggMin   string = "min"   // минимум
+	AggMax   string = "max"   // максимум
+	AggSum   string = "sum"   // сумма
+	AggCount string = "count" // количество значений
+)
+
+// Журнал обновлений (WAL) резервной копии.
+const (
+	WALSuffix          string        = ".wal"                         // суффикс файла журнала к имени файла резервной копии
+	WALPrevSuffix      string        = ".wal.prev"                    // суффикс журнала, закрытого на время сохранения снимка
+	WALSync            string        = "interval"                     // политика fsync журнала по умолчанию
+	WALSyncAlways      string        = "always"                       // fsync после каждой записи
+	WALSyncInterval    string        = "interval"                     // fsync не чаще, чем раз в WALSyncPeriod
+	WALSyncNever       string        = "never"                        // fsync только при сохранении снимка, остальное - на усмотрение ОС
+	WALSyncPeriod      time.Duration = time.Duration(1) * time.Second // период fsync журнала для политики interval
+	WALCompactInterval time.Duration = time.Duration(1) * time.Minute // интервал сохранения снимка и очистки журнала при синхронном сохранении
+	WALLockStripes     int           = 256                            // количество блокировок серий при записи в журнал, серии распределяются по хешу ключа
+)
+
+// Снимки резервной копии.
+const (
+	BackupGenerations   int = 3 // количество хранимых поколений снимков
+	BackupFormatVersion int = 2 // версия формата снимка, записываемая в заголовок
+)
+
+// Сроки хранения серий.
+const (
+	SweepInterval time.Duration = time.Duration(1) * time.Minute // интервал удаления устаревших серий
+)
+
+// Алертинг.
+const (
+	AlertInterval          int64         = 10                             // интервал в секундах, с которым проверяются правила алертинга
+	AlertResolvedRetention time.Duration = time.Duration(5) * time.Minute // сколько времени разрешенный алерт остается в списке
+
+	AlertStatePending  string = "pending"  // условие правила выполняется, но еще не дольше, чем for
+	AlertStateFiring   string = "firing"   // условие правила выполняется дольше, чем for
+	AlertStateResolved string = "resolved" // условие правила перестало выполняться
+
+	NotifyGroupWait time.Duration = time.Duration(30) * time.Second // окно группировки уведомлений по умолчанию
+
+	NotifyChannelWebhook  string = "webhook"  // канал уведомлений: json POST запрос
+	NotifyChannelTemplate string = "template" // канал уведомлений: POST запрос с телом по шаблону
+	NotifyChannelFile     string = "file"     // канал уведомлений: запись в файл NDJSON
 )
 
 // Названия параметров.
@@ -36,8 +156,19 @@ const (
 	MetricType  string = "metricType"
 	MetricName  string = "metricName"
 	MetricValue string = "metricValue"
+	SilenceID   string = "silenceID"
+	AlertRule   string = "alertRule"
+	MatchParam  string = "match"  // селектор серий в строке запроса
+	NameParam   string = "name"   // название метрики в строке запроса
+	TypeParam   string = "type"   // тип метрики в строке запроса
+	LabelsParam string = "labels" // метки в строке запроса, вида host=web-1,env=prod
+	FromParam   string = "from"   // начало периода: unix время в секундах или RFC3339
+	ToParam     string = "to"     // конец периода: unix время в секундах или RFC3339
+	StepParam   string = "step"   // шаг: длительность (15s) или секунды
+	AggParam    string = "agg"    // агрегат значений за шаг
 
 	RestoreSavedEnv string = "RESTORE"
+	SweepDryRunEnv  string = "SWEEP_DRY_RUN"
 )
 
 // Метрики.
@@ -62,6 +193,9 @@ const (
 	TrustedSubnet         string = ""
 	GRPCDefault           string = "127.0.0.1:8090" // адрес:порт gRRC сервера по умолчанию
 	ServerAPI             string = "http"           // по какому протоколу клиент будет общаться с сервером (http || grpc) (флаг запуска -server-api, переменная окружения SERVER_API)
+	AlertRulesPath        string = ""               // путь к файлу с правилами алертинга (json или yaml), пустая строка - алертинг выключен
+	NotifiersPath         string = ""               // путь к файлу с каналами уведомлений об алертах (json или yaml), пустая строка - уведомления выключены
+	InfluxRulesPath       string = ""               // путь к файлу с правилами сопоставления полей InfluxDB метрикам (json или yaml), пустая строка - все поля gauge
 )
 
 // Логгер.
@@ -75,6 +209,8 @@ const (
 	TextPlain       string = "text/plain"
 	TextHTML        string = "text/html"
 	ApplicationJSON string = "application/json"
+	PrometheusText  string = "text/plain; version=0.0.4; charset=utf-8"                   // текстовый формат экспозиции Prometheus
+	OpenMetricsText string = "application/openmetrics-text; version=1.0.0; charset=utf-8" // формат OpenMetrics
 	ServerAPIHTTP   string = "http"
 	ServerAPIGRPC   string = "grpc"
 )
@@ -82,6 +218,7 @@ const (
 // Encoding
 const (
 	EncodingGzip      string = "gzip"
+	EncodingSnappy    string = "snappy"             // сжатие тела запросов remote_write
 	CryptoHeaderName  string = "X-Content-Encoding" // ключ HTTP заголовка для асимметричного шифрования
 	CryptoHeaderValue string = "crypto"             // значение HTTP заголовка CryptoHeaderName для асимметричного шифрования
 )
@@ -94,13 +231,48 @@ const (
 
 // Периоды повтора для Retriable ошибок.
 const (
-	HTTPAttemtPeriods string = "1s,2s,5s"
-	DBAttemtPeriods   string = "1s,2s,5s"
+	HTTPAttemtPeriods    string = "1s,2s,5s"
+	DBAttemtPeriods      string = "1s,2s,5s"
+	NotifyAttemptPeriods string = "1s,2s,5s" // по умолчанию для каналов уведомлений
 )
 
 // HashHeaderName Имена заголовков.
 const HashHeaderName string = "HashSHA256"
 const XRealIPName string = "X-Real-IP"
+const BatchIDHeader string = "X-Batch-ID" // идентификатор пакета метрик, также ключ метаданных gRPC
+
+// Prometheus remote_write.
+const (
+	RemoteWriteMaxSize int = 32 << 20 // наибольший размер тела запроса и распакованного WriteRequest, байт
+)
+
+// InfluxDB line protocol.
+const (
+	InfluxMaxSize  int    = 32 << 20    // наибольший размер тела запроса, байт
+	PrecisionParam string = "precision" // параметр запроса с единицей времени точек
+)
+
+// Прием StatsD.
+const (
+	StatsdAddress       string        = ""                              // адрес:порт UDP, пустая строка - прием выключен
+	StatsdTCPAddress    string        = ""                              // адрес:порт TCP, пустая строка - прием выключен
+	StatsdFlushInterval time.Duration = time.Duration(10) * time.Second // окно агрегации перед записью в хранилище
+	StatsdMaxPacket     int           = 65535                           // наибольший размер UDP пакета, байт
+)
+
+// Прием Graphite.
+const (
+	GraphiteAddress   string = ""       // адрес:порт TCP для plaintext, пустая строка - прием выключен
+	GraphiteRulesPath string = ""       // путь к файлу с правилами сопоставления путей метрикам (json или yaml), пустая строка - название из пути
+	GraphiteBatchSize int    = 1000     // наибольшее кол-во строк plaintext в одной записи в хранилище
+	GraphiteMaxLine   int    = 64 << 10 // наибольшая длина строки plaintext, байт
+)
+
+// Окно дедупликации повторно отправленных пакетов.
+const (
+	BatchDedupSize int           = 10000            // максимальное кол-во запоминаемых пакетов
+	BatchDedupTTL  time.Duration = 10 * time.Minute // время хранения идентификатора пакета
+)
 
 // Для gopcutils.
 const (
@@ -26,6 +26,8 @@ const (
 	Metrics_GetAllMetrics_FullMethodName       = "/proto.Metrics/GetAllMetrics"
 	Metrics_UpdateMetricsBatch_FullMethodName  = "/proto.Metrics/UpdateMetricsBatch"
 	Metrics_UpdateMetricsStream_FullMethodName = "/proto.Metrics/UpdateMetricsStream"
+	Metrics_GetAlerts_FullMethodName           = "/proto.Metrics/GetAlerts"
+	Metrics_QueryRange_FullMethodName          = "/proto.Metrics/QueryRange"
 )
 
 // MetricsClient is the client API for Metrics service.
@@ -39,6 +41,8 @@ type MetricsClient interface {
 	GetAllMetrics(ctx context.Context, in *GetAllMetricsRequest, opts ...grpc.CallOption) (*GetAllMetricsResponse, error)
 	UpdateMetricsBatch(ctx context.Context, in *UpdateMetricBatchRequest, opts ...grpc.CallOption) (*UpdateMetricBatchResponse, error)
 	UpdateMetricsStream(ctx context.Context, opts ...grpc.CallOption) (Metrics_UpdateMetricsStreamClient, error)
+	GetAlerts(ctx context.Context, in *GetAlertsRequest, opts ...grpc.CallOption) (*GetAlertsResponse, error)
+	QueryRange(ctx context.Context, in *QueryRangeRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error)
 }
 
 type metricsClient struct {
@@ -134,6 +138,24 @@ func (x *metricsUpdateMetricsStreamClient) Recv() (*UpdateMetricExtResponse, err
 	return m, nil
 }
 
+func (c *metricsClient) GetAlerts(ctx context.Context, in *GetAlertsRequest, opts ...grpc.CallOption) (*GetAlertsResponse, error) {
+	out := new(GetAlertsResponse)
+	err := c.cc.Invoke(ctx, Metrics_GetAlerts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) QueryRange(ctx context.Context, in *QueryRangeRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error) {
+	out := new(QueryRangeResponse)
+	err := c.cc.Invoke(ctx, Metrics_QueryRange_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility
@@ -145,6 +167,8 @@ type MetricsServer interface {
 	GetAllMetrics(context.Context, *GetAllMetricsRequest) (*GetAllMetricsResponse, error)
 	UpdateMetricsBatch(context.Context, *UpdateMetricBatchRequest) (*UpdateMetricBatchResponse, error)
 	UpdateMetricsStream(Metrics_UpdateMetricsStreamServer) error
+	GetAlerts(context.Context, *GetAlertsRequest) (*GetAlertsResponse, error)
+	QueryRange(context.Context, *QueryRangeRequest) (*QueryRangeResponse, error)
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -173,6 +197,12 @@ func (UnimplementedMetricsServer) UpdateMetricsBatch(context.Context, *UpdateMet
 func (UnimplementedMetricsServer) UpdateMetricsStream(Metrics_UpdateMetricsStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method UpdateMetricsStream not implemented")
 }
+func (UnimplementedMetricsServer) GetAlerts(context.Context, *GetAlertsRequest) (*GetAlertsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAlerts not implemented")
+}
+func (UnimplementedMetricsServer) QueryRange(context.Context, *QueryRangeRequest) (*QueryRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryRange not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 
 // UnsafeMetricsServer may be embedded to opt out of forward compatibility for this service.
@@ -320,6 +350,42 @@ func (x *metricsUpdateMetricsStreamServer) Recv() (*UpdateMetricExtRequest, erro
 	return m, nil
 }
 
+func _Metrics_GetAlerts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAlertsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetAlerts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_GetAlerts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetAlerts(ctx, req.(*GetAlertsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_QueryRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).QueryRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_QueryRange_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).QueryRange(ctx, req.(*QueryRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -351,6 +417,14 @@ var Metrics_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateMetricsBatch",
 			Handler:    _Metrics_UpdateMetricsBatch_Handler,
 		},
+		{
+			MethodName: "GetAlerts",
+			Handler:    _Metrics_GetAlerts_Handler,
+		},
+		{
+			MethodName: "QueryRange",
+			Handler:    _Metrics_QueryRange_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
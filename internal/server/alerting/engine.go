@@ -0,0 +1,260 @@
+package alerting
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// MetricsReader получение значений метрик из хранилища
+type MetricsReader interface {
+	// GetAll значения всех серий gauge и counter, ключ - ключ серии
+	GetAll(ctx context.Context) (map[string]float64, map[string]int64, error)
+}
+
+// Notifier получатель изменений состояния алертов (переходы в firing и resolved)
+type Notifier interface {
+	// Notify не должен блокировать, вызывается во время проверки правил
+	Notify(alert Alert)
+}
+
+// Alert состояние алерта по одной серии, подходящей под правило
+type Alert struct {
+	Rule        string    `json:"rule"`         // название правила
+	Metric      string    `json:"metric"`       // ключ серии: название метрики и метки вида Alloc{host="web-1"}
+	MType       string    `json:"type"`         // тип метрики
+	Severity    string    `json:"severity"`     // важность
+	State       string    `json:"state"`        // pending, firing или resolved
+	Value       float64   `json:"value"`        // последнее значение метрики, при котором выполнялось условие
+	Threshold   float64   `json:"threshold"`    // порог из правила
+	ActiveSince time.Time `json:"active_since"` // с какого момента выполняется условие
+	FiredAt     time.Time `json:"fired_at"`     // когда алерт перешел в firing
+	ResolvedAt  time.Time `json:"resolved_at"`  // когда алерт перешел в resolved
+	Silenced    bool      `json:"silenced"`     // подходит ли под действующую заглушку
+	AckedBy     string    `json:"acked_by"`     // кто подтвердил алерт (пусто - не подтвержден)
+	AckedAt     time.Time `json:"acked_at"`     // когда алерт подтвержден
+}
+
+// Engine периодически проверяет правила по значениям метрик и хранит состояние алертов
+type Engine struct {
+	mutex    sync.Mutex
+	rules    []Rule
+	reader   MetricsReader
+	alerts   map[string]*Alert // ключ - alertKey
+	notifier Notifier          // nil, если уведомления не настроены
+	silences *Silences         // nil, если заглушки не используются
+	now      func() time.Time
+}
+
+func NewEngine(rules []Rule, reader MetricsReader, notifier Notifier, silences *Silences) *Engine {
+	return &Engine{
+		rules:    rules,
+		reader:   reader,
+		alerts:   make(map[string]*Alert),
+		notifier: notifier,
+		silences: silences,
+		now:      time.Now,
+	}
+}
+
+// Evaluate однократная проверка всех правил по всем подходящим сериям
+func (e *Engine) Evaluate(ctx context.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := e.now()
+
+	gauges, counters, err := e.reader.GetAll(ctx)
+	if err != nil {
+		logger.Log().Debug("alerting: no metric values: " + err.Error())
+	}
+
+	for _, rule := range e.rules {
+		values, errS := e.values(rule, gauges, counters)
+		if errS != nil {
+			logger.Log().Debug("alerting: rule " + rule.Name + ": " + errS.Error())
+		}
+
+		for series, value := range values {
+			// отсутствие значения серии считаем невыполнением условия
+			if !rule.match(value) {
+				continue
+			}
+
+			key := alertKey(rule.Name, series)
+			alert := e.alerts[key]
+
+			if alert == nil || alert.State == constants.AlertStateResolved {
+				alert = &Alert{
+					Rule:        rule.Name,
+					Metric:      series,
+					MType:       rule.MType,
+					Severity:    rule.Severity,
+					State:       constants.AlertStatePending,
+					Threshold:   rule.Threshold,
+					ActiveSince: now,
+				}
+				e.alerts[key] = alert
+			}
+
+			alert.Value = value
+
+			if alert.State == constants.AlertStatePending && now.Sub(alert.ActiveSince) >= time.Duration(rule.For) {
+				alert.State = constants.AlertStateFiring
+				alert.FiredAt = now
+				e.notify(alert)
+			}
+		}
+
+		for key, alert := range e.alerts {
+			if alert.Rule != rule.Name {
+				continue
+			}
+
+			if value, ok := values[alert.Metric]; ok && rule.match(value) {
+				continue
+			}
+
+			switch alert.State {
+			case constants.AlertStatePending:
+				delete(e.alerts, key)
+			case constants.AlertStateFiring:
+				alert.State = constants.AlertStateResolved
+				alert.ResolvedAt = now
+				e.notify(alert)
+			case constants.AlertStateResolved:
+				if now.Sub(alert.ResolvedAt) > constants.AlertResolvedRetention {
+					delete(e.alerts, key)
+				}
+			}
+		}
+	}
+}
+
+// Alerts список текущих алертов (pending, firing и недавно разрешенных), отсортированный по названию правила и серии
+func (e *Engine) Alerts() []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := e.now()
+
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		alert := *a
+		alert.Silenced = e.isSilenced(a, now)
+		alerts = append(alerts, alert)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+
+		return alerts[i].Metric < alerts[j].Metric
+	})
+
+	return alerts
+}
+
+// Acknowledge подтверждение сработавших алертов правила по всем сериям.
+// Подтверждение сбрасывается, когда алерт разрешится и сработает снова.
+func (e *Engine) Acknowledge(rule string, by string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	found := false
+	acked := false
+
+	for _, alert := range e.alerts {
+		if alert.Rule != rule {
+			continue
+		}
+		found = true
+
+		if alert.State != constants.AlertStateFiring {
+			continue
+		}
+
+		alert.AckedBy = by
+		alert.AckedAt = e.now()
+		acked = true
+	}
+
+	if !found {
+		return ErrAlertNotFound
+	}
+
+	if !acked {
+		return ErrAlertNotFiring
+	}
+
+	return nil
+}
+
+// notify передача изменения состояния алерта получателю, если алерт не заглушен
+func (e *Engine) notify(alert *Alert) {
+	if e.notifier == nil {
+		return
+	}
+
+	if e.isSilenced(alert, e.now()) {
+		logger.Log().Info("alerting: notification silenced for rule " + alert.Rule + ", series " + alert.Metric)
+		return
+	}
+
+	e.notifier.Notify(*alert)
+}
+
+// isSilenced подходит ли алерт под действующую заглушку по названию метрики или ключу серии
+func (e *Engine) isSilenced(alert *Alert, now time.Time) bool {
+	if e.silences == nil {
+		return false
+	}
+
+	name, _ := storage.SplitSeriesKey(alert.Metric)
+
+	return e.silences.IsSilenced(name, now) || e.silences.IsSilenced(alert.Metric, now)
+}
+
+// values значения серий правила, подходящих под его селектор, ключ - ключ серии
+func (e *Engine) values(rule Rule, gauges map[string]float64, counters map[string]int64) (map[string]float64, error) {
+	selector, err := rule.selector()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64)
+
+	if rule.MType == constants.Counter {
+		for key, v := range counters {
+			if selector.MatchKey(key) {
+				values[key] = float64(v)
+			}
+		}
+
+		return values, nil
+	}
+
+	for key, v := range gauges {
+		if selector.MatchKey(key) {
+			values[key] = v
+		}
+	}
+
+	return values, nil
+}
+
+// Key ключ алерта: правило и серия
+func (a Alert) Key() string {
+	return alertKey(a.Rule, a.Metric)
+}
+
+// alertKey ключ алерта: правило и серия
+func alertKey(rule string, series string) string {
+	return rule + "\x00" + series
+}
@@ -0,0 +1,221 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestEngine_Evaluate(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage()
+
+	rules := []Rule{
+		{Name: "HighAlloc", Metric: "Alloc", MType: constants.Gauge, Op: OpGreater, Threshold: 1000, For: Duration(time.Minute), Severity: "warning"},
+		{Name: "TooManyPolls", Metric: constants.PollCount, MType: constants.Counter, Op: OpGreaterEqual, Threshold: 100, Severity: "critical"},
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := NewEngine(rules, repo, nil, nil)
+	e.now = func() time.Time { return now }
+
+	// метрик нет - алертов нет
+	e.Evaluate(ctx)
+	assert.Empty(t, e.Alerts())
+
+	// условие выполнилось - pending, а для правила без for сразу firing
+	repo.SetGauge(ctx, "Alloc", 2000)
+	repo.SetCounter(ctx, constants.PollCount, 100)
+	e.Evaluate(ctx)
+
+	alerts := e.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, constants.AlertStatePending, alerts[0].State)
+	assert.Equal(t, float64(2000), alerts[0].Value)
+	assert.Equal(t, constants.AlertStateFiring, alerts[1].State)
+
+	// прошло меньше for - все еще pending
+	now = now.Add(30 * time.Second)
+	e.Evaluate(ctx)
+	assert.Equal(t, constants.AlertStatePending, e.Alerts()[0].State)
+
+	// прошло for - firing
+	now = now.Add(30 * time.Second)
+	e.Evaluate(ctx)
+	alerts = e.Alerts()
+	assert.Equal(t, constants.AlertStateFiring, alerts[0].State)
+	assert.Equal(t, now, alerts[0].FiredAt)
+
+	// условие перестало выполняться - resolved
+	repo.SetGauge(ctx, "Alloc", 10)
+	now = now.Add(10 * time.Second)
+	e.Evaluate(ctx)
+	alerts = e.Alerts()
+	assert.Equal(t, constants.AlertStateResolved, alerts[0].State)
+	assert.Equal(t, now, alerts[0].ResolvedAt)
+
+	// разрешенный алерт через некоторое время пропадает из списка
+	now = now.Add(constants.AlertResolvedRetention + time.Second)
+	e.Evaluate(ctx)
+	alerts = e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "TooManyPolls", alerts[0].Rule)
+}
+
+func TestEngine_PendingReset(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage()
+
+	rules := []Rule{
+		{Name: "LowFree", Metric: constants.FreeMemory, MType: constants.Gauge, Op: OpLess, Threshold: 100, For: Duration(time.Minute)},
+	}
+	e := NewEngine(rules, repo, nil, nil)
+
+	repo.SetGauge(ctx, constants.FreeMemory, 10)
+	e.Evaluate(ctx)
+	require.Len(t, e.Alerts(), 1)
+
+	// условие перестало выполняться до истечения for - алерт просто исчезает
+	repo.SetGauge(ctx, constants.FreeMemory, 1000)
+	e.Evaluate(ctx)
+	assert.Empty(t, e.Alerts())
+}
+
+type testNotifier struct {
+	alerts []Alert
+}
+
+func (n *testNotifier) Notify(alert Alert) {
+	n.alerts = append(n.alerts, alert)
+}
+
+func TestEngine_Notify(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage()
+	n := &testNotifier{}
+
+	rules := []Rule{
+		{Name: "HighAlloc", Metric: "Alloc", MType: constants.Gauge, Op: OpGreater, Threshold: 1000},
+	}
+	e := NewEngine(rules, repo, n, nil)
+
+	repo.SetGauge(ctx, "Alloc", 2000)
+	e.Evaluate(ctx)
+	e.Evaluate(ctx)
+
+	repo.SetGauge(ctx, "Alloc", 10)
+	e.Evaluate(ctx)
+	e.Evaluate(ctx)
+
+	// только переходы в firing и resolved, без повторов
+	require.Len(t, n.alerts, 2)
+	assert.Equal(t, constants.AlertStateFiring, n.alerts[0].State)
+	assert.Equal(t, constants.AlertStateResolved, n.alerts[1].State)
+}
+
+func TestEngine_LabeledSeries(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage()
+	n := &testNotifier{}
+
+	rules := []Rule{
+		{Name: "HighAlloc", Metric: "Alloc", Labels: `host=~"web-.*"`, MType: constants.Gauge, Op: OpGreater, Threshold: 1000},
+	}
+	e := NewEngine(rules, repo, n, nil)
+
+	web1 := storage.SeriesKey("Alloc", storage.Labels{"host": "web-1"})
+	web2 := storage.SeriesKey("Alloc", storage.Labels{"host": "web-2"})
+	db := storage.SeriesKey("Alloc", storage.Labels{"host": "db-1"})
+
+	// алерт по каждой подходящей серии, серия с другими метками не проверяется
+	repo.SetGauge(ctx, web1, 2000)
+	repo.SetGauge(ctx, web2, 3000)
+	repo.SetGauge(ctx, db, 5000)
+	e.Evaluate(ctx)
+
+	alerts := e.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, web1, alerts[0].Metric)
+	assert.Equal(t, float64(2000), alerts[0].Value)
+	assert.Equal(t, web2, alerts[1].Metric)
+	assert.Equal(t, float64(3000), alerts[1].Value)
+	require.Len(t, n.alerts, 2)
+
+	// разрешается только серия, значение которой вернулось в норму
+	repo.SetGauge(ctx, web1, 10)
+	e.Evaluate(ctx)
+
+	alerts = e.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, constants.AlertStateResolved, alerts[0].State)
+	assert.Equal(t, constants.AlertStateFiring, alerts[1].State)
+	require.Len(t, n.alerts, 3)
+	assert.Equal(t, web1, n.alerts[2].Metric)
+}
+
+func TestEngine_SilenceAndAck(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMemStorage()
+	n := &testNotifier{}
+	silences := NewSilences()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rules := []Rule{
+		{Name: "HighAlloc", Metric: "Alloc", MType: constants.Gauge, Op: OpGreater, Threshold: 1000},
+	}
+	e := NewEngine(rules, repo, n, silences)
+	e.now = func() time.Time { return now }
+
+	// подтвердить можно только сработавший алерт
+	assert.ErrorIs(t, e.Acknowledge("HighAlloc", "admin"), ErrAlertNotFound)
+
+	_, err := silences.Add(Silence{Matcher: "Al*", EndsAt: now.Add(time.Hour), CreatedBy: "admin"}, now)
+	require.NoError(t, err)
+
+	// алерт срабатывает, но уведомление заглушено
+	repo.SetGauge(ctx, "Alloc", 2000)
+	e.Evaluate(ctx)
+	assert.Empty(t, n.alerts)
+
+	alerts := e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Silenced)
+
+	require.NoError(t, e.Acknowledge("HighAlloc", "admin"))
+	alerts = e.Alerts()
+	assert.Equal(t, "admin", alerts[0].AckedBy)
+	assert.Equal(t, now, alerts[0].AckedAt)
+
+	// заглушка истекла - разрешение алерта отправляется
+	now = now.Add(2 * time.Hour)
+	repo.SetGauge(ctx, "Alloc", 10)
+	e.Evaluate(ctx)
+	require.Len(t, n.alerts, 1)
+	assert.Equal(t, constants.AlertStateResolved, n.alerts[0].State)
+	assert.False(t, e.Alerts()[0].Silenced)
+	assert.ErrorIs(t, e.Acknowledge("HighAlloc", "admin"), ErrAlertNotFiring)
+
+	// повторное срабатывание - подтверждение сброшено
+	repo.SetGauge(ctx, "Alloc", 2000)
+	e.Evaluate(ctx)
+	assert.Equal(t, "", e.Alerts()[0].AckedBy)
+
+	// заглушка по названию метрики действует и на серии с метками
+	sent := len(n.alerts)
+	labeled := NewEngine(rules, storage.NewMemStorage(), n, silences)
+	labeled.now = e.now
+	_, err = silences.Add(Silence{Matcher: "Alloc", EndsAt: now.Add(time.Hour), CreatedBy: "admin"}, now)
+	require.NoError(t, err)
+
+	labeled.reader.(*storage.MemStorage).SetGauge(ctx, storage.SeriesKey("Alloc", storage.Labels{"host": "web-1"}), 2000)
+	labeled.Evaluate(ctx)
+	require.Len(t, labeled.Alerts(), 1)
+	assert.True(t, labeled.Alerts()[0].Silenced)
+	assert.Len(t, n.alerts, sent)
+}
@@ -0,0 +1,182 @@
+// Package alerting правила алертинга и их периодическая проверка по данным хранилища метрик
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// Операции сравнения значения метрики с порогом.
+const (
+	OpGreater      string = ">"
+	OpGreaterEqual string = ">="
+	OpLess         string = "<"
+	OpLessEqual    string = "<="
+	OpEqual        string = "=="
+	OpNotEqual     string = "!="
+)
+
+// Duration длительность, которая в файле правил задается строкой вида "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON разбор длительности из json строки
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	return d.parse(s)
+}
+
+// MarshalJSON длительность в json в виде строки
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalYAML разбор длительности из yaml строки
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.parse(value.Value)
+}
+
+func (d *Duration) parse(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+// Rule правило алертинга.
+// Алерт срабатывает отдельно по каждой серии метрики Metric типа Type, метки которой подходят под Labels,
+// если ее значение удовлетворяет условию "значение Op Threshold" на протяжении не менее For.
+type Rule struct {
+	Name      string   `json:"name" yaml:"name"`           // уникальное название правила
+	Metric    string   `json:"metric" yaml:"metric"`       // название метрики
+	Labels    string   `json:"labels" yaml:"labels"`       // условия на метки вида host=~"web-.*",env!="dev" (пусто - все серии)
+	MType     string   `json:"type" yaml:"type"`           // тип метрики gauge или counter
+	Op        string   `json:"op" yaml:"op"`               // операция сравнения: >, >=, <, <=, ==, !=
+	Threshold float64  `json:"threshold" yaml:"threshold"` // порог
+	For       Duration `json:"for" yaml:"for"`             // сколько условие должно выполняться, чтобы алерт перешел в firing
+	Severity  string   `json:"severity" yaml:"severity"`   // важность алерта (произвольная строка, например warning или critical)
+}
+
+// rulesFile структура файла правил
+type rulesFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// LoadRules загрузка правил из файла. Формат определяется по расширению: .json - json, иначе yaml
+func LoadRules(filename string) ([]Rule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("LoadRules | ReadFile: %w", err)
+	}
+
+	var rf rulesFile
+
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = json.Unmarshal(data, &rf)
+	} else {
+		err = yaml.Unmarshal(data, &rf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LoadRules | Unmarshal: %w", err)
+	}
+
+	err = ValidateRules(rf.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("LoadRules | ValidateRules: %w", err)
+	}
+
+	return rf.Rules, nil
+}
+
+// ValidateRules проверка корректности правил
+func ValidateRules(rules []Rule) error {
+	names := make(map[string]bool, len(rules))
+
+	for _, r := range rules {
+		if r.Name == "" {
+			return errors.New("rule name required")
+		}
+
+		if names[r.Name] {
+			return fmt.Errorf("duplicate rule name: %s", r.Name)
+		}
+		names[r.Name] = true
+
+		if r.Metric == "" {
+			return fmt.Errorf("rule %s: metric name required", r.Name)
+		}
+
+		if _, err := r.selector(); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+
+		if r.MType != constants.Gauge && r.MType != constants.Counter {
+			return fmt.Errorf("rule %s: bad metric type: %s", r.Name, r.MType)
+		}
+
+		switch r.Op {
+		case OpGreater, OpGreaterEqual, OpLess, OpLessEqual, OpEqual, OpNotEqual:
+		default:
+			return fmt.Errorf("rule %s: bad comparison: %s", r.Name, r.Op)
+		}
+
+		if r.For < 0 {
+			return fmt.Errorf("rule %s: negative for duration", r.Name)
+		}
+	}
+
+	return nil
+}
+
+// selector селектор серий правила
+func (r Rule) selector() (storage.Selector, error) {
+	if strings.TrimSpace(r.Labels) == "" {
+		return storage.Selector{Name: r.Metric}, nil
+	}
+
+	return storage.ParseSelector(r.Metric + "{" + r.Labels + "}")
+}
+
+// match проверка выполнения условия правила для значения метрики
+func (r Rule) match(value float64) bool {
+	switch r.Op {
+	case OpGreater:
+		return value > r.Threshold
+	case OpGreaterEqual:
+		return value >= r.Threshold
+	case OpLess:
+		return value < r.Threshold
+	case OpLessEqual:
+		return value <= r.Threshold
+	case OpEqual:
+		return value == r.Threshold
+	case OpNotEqual:
+		return value != r.Threshold
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestLoadRules(t *testing.T) {
+	for file, labels := range map[string]string{"testdata/rules.yaml": "", "testdata/rules.json": `host=~"web-.*"`} {
+		rules, err := LoadRules(file)
+		require.NoError(t, err, file)
+		require.Len(t, rules, 2, file)
+
+		assert.Equal(t, "HighAlloc", rules[0].Name)
+		assert.Equal(t, "Alloc", rules[0].Metric)
+		assert.Equal(t, labels, rules[0].Labels, file)
+		assert.Equal(t, OpGreater, rules[0].Op)
+		assert.Equal(t, float64(1000), rules[0].Threshold)
+		assert.Equal(t, Duration(time.Minute), rules[0].For)
+		assert.Equal(t, "warning", rules[0].Severity)
+
+		assert.Equal(t, Duration(0), rules[1].For)
+		assert.Equal(t, "critical", rules[1].Severity)
+	}
+}
+
+func TestLoadRulesNegative(t *testing.T) {
+	_, err := LoadRules("testdata/nofile.yaml")
+	assert.Error(t, err)
+
+	_, err = LoadRules("testdata/bad_rules.yaml")
+	assert.Error(t, err)
+
+	err = ValidateRules([]Rule{{Name: "a", Metric: "Alloc", MType: "gauge", Op: ">"}, {Name: "a", Metric: "Alloc", MType: "gauge", Op: ">"}})
+	assert.Error(t, err)
+
+	err = ValidateRules([]Rule{{Name: "a", Metric: "Alloc", MType: "bad", Op: ">"}})
+	assert.Error(t, err)
+
+	err = ValidateRules([]Rule{{Name: "a", MType: "gauge", Op: ">"}})
+	assert.Error(t, err)
+
+	err = ValidateRules([]Rule{{Name: "a", Metric: "Alloc", Labels: "host=web", MType: "gauge", Op: ">"}})
+	assert.ErrorIs(t, err, storage.ErrBadLabels)
+}
+
+func TestRuleMatch(t *testing.T) {
+	r := Rule{Threshold: 10}
+
+	for op, expected := range map[string][3]bool{
+		OpGreater:      {false, false, true},
+		OpGreaterEqual: {false, true, true},
+		OpLess:         {true, false, false},
+		OpLessEqual:    {true, true, false},
+		OpEqual:        {false, true, false},
+		OpNotEqual:     {true, false, true},
+	} {
+		r.Op = op
+		assert.Equal(t, expected[0], r.match(9), op)
+		assert.Equal(t, expected[1], r.match(10), op)
+		assert.Equal(t, expected[2], r.match(11), op)
+	}
+}
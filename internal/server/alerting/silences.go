@@ -0,0 +1,164 @@
+package alerting
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSilenceNotFound = errors.New("silence not found")
+	ErrAlertNotFound   = errors.New("alert not found")
+	ErrAlertNotFiring  = errors.New("alert is not firing")
+)
+
+// Silence заглушка уведомлений: алерты по метрикам, название которых подходит под шаблон
+// Matcher, не рассылаются в период с StartsAt по EndsAt
+type Silence struct {
+	ID        string    `json:"id"`
+	Matcher   string    `json:"matcher"`    // шаблон названия метрики в формате path.Match, например "Heap*"
+	StartsAt  time.Time `json:"starts_at"`  // начало действия, если не указано - с момента создания
+	EndsAt    time.Time `json:"ends_at"`    // окончание действия
+	CreatedBy string    `json:"created_by"` // кто создал
+	Comment   string    `json:"comment"`    // причина, например "плановые работы"
+}
+
+// Validate проверка корректности заглушки
+func (s Silence) Validate() error {
+	if s.Matcher == "" {
+		return errors.New("silence matcher required")
+	}
+
+	if _, err := path.Match(s.Matcher, ""); err != nil {
+		return fmt.Errorf("bad silence matcher %s: %w", s.Matcher, err)
+	}
+
+	if s.EndsAt.IsZero() {
+		return errors.New("silence end time required")
+	}
+
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("silence must end after it starts")
+	}
+
+	return nil
+}
+
+// Match действует ли заглушка в момент now для метрики metric
+func (s Silence) Match(metric string, now time.Time) bool {
+	if now.Before(s.StartsAt) || !now.Before(s.EndsAt) {
+		return false
+	}
+
+	ok, _ := path.Match(s.Matcher, metric)
+
+	return ok
+}
+
+// Silences хранилище заглушек
+type Silences struct {
+	mutex    sync.Mutex
+	silences map[string]Silence
+}
+
+func NewSilences() *Silences {
+	return &Silences{
+		silences: make(map[string]Silence),
+	}
+}
+
+// Add добавление заглушки. Возвращает заглушку с присвоенным ID
+func (s *Silences) Add(silence Silence, now time.Time) (Silence, error) {
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = now
+	}
+
+	err := silence.Validate()
+	if err != nil {
+		return Silence{}, err
+	}
+
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		return Silence{}, err
+	}
+	silence.ID = hex.EncodeToString(id)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.silences[silence.ID] = silence
+
+	return silence, nil
+}
+
+// Delete удаление заглушки
+func (s *Silences) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.silences[id]; !ok {
+		return ErrSilenceNotFound
+	}
+
+	delete(s.silences, id)
+
+	return nil
+}
+
+// List список действующих и будущих заглушек, отсортированный по началу действия.
+// Истекшие заглушки удаляются.
+func (s *Silences) List(now time.Time) []Silence {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := make([]Silence, 0, len(s.silences))
+	for id, silence := range s.silences {
+		if !now.Before(silence.EndsAt) {
+			delete(s.silences, id)
+			continue
+		}
+
+		list = append(list, silence)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].StartsAt.Equal(list[j].StartsAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].StartsAt.Before(list[j].StartsAt)
+	})
+
+	return list
+}
+
+// Restore замена всех заглушек сохраненными (при загрузке из резервной копии)
+func (s *Silences) Restore(silences []Silence) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.silences = make(map[string]Silence, len(silences))
+	for _, silence := range silences {
+		s.silences[silence.ID] = silence
+	}
+}
+
+// IsSilenced заглушен ли алерт по метрике metric в момент now
+func (s *Silences) IsSilenced(metric string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, silence := range s.silences {
+		if silence.Match(metric, now) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSilences(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSilences()
+
+	s1, err := s.Add(Silence{Matcher: "Heap*", EndsAt: now.Add(time.Hour), Comment: "maintenance"}, now)
+	require.NoError(t, err)
+	assert.NotEmpty(t, s1.ID)
+	assert.Equal(t, now, s1.StartsAt)
+
+	s2, err := s.Add(Silence{Matcher: "Alloc", StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour)}, now)
+	require.NoError(t, err)
+
+	assert.True(t, s.IsSilenced("HeapAlloc", now))
+	assert.False(t, s.IsSilenced("Alloc", now))
+	assert.True(t, s.IsSilenced("Alloc", now.Add(90*time.Minute)))
+	assert.False(t, s.IsSilenced("HeapAlloc", now.Add(90*time.Minute)))
+
+	list := s.List(now)
+	require.Len(t, list, 2)
+	assert.Equal(t, s1.ID, list[0].ID)
+
+	// истекшие заглушки удаляются из списка
+	list = s.List(now.Add(90 * time.Minute))
+	require.Len(t, list, 1)
+	assert.Equal(t, s2.ID, list[0].ID)
+
+	require.NoError(t, s.Delete(s2.ID))
+	assert.ErrorIs(t, s.Delete(s2.ID), ErrSilenceNotFound)
+	assert.Empty(t, s.List(now))
+
+	s.Restore([]Silence{s1})
+	assert.Equal(t, []Silence{s1}, s.List(now))
+}
+
+func TestSilenceValidate(t *testing.T) {
+	now := time.Now()
+	s := NewSilences()
+
+	_, err := s.Add(Silence{EndsAt: now.Add(time.Hour)}, now)
+	assert.Error(t, err)
+
+	_, err = s.Add(Silence{Matcher: "[", EndsAt: now.Add(time.Hour)}, now)
+	assert.Error(t, err)
+
+	_, err = s.Add(Silence{Matcher: "Alloc"}, now)
+	assert.Error(t, err)
+
+	_, err = s.Add(Silence{Matcher: "Alloc", EndsAt: now.Add(-time.Hour)}, now)
+	assert.Error(t, err)
+}
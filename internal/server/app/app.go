@@ -9,12 +9,16 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/dnsoftware/go-metrics/internal/constants"
 	"github.com/dnsoftware/go-metrics/internal/crypto"
 	"github.com/dnsoftware/go-metrics/internal/logger"
 	"github.com/dnsoftware/go-metrics/internal/server/collector"
 	"github.com/dnsoftware/go-metrics/internal/server/config"
+	"github.com/dnsoftware/go-metrics/internal/server/graphite"
 	"github.com/dnsoftware/go-metrics/internal/server/handlers"
+	"github.com/dnsoftware/go-metrics/internal/server/statsd"
 	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
@@ -27,14 +31,85 @@ func ServerRun() error {
 		return err
 	}
 
+	err = backupStorage.SetWALSync(cfg.WALSync)
+	if err != nil {
+		return err
+	}
+
+	err = backupStorage.SetGenerations(cfg.BackupGens)
+	if err != nil {
+		return err
+	}
+
 	var (
 		repo    collector.ServerStorage
 		collect *collector.Collector
 	)
 
-	repo, err = storage.NewPostgresqlStorage(cfg.DatabaseDSN)
-	if err != nil { // значит база НЕ рабочая - используем Memory
-		repo = storage.NewMemStorage()
+	poolCfg, err := pgPoolConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	cacheCfg, err := cacheConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	statsdCfg, err := statsdConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	graphiteCfg, err := graphiteConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	memStorage := storage.NewMemStorage()
+	memStorage.SetHistorySize(cfg.HistorySize)
+	repo = memStorage
+
+	var (
+		hybrid *storage.HybridStorage
+		cache  *storage.CachedStorage
+	)
+	if cfg.DatabaseDSN != "" {
+		pgStorage, errPg := storage.OpenPostgresqlStorage(cfg.DatabaseDSN, poolCfg)
+		if errPg != nil {
+			return errPg
+		}
+		defer pgStorage.Close()
+
+		if cacheCfg.Size > 0 {
+			// несколько серверов с общей БД: запросы идут в БД через кеш чтения,
+			// кеш сбрасывается на всех серверах через LISTEN/NOTIFY
+			ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
+			errPg = pgStorage.CreateDatabaseTables(ctx)
+			cancel()
+			if errPg != nil {
+				return errPg
+			}
+
+			// общую БД восстанавливает из файла только первый сервер, пока она пуста,
+			// иначе восстановление сбросило бы значения, записанные другими серверами
+			if cfg.RestoreSaved {
+				ctx, cancel = context.WithTimeout(context.Background(), constants.DBContextTimeout)
+				cfg.RestoreSaved, errPg = sharedRestore(ctx, pgStorage)
+				cancel()
+				if errPg != nil {
+					return errPg
+				}
+			}
+
+			cache = storage.NewCachedStorage(pgStorage, cacheCfg, pgStorage)
+			repo = cache
+		} else {
+			// запросы обслуживает память, обновления пишутся в БД, пока она доступна,
+			// и копятся в памяти до ее восстановления, если недоступна
+			hybrid = storage.NewHybridStorage(memStorage, pgStorage)
+			repo = hybrid
+		}
 	}
 
 	collect, err = collector.NewCollector(cfg, repo, backupStorage)
@@ -42,6 +117,26 @@ func ServerRun() error {
 		return err
 	}
 
+	// прием StatsD, значения агрегируются за окно и пишутся в сборщик
+	var statsdServer *statsd.Server
+	if statsdCfg.UDPAddress != "" || statsdCfg.TCPAddress != "" {
+		statsdServer = statsd.NewServer(statsdCfg, collect)
+		err = statsdServer.Start()
+		if err != nil {
+			return err
+		}
+	}
+
+	// прием Graphite plaintext, пути сохраняются как gauge
+	var graphiteServer *graphite.Server
+	if graphiteCfg.Address != "" {
+		graphiteServer = graphite.NewServer(graphiteCfg, collect)
+		err = graphiteServer.Start()
+		if err != nil {
+			return err
+		}
+	}
+
 	privateCryptoKey, err := crypto.MakePrivateKey(cfg.AsymPrivKeyPath)
 	if err != nil {
 		logger.Log().Error(err.Error())
@@ -64,8 +159,8 @@ func ServerRun() error {
 	fmt.Println("Сервер gRPC начал работу")
 	// получаем запрос gRPC
 	go func() {
-		if err = grpcServer.Serve(listen); err != nil {
-			logger.Log().Fatal(err.Error())
+		if errServe := grpcServer.Serve(listen); errServe != nil {
+			logger.Log().Fatal(errServe.Error())
 		}
 	}()
 
@@ -84,9 +179,9 @@ func ServerRun() error {
 		// можно обойтись без цикла
 		<-sigint
 		// получили сигнал os.Interrupt, запускаем процедуру graceful shutdown
-		if err = srv.Shutdown(context.Background()); err != nil {
+		if errShutdown := srv.Shutdown(context.Background()); errShutdown != nil {
 			// ошибки закрытия Listener
-			logger.Log().Error("HTTP server Shutdown: " + err.Error())
+			logger.Log().Error("HTTP server Shutdown: " + errShutdown.Error())
 		}
 		fmt.Println("\nhttp server shutdown gracefully")
 
@@ -109,7 +204,136 @@ func ServerRun() error {
 	// здесь можно освобождать ресурсы перед выходом,
 	// например закрыть соединение с базой данных,
 	// закрыть открытые файлы
+	if graphiteServer != nil {
+		_ = graphiteServer.Close()
+	}
+
+	if statsdServer != nil {
+		errClose := statsdServer.Close()
+		if errClose != nil {
+			logger.Log().Error("statsd close: " + errClose.Error())
+		}
+	}
+
+	// фоновые задачи сборщика останавливаются до закрытия хранилищ
+	collect.Close()
+
+	if hybrid != nil {
+		errClose := hybrid.Close()
+		if errClose != nil {
+			logger.Log().Error("hybrid storage close: " + errClose.Error())
+		}
+	}
+
+	if cache != nil {
+		_ = cache.Close()
+	}
+
+	errClose := backupStorage.Close()
+	if errClose != nil {
+		logger.Log().Error("backup storage close: " + errClose.Error())
+	}
 	fmt.Println("Server Shutdown gracefully")
 
 	return nil // нормальное завершение
 }
+
+// pgPoolConfig параметры пула соединений с БД из конфигурации
+func pgPoolConfig(cfg *config.ServerConfig) (storage.PgPoolConfig, error) {
+	poolCfg := storage.PgPoolConfig{MaxConns: cfg.DBMaxConns}
+
+	if cfg.DBStmtTimeout != "" {
+		d, err := time.ParseDuration(cfg.DBStmtTimeout)
+		if err != nil || d < 0 {
+			return storage.PgPoolConfig{}, fmt.Errorf("bad db statement timeout %q", cfg.DBStmtTimeout)
+		}
+		poolCfg.StatementTimeout = d
+	}
+
+	return poolCfg, nil
+}
+
+// seriesLister хранилище со списком серий
+type seriesLister interface {
+	ListSeries(ctx context.Context) ([]storage.SeriesInfo, error)
+}
+
+// sharedRestore можно ли восстанавливать общую БД из файла: только если в ней еще нет серий
+func sharedRestore(ctx context.Context, db seriesLister) (bool, error) {
+	series, err := db.ListSeries(ctx)
+	if err != nil {
+		return false, fmt.Errorf("app sharedRestore | ListSeries: %w", err)
+	}
+
+	if len(series) > 0 {
+		logger.Log().Info("shared database is not empty, restore from file skipped")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// cacheConfig параметры кеша чтения БД из конфигурации
+func cacheConfig(cfg *config.ServerConfig) (storage.CacheConfig, error) {
+	if cfg.CacheSize < 0 {
+		return storage.CacheConfig{}, fmt.Errorf("bad cache size %d", cfg.CacheSize)
+	}
+
+	cacheCfg := storage.CacheConfig{Size: cfg.CacheSize, TTL: constants.CacheTTL}
+
+	if cfg.CacheTTL != "" {
+		d, err := time.ParseDuration(cfg.CacheTTL)
+		if err != nil || d < 0 {
+			return storage.CacheConfig{}, fmt.Errorf("bad cache ttl %q", cfg.CacheTTL)
+		}
+		cacheCfg.TTL = d
+	}
+
+	return cacheCfg, nil
+}
+
+// statsdConfig параметры приема StatsD из конфигурации
+func statsdConfig(cfg *config.ServerConfig) (statsd.Config, error) {
+	statsdCfg := statsd.Config{
+		UDPAddress:    cfg.StatsdAddress,
+		TCPAddress:    cfg.StatsdTCP,
+		FlushInterval: constants.StatsdFlushInterval,
+	}
+
+	if cfg.StatsdFlush != "" {
+		d, err := time.ParseDuration(cfg.StatsdFlush)
+		if err != nil || d <= 0 {
+			return statsd.Config{}, fmt.Errorf("bad statsd flush interval %q", cfg.StatsdFlush)
+		}
+		statsdCfg.FlushInterval = d
+	}
+
+	bounds := cfg.HistogramBounds
+	if bounds == "" {
+		bounds = constants.HistogramBounds
+	}
+
+	var err error
+
+	statsdCfg.HistogramBounds, err = storage.ParseBounds(bounds)
+	if err != nil {
+		return statsd.Config{}, fmt.Errorf("bad histogram buckets %q", bounds)
+	}
+
+	return statsdCfg, nil
+}
+
+// graphiteConfig параметры приема Graphite из конфигурации
+func graphiteConfig(cfg *config.ServerConfig) (graphite.Config, error) {
+	graphiteCfg := graphite.Config{Address: cfg.GraphiteAddress}
+
+	if cfg.GraphiteRules != "" {
+		rules, err := graphite.LoadRules(cfg.GraphiteRules)
+		if err != nil {
+			return graphite.Config{}, err
+		}
+		graphiteCfg.Rules = rules
+	}
+
+	return graphiteCfg, nil
+}
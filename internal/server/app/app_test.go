@@ -1,11 +1,20 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/server/config"
+	"github.com/dnsoftware/go-metrics/internal/server/graphite"
+	"github.com/dnsoftware/go-metrics/internal/server/statsd"
+	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
 func TestApp(t *testing.T) {
@@ -20,3 +29,113 @@ func TestApp(t *testing.T) {
 
 	assert.NoError(t, errShutdown)
 }
+
+func TestParseMigrateArgs(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want migrateCommand
+	}{
+		{nil, migrateCommand{action: "up"}},
+		{[]string{"up"}, migrateCommand{action: "up"}},
+		{[]string{"status"}, migrateCommand{action: "status"}},
+		{[]string{"down"}, migrateCommand{action: "down", n: 1}},
+		{[]string{"down", "3"}, migrateCommand{action: "down", n: 3}},
+		{[]string{"to", "0"}, migrateCommand{action: "to", n: 0}},
+		{[]string{"to", "2"}, migrateCommand{action: "to", n: 2}},
+	} {
+		cmd, err := parseMigrateArgs(tt.args)
+		assert.NoError(t, err, tt.args)
+		assert.Equal(t, tt.want, cmd, tt.args)
+	}
+
+	for _, args := range [][]string{{"sideways"}, {"to"}, {"to", "x"}, {"down", "0"}, {"down", "-1"}, {"up", "1"}, {"status", "1"}} {
+		_, err := parseMigrateArgs(args)
+		assert.ErrorIs(t, err, ErrBadMigrateArgs, args)
+	}
+}
+
+func TestPgPoolConfig(t *testing.T) {
+	poolCfg, err := pgPoolConfig(&config.ServerConfig{DBMaxConns: 20, DBStmtTimeout: "5s"})
+	assert.NoError(t, err)
+	assert.Equal(t, storage.PgPoolConfig{MaxConns: 20, StatementTimeout: 5 * time.Second}, poolCfg)
+
+	poolCfg, err = pgPoolConfig(&config.ServerConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, storage.PgPoolConfig{}, poolCfg)
+
+	for _, timeout := range []string{"5", "-1s"} {
+		_, err = pgPoolConfig(&config.ServerConfig{DBStmtTimeout: timeout})
+		assert.Error(t, err, timeout)
+	}
+}
+
+func TestCacheConfig(t *testing.T) {
+	cacheCfg, err := cacheConfig(&config.ServerConfig{CacheSize: 1000, CacheTTL: "1m"})
+	assert.NoError(t, err)
+	assert.Equal(t, storage.CacheConfig{Size: 1000, TTL: time.Minute}, cacheCfg)
+
+	cacheCfg, err = cacheConfig(&config.ServerConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, storage.CacheConfig{TTL: constants.CacheTTL}, cacheCfg)
+
+	for _, bad := range []*config.ServerConfig{{CacheSize: -1}, {CacheTTL: "1"}, {CacheTTL: "-1s"}} {
+		_, err = cacheConfig(bad)
+		assert.Error(t, err)
+	}
+}
+
+// seriesList список серий общей БД
+type seriesList struct {
+	series []storage.SeriesInfo
+	err    error
+}
+
+func (l seriesList) ListSeries(_ context.Context) ([]storage.SeriesInfo, error) {
+	return l.series, l.err
+}
+
+func TestSharedRestore(t *testing.T) {
+	ctx := context.Background()
+
+	ok, err := sharedRestore(ctx, seriesList{})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = sharedRestore(ctx, seriesList{series: []storage.SeriesInfo{{MType: constants.Gauge, Name: "Alloc"}}})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	errDB := errors.New("db down")
+	_, err = sharedRestore(ctx, seriesList{err: errDB})
+	assert.ErrorIs(t, err, errDB)
+}
+
+func TestStatsdConfig(t *testing.T) {
+	statsdCfg, err := statsdConfig(&config.ServerConfig{StatsdAddress: ":8125", StatsdTCP: ":8126", StatsdFlush: "1m", HistogramBounds: "0.1,1"})
+	assert.NoError(t, err)
+	assert.Equal(t, statsd.Config{UDPAddress: ":8125", TCPAddress: ":8126", FlushInterval: time.Minute, HistogramBounds: []float64{0.1, 1}}, statsdCfg)
+
+	statsdCfg, err = statsdConfig(&config.ServerConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, constants.StatsdFlushInterval, statsdCfg.FlushInterval)
+	assert.Len(t, statsdCfg.HistogramBounds, 11)
+
+	for _, bad := range []*config.ServerConfig{{StatsdFlush: "1"}, {StatsdFlush: "0s"}, {HistogramBounds: "1,0.1"}} {
+		_, err = statsdConfig(bad)
+		assert.Error(t, err)
+	}
+}
+
+func TestGraphiteConfig(t *testing.T) {
+	graphiteCfg, err := graphiteConfig(&config.ServerConfig{GraphiteAddress: ":2003", GraphiteRules: "../graphite/testdata/rules.yaml"})
+	assert.NoError(t, err)
+	assert.Equal(t, ":2003", graphiteCfg.Address)
+	assert.NotNil(t, graphiteCfg.Rules)
+
+	graphiteCfg, err = graphiteConfig(&config.ServerConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, graphite.Config{}, graphiteCfg)
+
+	_, err = graphiteConfig(&config.ServerConfig{GraphiteRules: "../graphite/testdata/bad_rules.yaml"})
+	assert.Error(t, err)
+}
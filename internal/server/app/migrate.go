@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/dnsoftware/go-metrics/internal/server/config"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// ErrBadMigrateArgs некорректные аргументы подкоманды migrate
+var ErrBadMigrateArgs = errors.New("usage: server migrate [flags] [up | down [N] | to VERSION | status]")
+
+// migrateCommand действие подкоманды migrate
+type migrateCommand struct {
+	action string // up, down, to или status
+	n      int    // количество откатываемых миграций для down, версия для to
+}
+
+// parseMigrateArgs разбор аргументов подкоманды migrate, оставшихся после флагов
+func parseMigrateArgs(args []string) (migrateCommand, error) {
+	if len(args) == 0 {
+		return migrateCommand{action: "up"}, nil
+	}
+
+	cmd := migrateCommand{action: args[0]}
+
+	switch {
+	case (cmd.action == "up" || cmd.action == "status") && len(args) == 1:
+		return cmd, nil
+	case cmd.action == "down" && len(args) == 1:
+		cmd.n = 1
+		return cmd, nil
+	case (cmd.action == "down" || cmd.action == "to") && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 || (cmd.action == "down" && n == 0) {
+			return migrateCommand{}, ErrBadMigrateArgs
+		}
+		cmd.n = n
+		return cmd, nil
+	}
+
+	return migrateCommand{}, ErrBadMigrateArgs
+}
+
+// MigrateRun подкоманда migrate: применение и откат миграций схемы БД, заданной флагом -d или DATABASE_DSN.
+// Флаги те же, что и у сервера, после них - действие (по умолчанию up)
+func MigrateRun() error {
+	cfg := config.NewServerConfig()
+
+	// аргументы, оставшиеся после флагов сервера
+	cmd, err := parseMigrateArgs(flag.Args())
+	if err != nil {
+		return err
+	}
+
+	if cfg.DatabaseDSN == "" {
+		return errors.New("migrate: database dsn is not set")
+	}
+
+	poolCfg, err := pgPoolConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	pgs, err := storage.OpenPostgresqlStorage(cfg.DatabaseDSN, poolCfg)
+	if err != nil {
+		return err
+	}
+	defer pgs.Close()
+
+	ctx := context.Background()
+
+	switch cmd.action {
+	case "up":
+		err = pgs.MigrateUp(ctx)
+	case "down":
+		err = pgs.MigrateDown(ctx, cmd.n)
+	case "to":
+		err = pgs.MigrateTo(ctx, cmd.n)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := pgs.MigrationsStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range status {
+		applied := "pending"
+		if s.Applied() {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d %-24s %s\n", s.Version, s.Name, applied)
+	}
+
+	return nil
+}
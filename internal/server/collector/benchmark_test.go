@@ -2,6 +2,11 @@ package collector
 
 import (
 	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -22,7 +27,10 @@ func BenchmarkGetAll(b *testing.B) {
 
 	backupStorage := mock_collector.NewMockBackupStorage(ctrl)
 
-	backupStorage.EXPECT().Load().Return("", nil).AnyTimes()
+	backupStorage.EXPECT().Load(0).DoAndReturn(func(gen int) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("")), nil
+	}).AnyTimes()
+	backupStorage.EXPECT().Generations().Return(1).AnyTimes()
 
 	var (
 		repo    ServerStorage
@@ -35,6 +43,7 @@ func BenchmarkGetAll(b *testing.B) {
 	if err != nil {
 		panic(err)
 	}
+	defer collect.Close()
 
 	batch := `[{"id":"Alloc","type":"gauge","value":343728},{"id":"BuckHashSys","type":"gauge","value":7321},{"id":"Frees","type":"gauge","value":268},{"id":"GCCPUFraction","type":"gauge","value":0},{"id":"GCSys","type":"gauge","value":1758064},{"id":"HeapAlloc","type":"gauge","value":343728},{"id":"HeapIdle","type":"gauge","value":2490368},{"id":"HeapInuse","type":"gauge","value":1179648},{"id":"HeapObjects","type":"gauge","value":1959},{"id":"HeapReleased","type":"gauge","value":2490368},{"id":"HeapSys","type":"gauge","value":3670016},{"id":"LastGC","type":"gauge","value":0},{"id":"Lookups","type":"gauge","value":0},{"id":"MCacheInuse","type":"gauge","value":4800},{"id":"MCacheSys","type":"gauge","value":15600},{"id":"MSpanInuse","type":"gauge","value":54400},{"id":"MSpanSys","type":"gauge","value":65280},{"id":"Mallocs","type":"gauge","value":2227},{"id":"NextGC","type":"gauge","value":4194304},{"id":"NumForcedGC","type":"gauge","value":0},{"id":"NumGC","type":"gauge","value":0},{"id":"OtherSys","type":"gauge","value":1126423},{"id":"PauseTotalNs","type":"gauge","value":0},{"id":"StackInuse","type":"gauge","value":524288},{"id":"StackSys","type":"gauge","value":524288},{"id":"Sys","type":"gauge","value":7166992},{"id":"TotalAlloc","type":"gauge","value":343728},{"id":"RandomValue","type":"gauge","value":0.5116380300334399},{"id":"TotalMemory","type":"gauge","value":33518669824},{"id":"FreeMemory","type":"gauge","value":3527917568},{"id":"CPUutilization1","type":"gauge","value":59.793814433156726},{"id":"CPUutilization2","type":"gauge","value":46.487603307343086},{"id":"CPUutilization3","type":"gauge","value":42.47422680367953},{"id":"CPUutilization4","type":"gauge","value":25.63559321940101},{"id":"PollCount","type":"counter","delta":62}]`
 	collect.storage.SetBatch(ctx, []byte(batch))
@@ -50,3 +59,110 @@ func BenchmarkGetAll(b *testing.B) {
 	}
 
 }
+
+// unshardedStorage хранилище в памяти под одной блокировкой, как до разбиения на сегменты, - база для сравнения
+type unshardedStorage struct {
+	ServerStorage
+	mutex sync.RWMutex
+}
+
+func (u *unshardedStorage) SetBatch(ctx context.Context, batch []byte) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.ServerStorage.SetBatch(ctx, batch)
+}
+
+func (u *unshardedStorage) GetAll(ctx context.Context) (map[string]float64, map[string]int64, error) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+
+	return u.ServerStorage.GetAll(ctx)
+}
+
+// BenchmarkConcurrentAgents пакетные обновления от множества агентов, у каждого агента свои серии.
+// В варианте read каждый 16-й запрос - чтение всех метрик.
+// sharded - хранилище с сегментами, unsharded - то же хранилище под одной блокировкой.
+// Серии агентов попадают в разные сегменты и обновляются параллельно, выигрыш сегментов растет с количеством ядер;
+// на одном ядре параллельной работы нет, и разница между вариантами в пределах шума.
+// go test -bench BenchmarkConcurrentAgents -run ^$ -cpu 1,4,8 ./internal/server/collector/
+// result (1 ядро, -cpu 4 и 8 задают GOMAXPROCS, но не добавляют ядер):
+// sharded/write        26058     43924 ns/op
+// sharded/write-4      32236     36847 ns/op
+// sharded/write-8      25294     39899 ns/op
+// sharded/read         30280     35550 ns/op
+// sharded/read-4       26047     42019 ns/op
+// sharded/read-8       25603     45669 ns/op
+// unsharded/write      42526     30956 ns/op
+// unsharded/write-4    31299     45112 ns/op
+// unsharded/write-8    22384     44988 ns/op
+// unsharded/read       34166     37685 ns/op
+// unsharded/read-4     21301     53316 ns/op
+// unsharded/read-8     19592     60233 ns/op
+func BenchmarkConcurrentAgents(b *testing.B) {
+	ctx := context.Background()
+	cfg := &config.ServerConfig{}
+
+	ctrl := gomock.NewController(b)
+	defer ctrl.Finish()
+
+	backupStorage := mock_collector.NewMockBackupStorage(ctrl)
+	backupStorage.EXPECT().Load(0).DoAndReturn(func(gen int) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("")), nil
+	}).AnyTimes()
+	backupStorage.EXPECT().Generations().Return(1).AnyTimes()
+
+	stores := []struct {
+		name string
+		new  func() ServerStorage
+	}{
+		{name: "sharded", new: func() ServerStorage { return storage.NewMemStorage() }},
+		{name: "unsharded", new: func() ServerStorage { return &unshardedStorage{ServerStorage: storage.NewMemStorage()} }},
+	}
+
+	for _, store := range stores {
+		for _, read := range []bool{false, true} {
+			name := store.name + "/write"
+			if read {
+				name = store.name + "/read"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				collect, err := NewCollector(cfg, store.new(), backupStorage)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer collect.Close()
+
+				var agents atomic.Int64
+
+				b.SetParallelism(16)
+				b.ResetTimer()
+
+				b.RunParallel(func(pb *testing.PB) {
+					host := "agent" + strconv.FormatInt(agents.Add(1), 10)
+					batch := []byte(`[` +
+						`{"id":"Alloc","type":"gauge","value":343728,"labels":{"host":"` + host + `"}},` +
+						`{"id":"HeapAlloc","type":"gauge","value":343728,"labels":{"host":"` + host + `"}},` +
+						`{"id":"HeapSys","type":"gauge","value":3670016,"labels":{"host":"` + host + `"}},` +
+						`{"id":"RandomValue","type":"gauge","value":0.51,"labels":{"host":"` + host + `"}},` +
+						`{"id":"CPUutilization1","type":"gauge","value":59.79,"labels":{"host":"` + host + `"}},` +
+						`{"id":"PollCount","type":"counter","delta":5,"labels":{"host":"` + host + `"}}]`)
+
+					for i := 0; pb.Next(); i++ {
+						if read && i%16 == 15 {
+							if _, _, err := collect.GetAllByTypes(ctx); err != nil {
+								b.Error(err)
+							}
+							continue
+						}
+
+						if err := collect.SetBatchMetrics(ctx, batch); err != nil {
+							b.Error(err)
+						}
+					}
+				})
+			})
+		}
+	}
+}
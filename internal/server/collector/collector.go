@@ -3,14 +3,23 @@ package collector
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
 	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
 	"github.com/dnsoftware/go-metrics/internal/server/config"
+	"github.com/dnsoftware/go-metrics/internal/server/influx"
+	"github.com/dnsoftware/go-metrics/internal/server/notifier"
+	"github.com/dnsoftware/go-metrics/internal/storage"
 	_ "github.com/golang/mock/mockgen/model"
 )
 
@@ -21,10 +30,22 @@ type ServerStorage interface {
 	// Параметры: name - название метрики, value - ее значение.
 	SetGauge(ctx context.Context, name string, value float64) error
 
+	// SetGaugeSamples сохранение значений метрик типа gauge с заданным временем по сериям одним пакетом:
+	// все значения добавляются в историю, значение с наибольшим временем становится текущим.
+	// Параметры: series - значения по ключам серий.
+	SetGaugeSamples(ctx context.Context, series map[string][]storage.Sample) error
+
 	// SetCounter сохранение метрики типа counter в хранилище.
 	// Параметры: name - название метрики, value - ее значение.
 	SetCounter(ctx context.Context, name string, value int64) error
 
+	// IncrementCounter атомарное прибавление delta к метрике типа counter.
+	// Параметры: name - название метрики, delta - приращение.
+	IncrementCounter(ctx context.Context, name string, delta int64) error
+
+	// IncrementCounters атомарное прибавление приращений к нескольким метрикам типа counter
+	IncrementCounters(ctx context.Context, deltas map[string]int64) error
+
 	// SetBatch сохраняет метрики в базу пакетом из нескольких штук
 	SetBatch(ctx context.Context, batch []byte) error
 
@@ -36,51 +57,181 @@ type ServerStorage interface {
 	// Параметры: name - название метрики.
 	GetCounter(ctx context.Context, name string) (int64, error)
 
+	// AddHistogram прибавление гистограммы к сохраненной (слияние корзин, суммы и количества).
+	// Параметры: name - название метрики, value - прибавляемая гистограмма.
+	AddHistogram(ctx context.Context, name string, value storage.Histogram) error
+
+	// GetHistogram получение метрики типа histogram из хранилища.
+	// Параметры: name - название метрики.
+	GetHistogram(ctx context.Context, name string) (storage.Histogram, error)
+
+	// AddSummary прибавление скетча summary к сохраненному.
+	// Параметры: name - название метрики, value - прибавляемый скетч.
+	AddSummary(ctx context.Context, name string, value storage.Summary) error
+
+	// GetSummary получение скетча метрики типа summary из хранилища.
+	// Параметры: name - название метрики.
+	GetSummary(ctx context.Context, name string) (storage.Summary, error)
+
 	// GetAll получение всех метрик. Возвращает карты gauge и counters
 	GetAll(ctx context.Context) (map[string]float64, map[string]int64, error)
 
-	// GetDump получение дампа базы данных
-	GetDump(ctx context.Context) (string, error)
+	// GetAllHistograms получение всех гистограмм
+	GetAllHistograms(ctx context.Context) (map[string]storage.Histogram, error)
+
+	// GetAllSummaries получение всех скетчей summary
+	GetAllSummaries(ctx context.Context) (map[string]storage.Summary, error)
 
-	// RestoreFromDump восстановление в базу данных из дампа
-	RestoreFromDump(ctx context.Context, dump string) error
+	// QueryRange история значений серии типа mType (gauge или counter) с from по to в порядке времени.
+	// Параметры: name - ключ серии.
+	QueryRange(ctx context.Context, mType string, name string, from, to time.Time) ([]storage.Sample, error)
+
+	// AddRollups сохранение агрегатов истории серии с разрешением resolution
+	AddRollups(ctx context.Context, resolution time.Duration, mType string, name string, rollups []storage.Rollup) error
+
+	// QueryRollups агрегаты истории серии с разрешением resolution с from по to в порядке времени
+	QueryRollups(ctx context.Context, resolution time.Duration, mType string, name string, from, to time.Time) ([]storage.Rollup, error)
+
+	// PruneHistory удаление истории старше before: исходных значений (resolution 0) или агрегатов с разрешением resolution
+	PruneHistory(ctx context.Context, resolution time.Duration, before time.Time) error
+
+	// ListSeries все серии и время их последнего обновления
+	ListSeries(ctx context.Context) ([]storage.SeriesInfo, error)
+
+	// DeleteSeries удаление серии типа mType, если она не обновлялась с момента updatedBefore.
+	// Возвращает, была ли серия удалена
+	DeleteSeries(ctx context.Context, mType string, name string, updatedBefore time.Time) (bool, error)
+
+	// WriteDump запись дампа базы данных в w в формате NDJSON
+	WriteDump(ctx context.Context, w io.Writer) error
+
+	// RestoreFromDump восстановление в базу данных из дампа в формате NDJSON
+	RestoreFromDump(ctx context.Context, r io.Reader) error
 
 	// DatabasePing проверяет работоспособность БД
 	DatabasePing(ctx context.Context) bool
 }
 
 // BackupStorage работает с резервной копией БД. Сохранение дампа в базу и получение дампа базы.
+// Обновления между сохранениями дампа дописываются в журнал, который применяется к дампу при получении.
+// Хранится несколько поколений дампа, 0 - последнее.
 type BackupStorage interface {
-	Save(dump string) error
-	Load() (string, error)
+	Save(write func(w io.Writer) error) error
+	Load(gen int) (io.ReadCloser, error)
+	Generations() int
+	Append(records ...storage.DumpRecord) error
 }
 
 // Collector работает с метриками. Сохраняет их в базу и получает их из базы.
 type Collector struct {
-	cfg           *config.ServerConfig
-	storage       ServerStorage
-	backupStorage BackupStorage
+	cfg             *config.ServerConfig
+	storage         ServerStorage
+	backupStorage   BackupStorage
+	alerts          *alerting.Engine   // nil, если алертинг не настроен
+	notifier        *notifier.Notifier // nil, если каналы уведомлений не настроены
+	silences        *alerting.Silences
+	histogramBounds []float64 // границы корзин для гистограмм, созданных одиночным наблюдением
+	quantiles       []float64 // квантили summary, возвращаемые по умолчанию
+
+	walLocks *walLocks // блокировки серий на время изменения и записи в журнал
+
+	tiers       []storage.Tier              // уровни хранения истории, первый - исходные значения
+	rollupMutex sync.Mutex                  // расчет агрегатов не выполняется параллельно
+	rolledUp    map[time.Duration]time.Time // конец последнего рассчитанного интервала каждого уровня
+
+	staleTTL  time.Duration   // срок хранения необновляемых серий, 0 - без ограничения
+	retention []RetentionRule // сроки хранения серий по префиксу названия
+
+	dedup *batchDedup // окно идентификаторов примененных пакетов
+
+	influxRules *influx.Rules // правила сопоставления полей InfluxDB метрикам, nil - все поля gauge
+
+	done chan struct{} // закрывается при остановке фоновых задач
+	wg   sync.WaitGroup
 }
 
+// silencesSection раздел дополнительных данных дампа с заглушками алертов
+const silencesSection = "silences"
+
 // gaugeMetricsList список доступных gauge метрик
 var gaugeMetricsList = []string{"Alloc", "BuckHashSys", "Frees", "GCCPUFraction", "GCSys", "HeapAlloc", "HeapIdle", "HeapInuse", "HeapObjects", "HeapReleased", "HeapSys", "LastGC", "Lookups", "MCacheInuse", "MCacheSys", "MSpanInuse", "MSpanSys", "Mallocs", "NextGC", "NumForcedGC", "NumGC", "OtherSys", "PauseTotalNs", "StackInuse", "StackSys", "Sys", "TotalAlloc", "RandomValue"}
 
-func NewCollector(cfg *config.ServerConfig, storage ServerStorage, backupStorage BackupStorage) (*Collector, error) {
+func NewCollector(cfg *config.ServerConfig, serverStorage ServerStorage, backupStorage BackupStorage) (*Collector, error) {
 	collector := &Collector{
 		cfg:           cfg,
-		storage:       storage,
+		storage:       serverStorage,
 		backupStorage: backupStorage,
+		silences:      alerting.NewSilences(),
+		dedup:         newBatchDedup(constants.BatchDedupSize, constants.BatchDedupTTL),
+		walLocks:      newWALLocks(),
+		done:          make(chan struct{}),
+	}
+
+	bounds := cfg.HistogramBounds
+	if bounds == "" {
+		bounds = constants.HistogramBounds
+	}
+
+	var err error
+
+	collector.histogramBounds, err = storage.ParseBounds(bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	collector.quantiles, _ = storage.ParseQuantiles(constants.SummaryQuantiles)
+
+	tiers := cfg.HistoryTiers
+	if tiers == "" {
+		tiers = constants.HistoryTiers
+	}
+
+	collector.tiers, err = storage.ParseTiers(tiers)
+	if err != nil {
+		return nil, err
+	}
+	collector.rolledUp = make(map[time.Duration]time.Time)
+
+	if cfg.StaleTTL != "" {
+		collector.staleTTL, err = storage.ParseLongDuration(cfg.StaleTTL)
+		if err != nil || collector.staleTTL < 0 {
+			return nil, fmt.Errorf("%w: bad stale ttl %q", ErrBadRetention, cfg.StaleTTL)
+		}
+	}
+
+	collector.retention, err = ParseRetention(cfg.Retention)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.InfluxRulesPath != "" {
+		collector.influxRules, err = influx.LoadRules(cfg.InfluxRulesPath)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Загружаем сохраненную базу, если нужно
 	if cfg.RestoreSaved {
-		err := collector.LoadFromDump()
+		err = collector.LoadFromDump()
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	collector.startBackup()
+	collector.startRollups()
+	collector.startSweeper()
+	collector.startSelfMetrics()
+
+	// Запускаем проверку правил алертинга, если указан файл правил
+	if cfg.AlertRulesPath != "" {
+		err = collector.startAlerting()
+		if err != nil {
+			collector.Close()
+			return nil, err
+		}
+	}
 
 	return collector, nil
 }
@@ -108,20 +259,36 @@ func (c *Collector) IsMetric(mType string, name string) bool {
 // SetGaugeMetric сохранение метрики типа gauge.
 // Параметры: metricName - название метрики, metricValue - ее значение.
 func (c *Collector) SetGaugeMetric(ctx context.Context, metricName string, metricValue float64) error {
+	series := historySeries{mType: constants.Gauge, key: metricName}
+
+	defer c.lockSeries(series)()
+
 	err := c.storage.SetGauge(ctx, metricName, metricValue)
 	if err != nil {
 		return err
 	}
 
-	// если бэкап синхронный и указан файл
-	if c.cfg.StoreInterval == constants.BackupPeriodSync && c.cfg.FileStoragePath != "" {
-		err = c.GenerateDump()
-		if err != nil {
-			return err
-		}
+	return c.appendWAL(ctx, series)
+}
+
+// SetGaugeSamples сохранение значений метрик типа gauge с заданным временем по сериям одним пакетом:
+// все значения добавляются в историю, значение с наибольшим временем становится текущим.
+// При ошибке не сохраняется ни одна серия, пакет можно повторить целиком.
+// Параметры: series - значения по ключам серий.
+func (c *Collector) SetGaugeSamples(ctx context.Context, series map[string][]storage.Sample) error {
+	keys := make([]historySeries, 0, len(series))
+	for key := range series {
+		keys = append(keys, historySeries{mType: constants.Gauge, key: key})
 	}
 
-	return nil
+	defer c.lockSeries(keys...)()
+
+	err := c.storage.SetGaugeSamples(ctx, series)
+	if err != nil {
+		return err
+	}
+
+	return c.appendWAL(ctx, keys...)
 }
 
 // GetGaugeMetric получение значения метрики типа gauge.
@@ -132,30 +299,59 @@ func (c *Collector) GetGaugeMetric(ctx context.Context, metricName string) (floa
 
 // SetCounterMetric сохранение метрики типа counter.
 // Параметры: metricName - название метрики, metricValue - ее значение.
-// Прибавляем к уже существующему значению
+// Прибавляем к уже существующему значению атомарно, параллельные обновления не теряются
 func (c *Collector) SetCounterMetric(ctx context.Context, metricName string, metricValue int64) error {
-	oldVal, _ := c.storage.GetCounter(ctx, metricName)
-	newVal := oldVal + metricValue
+	series := historySeries{mType: constants.Counter, key: metricName}
+
+	defer c.lockSeries(series)()
 
-	err := c.storage.SetCounter(ctx, metricName, newVal)
+	err := c.storage.IncrementCounter(ctx, metricName, metricValue)
 	if err != nil {
 		return err
 	}
 
-	// если бэкап синхронный и указан файл
-	if c.cfg.StoreInterval == constants.BackupPeriodSync && c.cfg.FileStoragePath != "" {
-		errB := c.GenerateDump()
-		if errB != nil {
-			return errB
-		}
-	}
-
-	return nil
+	return c.appendWAL(ctx, series)
 }
 
 // SetBatchMetrics сохраняет метрики в базу пакетом из нескольких штук
 func (c *Collector) SetBatchMetrics(ctx context.Context, batch []byte) error {
-	return c.storage.SetBatch(ctx, batch)
+	if c.cfg.FileStoragePath == "" {
+		return c.storage.SetBatch(ctx, batch)
+	}
+
+	var metrics []storage.Metrics
+
+	err := json.Unmarshal(batch, &metrics)
+	if err != nil {
+		// некорректный пакет отклоняет хранилище
+		return c.storage.SetBatch(ctx, batch)
+	}
+
+	series := make([]historySeries, 0, len(metrics))
+	for _, mt := range metrics {
+		series = append(series, historySeries{mType: mt.MType, key: mt.Key()})
+	}
+
+	defer c.lockSeries(series...)()
+
+	err = c.storage.SetBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	return c.appendWAL(ctx, series...)
+}
+
+// InfluxRules правила сопоставления полей InfluxDB line protocol метрикам
+func (c *Collector) InfluxRules() *influx.Rules {
+	return c.influxRules
+}
+
+// ApplyBatchOnce применяет пакет метрик функцией apply, если пакет с идентификатором batchID еще не применялся.
+// Возвращает true для повторно отправленного пакета, который подтверждается без применения.
+// Пустой batchID - без проверки повтора.
+func (c *Collector) ApplyBatchOnce(ctx context.Context, batchID string, apply func() error) (bool, error) {
+	return c.dedup.Do(ctx, batchID, apply)
 }
 
 // GetCounterMetric получение значения метрики типа counter.
@@ -164,6 +360,102 @@ func (c *Collector) GetCounterMetric(ctx context.Context, metricName string) (in
 	return c.storage.GetCounter(ctx, metricName)
 }
 
+// SetHistogramMetric сохранение метрики типа histogram.
+// Параметры: metricName - название метрики, metricValue - гистограмма.
+// Прибавляем к уже существующей, границы корзин должны совпадать
+func (c *Collector) SetHistogramMetric(ctx context.Context, metricName string, metricValue storage.Histogram) error {
+	series := historySeries{mType: constants.Histogram, key: metricName}
+
+	defer c.lockSeries(series)()
+
+	err := c.storage.AddHistogram(ctx, metricName, metricValue)
+	if err != nil {
+		return err
+	}
+
+	return c.appendWAL(ctx, series)
+}
+
+// ObserveHistogramMetric добавление одного наблюдения в метрику типа histogram.
+// Если метрики еще нет - она создается с границами корзин из настроек.
+func (c *Collector) ObserveHistogramMetric(ctx context.Context, metricName string, value float64) error {
+	bounds := c.histogramBounds
+	if h, err := c.storage.GetHistogram(ctx, metricName); err == nil {
+		bounds = h.Bounds
+	}
+
+	h := storage.NewHistogram(bounds)
+	h.Observe(value)
+
+	return c.SetHistogramMetric(ctx, metricName, h)
+}
+
+// GetHistogramMetric получение метрики типа histogram.
+// Параметры: metricName - название метрики.
+func (c *Collector) GetHistogramMetric(ctx context.Context, metricName string) (storage.Histogram, error) {
+	return c.storage.GetHistogram(ctx, metricName)
+}
+
+// GetAllHistograms все гистограммы картой
+func (c *Collector) GetAllHistograms(ctx context.Context) (map[string]storage.Histogram, error) {
+	return c.storage.GetAllHistograms(ctx)
+}
+
+// SetSummaryMetric сохранение метрики типа summary.
+// Параметры: metricName - название метрики, metricValue - скетч.
+// Сливаем с уже существующим, точность скетчей должна совпадать
+func (c *Collector) SetSummaryMetric(ctx context.Context, metricName string, metricValue storage.Summary) error {
+	series := historySeries{mType: constants.Summary, key: metricName}
+
+	defer c.lockSeries(series)()
+
+	err := c.storage.AddSummary(ctx, metricName, metricValue)
+	if err != nil {
+		return err
+	}
+
+	return c.appendWAL(ctx, series)
+}
+
+// ObserveSummaryMetric добавление одного наблюдения в метрику типа summary
+func (c *Collector) ObserveSummaryMetric(ctx context.Context, metricName string, value float64) error {
+	var alpha float64
+	if sm, err := c.storage.GetSummary(ctx, metricName); err == nil {
+		alpha = sm.Alpha
+	}
+
+	sm := storage.NewSummary(alpha)
+	sm.Observe(value)
+
+	return c.SetSummaryMetric(ctx, metricName, sm)
+}
+
+// GetSummaryMetric получение скетча метрики типа summary.
+// Параметры: metricName - название метрики.
+func (c *Collector) GetSummaryMetric(ctx context.Context, metricName string) (storage.Summary, error) {
+	return c.storage.GetSummary(ctx, metricName)
+}
+
+// GetSummaryStats количество, сумма, минимум, максимум и квантили метрики типа summary.
+// Если квантили не указаны - возвращаются p50, p90 и p99
+func (c *Collector) GetSummaryStats(ctx context.Context, metricName string, quantiles []float64) (storage.SummaryStats, error) {
+	sm, err := c.storage.GetSummary(ctx, metricName)
+	if err != nil {
+		return storage.SummaryStats{}, err
+	}
+
+	if len(quantiles) == 0 {
+		quantiles = c.quantiles
+	}
+
+	return sm.Stats(quantiles), nil
+}
+
+// GetAllSummaries все скетчи summary картой
+func (c *Collector) GetAllSummaries(ctx context.Context) (map[string]storage.Summary, error) {
+	return c.storage.GetAllSummaries(ctx)
+}
+
 // GetMetric получение метрики в текстовом виде
 func (c *Collector) GetMetric(ctx context.Context, metricType string, metricName string) (string, error) {
 	var valStr string
@@ -184,6 +476,32 @@ func (c *Collector) GetMetric(ctx context.Context, metricType string, metricName
 		}
 
 		valStr = strconv.FormatInt(val, 10)
+
+	case constants.Histogram:
+		val, err := c.GetHistogramMetric(ctx, metricName)
+		if err != nil {
+			return "", err
+		}
+
+		data, err := json.Marshal(val)
+		if err != nil {
+			return "", err
+		}
+
+		valStr = string(data)
+
+	case constants.Summary:
+		val, err := c.GetSummaryStats(ctx, metricName, nil)
+		if err != nil {
+			return "", err
+		}
+
+		data, err := json.Marshal(val)
+		if err != nil {
+			return "", err
+		}
+
+		valStr = string(data)
 	default:
 		return "", errors.New("bad metric type")
 	}
@@ -207,6 +525,28 @@ func (c *Collector) GetAll(ctx context.Context) (string, error) {
 		mList = mList + key + ": " + strconv.FormatInt(val, 10) + "\n"
 	}
 
+	histograms, err := c.storage.GetAllHistograms(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for key, val := range histograms {
+		mList = mList + key + ": " + fmt.Sprintf("count=%d sum=%f", val.Count, val.Sum) + "\n"
+	}
+
+	summaries, err := c.storage.GetAllSummaries(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for key, val := range summaries {
+		mList = mList + key + ": " + fmt.Sprintf("count=%d sum=%f", val.Count, val.Sum)
+		for _, q := range c.quantiles {
+			mList = mList + fmt.Sprintf(" p%s=%f", strconv.FormatFloat(q*100, 'f', -1, 64), val.Quantile(q))
+		}
+		mList = mList + "\n"
+	}
+
 	return mList, nil
 }
 
@@ -220,18 +560,83 @@ func (c *Collector) GetAllByTypes(ctx context.Context) (map[string]float64, map[
 	return gauges, counters, nil
 }
 
+// FindSeries все серии, подходящие под селектор, отсортированные по типу и ключу серии.
+// Для summary возвращается скетч
+func (c *Collector) FindSeries(ctx context.Context, selector storage.Selector) ([]storage.Metrics, error) {
+	gauges, counters, err := c.storage.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	histograms, err := c.storage.GetAllHistograms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries, err := c.storage.GetAllSummaries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var series []storage.Metrics
+
+	add := func(mType string, key string, mt storage.Metrics) {
+		name, labels := storage.SplitSeriesKey(key)
+		if !selector.Match(name, labels) {
+			return
+		}
+
+		mt.ID = name
+		mt.MType = mType
+		if len(labels) > 0 {
+			mt.Labels = labels
+		}
+		series = append(series, mt)
+	}
+
+	for key, val := range gauges {
+		v := val
+		add(constants.Gauge, key, storage.Metrics{Value: &v})
+	}
+	for key, val := range counters {
+		v := val
+		add(constants.Counter, key, storage.Metrics{Delta: &v})
+	}
+	for key, val := range histograms {
+		v := val
+		add(constants.Histogram, key, storage.Metrics{Histogram: &v})
+	}
+	for key, val := range summaries {
+		v := val
+		add(constants.Summary, key, storage.Metrics{Summary: &v})
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].MType != series[j].MType {
+			return series[i].MType < series[j].MType
+		}
+
+		return series[i].Key() < series[j].Key()
+	})
+
+	return series, nil
+}
+
 // GenerateDump сохранение дампа в файл
 func (c *Collector) GenerateDump() error {
 	ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
 	defer cancel()
 
-	dump, err := c.storage.GetDump(ctx)
-	if err != nil {
-		logger.Log().Error(err.Error())
-		return err
-	}
+	// обновления во время записи дампа не останавливаются: журнал закрывается до начала записи,
+	// и обновления, сделанные после, попадают в новый журнал
+	err := c.backupStorage.Save(func(w io.Writer) error {
+		err := c.storage.WriteDump(ctx, w)
+		if err != nil {
+			return err
+		}
 
-	err = c.backupStorage.Save(dump)
+		return c.writeSilences(w)
+	})
 	if err != nil {
 		logger.Log().Error(err.Error())
 		return err
@@ -240,36 +645,111 @@ func (c *Collector) GenerateDump() error {
 	return nil
 }
 
-// LoadFromDump загрузка данных из дампа
+// LoadFromDump загрузка данных из последнего неповрежденного поколения дампа.
+// Если неповрежденных поколений нет - работа начинается с пустой базой
 func (c *Collector) LoadFromDump() error {
 	ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
 	defer cancel()
 
-	dump, err := c.backupStorage.Load()
-	if err != nil {
-		logger.Log().Error(err.Error())
-		return err
+	skipped := false
+	for gen := 0; gen < c.backupStorage.Generations(); gen++ {
+		dump, err := c.backupStorage.Load(gen)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = c.restoreDump(ctx, dump)
+			dump.Close()
+		}
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("backup generation %d skipped: %s", gen, err.Error()))
+			skipped = true
+			continue
+		}
+
+		logger.Log().Info(fmt.Sprintf("backup restored from generation %d", gen))
+
+		return nil
 	}
 
-	// пустой файл
-	if len(dump) == 0 {
+	if skipped {
+		logger.Log().Error("no valid backup generation found, starting with empty storage")
+	}
+
+	return nil
+}
+
+// restoreDump восстановление метрик и заглушек алертов из дампа.
+// Дамп сначала применяется к пустому хранилищу в памяти и переносится в хранилище сервера, только если прочитан
+// без ошибок, поэтому поврежденное поколение не оставляет частично восстановленных данных
+func (c *Collector) restoreDump(ctx context.Context, dump io.Reader) error {
+	var silences []alerting.Silence
+
+	metrics := storage.FilterExtra(dump, func(rec storage.DumpRecord) error {
+		if rec.MType != silencesSection {
+			return nil
+		}
+
+		var s alerting.Silence
+
+		err := json.Unmarshal(rec.Value, &s)
+		if err != nil {
+			return fmt.Errorf("%w: silence: %s", storage.ErrBadDump, err.Error())
+		}
+		silences = append(silences, s)
+
 		return nil
+	})
+
+	staged := storage.NewMemStorage()
+
+	err := staged.RestoreFromDump(ctx, metrics)
+	if err != nil {
+		return err
 	}
 
-	err = c.storage.RestoreFromDump(ctx, dump)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(staged.WriteDump(ctx, pw))
+	}()
+
+	err = c.storage.RestoreFromDump(ctx, pr)
+	pr.CloseWithError(err)
 	if err != nil {
-		logger.Log().Error(err.Error())
 		return err
 	}
 
+	c.silences.Restore(silences)
+
 	return nil
 }
 
-// startBackup периодическое сохранение метрик
+// writeSilences запись действующих заглушек алертов в дамп
+func (c *Collector) writeSilences(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	for _, s := range c.silences.List(time.Now()) {
+		rec, err := storage.NewExtraRecord(silencesSection, s)
+		if err != nil {
+			return fmt.Errorf("writeSilences: %w", err)
+		}
+
+		err = enc.Encode(rec)
+		if err != nil {
+			return fmt.Errorf("writeSilences: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// startBackup периодическое сохранение дампа метрик и очистка журнала обновлений
 func (c *Collector) startBackup() string {
-	// если обновление синхронное - не запускаем периодическое обновление
+	status, backupPeriod := "periodical", time.Duration(c.cfg.StoreInterval)*time.Second
+
+	// при синхронном сохранении обновления сразу пишутся в журнал, дамп нужен только для его сжатия
 	if c.cfg.StoreInterval == constants.BackupPeriodSync {
-		return "sync"
+		status, backupPeriod = "sync", constants.WALCompactInterval
 	}
 
 	// если файл не указан - не запускаем сохранение на диск
@@ -277,23 +757,172 @@ func (c *Collector) startBackup() string {
 		return "no"
 	}
 
-	backupPeriod := time.Duration(c.cfg.StoreInterval) * time.Second
+	c.every(backupPeriod, func() {
+		err := c.GenerateDump()
+		if err != nil {
+			logger.Log().Error(err.Error())
+		}
+	})
+
+	return status
+}
 
+// every периодический вызов f в фоне с интервалом interval до остановки Close
+func (c *Collector) every(interval time.Duration, f func()) {
+	c.wg.Add(1)
 	go func() {
-		for {
-			time.Sleep(backupPeriod)
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 
-			err := c.GenerateDump()
-			if err != nil {
-				logger.Log().Error(err.Error())
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				f()
 			}
 		}
 	}()
+}
+
+// Close остановка фоновых задач: сохранения дампа, расчета агрегатов, удаления устаревших серий,
+// записи собственных метрик хранилища и проверки правил алертинга.
+// Накопленные уведомления об алертах отправляются после остановки проверки правил. Выполняющаяся задача завершается до возврата
+func (c *Collector) Close() {
+	select {
+	case <-c.done:
+		return
+	default:
+		close(c.done)
+	}
 
-	return "periodical"
+	c.wg.Wait()
+
+	if c.notifier != nil {
+		c.notifier.Close()
+	}
+}
+
+// startAlerting загрузка правил алертинга и запуск их периодической проверки.
+// Если указан файл каналов уведомлений - запускается и рассылка уведомлений.
+func (c *Collector) startAlerting() error {
+	rules, err := alerting.LoadRules(c.cfg.AlertRulesPath)
+	if err != nil {
+		logger.Log().Error(err.Error())
+		return err
+	}
+
+	interval := c.cfg.AlertInterval
+	if interval <= 0 {
+		interval = constants.AlertInterval
+	}
+
+	var alertNotifier alerting.Notifier
+
+	if c.cfg.NotifiersPath != "" {
+		notifierCfg, errN := notifier.LoadConfig(c.cfg.NotifiersPath)
+		if errN != nil {
+			logger.Log().Error(errN.Error())
+			return errN
+		}
+
+		n, errN := notifier.NewNotifierFromConfig(notifierCfg)
+		if errN != nil {
+			logger.Log().Error(errN.Error())
+			return errN
+		}
+
+		n.Start()
+		c.notifier = n
+		alertNotifier = n
+	}
+
+	c.alerts = alerting.NewEngine(rules, c.storage, alertNotifier, c.silences)
+	c.every(time.Duration(interval)*time.Second, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
+		defer cancel()
+
+		c.alerts.Evaluate(ctx)
+	})
+
+	return nil
+}
+
+// GetAlerts список текущих алертов. Если алертинг не настроен - пустой список
+func (c *Collector) GetAlerts(ctx context.Context) ([]alerting.Alert, error) {
+	if c.alerts == nil {
+		return []alerting.Alert{}, nil
+	}
+
+	return c.alerts.Alerts(), nil
+}
+
+// GetSilences список действующих и будущих заглушек алертов
+func (c *Collector) GetSilences(ctx context.Context) ([]alerting.Silence, error) {
+	return c.silences.List(time.Now()), nil
+}
+
+// AddSilence добавление заглушки алертов. Возвращает заглушку с присвоенным ID
+func (c *Collector) AddSilence(ctx context.Context, silence alerting.Silence) (alerting.Silence, error) {
+	silence, err := c.silences.Add(silence, time.Now())
+	if err != nil {
+		return alerting.Silence{}, err
+	}
+
+	err = c.syncBackup()
+	if err != nil {
+		return alerting.Silence{}, err
+	}
+
+	return silence, nil
+}
+
+// DeleteSilence удаление заглушки алертов
+func (c *Collector) DeleteSilence(ctx context.Context, id string) error {
+	err := c.silences.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	return c.syncBackup()
+}
+
+// AcknowledgeAlert подтверждение сработавшего алерта
+func (c *Collector) AcknowledgeAlert(ctx context.Context, rule string, by string) error {
+	if c.alerts == nil {
+		return alerting.ErrAlertNotFound
+	}
+
+	return c.alerts.Acknowledge(rule, by)
+}
+
+// syncBackup сохранение дампа, если бэкап синхронный и указан файл
+func (c *Collector) syncBackup() error {
+	if c.cfg.StoreInterval == constants.BackupPeriodSync && c.cfg.FileStoragePath != "" {
+		return c.GenerateDump()
+	}
+
+	return nil
 }
 
 // DatabasePing проверка работоспособности СУБД
 func (c *Collector) DatabasePing(ctx context.Context) bool {
 	return c.storage.DatabasePing(ctx)
 }
+
+// Health состояние хранилища. Хранилище без собственного состояния (память, Postgresql) - по доступности СУБД
+func (c *Collector) Health(ctx context.Context) storage.Health {
+	if hs, ok := c.storage.(interface {
+		Health(ctx context.Context) storage.Health
+	}); ok {
+		return hs.Health(ctx)
+	}
+
+	if c.storage.DatabasePing(ctx) {
+		return storage.Health{Status: constants.HealthOK}
+	}
+
+	return storage.Health{Status: constants.HealthUnavailable}
+}
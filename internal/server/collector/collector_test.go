@@ -2,15 +2,25 @@ package collector
 
 import (
 	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
 
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
 	mock_collector "github.com/dnsoftware/go-metrics/internal/server/collector/mocks"
 	"github.com/dnsoftware/go-metrics/internal/server/config"
 	"github.com/dnsoftware/go-metrics/internal/storage"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewCollector(t *testing.T) {
@@ -69,6 +79,179 @@ func TestCollector_SetBatchMetrics(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCollector_WAL(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.ServerConfig{
+		StoreInterval:   constants.BackupPeriodSync,
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics-db.json"),
+		RestoreSaved:    true,
+	}
+
+	backupStorage, err := storage.NewBackupStorage(cfg.FileStoragePath)
+	require.NoError(t, err)
+
+	c, err := NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+
+	require.NoError(t, c.SetGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, c.GenerateDump())
+
+	// обновления после дампа - только в журнале
+	require.NoError(t, c.SetGaugeMetric(ctx, "Alloc", 2))
+	require.NoError(t, c.SetCounterMetric(ctx, "PollCount", 3))
+	require.NoError(t, c.SetCounterMetric(ctx, "PollCount", 4))
+	require.NoError(t, c.ObserveHistogramMetric(ctx, "latency", 0.3))
+	require.NoError(t, c.SetBatchMetrics(ctx, []byte(`[{"id":"Frees","type":"gauge","labels":{"host":"a"},"value":5},{"id":"PollCount","type":"counter","delta":1}]`)))
+	require.NoError(t, c.SetGaugeSamples(ctx, map[string][]storage.Sample{
+		`up{job="api"}`: {{Timestamp: 2000, Value: 1}, {Timestamp: 1000, Value: 0}},
+	}))
+	require.NoError(t, backupStorage.Close())
+
+	// восстановление: дамп и журнал
+	backupStorage, err = storage.NewBackupStorage(cfg.FileStoragePath)
+	require.NoError(t, err)
+	defer backupStorage.Close()
+
+	c, err = NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+
+	gauges, counters, err := c.GetAllByTypes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"Alloc": 2, `Frees{host="a"}`: 5, `up{job="api"}`: 1}, gauges)
+	assert.Equal(t, map[string]int64{"PollCount": 8}, counters)
+
+	h, err := c.GetHistogramMetric(ctx, "latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), h.Count)
+}
+
+func TestCollector_WALConcurrent(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.ServerConfig{
+		StoreInterval:   constants.BackupPeriod,
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics-db.json"),
+		RestoreSaved:    true,
+	}
+
+	backupStorage, err := storage.NewBackupStorage(cfg.FileStoragePath)
+	require.NoError(t, err)
+
+	c, err := NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+
+	// параллельные обновления одних серий во время сохранения дампов
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				assert.NoError(t, c.SetGaugeMetric(ctx, "Alloc", float64(i*1000+j)))
+				assert.NoError(t, c.SetCounterMetric(ctx, "PollCount", 1))
+				assert.NoError(t, c.SetBatchMetrics(ctx, []byte(`[{"id":"Frees","type":"gauge","value":`+strconv.Itoa(j)+`},{"id":"Mallocs","type":"counter","delta":2}]`)))
+			}
+		}(i)
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, c.GenerateDump())
+	}
+	wg.Wait()
+
+	gauges, counters, err := c.GetAllByTypes(ctx)
+	require.NoError(t, err)
+	require.NoError(t, backupStorage.Close())
+
+	// восстановленные значения совпадают с последними записанными
+	backupStorage, err = storage.NewBackupStorage(cfg.FileStoragePath)
+	require.NoError(t, err)
+	defer backupStorage.Close()
+
+	c, err = NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+
+	restoredGauges, restoredCounters, err := c.GetAllByTypes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, gauges, restoredGauges)
+	assert.Equal(t, map[string]int64{"PollCount": 1600, "Mallocs": 3200}, restoredCounters)
+	assert.Equal(t, counters, restoredCounters)
+}
+
+func TestCollector_Close(t *testing.T) {
+	c, err := setup(t)
+	require.NoError(t, err)
+
+	var runs atomic.Int64
+	c.every(time.Millisecond, func() {
+		runs.Add(1)
+	})
+	require.Eventually(t, func() bool { return runs.Load() > 0 }, time.Second, time.Millisecond)
+
+	// после остановки фоновые задачи не вызываются, повторная остановка ничего не делает
+	c.Close()
+	stopped := runs.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load())
+	c.Close()
+}
+
+func TestCollector_BackupFallback(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.ServerConfig{
+		StoreInterval:   constants.BackupPeriod,
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics-db.json"),
+		RestoreSaved:    true,
+	}
+
+	backupStorage, err := storage.NewBackupStorage(cfg.FileStoragePath)
+	require.NoError(t, err)
+	defer backupStorage.Close()
+
+	c, err := NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+
+	require.NoError(t, c.SetGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, c.GenerateDump())
+	require.NoError(t, c.SetGaugeMetric(ctx, "Alloc", 2))
+	require.NoError(t, c.GenerateDump())
+
+	// последнее поколение повреждено - восстанавливается предыдущее
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath, []byte("garbage"), 0644))
+
+	c, err = NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+	val, err := c.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), val)
+
+	// запись журнала повреждена - последнее поколение отбрасывается целиком, журнал к предыдущему не применяется
+	require.NoError(t, c.SetGaugeMetric(ctx, "Frees", 5))
+	require.NoError(t, c.GenerateDump())
+	require.NoError(t, c.SetCounterMetric(ctx, "PollCount", 3))
+	wal, err := os.OpenFile(cfg.FileStoragePath+constants.WALSuffix, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = wal.WriteString(`{"op":"bogus","type":"gauge","name":"Frees"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	c, err = NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+	gauges, counters, err := c.GetAllByTypes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"Alloc": 1}, gauges)
+	assert.Empty(t, counters)
+
+	// все поколения повреждены - сервер стартует с пустой базой
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath, []byte("garbage"), 0644))
+	for gen := 1; gen < backupStorage.Generations(); gen++ {
+		require.NoError(t, os.WriteFile(cfg.FileStoragePath+"."+strconv.Itoa(gen), []byte("garbage"), 0644))
+	}
+
+	c, err = NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+	_, err = c.GetGaugeMetric(ctx, "Alloc")
+	assert.Error(t, err)
+}
+
 func TestCollector_GetMetric(t *testing.T) {
 	ctx := context.Background()
 	c, _ := setup(t)
@@ -127,8 +310,11 @@ func setup(t *testing.T) (*Collector, error) {
 
 	backupStorage := mock_collector.NewMockBackupStorage(ctrl)
 
-	backupStorage.EXPECT().Save(`{"gauges":{},"counters":{}}`).Return(nil).AnyTimes()
-	backupStorage.EXPECT().Load().Return(`{"gauges":{},"counters":{}}`, nil).AnyTimes()
+	backupStorage.EXPECT().Save(gomock.Any()).Return(nil).AnyTimes()
+	backupStorage.EXPECT().Load(0).DoAndReturn(func(gen int) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("")), nil
+	}).AnyTimes()
+	backupStorage.EXPECT().Generations().Return(1).AnyTimes()
 
 	var (
 		repo    ServerStorage
@@ -172,5 +358,220 @@ func TestCollector_DatabasePing(t *testing.T) {
 
 	p := collect.DatabasePing(ctx)
 	assert.False(t, p)
+	assert.Equal(t, storage.Health{Status: constants.HealthUnavailable}, collect.Health(ctx))
+
+}
+
+func TestCollector_Alerts(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.ServerConfig{AlertRulesPath: "../alerting/testdata/rules.yaml"}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	backupStorage := mock_collector.NewMockBackupStorage(ctrl)
+	repo := storage.NewMemStorage()
+
+	collect, err := NewCollector(cfg, repo, backupStorage)
+	assert.NoError(t, err)
+
+	alerts, err := collect.GetAlerts(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+
+	err = collect.SetCounterMetric(ctx, constants.PollCount, 150)
+	assert.NoError(t, err)
+	collect.alerts.Evaluate(ctx)
 
+	alerts, err = collect.GetAlerts(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, constants.AlertStateFiring, alerts[0].State)
+
+	// правила проверяются в фоне до остановки коллектора
+	collect, err = NewCollector(&config.ServerConfig{AlertRulesPath: cfg.AlertRulesPath, AlertInterval: 1}, repo, backupStorage)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		alerts, _ = collect.GetAlerts(ctx)
+		return len(alerts) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	collect.Close()
+
+	// без правил алертинг выключен
+	collect, _ = NewCollector(&config.ServerConfig{}, repo, backupStorage)
+	alerts, err = collect.GetAlerts(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+
+	// ошибка в файле правил
+	_, err = NewCollector(&config.ServerConfig{AlertRulesPath: "../alerting/testdata/bad_rules.yaml"}, repo, backupStorage)
+	assert.Error(t, err)
+
+	// с каналами уведомлений
+	_, err = NewCollector(&config.ServerConfig{AlertRulesPath: "../alerting/testdata/rules.yaml", NotifiersPath: "../notifier/testdata/notifiers.yaml"}, repo, backupStorage)
+	assert.NoError(t, err)
+
+	_, err = NewCollector(&config.ServerConfig{AlertRulesPath: "../alerting/testdata/rules.yaml", NotifiersPath: "../notifier/testdata/bad_notifiers.yaml"}, repo, backupStorage)
+	assert.Error(t, err)
+}
+
+func TestCollector_InfluxRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	backupStorage := mock_collector.NewMockBackupStorage(ctrl)
+	repo := storage.NewMemStorage()
+
+	collect, err := NewCollector(&config.ServerConfig{InfluxRulesPath: "../influx/testdata/rules.yaml"}, repo, backupStorage)
+	require.NoError(t, err)
+	assert.Equal(t, constants.Counter, collect.InfluxRules().Type("net", "bytes_recv"))
+
+	// без файла правил все поля gauge
+	collect, err = NewCollector(&config.ServerConfig{}, repo, backupStorage)
+	require.NoError(t, err)
+	assert.Equal(t, constants.Gauge, collect.InfluxRules().Type("net", "bytes_recv"))
+
+	_, err = NewCollector(&config.ServerConfig{InfluxRulesPath: "../influx/testdata/bad_rules.yaml"}, repo, backupStorage)
+	assert.Error(t, err)
+}
+
+func TestCollector_SilencesDump(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.ServerConfig{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var saved string
+
+	backupStorage := mock_collector.NewMockBackupStorage(ctrl)
+	backupStorage.EXPECT().Save(gomock.Any()).DoAndReturn(func(write func(w io.Writer) error) error {
+		var buf strings.Builder
+		err := write(&buf)
+		saved = buf.String()
+		return err
+	}).AnyTimes()
+	backupStorage.EXPECT().Load(0).DoAndReturn(func(gen int) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(saved)), nil
+	}).AnyTimes()
+	backupStorage.EXPECT().Generations().Return(1).AnyTimes()
+
+	collect, err := NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	assert.NoError(t, err)
+
+	silence, err := collect.AddSilence(ctx, alerting.Silence{Matcher: "Heap*", EndsAt: time.Now().Add(time.Hour)})
+	assert.NoError(t, err)
+
+	_, err = collect.AddSilence(ctx, alerting.Silence{Matcher: "Heap*"})
+	assert.Error(t, err)
+
+	err = collect.GenerateDump()
+	assert.NoError(t, err)
+	assert.Contains(t, saved, silence.ID)
+
+	// заглушки восстанавливаются из дампа вместе с метриками
+	restored, err := NewCollector(&config.ServerConfig{RestoreSaved: true}, storage.NewMemStorage(), backupStorage)
+	assert.NoError(t, err)
+
+	silences, err := restored.GetSilences(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, silences, 1)
+	assert.Equal(t, silence.ID, silences[0].ID)
+
+	err = restored.DeleteSilence(ctx, silence.ID)
+	assert.NoError(t, err)
+	err = restored.DeleteSilence(ctx, silence.ID)
+	assert.Error(t, err)
+
+	err = restored.AcknowledgeAlert(ctx, "HighAlloc", "admin")
+	assert.ErrorIs(t, err, alerting.ErrAlertNotFound)
+}
+
+func TestCollector_Histogram(t *testing.T) {
+	ctx := context.Background()
+	c, err := setup(t)
+	assert.NoError(t, err)
+
+	// первое наблюдение создает гистограмму с границами по умолчанию
+	err = c.ObserveHistogramMetric(ctx, "Latency", 0.3)
+	assert.NoError(t, err)
+
+	h, err := c.GetHistogramMetric(ctx, "Latency")
+	assert.NoError(t, err)
+	bounds, _ := storage.ParseBounds(constants.HistogramBounds)
+	assert.Equal(t, bounds, h.Bounds)
+	assert.Equal(t, uint64(1), h.Count)
+
+	delta := storage.NewHistogram(bounds)
+	delta.Observe(7)
+	err = c.SetHistogramMetric(ctx, "Latency", delta)
+	assert.NoError(t, err)
+
+	err = c.SetHistogramMetric(ctx, "Latency", storage.NewHistogram([]float64{1}))
+	assert.ErrorIs(t, err, storage.ErrBadHistogram)
+
+	m, err := c.GetMetric(ctx, constants.Histogram, "Latency")
+	assert.NoError(t, err)
+	assert.Contains(t, m, `"count":2`)
+
+	all, err := c.GetAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "Latency: count=2 sum=7.300000\n", all)
+
+	histograms, err := c.GetAllHistograms(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, histograms, 1)
+
+	// настроенные границы корзин
+	c, err = NewCollector(&config.ServerConfig{HistogramBounds: "1,2"}, storage.NewMemStorage(), nil)
+	assert.NoError(t, err)
+	err = c.ObserveHistogramMetric(ctx, "Latency", 1.5)
+	assert.NoError(t, err)
+	h, _ = c.GetHistogramMetric(ctx, "Latency")
+	assert.Equal(t, []uint64{0, 1, 0}, h.Counts)
+
+	_, err = NewCollector(&config.ServerConfig{HistogramBounds: "2,1"}, storage.NewMemStorage(), nil)
+	assert.Error(t, err)
+}
+
+func TestCollector_Summary(t *testing.T) {
+	ctx := context.Background()
+	c, err := setup(t)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 100; i++ {
+		err = c.ObserveSummaryMetric(ctx, "Latency", float64(i))
+		assert.NoError(t, err)
+	}
+
+	delta := storage.NewSummary(0)
+	delta.Observe(1000)
+	err = c.SetSummaryMetric(ctx, "Latency", delta)
+	assert.NoError(t, err)
+
+	err = c.SetSummaryMetric(ctx, "Latency", storage.NewSummary(0.1))
+	assert.ErrorIs(t, err, storage.ErrBadSummary)
+
+	stats, err := c.GetSummaryStats(ctx, "Latency", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(101), stats.Count)
+	assert.Equal(t, float64(1000), stats.Max)
+	assert.InEpsilon(t, 51, stats.Quantiles["0.5"], 0.01)
+	assert.InEpsilon(t, 91, stats.Quantiles["0.9"], 0.01)
+	assert.Len(t, stats.Quantiles, 3)
+
+	stats, err = c.GetSummaryStats(ctx, "Latency", []float64{0.25})
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 26, stats.Quantiles["0.25"], 0.01)
+
+	m, err := c.GetMetric(ctx, constants.Summary, "Latency")
+	assert.NoError(t, err)
+	assert.Contains(t, m, `"count":101`)
+
+	all, err := c.GetAll(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, all, "Latency: count=101 sum=6050.000000 p50=")
+
+	summaries, err := c.GetAllSummaries(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, summaries, 1)
+
+	_, err = c.GetSummaryStats(ctx, "NoSuch", nil)
+	assert.Error(t, err)
 }
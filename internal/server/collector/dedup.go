@@ -0,0 +1,118 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// batchDedup окно дедупликации пакетов метрик по идентификатору.
+// Хранит не более size последних идентификаторов, каждый не дольше ttl.
+type batchDedup struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*dedupEntry
+	order   []dedupItem // пакеты в порядке поступления, для вытеснения старых
+}
+
+type dedupItem struct {
+	id    string
+	entry *dedupEntry
+}
+
+// dedupEntry состояние пакета: done закрывается после завершения обработки
+type dedupEntry struct {
+	done    chan struct{}
+	applied bool
+	at      time.Time
+}
+
+func newBatchDedup(size int, ttl time.Duration) *batchDedup {
+	return &batchDedup{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// Do выполняет apply, если пакет с идентификатором id еще не был применен.
+// Возвращает true, если пакет уже применялся и apply не вызывалась.
+// Параллельный повтор пакета ожидает завершения первой обработки.
+// При ошибке apply идентификатор забывается, чтобы повтор мог примениться.
+func (d *batchDedup) Do(ctx context.Context, id string, apply func() error) (bool, error) {
+	if id == "" {
+		return false, apply()
+	}
+
+	var entry *dedupEntry
+	for {
+		d.mutex.Lock()
+		now := time.Now()
+		d.evict(now)
+
+		e, ok := d.entries[id]
+		if !ok {
+			entry = &dedupEntry{done: make(chan struct{}), at: now}
+			d.entries[id] = entry
+			d.order = append(d.order, dedupItem{id: id, entry: entry})
+			d.mutex.Unlock()
+			break
+		}
+		d.mutex.Unlock()
+
+		select {
+		case <-e.done:
+			if e.applied {
+				return true, nil
+			}
+			// первая обработка завершилась ошибкой, пробуем применить сами
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	err := apply()
+
+	d.mutex.Lock()
+	if err != nil {
+		delete(d.entries, id)
+	} else {
+		entry.applied = true
+	}
+	close(entry.done)
+	d.mutex.Unlock()
+
+	return false, err
+}
+
+// evict удаляет устаревшие идентификаторы и вытесняет самые старые, освобождая место для нового
+func (d *batchDedup) evict(now time.Time) {
+	n := 0
+	for n < len(d.order) {
+		item := d.order[n]
+		// идентификатор мог быть забыт после ошибки и добавлен заново
+		current := d.entries[item.id] == item.entry
+		if current {
+			if len(d.order)-n < d.size && now.Sub(item.entry.at) < d.ttl {
+				break
+			}
+			// обрабатываемые пакеты не вытесняем, иначе повтор будет применен параллельно
+			if !isDone(item.entry) {
+				break
+			}
+			delete(d.entries, item.id)
+		}
+		n++
+	}
+	d.order = d.order[n:]
+}
+
+func isDone(e *dedupEntry) bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
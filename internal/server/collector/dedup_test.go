@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchDedup(t *testing.T) {
+	ctx := context.Background()
+	var applied int
+	apply := func() error {
+		applied++
+		return nil
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		d := newBatchDedup(10, time.Minute)
+		applied = 0
+
+		dup, err := d.Do(ctx, "a", apply)
+		require.NoError(t, err)
+		assert.False(t, dup)
+
+		dup, err = d.Do(ctx, "a", apply)
+		require.NoError(t, err)
+		assert.True(t, dup)
+
+		// пустой идентификатор не запоминается
+		_, _ = d.Do(ctx, "", apply)
+		_, _ = d.Do(ctx, "", apply)
+
+		assert.Equal(t, 3, applied)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		d := newBatchDedup(10, time.Minute)
+		applied = 0
+
+		errApply := errors.New("apply")
+		dup, err := d.Do(ctx, "a", func() error { return errApply })
+		require.ErrorIs(t, err, errApply)
+		assert.False(t, dup)
+
+		dup, err = d.Do(ctx, "a", apply)
+		require.NoError(t, err)
+		assert.False(t, dup)
+		assert.Equal(t, 1, applied)
+	})
+
+	t.Run("window", func(t *testing.T) {
+		d := newBatchDedup(2, time.Minute)
+		applied = 0
+
+		for _, id := range []string{"a", "b", "c", "a"} {
+			_, err := d.Do(ctx, id, apply)
+			require.NoError(t, err)
+		}
+		// "a" вытеснен из окна и применен повторно
+		assert.Equal(t, 4, applied)
+		assert.LessOrEqual(t, len(d.entries), 2)
+
+		d = newBatchDedup(10, time.Millisecond)
+		_, _ = d.Do(ctx, "a", apply)
+		time.Sleep(5 * time.Millisecond)
+		dup, _ := d.Do(ctx, "a", apply)
+		assert.False(t, dup)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		d := newBatchDedup(10, time.Minute)
+
+		var (
+			wg    sync.WaitGroup
+			count atomic.Int64
+			dups  atomic.Int64
+		)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dup, err := d.Do(ctx, "a", func() error {
+					count.Add(1)
+					time.Sleep(10 * time.Millisecond)
+					return nil
+				})
+				assert.NoError(t, err)
+				if dup {
+					dups.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int64(1), count.Load())
+		assert.Equal(t, int64(19), dups.Load())
+	})
+}
@@ -0,0 +1,219 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// historySeries серия с историей значений
+type historySeries struct {
+	mType string
+	key   string
+}
+
+// QueryRange история значений серии типа gauge или counter за период.
+// Уровень хранения выбирается по шагу и началу периода, в ответе - разрешение выбранного уровня (0 - исходные значения)
+func (c *Collector) QueryRange(ctx context.Context, q storage.RangeQuery) ([]storage.Sample, time.Duration, error) {
+	if q.MType != constants.Gauge && q.MType != constants.Counter {
+		return nil, 0, fmt.Errorf("%w: history is kept for gauge and counter only", storage.ErrBadRange)
+	}
+
+	if q.To.Before(q.From) {
+		return nil, 0, fmt.Errorf("%w: end of range is before start", storage.ErrBadRange)
+	}
+
+	if q.Step < 0 || (q.Step > 0 && q.Step < time.Millisecond) {
+		return nil, 0, fmt.Errorf("%w: bad step %s", storage.ErrBadRange, q.Step)
+	}
+
+	if q.Step > 0 && q.To.Sub(q.From)/q.Step >= time.Duration(constants.QueryRangeMaxPoints) {
+		return nil, 0, fmt.Errorf("%w: too many points, increase step", storage.ErrBadRange)
+	}
+
+	if q.Agg == "" {
+		q.Agg = constants.AggLast
+	}
+	if !storage.IsAgg(q.Agg) {
+		return nil, 0, fmt.Errorf("%w: bad aggregation %q", storage.ErrBadRange, q.Agg)
+	}
+
+	tier := c.pickTier(q.From, q.Step, time.Now())
+
+	// для первой точки нужны значения за шаг до начала периода
+	from := q.From.Add(-q.Step)
+
+	var data []storage.Rollup
+
+	if tier.Resolution == 0 {
+		samples, err := c.storage.QueryRange(ctx, q.MType, q.Name, from, q.To)
+		if err != nil {
+			return nil, 0, err
+		}
+		data = storage.SamplesToRollups(samples)
+	} else {
+		var err error
+
+		data, err = c.storage.QueryRollups(ctx, tier.Resolution, q.MType, q.Name, from, q.To)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return storage.Downsample(data, q.From, q.To, q.Step, q.Agg), tier.Resolution, nil
+}
+
+// pickTier уровень хранения истории для запроса с начала периода from с шагом step:
+// самый грубый из хранящих значения с начала периода уровней с разрешением не больше шага,
+// если таких нет - самый подробный из хранящих значения с начала периода,
+// если период начинается раньше всех сроков хранения - уровень с самым долгим сроком
+func (c *Collector) pickTier(from time.Time, step time.Duration, now time.Time) storage.Tier {
+	var (
+		best    *storage.Tier
+		longest = c.tiers[0]
+	)
+
+	for i := range c.tiers {
+		tier := c.tiers[i]
+
+		if tier.Retention > longest.Retention {
+			longest = tier
+		}
+
+		if now.Sub(from) > tier.Retention {
+			continue
+		}
+
+		switch {
+		case best == nil:
+			best = &c.tiers[i]
+		case tier.Resolution <= step:
+			// уровни упорядочены по возрастанию разрешения
+			best = &c.tiers[i]
+		}
+	}
+
+	if best == nil {
+		return longest
+	}
+
+	return *best
+}
+
+// RollupHistory расчет агрегатов истории за завершившиеся к моменту now интервалы каждого уровня
+// из значений предыдущего уровня и удаление истории старше срока хранения уровней
+func (c *Collector) RollupHistory(ctx context.Context, now time.Time) error {
+	c.rollupMutex.Lock()
+	defer c.rollupMutex.Unlock()
+
+	gauges, counters, err := c.storage.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	series := make([]historySeries, 0, len(gauges)+len(counters))
+	for key := range gauges {
+		series = append(series, historySeries{mType: constants.Gauge, key: key})
+	}
+	for key := range counters {
+		series = append(series, historySeries{mType: constants.Counter, key: key})
+	}
+
+	for i := 1; i < len(c.tiers); i++ {
+		tier, src := c.tiers[i], c.tiers[i-1]
+
+		// конец последнего завершившегося интервала, интервалы отсчитываются от начала unix времени
+		res := tier.Resolution.Milliseconds()
+		end := time.UnixMilli(now.UnixMilli() / res * res)
+
+		// после перезапуска расчет продолжается с последнего сохраненного агрегата серии,
+		// без агрегатов - за весь срок хранения предыдущего уровня
+		start, known := c.rolledUp[tier.Resolution]
+		if !known {
+			start = time.UnixMilli(end.Add(-src.Retention).UnixMilli() / res * res)
+		}
+
+		if !end.After(start) {
+			continue
+		}
+
+		for _, s := range series {
+			from := start
+			if !known {
+				from, err = c.lastRollup(ctx, tier, s, start, end)
+				if err != nil {
+					return err
+				}
+				if !end.After(from) {
+					continue
+				}
+			}
+
+			// интервалы [from, end): значение с временем end относится к еще не завершившемуся интервалу
+			var rollups []storage.Rollup
+
+			if src.Resolution == 0 {
+				samples, errQ := c.storage.QueryRange(ctx, s.mType, s.key, from, end.Add(-time.Millisecond))
+				if errQ != nil {
+					return errQ
+				}
+				rollups = storage.AggregateSamples(samples, tier.Resolution)
+			} else {
+				// агрегат предыдущего уровня с концом интервала e охватывает [e-resolution, e)
+				data, errQ := c.storage.QueryRollups(ctx, src.Resolution, s.mType, s.key, from.Add(time.Millisecond), end)
+				if errQ != nil {
+					return errQ
+				}
+				rollups = storage.Aggregate(data, tier.Resolution)
+			}
+
+			err = c.storage.AddRollups(ctx, tier.Resolution, s.mType, s.key, rollups)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.rolledUp[tier.Resolution] = end
+	}
+
+	for _, tier := range c.tiers {
+		err = c.storage.PruneHistory(ctx, tier.Resolution, now.Add(-tier.Retention))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lastRollup конец последнего сохраненного интервала уровня tier серии s в периоде (start, end],
+// без агрегатов - start
+func (c *Collector) lastRollup(ctx context.Context, tier storage.Tier, s historySeries, start, end time.Time) (time.Time, error) {
+	data, err := c.storage.QueryRollups(ctx, tier.Resolution, s.mType, s.key, start.Add(time.Millisecond), end)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(data) == 0 {
+		return start, nil
+	}
+
+	return time.UnixMilli(data[len(data)-1].Timestamp), nil
+}
+
+// startRollups периодический расчет агрегатов истории
+func (c *Collector) startRollups() {
+	c.every(constants.RollupInterval, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.RollupInterval)
+		err := c.RollupHistory(ctx, time.Now())
+		cancel()
+
+		if err != nil {
+			logger.Log().Error(err.Error())
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestCollector_QueryRange(t *testing.T) {
+	ctx := context.Background()
+	c, err := setup(t)
+	require.NoError(t, err)
+
+	from := time.Now().Add(-time.Minute)
+	err = c.SetGaugeMetric(ctx, "Alloc", 1)
+	assert.NoError(t, err)
+	err = c.SetGaugeMetric(ctx, "Alloc", 2)
+	assert.NoError(t, err)
+	to := time.Now().Add(time.Minute)
+
+	points, res, err := c.QueryRange(ctx, storage.RangeQuery{MType: constants.Gauge, Name: "Alloc", From: from, To: to})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), res)
+	assert.Len(t, points, 2)
+
+	// с шагом меньше разрешения агрегатов - исходные значения, последнее значение за шаг
+	points, res, err = c.QueryRange(ctx, storage.RangeQuery{MType: constants.Gauge, Name: "Alloc", From: from, To: to, Step: 45 * time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), res)
+	assert.Equal(t, []storage.Sample{{Timestamp: from.Add(90 * time.Second).UnixMilli(), Value: 2}}, points)
+
+	for _, q := range []storage.RangeQuery{
+		{MType: constants.Histogram, Name: "Alloc", From: from, To: to},
+		{MType: constants.Gauge, Name: "Alloc", From: to, To: from},
+		{MType: constants.Gauge, Name: "Alloc", From: from, To: to, Step: time.Millisecond},
+		{MType: constants.Gauge, Name: "Alloc", From: from, To: to, Agg: "median"},
+	} {
+		_, _, err = c.QueryRange(ctx, q)
+		assert.ErrorIs(t, err, storage.ErrBadRange)
+	}
+}
+
+func TestCollector_RollupHistory(t *testing.T) {
+	ctx := context.Background()
+	c, err := setup(t)
+	require.NoError(t, err)
+
+	now := time.Now()
+	for _, v := range []float64{1, 5, 3} {
+		err = c.SetGaugeMetric(ctx, "Alloc", v)
+		assert.NoError(t, err)
+	}
+	err = c.SetCounterMetric(ctx, "PollCount", 2)
+	assert.NoError(t, err)
+
+	err = c.RollupHistory(ctx, now.Add(2*time.Minute))
+	assert.NoError(t, err)
+
+	// повторный расчет за те же интервалы не выполняется
+	err = c.RollupHistory(ctx, now.Add(2*time.Minute))
+	assert.NoError(t, err)
+
+	q := storage.RangeQuery{MType: constants.Gauge, Name: "Alloc", From: now.Add(-10 * time.Minute), To: now.Add(2 * time.Minute), Step: time.Minute}
+
+	for agg, want := range map[string]float64{constants.AggMax: 5, constants.AggAvg: 3, constants.AggCount: 3, constants.AggLast: 3} {
+		q.Agg = agg
+
+		points, res, errQ := c.QueryRange(ctx, q)
+		assert.NoError(t, errQ)
+		assert.Equal(t, time.Minute, res)
+		require.Len(t, points, 1, agg)
+		assert.Equal(t, want, points[0].Value, agg)
+	}
+
+	q = storage.RangeQuery{MType: constants.Counter, Name: "PollCount", From: now.Add(-10 * time.Minute), To: now.Add(5 * time.Minute), Step: 5 * time.Minute}
+	points, res, err := c.QueryRange(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, res)
+	require.Len(t, points, 1)
+	assert.Equal(t, float64(2), points[0].Value)
+}
+
+func TestCollector_pickTier(t *testing.T) {
+	c, err := setup(t)
+	require.NoError(t, err)
+
+	now := time.Now()
+	day := 24 * time.Hour
+
+	assert.Equal(t, time.Duration(0), c.pickTier(now.Add(-time.Hour), 0, now).Resolution)
+	assert.Equal(t, time.Duration(0), c.pickTier(now.Add(-time.Hour), 30*time.Second, now).Resolution)
+	assert.Equal(t, time.Minute, c.pickTier(now.Add(-time.Hour), time.Minute, now).Resolution)
+	assert.Equal(t, time.Hour, c.pickTier(now.Add(-time.Hour), 2*time.Hour, now).Resolution)
+
+	// исходные значения за период уже не хранятся
+	assert.Equal(t, time.Minute, c.pickTier(now.Add(-2*day), 0, now).Resolution)
+	assert.Equal(t, time.Hour, c.pickTier(now.Add(-60*day), time.Minute, now).Resolution)
+	assert.Equal(t, time.Hour, c.pickTier(now.Add(-700*day), time.Minute, now).Resolution)
+}
+
+func TestCollector_RollupHistoryBounds(t *testing.T) {
+	ctx := context.Background()
+	c, err := setup(t)
+	require.NoError(t, err)
+
+	c.tiers = []storage.Tier{{Resolution: 0, Retention: time.Hour}, {Resolution: time.Millisecond, Retention: time.Hour}}
+
+	err = c.SetGaugeMetric(ctx, "Alloc", 7)
+	require.NoError(t, err)
+
+	samples, err := c.storage.QueryRange(ctx, constants.Gauge, "Alloc", time.Now().Add(-time.Minute), time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	ts := time.UnixMilli(samples[0].Timestamp)
+
+	query := func() []storage.Rollup {
+		data, errQ := c.storage.QueryRollups(ctx, time.Millisecond, constants.Gauge, "Alloc", ts.Add(-time.Minute), ts.Add(time.Minute))
+		require.NoError(t, errQ)
+		return data
+	}
+
+	// интервал, который завершает значение на границе, еще не закончился
+	require.NoError(t, c.RollupHistory(ctx, ts))
+	assert.Empty(t, query())
+
+	require.NoError(t, c.RollupHistory(ctx, ts.Add(time.Millisecond)))
+	assert.Equal(t, []storage.Rollup{{Timestamp: ts.UnixMilli() + 1, Count: 1, Sum: 7, Min: 7, Max: 7, Last: 7}}, query())
+
+	// после перезапуска уже рассчитанные интервалы не пересчитываются
+	marked := storage.Rollup{Timestamp: ts.UnixMilli() + 1, Count: 100}
+	require.NoError(t, c.storage.AddRollups(ctx, time.Millisecond, constants.Gauge, "Alloc", []storage.Rollup{marked}))
+	c.rolledUp = make(map[time.Duration]time.Time)
+
+	require.NoError(t, c.RollupHistory(ctx, ts.Add(2*time.Millisecond)))
+	assert.Equal(t, []storage.Rollup{marked}, query())
+}
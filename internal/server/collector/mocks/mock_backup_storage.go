@@ -5,8 +5,10 @@
 package mock_collector
 
 import (
+	io "io"
 	reflect "reflect"
 
+	storage "github.com/dnsoftware/go-metrics/internal/storage"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -80,21 +82,6 @@ func (mr *MockServerStorageMockRecorder) GetCounter(ctx, name interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCounter", reflect.TypeOf((*MockServerStorage)(nil).GetCounter), ctx, name)
 }
 
-// GetDump mocks base method.
-func (m *MockServerStorage) GetDump(ctx context.Context) (string, error) {
-	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetDump", ctx)
-	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
-}
-
-// GetDump indicates an expected call of GetDump.
-func (mr *MockServerStorageMockRecorder) GetDump(ctx interface{}) *gomock.Call {
-	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDump", reflect.TypeOf((*MockServerStorage)(nil).GetDump), ctx)
-}
-
 // GetGauge mocks base method.
 func (m *MockServerStorage) GetGauge(ctx context.Context, name string) (float64, error) {
 	m.ctrl.T.Helper()
@@ -110,18 +97,46 @@ func (mr *MockServerStorageMockRecorder) GetGauge(ctx, name interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGauge", reflect.TypeOf((*MockServerStorage)(nil).GetGauge), ctx, name)
 }
 
+// IncrementCounter mocks base method.
+func (m *MockServerStorage) IncrementCounter(ctx context.Context, name string, delta int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementCounter", ctx, name, delta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IncrementCounter indicates an expected call of IncrementCounter.
+func (mr *MockServerStorageMockRecorder) IncrementCounter(ctx, name, delta interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementCounter", reflect.TypeOf((*MockServerStorage)(nil).IncrementCounter), ctx, name, delta)
+}
+
+// IncrementCounters mocks base method.
+func (m *MockServerStorage) IncrementCounters(ctx context.Context, deltas map[string]int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementCounters", ctx, deltas)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IncrementCounters indicates an expected call of IncrementCounters.
+func (mr *MockServerStorageMockRecorder) IncrementCounters(ctx, deltas interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementCounters", reflect.TypeOf((*MockServerStorage)(nil).IncrementCounters), ctx, deltas)
+}
+
 // RestoreFromDump mocks base method.
-func (m *MockServerStorage) RestoreFromDump(ctx context.Context, dump string) error {
+func (m *MockServerStorage) RestoreFromDump(ctx context.Context, r io.Reader) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RestoreFromDump", ctx, dump)
+	ret := m.ctrl.Call(m, "RestoreFromDump", ctx, r)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RestoreFromDump indicates an expected call of RestoreFromDump.
-func (mr *MockServerStorageMockRecorder) RestoreFromDump(ctx, dump interface{}) *gomock.Call {
+func (mr *MockServerStorageMockRecorder) RestoreFromDump(ctx, r interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreFromDump", reflect.TypeOf((*MockServerStorage)(nil).RestoreFromDump), ctx, dump)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreFromDump", reflect.TypeOf((*MockServerStorage)(nil).RestoreFromDump), ctx, r)
 }
 
 // SetBatch mocks base method.
@@ -166,6 +181,19 @@ func (mr *MockServerStorageMockRecorder) SetGauge(ctx, name, value interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetGauge", reflect.TypeOf((*MockServerStorage)(nil).SetGauge), ctx, name, value)
 }
 
+// WriteDump mocks base method.
+func (m *MockServerStorage) WriteDump(ctx context.Context, w io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteDump", ctx, w)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteDump indicates an expected call of WriteDump.
+func (mr *MockServerStorageMockRecorder) WriteDump(ctx, w interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteDump", reflect.TypeOf((*MockServerStorage)(nil).WriteDump), ctx, w)
+}
 */
 
 // MockBackupStorage is a mock of BackupStorage interface.
@@ -191,31 +219,63 @@ func (m *MockBackupStorage) EXPECT() *MockBackupStorageMockRecorder {
 	return m.recorder
 }
 
+// Append mocks base method.
+func (m *MockBackupStorage) Append(records ...storage.DumpRecord) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range records {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Append", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Append indicates an expected call of Append.
+func (mr *MockBackupStorageMockRecorder) Append(records ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Append", reflect.TypeOf((*MockBackupStorage)(nil).Append), records...)
+}
+
+// Generations mocks base method.
+func (m *MockBackupStorage) Generations() int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Generations")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// Generations indicates an expected call of Generations.
+func (mr *MockBackupStorageMockRecorder) Generations() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Generations", reflect.TypeOf((*MockBackupStorage)(nil).Generations))
+}
+
 // Load mocks base method.
-func (m *MockBackupStorage) Load() (string, error) {
+func (m *MockBackupStorage) Load(gen int) (io.ReadCloser, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Load")
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Load", gen)
+	ret0, _ := ret[0].(io.ReadCloser)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Load indicates an expected call of Load.
-func (mr *MockBackupStorageMockRecorder) Load() *gomock.Call {
+func (mr *MockBackupStorageMockRecorder) Load(gen interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Load", reflect.TypeOf((*MockBackupStorage)(nil).Load))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Load", reflect.TypeOf((*MockBackupStorage)(nil).Load), gen)
 }
 
 // Save mocks base method.
-func (m *MockBackupStorage) Save(dump string) error {
+func (m *MockBackupStorage) Save(write func(io.Writer) error) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Save", dump)
+	ret := m.ctrl.Call(m, "Save", write)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Save indicates an expected call of Save.
-func (mr *MockBackupStorageMockRecorder) Save(dump interface{}) *gomock.Call {
+func (mr *MockBackupStorageMockRecorder) Save(write interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockBackupStorage)(nil).Save), dump)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockBackupStorage)(nil).Save), write)
 }
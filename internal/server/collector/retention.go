@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// ErrBadRetention некорректные сроки хранения серий
+var ErrBadRetention = errors.New("bad retention")
+
+// RetentionRule срок хранения необновляемых серий, название которых начинается с Prefix (0 - без ограничения)
+type RetentionRule struct {
+	Prefix string
+	TTL    time.Duration
+}
+
+// ParseRetention разбор сроков хранения из строки вида "host_:7d,tmp_:1h" (пустая строка - без правил)
+func ParseRetention(s string) ([]RetentionRule, error) {
+	var rules []RetentionRule
+
+	if strings.TrimSpace(s) == "" {
+		return rules, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+
+		// префикс может содержать двоеточие, срок - нет
+		i := strings.LastIndexByte(item, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: bad rule %q", ErrBadRetention, item)
+		}
+
+		ttl, err := storage.ParseLongDuration(item[i+1:])
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("%w: bad ttl in rule %q", ErrBadRetention, item)
+		}
+
+		rules = append(rules, RetentionRule{Prefix: item[:i], TTL: ttl})
+	}
+
+	return rules, nil
+}
+
+// seriesTTL срок хранения серии: по правилу с самым длинным подходящим префиксом, иначе - общий
+func (c *Collector) seriesTTL(key string) time.Duration {
+	ttl, prefix := c.staleTTL, -1
+
+	for _, rule := range c.retention {
+		if strings.HasPrefix(key, rule.Prefix) && len(rule.Prefix) > prefix {
+			ttl, prefix = rule.TTL, len(rule.Prefix)
+		}
+	}
+
+	return ttl
+}
+
+// SweepStale удаление серий, не обновлявшихся дольше срока хранения, к моменту now.
+// Возвращает устаревшие серии; в режиме dry-run они только выводятся в лог.
+// Удаление записывается в журнал резервной копии, если она ведется
+func (c *Collector) SweepStale(ctx context.Context, now time.Time) ([]storage.SeriesInfo, error) {
+	series, err := c.storage.ListSeries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stale := make([]storage.SeriesInfo, 0)
+
+	for _, s := range series {
+		ttl := c.seriesTTL(s.Name)
+		if ttl == 0 || now.Sub(s.UpdatedAt) < ttl {
+			continue
+		}
+
+		if c.cfg.SweepDryRun {
+			logger.Log().Info("stale series (dry run)",
+				zap.String("type", s.MType),
+				zap.String("series", s.Name),
+				zap.Time("updated", s.UpdatedAt),
+			)
+			stale = append(stale, s)
+
+			continue
+		}
+
+		// серия могла обновиться после получения списка
+		deleted, err := c.deleteSeries(ctx, s, now.Add(-ttl))
+		if err != nil {
+			return nil, err
+		}
+
+		if deleted {
+			logger.Log().Info("stale series removed",
+				zap.String("type", s.MType),
+				zap.String("series", s.Name),
+				zap.Time("updated", s.UpdatedAt),
+			)
+			stale = append(stale, s)
+		}
+	}
+
+	return stale, nil
+}
+
+// deleteSeries удаление серии, не обновлявшейся с момента updatedBefore, с записью в журнал резервной копии
+func (c *Collector) deleteSeries(ctx context.Context, s storage.SeriesInfo, updatedBefore time.Time) (bool, error) {
+	defer c.lockSeries(historySeries{mType: s.MType, key: s.Name})()
+
+	deleted, err := c.storage.DeleteSeries(ctx, s.MType, s.Name, updatedBefore)
+	if err != nil || !deleted || c.cfg.FileStoragePath == "" {
+		return deleted, err
+	}
+
+	return true, c.backupStorage.Append(storage.NewDeleteRecord(s.MType, s.Name))
+}
+
+// startSweeper периодическое удаление устаревших серий, если заданы сроки хранения
+func (c *Collector) startSweeper() {
+	if c.staleTTL == 0 && len(c.retention) == 0 {
+		return
+	}
+
+	c.every(constants.SweepInterval, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.SweepInterval)
+		_, err := c.SweepStale(ctx, time.Now())
+		cancel()
+
+		if err != nil {
+			logger.Log().Error(err.Error())
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	mock_collector "github.com/dnsoftware/go-metrics/internal/server/collector/mocks"
+	"github.com/dnsoftware/go-metrics/internal/server/config"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestParseRetention(t *testing.T) {
+	rules, err := ParseRetention(" host_:7d, tmp_:1h,a:b:0 ")
+	require.NoError(t, err)
+	assert.Equal(t, []RetentionRule{{Prefix: "host_", TTL: 7 * 24 * time.Hour}, {Prefix: "tmp_", TTL: time.Hour}, {Prefix: "a:b", TTL: 0}}, rules)
+
+	rules, err = ParseRetention("")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	for _, bad := range []string{"host_", ":1h", "host_:x", "host_:-1h"} {
+		_, err = ParseRetention(bad)
+		assert.ErrorIs(t, err, ErrBadRetention, bad)
+	}
+}
+
+func TestCollector_SweepStale(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	backupStorage := mock_collector.NewMockBackupStorage(ctrl)
+
+	cfg := &config.ServerConfig{StaleTTL: "1h", Retention: "tmp_:1m,tmp_keep_:0", SweepDryRun: true}
+	c, err := NewCollector(cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Hour, c.seriesTTL("Alloc"))
+	assert.Equal(t, time.Minute, c.seriesTTL(`tmp_x{host="a"}`))
+	assert.Equal(t, time.Duration(0), c.seriesTTL("tmp_keep_x"))
+
+	for _, name := range []string{"Alloc", "tmp_x", "tmp_keep_x"} {
+		err = c.SetGaugeMetric(ctx, name, 1)
+		require.NoError(t, err)
+	}
+
+	// ничего не устарело
+	stale, err := c.SweepStale(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+
+	// dry-run: устаревшие серии не удаляются, резервная копия не обновляется
+	stale, err = c.SweepStale(ctx, time.Now().Add(10*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	assert.Equal(t, "tmp_x", stale[0].Name)
+	_, err = c.GetGaugeMetric(ctx, "tmp_x")
+	assert.NoError(t, err)
+
+	// удаление записывается в журнал резервной копии
+	c.cfg.SweepDryRun = false
+	c.cfg.FileStoragePath = "/tmp/metrics-db.json"
+	backupStorage.EXPECT().Append(storage.NewDeleteRecord(constants.Gauge, "Alloc")).Return(nil).Times(1)
+	backupStorage.EXPECT().Append(storage.NewDeleteRecord(constants.Gauge, "tmp_x")).Return(nil).Times(1)
+	stale, err = c.SweepStale(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, stale, 2)
+
+	gauges, _, err := c.GetAllByTypes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"tmp_keep_x": 1}, gauges)
+
+	_, err = NewCollector(&config.ServerConfig{StaleTTL: "week"}, storage.NewMemStorage(), backupStorage)
+	assert.ErrorIs(t, err, ErrBadRetention)
+	_, err = NewCollector(&config.ServerConfig{Retention: "tmp_"}, storage.NewMemStorage(), backupStorage)
+	assert.ErrorIs(t, err, ErrBadRetention)
+}
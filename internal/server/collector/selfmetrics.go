@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+)
+
+// selfMetricsSource хранилище с собственными счетчиками (кеш чтения)
+type selfMetricsSource interface {
+	// SelfMetrics итоговые значения счетчиков по названиям метрик
+	SelfMetrics() map[string]int64
+}
+
+// startSelfMetrics периодическая запись собственных счетчиков хранилища в метрики сервера
+func (c *Collector) startSelfMetrics() {
+	source, ok := c.storage.(selfMetricsSource)
+	if !ok {
+		return
+	}
+
+	reported := make(map[string]int64)
+	c.every(constants.SelfMetricsInterval, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.SelfMetricsInterval)
+		err := c.reportSelfMetrics(ctx, source, reported)
+		cancel()
+
+		if err != nil {
+			logger.Log().Error(err.Error())
+		}
+	})
+}
+
+// reportSelfMetrics запись приращений собственных счетчиков хранилища с прошлой записи.
+// Параметры: reported - уже записанные значения, обновляются после записи.
+func (c *Collector) reportSelfMetrics(ctx context.Context, source selfMetricsSource, reported map[string]int64) error {
+	deltas := make(map[string]int64)
+	for name, value := range source.SelfMetrics() {
+		if delta := value - reported[name]; delta > 0 {
+			deltas[name] = delta
+		}
+	}
+
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	series := make([]historySeries, 0, len(deltas))
+	for name := range deltas {
+		series = append(series, historySeries{mType: constants.Counter, key: name})
+	}
+
+	defer c.lockSeries(series...)()
+
+	err := c.storage.IncrementCounters(ctx, deltas)
+	if err != nil {
+		return err
+	}
+
+	for name, delta := range deltas {
+		reported[name] += delta
+	}
+
+	return c.appendWAL(ctx, series...)
+}
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	mock_collector "github.com/dnsoftware/go-metrics/internal/server/collector/mocks"
+	"github.com/dnsoftware/go-metrics/internal/server/config"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestCollector_ReportSelfMetrics(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	backupStorage := mock_collector.NewMockBackupStorage(ctrl)
+
+	cache := storage.NewCachedStorage(storage.NewMemStorage(), storage.CacheConfig{Size: 10, TTL: time.Minute}, nil)
+	defer cache.Close()
+
+	collect, err := NewCollector(&config.ServerConfig{}, cache, backupStorage)
+	require.NoError(t, err)
+
+	require.NoError(t, collect.SetGaugeMetric(ctx, "Alloc", 1))
+	_, _ = collect.GetGaugeMetric(ctx, "Alloc")
+	_, _ = collect.GetGaugeMetric(ctx, "Missing")
+
+	reported := make(map[string]int64)
+	err = collect.reportSelfMetrics(ctx, cache, reported)
+	require.NoError(t, err)
+
+	// значение записано в кеш при обновлении, промах - по отсутствующей метрике
+	hits, err := collect.GetCounterMetric(ctx, constants.CacheHitsMetric)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), hits)
+
+	misses, err := collect.GetCounterMetric(ctx, constants.CacheMissesMetric)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), misses)
+
+	// записываются только приращения с прошлой записи, чтение счетчиков выше - тоже промахи
+	err = collect.reportSelfMetrics(ctx, cache, reported)
+	require.NoError(t, err)
+
+	misses, err = collect.GetCounterMetric(ctx, constants.CacheMissesMetric)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), misses)
+}
@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"sync"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// walLocks блокировки серий при записи в журнал резервной копии.
+// Изменение серии в хранилище и запись ее итогового значения в журнал выполняются под одной блокировкой,
+// поэтому записи серии идут в журнал в порядке ее обновлений
+type walLocks struct {
+	seed    maphash.Seed
+	mutexes [constants.WALLockStripes]sync.Mutex
+}
+
+func newWALLocks() *walLocks {
+	return &walLocks{seed: maphash.MakeSeed()}
+}
+
+// lock блокировка серий по возрастанию номера блокировки, чтобы параллельные пакеты не блокировали друг друга взаимно.
+// Возвращает функцию разблокировки
+func (l *walLocks) lock(series ...historySeries) func() {
+	var used [constants.WALLockStripes]bool
+	for _, s := range series {
+		used[maphash.String(l.seed, s.key)%uint64(len(l.mutexes))] = true
+	}
+
+	locked := make([]*sync.Mutex, 0, len(series))
+	for i, ok := range used {
+		if ok {
+			l.mutexes[i].Lock()
+			locked = append(locked, &l.mutexes[i])
+		}
+	}
+
+	return func() {
+		for _, m := range locked {
+			m.Unlock()
+		}
+	}
+}
+
+// lockSeries блокировка серий на время изменения и записи в журнал, если указан файл.
+// Возвращает функцию разблокировки
+func (c *Collector) lockSeries(series ...historySeries) func() {
+	if c.cfg.FileStoragePath == "" {
+		return func() {}
+	}
+
+	return c.walLocks.lock(series...)
+}
+
+// appendWAL запись итоговых значений обновленных серий в журнал резервной копии, если указан файл.
+// Вызывается под блокировкой серий lockSeries
+func (c *Collector) appendWAL(ctx context.Context, series ...historySeries) error {
+	if c.cfg.FileStoragePath == "" {
+		return nil
+	}
+
+	records, err := c.walRecords(ctx, series...)
+	if err != nil {
+		return err
+	}
+
+	return c.backupStorage.Append(records...)
+}
+
+// walRecords записи журнала с текущими значениями серий
+func (c *Collector) walRecords(ctx context.Context, series ...historySeries) ([]storage.DumpRecord, error) {
+	records := make([]storage.DumpRecord, 0, len(series))
+	for _, s := range series {
+		var (
+			value any
+			err   error
+		)
+
+		switch s.mType {
+		case constants.Gauge:
+			value, err = c.storage.GetGauge(ctx, s.key)
+		case constants.Counter:
+			value, err = c.storage.GetCounter(ctx, s.key)
+		case constants.Histogram:
+			value, err = c.storage.GetHistogram(ctx, s.key)
+		case constants.Summary:
+			value, err = c.storage.GetSummary(ctx, s.key)
+		default:
+			err = fmt.Errorf("bad metric type %q", s.mType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("appendWAL: %w", err)
+		}
+
+		record, err := storage.NewSetRecord(s.mType, s.key, value)
+		if err != nil {
+			return nil, fmt.Errorf("appendWAL: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
@@ -21,7 +21,28 @@ type ServerConfig struct {
 	AsymCertKeyPath string `env:"CRYPTO_CERT"` // путь к файлу с публичным асимметричным ключом
 	AsymPrivKeyPath string `env:"CRYPTO_KEY"`  // путь к файлу с приватным асимметричным ключом
 	TrustedSubnet   string `env:"TRUSTED_SUBNET"`
-	GrpcAddress     string `env:"GRPC_ADDRESS"` // адрес:порт на котором работает gRPC сервер
+	GrpcAddress     string `env:"GRPC_ADDRESS"`                       // адрес:порт на котором работает gRPC сервер
+	AlertRulesPath  string `env:"ALERT_RULES"`                        // путь к файлу с правилами алертинга
+	AlertInterval   int64  `env:"ALERT_INTERVAL" envDefault:"-1"`     // интервал проверки правил алертинга в секундах
+	NotifiersPath   string `env:"NOTIFIERS"`                          // путь к файлу с каналами уведомлений об алертах
+	HistogramBounds string `env:"HISTOGRAM_BUCKETS"`                  // границы корзин гистограмм по умолчанию, через запятую
+	HistorySize     int    `env:"HISTORY_SIZE" envDefault:"-1"`       // количество хранимых в памяти значений каждой серии
+	HistoryTiers    string `env:"HISTORY_TIERS"`                      // уровни хранения истории, вида raw:24h,1m:30d,1h:365d
+	StaleTTL        string `env:"STALE_TTL"`                          // срок хранения необновляемых серий, пусто - без ограничения
+	Retention       string `env:"RETENTION"`                          // сроки хранения серий по префиксу названия, вида host_:7d,tmp_:1h
+	SweepDryRun     bool   `env:"SWEEP_DRY_RUN"`                      // устаревшие серии только выводятся в лог, но не удаляются
+	WALSync         string `env:"WAL_FSYNC"`                          // политика fsync журнала резервной копии: always, interval или never
+	BackupGens      int    `env:"BACKUP_GENERATIONS" envDefault:"-1"` // количество хранимых поколений резервной копии
+	DBMaxConns      int    `env:"DB_MAX_CONNS" envDefault:"-1"`       // размер пула соединений с БД, 0 - по умолчанию pgx
+	DBStmtTimeout   string `env:"DB_STATEMENT_TIMEOUT"`               // ограничение времени выполнения запроса в БД, пусто - без ограничения
+	CacheSize       int    `env:"CACHE_SIZE" envDefault:"-1"`         // кол-во серий в кеше чтения БД, 0 - без кеша (гибридное хранилище)
+	CacheTTL        string `env:"CACHE_TTL"`                          // срок жизни значения в кеше чтения БД
+	InfluxRulesPath string `env:"INFLUX_RULES"`                       // путь к файлу с правилами сопоставления полей InfluxDB метрикам
+	StatsdAddress   string `env:"STATSD_ADDRESS"`                     // адрес:порт UDP для приема StatsD, пусто - прием выключен
+	StatsdTCP       string `env:"STATSD_TCP_ADDRESS"`                 // адрес:порт TCP для приема StatsD, пусто - прием выключен
+	StatsdFlush     string `env:"STATSD_FLUSH_INTERVAL"`              // окно агрегации StatsD перед записью в хранилище
+	GraphiteAddress string `env:"GRAPHITE_ADDRESS"`                   // адрес:порт TCP для приема Graphite plaintext, пусто - прием выключен
+	GraphiteRules   string `env:"GRAPHITE_RULES"`                     // путь к файлу с правилами сопоставления путей Graphite метрикам
 }
 
 // serverFlags флаги конфигурации
@@ -36,6 +57,27 @@ type serverFlags struct {
 	asymPrivKeyPath string // путь к файлу с приватным асимметричным ключом
 	trustedSubnet   string
 	grpcAddress     string // адрес:порт на котором работает gRPC сервер
+	alertRulesPath  string // путь к файлу с правилами алертинга
+	alertInterval   int64  // интервал проверки правил алертинга в секундах
+	notifiersPath   string // путь к файлу с каналами уведомлений об алертах
+	histogramBounds string // границы корзин гистограмм по умолчанию, через запятую
+	historySize     int    // количество хранимых в памяти значений каждой серии
+	historyTiers    string // уровни хранения истории, вида raw:24h,1m:30d,1h:365d
+	staleTTL        string // срок хранения необновляемых серий, пусто - без ограничения
+	retention       string // сроки хранения серий по префиксу названия, вида host_:7d,tmp_:1h
+	sweepDryRun     bool   // устаревшие серии только выводятся в лог, но не удаляются
+	walSync         string // политика fsync журнала резервной копии: always, interval или never
+	backupGens      int    // количество хранимых поколений резервной копии
+	dbMaxConns      int    // размер пула соединений с БД, 0 - по умолчанию pgx
+	dbStmtTimeout   string // ограничение времени выполнения запроса в БД, пусто - без ограничения
+	cacheSize       int    // кол-во серий в кеше чтения БД, 0 - без кеша (гибридное хранилище)
+	cacheTTL        string // срок жизни значения в кеше чтения БД
+	influxRulesPath string // путь к файлу с правилами сопоставления полей InfluxDB метрикам
+	statsdAddress   string // адрес:порт UDP для приема StatsD, пусто - прием выключен
+	statsdTCP       string // адрес:порт TCP для приема StatsD, пусто - прием выключен
+	statsdFlush     string // окно агрегации StatsD перед записью в хранилище
+	graphiteAddress string // адрес:порт TCP для приема Graphite plaintext, пусто - прием выключен
+	graphiteRules   string // путь к файлу с правилами сопоставления путей Graphite метрикам
 }
 
 func NewServerConfig() *ServerConfig {
@@ -62,6 +104,27 @@ func NewServerConfig() *ServerConfig {
 	flag.StringVar(&sf.asymPrivKeyPath, "crypto-key", constants.CryptoPrivateFilePath, "asymmetric crypto key")
 	flag.StringVar(&sf.trustedSubnet, "t", constants.TrustedSubnet, "trusted subnet")
 	flag.StringVar(&sf.grpcAddress, "g", constants.GRPCDefault, "grpc address")
+	flag.StringVar(&sf.alertRulesPath, "alert-rules", constants.AlertRulesPath, "alert rules file path (json or yaml)")
+	flag.Int64Var(&sf.alertInterval, "alert-interval", constants.AlertInterval, "alert rules evaluation interval")
+	flag.StringVar(&sf.notifiersPath, "notifiers", constants.NotifiersPath, "alert notification channels file path (json or yaml)")
+	flag.StringVar(&sf.histogramBounds, "histogram-buckets", constants.HistogramBounds, "default histogram bucket bounds, comma separated")
+	flag.IntVar(&sf.historySize, "history-size", constants.HistorySize, "number of samples kept in memory per series, 0 - no history")
+	flag.StringVar(&sf.historyTiers, "history-tiers", constants.HistoryTiers, "history rollup tiers resolution:retention, comma separated, first is raw")
+	flag.StringVar(&sf.staleTTL, "stale-ttl", "", "remove series not updated for this duration (30m, 7d), empty - never")
+	flag.StringVar(&sf.retention, "retention", "", "series ttl by name prefix prefix:ttl, comma separated, longest prefix wins")
+	flag.BoolVar(&sf.sweepDryRun, "sweep-dry-run", false, "only log stale series, do not remove them")
+	flag.StringVar(&sf.walSync, "wal-fsync", constants.WALSync, "backup update log fsync policy: always, interval or never")
+	flag.IntVar(&sf.backupGens, "backup-generations", constants.BackupGenerations, "number of rotated backup snapshots kept")
+	flag.IntVar(&sf.dbMaxConns, "db-max-conns", constants.DBMaxConns, "database connection pool size, 0 - pgx default")
+	flag.StringVar(&sf.dbStmtTimeout, "db-statement-timeout", "", "database statement timeout (5s, 1m), empty - no limit")
+	flag.IntVar(&sf.cacheSize, "cache-size", constants.CacheSize, "database read cache size in series for several servers sharing one database, 0 - hybrid memory storage")
+	flag.StringVar(&sf.cacheTTL, "cache-ttl", constants.CacheTTL.String(), "database read cache value ttl (30s, 1m), 0 - no expiry")
+	flag.StringVar(&sf.influxRulesPath, "influx-rules", constants.InfluxRulesPath, "influxdb line protocol field to metric type rules file path (json or yaml)")
+	flag.StringVar(&sf.statsdAddress, "statsd", constants.StatsdAddress, "statsd udp listen address, empty - disabled")
+	flag.StringVar(&sf.statsdTCP, "statsd-tcp", constants.StatsdTCPAddress, "statsd tcp listen address, empty - disabled")
+	flag.StringVar(&sf.statsdFlush, "statsd-flush", constants.StatsdFlushInterval.String(), "statsd aggregation window (10s, 1m)")
+	flag.StringVar(&sf.graphiteAddress, "graphite", constants.GraphiteAddress, "graphite plaintext tcp listen address, empty - disabled")
+	flag.StringVar(&sf.graphiteRules, "graphite-rules", constants.GraphiteRulesPath, "graphite path to metric name and labels rules file path (json or yaml)")
 	flag.Parse()
 
 	// из конфиг файла
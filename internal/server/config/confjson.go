@@ -21,6 +21,28 @@ type JSONConfig struct {
 	AsymPrivKeyPath  string `json:"crypto_key"`
 	TrustedSubnet    string `json:"trusted_subnet"`
 	GrpcAddress      string `json:"grpc_address"`
+	AlertRulesPath   string `json:"alert_rules"`
+	AlertIntervalStr string `json:"alert_interval"`
+	AlertInterval    int64
+	NotifiersPath    string `json:"notifiers"`
+	HistogramBounds  string `json:"histogram_buckets"`
+	HistorySize      int    `json:"history_size"`
+	HistoryTiers     string `json:"history_tiers"`
+	StaleTTL         string `json:"stale_ttl"`
+	Retention        string `json:"retention"`
+	SweepDryRun      bool   `json:"sweep_dry_run"`
+	WALSync          string `json:"wal_fsync"`
+	BackupGens       int    `json:"backup_generations"`
+	DBMaxConns       int    `json:"db_max_conns"`
+	DBStmtTimeout    string `json:"db_statement_timeout"`
+	CacheSize        int    `json:"cache_size"`
+	CacheTTL         string `json:"cache_ttl"`
+	InfluxRulesPath  string `json:"influx_rules"`
+	StatsdAddress    string `json:"statsd_address"`
+	StatsdTCP        string `json:"statsd_tcp_address"`
+	StatsdFlush      string `json:"statsd_flush_interval"`
+	GraphiteAddress  string `json:"graphite_address"`
+	GraphiteRules    string `json:"graphite_rules"`
 }
 
 func newJSONConfigServer(configFile string) (*JSONConfig, error) {
@@ -50,6 +72,15 @@ func newJSONConfigServer(configFile string) (*JSONConfig, error) {
 	}
 	cfg.StoreInterval = int64(d.Seconds())
 
+	if cfg.AlertIntervalStr != "" {
+		d, err = time.ParseDuration(cfg.AlertIntervalStr)
+		if err != nil {
+			logger.Log().Error("server parse alert interval error: " + err.Error())
+			return nil, err
+		}
+		cfg.AlertInterval = int64(d.Seconds())
+	}
+
 	return &cfg, err
 }
 
@@ -108,6 +139,110 @@ func consolidateConfigServer(jsonConf *JSONConfig, cfg *ServerConfig, sf serverF
 			cfg.GrpcAddress = constants.GRPCDefault
 		}
 
+		if jsonConf.AlertRulesPath != "" {
+			cfg.AlertRulesPath = jsonConf.AlertRulesPath
+		}
+
+		if jsonConf.NotifiersPath != "" {
+			cfg.NotifiersPath = jsonConf.NotifiersPath
+		}
+
+		if jsonConf.AlertInterval != 0 {
+			cfg.AlertInterval = jsonConf.AlertInterval
+		} else {
+			cfg.AlertInterval = constants.AlertInterval
+		}
+
+		if jsonConf.HistogramBounds != "" {
+			cfg.HistogramBounds = jsonConf.HistogramBounds
+		} else {
+			cfg.HistogramBounds = constants.HistogramBounds
+		}
+
+		if jsonConf.HistorySize != 0 {
+			cfg.HistorySize = jsonConf.HistorySize
+		} else {
+			cfg.HistorySize = constants.HistorySize
+		}
+
+		if jsonConf.HistoryTiers != "" {
+			cfg.HistoryTiers = jsonConf.HistoryTiers
+		} else {
+			cfg.HistoryTiers = constants.HistoryTiers
+		}
+
+		if jsonConf.StaleTTL != "" {
+			cfg.StaleTTL = jsonConf.StaleTTL
+		}
+
+		if jsonConf.Retention != "" {
+			cfg.Retention = jsonConf.Retention
+		}
+
+		if jsonConf.SweepDryRun {
+			cfg.SweepDryRun = jsonConf.SweepDryRun
+		}
+
+		if jsonConf.WALSync != "" {
+			cfg.WALSync = jsonConf.WALSync
+		} else {
+			cfg.WALSync = constants.WALSync
+		}
+
+		if jsonConf.BackupGens != 0 {
+			cfg.BackupGens = jsonConf.BackupGens
+		} else {
+			cfg.BackupGens = constants.BackupGenerations
+		}
+
+		if jsonConf.DBMaxConns != 0 {
+			cfg.DBMaxConns = jsonConf.DBMaxConns
+		} else {
+			cfg.DBMaxConns = constants.DBMaxConns
+		}
+
+		if jsonConf.DBStmtTimeout != "" {
+			cfg.DBStmtTimeout = jsonConf.DBStmtTimeout
+		}
+
+		if jsonConf.CacheSize != 0 {
+			cfg.CacheSize = jsonConf.CacheSize
+		} else {
+			cfg.CacheSize = constants.CacheSize
+		}
+
+		if jsonConf.CacheTTL != "" {
+			cfg.CacheTTL = jsonConf.CacheTTL
+		} else {
+			cfg.CacheTTL = constants.CacheTTL.String()
+		}
+
+		if jsonConf.InfluxRulesPath != "" {
+			cfg.InfluxRulesPath = jsonConf.InfluxRulesPath
+		}
+
+		if jsonConf.StatsdAddress != "" {
+			cfg.StatsdAddress = jsonConf.StatsdAddress
+		}
+
+		if jsonConf.StatsdTCP != "" {
+			cfg.StatsdTCP = jsonConf.StatsdTCP
+		}
+
+		if jsonConf.StatsdFlush != "" {
+			cfg.StatsdFlush = jsonConf.StatsdFlush
+		} else {
+			cfg.StatsdFlush = constants.StatsdFlushInterval.String()
+		}
+
+		if jsonConf.GraphiteAddress != "" {
+			cfg.GraphiteAddress = jsonConf.GraphiteAddress
+		}
+
+		if jsonConf.GraphiteRules != "" {
+			cfg.GraphiteRules = jsonConf.GraphiteRules
+		}
+
 	} else {
 		if sf.serverAddress == "" {
 			sf.serverAddress = constants.ServerDefault
@@ -133,6 +268,21 @@ func consolidateConfigServer(jsonConf *JSONConfig, cfg *ServerConfig, sf serverF
 		if sf.grpcAddress == "" {
 			sf.grpcAddress = constants.GRPCDefault
 		}
+		if sf.alertInterval == 0 {
+			sf.alertInterval = constants.AlertInterval
+		}
+		if sf.histogramBounds == "" {
+			sf.histogramBounds = constants.HistogramBounds
+		}
+		if sf.historyTiers == "" {
+			sf.historyTiers = constants.HistoryTiers
+		}
+		if sf.walSync == "" {
+			sf.walSync = constants.WALSync
+		}
+		if sf.backupGens == 0 {
+			sf.backupGens = constants.BackupGenerations
+		}
 	}
 
 	// если какого-то параметра нет в переменных окружения - берем значение флага, а если и флага нет - берем по умолчанию
@@ -176,5 +326,89 @@ func consolidateConfigServer(jsonConf *JSONConfig, cfg *ServerConfig, sf serverF
 		cfg.GrpcAddress = sf.grpcAddress
 	}
 
+	if cfg.AlertRulesPath == "" {
+		cfg.AlertRulesPath = sf.alertRulesPath
+	}
+
+	if cfg.NotifiersPath == "" {
+		cfg.NotifiersPath = sf.notifiersPath
+	}
+
+	if cfg.AlertInterval == -1 {
+		cfg.AlertInterval = sf.alertInterval
+	}
+
+	if cfg.HistogramBounds == "" {
+		cfg.HistogramBounds = sf.histogramBounds
+	}
+
+	if cfg.HistorySize == -1 {
+		cfg.HistorySize = sf.historySize
+	}
+
+	if cfg.HistoryTiers == "" {
+		cfg.HistoryTiers = sf.historyTiers
+	}
+
+	if cfg.StaleTTL == "" {
+		cfg.StaleTTL = sf.staleTTL
+	}
+
+	if cfg.Retention == "" {
+		cfg.Retention = sf.retention
+	}
+
+	if _, ok := os.LookupEnv(constants.SweepDryRunEnv); !ok {
+		cfg.SweepDryRun = cfg.SweepDryRun || sf.sweepDryRun
+	}
+
+	if cfg.WALSync == "" {
+		cfg.WALSync = sf.walSync
+	}
+
+	if cfg.BackupGens == -1 {
+		cfg.BackupGens = sf.backupGens
+	}
+
+	if cfg.DBMaxConns == -1 {
+		cfg.DBMaxConns = sf.dbMaxConns
+	}
+
+	if cfg.DBStmtTimeout == "" {
+		cfg.DBStmtTimeout = sf.dbStmtTimeout
+	}
+
+	if cfg.CacheSize == -1 {
+		cfg.CacheSize = sf.cacheSize
+	}
+
+	if cfg.CacheTTL == "" {
+		cfg.CacheTTL = sf.cacheTTL
+	}
+
+	if cfg.InfluxRulesPath == "" {
+		cfg.InfluxRulesPath = sf.influxRulesPath
+	}
+
+	if cfg.StatsdAddress == "" {
+		cfg.StatsdAddress = sf.statsdAddress
+	}
+
+	if cfg.StatsdTCP == "" {
+		cfg.StatsdTCP = sf.statsdTCP
+	}
+
+	if cfg.StatsdFlush == "" {
+		cfg.StatsdFlush = sf.statsdFlush
+	}
+
+	if cfg.GraphiteAddress == "" {
+		cfg.GraphiteAddress = sf.graphiteAddress
+	}
+
+	if cfg.GraphiteRules == "" {
+		cfg.GraphiteRules = sf.graphiteRules
+	}
+
 	return cfg
 }
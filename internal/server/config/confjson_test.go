@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
 )
 
 func TestJsonConfig(t *testing.T) {
@@ -95,6 +97,72 @@ func TestConsolidate(t *testing.T) {
 	jsonConf.GrpcAddress = ":8090"
 	cfg = consolidateConfigServer(jsonConf, cfg, sf)
 	assert.Equal(t, ":8090", cfg.GrpcAddress)
+	assert.Equal(t, constants.AlertInterval, cfg.AlertInterval)
+	jsonConf.AlertRulesPath = "rules.yaml"
+	jsonConf.AlertInterval = 30
+	jsonConf.NotifiersPath = "notifiers.yaml"
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, "notifiers.yaml", cfg.NotifiersPath)
+	assert.Equal(t, "rules.yaml", cfg.AlertRulesPath)
+	assert.Equal(t, int64(30), cfg.AlertInterval)
+	assert.Equal(t, constants.HistogramBounds, cfg.HistogramBounds)
+	jsonConf.HistogramBounds = "0.1,1,10"
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, "0.1,1,10", cfg.HistogramBounds)
+	assert.Equal(t, constants.HistorySize, cfg.HistorySize)
+	jsonConf.HistorySize = 50
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, 50, cfg.HistorySize)
+	assert.Equal(t, constants.HistoryTiers, cfg.HistoryTiers)
+	jsonConf.HistoryTiers = "raw:1h,5m:7d"
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, "raw:1h,5m:7d", cfg.HistoryTiers)
+	assert.Equal(t, "", cfg.StaleTTL)
+	assert.Equal(t, false, cfg.SweepDryRun)
+	jsonConf.StaleTTL = "7d"
+	jsonConf.Retention = "tmp_:1h"
+	jsonConf.SweepDryRun = true
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, "7d", cfg.StaleTTL)
+	assert.Equal(t, "tmp_:1h", cfg.Retention)
+	assert.Equal(t, true, cfg.SweepDryRun)
+	assert.Equal(t, constants.WALSync, cfg.WALSync)
+	jsonConf.WALSync = constants.WALSyncAlways
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, constants.WALSyncAlways, cfg.WALSync)
+	assert.Equal(t, constants.BackupGenerations, cfg.BackupGens)
+	jsonConf.BackupGens = 5
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, 5, cfg.BackupGens)
+	assert.Equal(t, constants.DBMaxConns, cfg.DBMaxConns)
+	jsonConf.DBMaxConns = 20
+	jsonConf.DBStmtTimeout = "5s"
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, 20, cfg.DBMaxConns)
+	assert.Equal(t, "5s", cfg.DBStmtTimeout)
+	assert.Equal(t, constants.CacheSize, cfg.CacheSize)
+	assert.Equal(t, constants.CacheTTL.String(), cfg.CacheTTL)
+	jsonConf.CacheSize = 10000
+	jsonConf.CacheTTL = "1m"
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, 10000, cfg.CacheSize)
+	assert.Equal(t, "1m", cfg.CacheTTL)
+	jsonConf.InfluxRulesPath = "influx.yaml"
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, "influx.yaml", cfg.InfluxRulesPath)
+	assert.Equal(t, constants.StatsdFlushInterval.String(), cfg.StatsdFlush)
+	jsonConf.StatsdAddress = ":8125"
+	jsonConf.StatsdTCP = ":8126"
+	jsonConf.StatsdFlush = "1m"
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, ":8125", cfg.StatsdAddress)
+	assert.Equal(t, ":8126", cfg.StatsdTCP)
+	assert.Equal(t, "1m", cfg.StatsdFlush)
+	jsonConf.GraphiteAddress = ":2003"
+	jsonConf.GraphiteRules = "graphite.yaml"
+	cfg = consolidateConfigServer(jsonConf, cfg, sf)
+	assert.Equal(t, ":2003", cfg.GraphiteAddress)
+	assert.Equal(t, "graphite.yaml", cfg.GraphiteRules)
 
 	jsonConf = nil
 	sf.restoreSaved = false
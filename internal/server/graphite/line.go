@@ -0,0 +1,72 @@
+// Package graphite прием метрик по протоколу Graphite plaintext и сопоставление путей метрикам
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample значение пути Graphite
+type Sample struct {
+	Path  string            // путь через точку без тегов
+	Tags  map[string]string // теги вида path;tag=value, nil - без тегов
+	Value float64
+	Time  float64 // время в секундах Unix
+}
+
+// parseLine разбор строки plaintext вида path value timestamp. Время -1 - текущее
+func parseLine(s string, now time.Time) (Sample, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return Sample{}, errors.New("expected path value timestamp")
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("bad value %q", fields[1])
+	}
+
+	ts, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("bad timestamp %q", fields[2])
+	}
+	if ts == -1 {
+		ts = float64(now.Unix())
+	}
+
+	return newSample(fields[0], value, ts)
+}
+
+// newSample проверка значения и разбор тегов пути
+func newSample(path string, value float64, ts float64) (Sample, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Sample{}, fmt.Errorf("bad value %v", value)
+	}
+	if math.IsNaN(ts) || math.IsInf(ts, 0) {
+		return Sample{}, fmt.Errorf("bad timestamp %v", ts)
+	}
+
+	path, rest, tagged := strings.Cut(path, ";")
+	if path == "" {
+		return Sample{}, errors.New("path required")
+	}
+
+	sm := Sample{Path: path, Value: value, Time: ts}
+
+	if tagged {
+		sm.Tags = make(map[string]string)
+		for _, tag := range strings.Split(rest, ";") {
+			k, v, ok := strings.Cut(tag, "=")
+			if !ok || k == "" || v == "" {
+				return Sample{}, fmt.Errorf("bad tag %q", tag)
+			}
+			sm.Tags[k] = v
+		}
+	}
+
+	return sm, nil
+}
@@ -0,0 +1,37 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+
+	for _, tt := range []struct {
+		in   string
+		want Sample
+	}{
+		{"servers.web1.cpu.user 12.5 1700000000", Sample{Path: "servers.web1.cpu.user", Value: 12.5, Time: 1700000000}},
+		{"  jobs.done\t-3   1700000000.5 ", Sample{Path: "jobs.done", Value: -3, Time: 1700000000.5}},
+		{"jobs.done 1 -1", Sample{Path: "jobs.done", Value: 1, Time: 1700000100}},
+		{"jobs.queue;env=prod;dc=eu 2 1700000000", Sample{Path: "jobs.queue", Tags: map[string]string{"env": "prod", "dc": "eu"},
+			Value: 2, Time: 1700000000}},
+	} {
+		sm, err := parseLine(tt.in, now)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, sm, tt.in)
+	}
+
+	for _, bad := range []string{
+		"jobs.done", "jobs.done 1", "jobs.done 1 2 3", "jobs.done x 1700000000", "jobs.done 1 x",
+		"jobs.done NaN 1700000000", "jobs.done Inf 1700000000", ";env=prod 1 1700000000", "jobs.done;env 1 1700000000",
+		"jobs.done;env= 1 1700000000",
+	} {
+		_, err := parseLine(bad, now)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,146 @@
+package graphite
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// Rule правило сопоставления пути метрике.
+// Match - шаблон пути, * совпадает с любой частью одного сегмента пути (без точки).
+// В Name и значениях Labels $1 или ${1} заменяется на текст, совпавший с первой *, и т.д.
+// Перед буквой, цифрой или _ нужна форма ${1}.
+type Rule struct {
+	Match  string            `json:"match" yaml:"match"`   // шаблон пути, например servers.*.cpu.*
+	Name   string            `json:"name" yaml:"name"`     // название метрики, например cpu_${2}
+	Labels map[string]string `json:"labels" yaml:"labels"` // метки, например host: ${1}
+}
+
+// rule правило с разобранным шаблоном
+type rule struct {
+	match  *regexp.Regexp
+	name   string
+	labels map[string]string
+}
+
+// Rules правила сопоставления путей, применяется первое подходящее.
+// Путь, не подошедший ни под одно правило, сохраняется метрикой с названием из пути, где точки заменены на _
+type Rules struct {
+	rules []rule
+}
+
+// rulesFile структура файла правил
+type rulesFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// NewRules проверка и разбор правил
+func NewRules(rules []Rule) (*Rules, error) {
+	r := &Rules{rules: make([]rule, 0, len(rules))}
+
+	for i, rl := range rules {
+		if rl.Match == "" {
+			return nil, fmt.Errorf("rule %d: match required", i+1)
+		}
+		if rl.Name == "" {
+			return nil, fmt.Errorf("rule %d: name required", i+1)
+		}
+
+		err := storage.ValidateLabels(rl.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+
+		parts := strings.Split(rl.Match, "*")
+		for j, p := range parts {
+			parts[j] = regexp.QuoteMeta(p)
+		}
+
+		r.rules = append(r.rules, rule{
+			match:  regexp.MustCompile("^" + strings.Join(parts, "([^.]*)") + "$"),
+			name:   rl.Name,
+			labels: rl.Labels,
+		})
+	}
+
+	return r, nil
+}
+
+// LoadRules загрузка правил из файла. Формат определяется по расширению: .json - json, иначе yaml
+func LoadRules(filename string) (*Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("graphite LoadRules | ReadFile: %w", err)
+	}
+
+	var rf rulesFile
+
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = json.Unmarshal(data, &rf)
+	} else {
+		err = yaml.Unmarshal(data, &rf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("graphite LoadRules | Unmarshal: %w", err)
+	}
+
+	rules, err := NewRules(rf.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("graphite LoadRules | NewRules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// Metric gauge для значения. Теги пути становятся метками, метки правила имеют приоритет.
+// Недопустимые символы в названиях заменяются на _, метки с пустым значением не сохраняются
+func (r *Rules) Metric(sm Sample) (storage.Metrics, error) {
+	name := sm.Path
+	labels := storage.Labels{}
+
+	for k, v := range sm.Tags {
+		labels[storage.SanitizeName(k, false)] = v
+	}
+
+	if r != nil {
+		for _, rl := range r.rules {
+			m := rl.match.FindStringSubmatchIndex(sm.Path)
+			if m == nil {
+				continue
+			}
+
+			name = string(rl.match.ExpandString(nil, rl.name, sm.Path, m))
+			for k, v := range rl.labels {
+				labels[k] = string(rl.match.ExpandString(nil, v, sm.Path, m))
+			}
+			break
+		}
+	}
+
+	for k, v := range labels {
+		if v == "" {
+			delete(labels, k)
+		}
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+
+	name = storage.SanitizeName(name, true)
+	if name == "" {
+		return storage.Metrics{}, errors.New("empty metric name")
+	}
+
+	value := sm.Value
+
+	return storage.Metrics{ID: name, MType: constants.Gauge, Labels: labels, Value: &value}, nil
+}
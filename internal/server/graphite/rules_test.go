@@ -0,0 +1,70 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestRules_Metric(t *testing.T) {
+	rules, err := LoadRules("testdata/rules.yaml")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		in     Sample
+		name   string
+		labels storage.Labels
+	}{
+		{Sample{Path: "servers.web1.cpu.user", Value: 1}, "cpu_user_percent", storage.Labels{"host": "web1"}},
+		{Sample{Path: "servers.web-2.cpu.system", Value: 1}, "cpu_system_percent", storage.Labels{"host": "web-2"}},
+		{Sample{Path: "servers.web1.cpu.user.max", Value: 1}, "servers_web1_cpu_user_max", nil},
+		{Sample{Path: "jobs.queue", Tags: map[string]string{"env": "prod", "state": "x"}, Value: 1}, "jobs",
+			storage.Labels{"env": "prod", "state": "queue"}},
+		{Sample{Path: "jobs.", Value: 1}, "jobs", nil},
+		{Sample{Path: "1min.load-avg", Tags: map[string]string{"dc.name": "eu"}, Value: 1}, "_1min_load_avg",
+			storage.Labels{"dc_name": "eu"}},
+	} {
+		mt, err := rules.Metric(tt.in)
+		require.NoError(t, err, tt.in.Path)
+		assert.Equal(t, tt.name, mt.ID, tt.in.Path)
+		assert.Equal(t, tt.labels, mt.Labels, tt.in.Path)
+		assert.Equal(t, constants.Gauge, mt.MType)
+		assert.Equal(t, float64(1), *mt.Value)
+	}
+
+	// без правил название берется из пути
+	var none *Rules
+	mt, err := none.Metric(Sample{Path: "servers.web1.cpu.user", Value: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "servers_web1_cpu_user", mt.ID)
+	assert.Nil(t, mt.Labels)
+
+	rules, err = NewRules([]Rule{{Match: "drop.*", Name: "$1"}})
+	require.NoError(t, err)
+	_, err = rules.Metric(Sample{Path: "drop."})
+	assert.Error(t, err)
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, err := LoadRules("testdata/rules.json")
+	require.NoError(t, err)
+	mt, err := rules.Metric(Sample{Path: "servers.db.cpu.idle"})
+	require.NoError(t, err)
+	assert.Equal(t, "cpu_idle_percent", mt.ID)
+	assert.Equal(t, storage.Labels{"host": "db"}, mt.Labels)
+
+	_, err = LoadRules("testdata/bad_rules.yaml")
+	assert.ErrorIs(t, err, storage.ErrBadLabels)
+
+	_, err = LoadRules("testdata/none.yaml")
+	assert.Error(t, err)
+
+	for _, bad := range [][]Rule{{{Name: "x"}}, {{Match: "x"}}} {
+		_, err = NewRules(bad)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,187 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// Sink получатель метрик
+type Sink interface {
+	// SetGaugeSamples сохранение значений метрик типа gauge с заданным временем по сериям одним пакетом:
+	// все значения добавляются в историю, значение с наибольшим временем становится текущим
+	SetGaugeSamples(ctx context.Context, series map[string][]storage.Sample) error
+}
+
+// Config параметры приема Graphite
+type Config struct {
+	Address string // адрес:порт TCP для plaintext, пусто - не слушать
+	Rules   *Rules // правила сопоставления путей метрикам, nil - название из пути
+}
+
+// Server прием Graphite. Значения сохраняются как gauge.
+// Строки plaintext пишутся пакетом, когда клиент делает паузу или набирается GraphiteBatchSize строк
+type Server struct {
+	cfg  Config
+	sink Sink
+
+	plain net.Listener
+
+	mutex sync.Mutex
+	conns map[net.Conn]struct{} // открытые соединения, закрываются при остановке
+	wg    sync.WaitGroup
+}
+
+// NewServer прием Graphite с записью в sink, слушать адреса начинает Start
+func NewServer(cfg Config, sink Sink) *Server {
+	return &Server{
+		cfg:   cfg,
+		sink:  sink,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// Start открытие адреса plaintext
+func (s *Server) Start() error {
+	var err error
+
+	if s.cfg.Address != "" {
+		s.plain, err = net.Listen("tcp", s.cfg.Address)
+		if err != nil {
+			return err
+		}
+
+		s.wg.Add(1)
+		go s.serve(s.plain, s.readPlain)
+	}
+
+	return nil
+}
+
+// Addr адрес plaintext, nil - не слушается
+func (s *Server) Addr() net.Addr {
+	if s.plain == nil {
+		return nil
+	}
+
+	return s.plain.Addr()
+}
+
+// Close остановка приема. Прочитанные значения записываются до возврата
+func (s *Server) Close() error {
+	if s.plain != nil {
+		_ = s.plain.Close()
+	}
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	return nil
+}
+
+// serve прием соединений, каждое читает read
+func (s *Server) serve(ln net.Listener, read func(conn net.Conn)) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Log().Error("graphite accept: " + err.Error())
+			continue
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			read(conn)
+
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+// readPlain чтение строк plaintext. Ошибочные и слишком длинные строки выводятся в лог и пропускаются
+func (s *Server) readPlain(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, constants.GraphiteMaxLine)
+
+	var batch []Sample
+	for {
+		data, err := r.ReadSlice('\n')
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			logger.Log().Info("graphite: line too long", zap.String("remote", conn.RemoteAddr().String()))
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = r.ReadSlice('\n')
+			}
+		} else if text := strings.TrimSpace(string(data)); text != "" {
+			sm, errLine := parseLine(text, time.Now())
+			if errLine != nil {
+				logger.Log().Info("graphite: bad line", zap.String("line", text), zap.Error(errLine))
+			} else {
+				batch = append(batch, sm)
+			}
+		}
+
+		if err != nil || r.Buffered() == 0 || len(batch) >= constants.GraphiteBatchSize {
+			s.write(batch)
+			batch = batch[:0]
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write запись значений в историю серий со временем строк, значение с наибольшим временем становится текущим
+func (s *Server) write(samples []Sample) {
+	series := make(map[string][]storage.Sample)
+	for _, sm := range samples {
+		mt, err := s.cfg.Rules.Metric(sm)
+		if err != nil {
+			logger.Log().Info("graphite: path skipped", zap.String("path", sm.Path), zap.Error(err))
+			continue
+		}
+
+		key := mt.Key()
+		series[key] = append(series[key], storage.Sample{Timestamp: int64(sm.Time * 1000), Value: *mt.Value})
+	}
+
+	if len(series) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
+	defer cancel()
+
+	err := s.sink.SetGaugeSamples(ctx, series)
+	if err != nil {
+		logger.Log().Error("graphite write: " + err.Error())
+	}
+}
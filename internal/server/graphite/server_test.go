@@ -0,0 +1,112 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// sink получатель пакетов для тестов
+type sink struct {
+	mutex   sync.Mutex
+	samples map[string][]storage.Sample
+}
+
+func (s *sink) SetGaugeSamples(_ context.Context, series map[string][]storage.Sample) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, samples := range series {
+		s.samples[key] = append(s.samples[key], samples...)
+	}
+
+	return nil
+}
+
+// value значение серии с наибольшим временем, false - не получено
+func (s *sink) value(key string) (float64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	samples, ok := s.samples[key]
+	if !ok {
+		return 0, false
+	}
+
+	last := samples[0]
+	for _, sm := range samples {
+		if sm.Timestamp >= last.Timestamp {
+			last = sm
+		}
+	}
+
+	return last.Value, true
+}
+
+// history значения серии со временем
+func (s *sink) history(key string) []storage.Sample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]storage.Sample(nil), s.samples[key]...)
+}
+
+func TestServer(t *testing.T) {
+	rules, err := LoadRules("testdata/rules.yaml")
+	require.NoError(t, err)
+
+	out := &sink{samples: make(map[string][]storage.Sample)}
+	s := NewServer(Config{Address: "127.0.0.1:0", Rules: rules}, out)
+	require.NoError(t, s.Start())
+
+	plain, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+
+	// все значения сохраняются со временем строки, текущим становится значение с наибольшим временем
+	_, err = fmt.Fprint(plain, "servers.web1.cpu.user 12.5 1700000010\nservers.web1.cpu.user 10 1700000000\nbad line\nmemory.free 100 -1\n")
+	require.NoError(t, err)
+
+	cpu := storage.SeriesKey("cpu_user_percent", storage.Labels{"host": "web1"})
+	assert.Eventually(t, func() bool {
+		v, ok := out.value("memory_free")
+		return ok && v == 100
+	}, 5*time.Second, 10*time.Millisecond)
+	v, _ := out.value(cpu)
+	assert.Equal(t, 12.5, v)
+	assert.Equal(t, []storage.Sample{{Timestamp: 1700000010000, Value: 12.5}, {Timestamp: 1700000000000, Value: 10}}, out.history(cpu))
+
+	// второе соединение пишется отдельными пакетами
+	other, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer other.Close()
+
+	_, err = fmt.Fprint(other, "jobs.done 7 1700000000\nservers.web1.cpu.system 3 1700000000\n")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		v, ok := out.value(storage.SeriesKey("jobs", storage.Labels{"state": "done"}))
+		return ok && v == 7
+	}, 5*time.Second, 10*time.Millisecond)
+	v, _ = out.value(storage.SeriesKey("cpu_system_percent", storage.Labels{"host": "web1"}))
+	assert.Equal(t, float64(3), v)
+
+	// последняя строка без перевода строки записывается при закрытии соединения клиентом
+	_, err = fmt.Fprint(plain, "servers.web1.cpu.user 20 1700000020")
+	require.NoError(t, err)
+	require.NoError(t, plain.Close())
+	assert.Eventually(t, func() bool {
+		v, _ := out.value(cpu)
+		return v == 20
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// незакрытое соединение не мешает остановке
+	require.NoError(t, s.Close())
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// metricFamily семейство метрик экспозиции: серии одного названия и типа
+type metricFamily struct {
+	name    string
+	typ     string
+	samples []string
+	series  map[string]bool // метки серий семейства, повторы после приведения имен пропускаются
+}
+
+// exposition метрики в текстовом формате Prometheus (0.0.4) или OpenMetrics (1.0.0)
+type exposition struct {
+	openMetrics bool
+	families    map[string]*metricFamily
+}
+
+func newExposition(openMetrics bool) *exposition {
+	return &exposition{openMetrics: openMetrics, families: make(map[string]*metricFamily)}
+}
+
+// family семейство метрик name типа typ, nil - название занято семейством другого типа
+func (e *exposition) family(name string, typ string) *metricFamily {
+	f, ok := e.families[name]
+	if !ok {
+		f = &metricFamily{name: name, typ: typ, series: make(map[string]bool)}
+		e.families[name] = f
+	}
+
+	if f.typ != typ {
+		return nil
+	}
+
+	return f
+}
+
+// add добавление серии. Для summary выводятся квантили и агрегаты stats.
+// Серии неизвестных типов со значением выводятся как untyped (unknown)
+func (e *exposition) add(mt storage.Metrics, stats *storage.SummaryStats) {
+	name := sanitizeMetricName(mt.ID)
+	labels := formatLabels(mt.Labels)
+
+	var (
+		f   *metricFamily
+		typ string
+	)
+
+	switch mt.MType {
+	case constants.Gauge, constants.Counter, constants.Histogram, constants.Summary:
+		typ = mt.MType
+	default:
+		typ = "untyped"
+		if e.openMetrics {
+			typ = "unknown"
+		}
+	}
+
+	// в OpenMetrics название семейства счетчиков без суффикса _total, у значения - с ним
+	sample := name
+	if mt.MType == constants.Counter && e.openMetrics {
+		name = strings.TrimSuffix(name, "_total")
+		sample = name + "_total"
+	}
+
+	f = e.family(name, typ)
+	if f == nil || f.series[labels] {
+		return
+	}
+	f.series[labels] = true
+
+	switch {
+	case mt.MType == constants.Gauge && mt.Value != nil:
+		f.add(sample, labels, formatFloat(*mt.Value))
+
+	case mt.MType == constants.Counter && mt.Delta != nil:
+		f.add(sample, labels, strconv.FormatInt(*mt.Delta, 10))
+
+	case mt.MType == constants.Histogram && mt.Histogram != nil:
+		h := mt.Histogram
+		var cumulative uint64
+		for i, bound := range h.Bounds {
+			cumulative += h.Counts[i]
+			f.add(name+"_bucket", formatLabels(mt.Labels, "le", formatFloat(bound)), strconv.FormatUint(cumulative, 10))
+		}
+		f.add(name+"_bucket", formatLabels(mt.Labels, "le", "+Inf"), strconv.FormatUint(h.Count, 10))
+		f.add(name+"_sum", labels, formatFloat(h.Sum))
+		f.add(name+"_count", labels, strconv.FormatUint(h.Count, 10))
+
+	case mt.MType == constants.Summary && stats != nil:
+		quantiles := make([]string, 0, len(stats.Quantiles))
+		for q := range stats.Quantiles {
+			quantiles = append(quantiles, q)
+		}
+		sort.Slice(quantiles, func(i, j int) bool {
+			a, _ := strconv.ParseFloat(quantiles[i], 64)
+			b, _ := strconv.ParseFloat(quantiles[j], 64)
+			return a < b
+		})
+
+		for _, q := range quantiles {
+			f.add(name, formatLabels(mt.Labels, "quantile", q), formatFloat(stats.Quantiles[q]))
+		}
+		f.add(name+"_sum", labels, formatFloat(stats.Sum))
+		f.add(name+"_count", labels, strconv.FormatUint(stats.Count, 10))
+
+	case mt.Value != nil:
+		f.add(sample, labels, formatFloat(*mt.Value))
+	}
+}
+
+func (f *metricFamily) add(name string, labels string, value string) {
+	f.samples = append(f.samples, name+labels+" "+value)
+}
+
+// write вывод семейств в порядке названий
+func (e *exposition) write(w io.Writer) error {
+	names := make([]string, 0, len(e.families))
+	for name, f := range e.families {
+		if len(f.samples) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := e.families[name]
+		bw.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+		for _, s := range f.samples {
+			bw.WriteString(s + "\n")
+		}
+	}
+
+	if e.openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+
+	return bw.Flush()
+}
+
+// contentType тип контента экспозиции
+func (e *exposition) contentType() string {
+	if e.openMetrics {
+		return constants.OpenMetricsText
+	}
+
+	return constants.PrometheusText
+}
+
+// acceptsOpenMetrics выбор формата OpenMetrics по заголовку Accept: его вес не меньше веса text/plain
+func acceptsOpenMetrics(accept string) bool {
+	var om, text float64
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/openmetrics-text":
+			om = math.Max(om, q)
+		case constants.TextPlain, "text/*", "*/*":
+			text = math.Max(text, q)
+		}
+	}
+
+	return om > 0 && om >= text
+}
+
+// sanitizeMetricName приведение названия метрики к [a-zA-Z_:][a-zA-Z0-9_:]*, недопустимые символы заменяются на _
+func sanitizeMetricName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	return storage.SanitizeName(name, true)
+}
+
+// sanitizeLabelName приведение имени метки к [a-zA-Z_][a-zA-Z0-9_]*, имена с __ зарезервированы Prometheus
+func sanitizeLabelName(name string) string {
+	name = storage.SanitizeName(name, false)
+	if strings.HasPrefix(name, "__") {
+		name = "x" + name
+	}
+
+	return name
+}
+
+// formatLabels метки серии в порядке имен и дополнительная метка extra (имя, значение) последней
+func formatLabels(labels storage.Labels, extra ...string) string {
+	if len(labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	seen := make(map[string]bool, len(names))
+	write := func(name string, value string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabelValue(value) + `"`)
+	}
+
+	// служебные метки le и quantile не перезаписываются метками серии
+	for i := 0; i+1 < len(extra); i += 2 {
+		seen[extra[i]] = true
+	}
+	for _, name := range names {
+		write(sanitizeLabelName(name), labels[name])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		seen[extra[i]] = false
+		write(extra[i], extra[i+1])
+	}
+
+	return "{" + b.String() + "}"
+}
+
+// escapeLabelValue экранирование значения метки
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatFloat значение в формате экспозиции
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
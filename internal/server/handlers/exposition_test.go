@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestExposition(t *testing.T) {
+	gauge := func(v float64) *float64 { return &v }
+	delta := func(v int64) *int64 { return &v }
+
+	series := []storage.Metrics{
+		{ID: "Alloc", MType: constants.Gauge, Value: gauge(1.5)},
+		{ID: "Alloc", MType: constants.Gauge, Labels: storage.Labels{"host": `a"1\`}, Value: gauge(math.Inf(1))},
+		{ID: "http.requests_total", MType: constants.Counter, Delta: delta(7)},
+		{ID: "Latency", MType: constants.Histogram, Histogram: &storage.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{2, 1, 1}, Sum: 3.2, Count: 4}},
+		{ID: "Latency", MType: constants.Gauge, Value: gauge(1)}, // название занято гистограммой
+	}
+	stats := &storage.SummaryStats{Count: 3, Sum: 6, Quantiles: map[string]float64{"0.99": 3, "0.5": 2}}
+
+	tests := []struct {
+		name        string
+		openMetrics bool
+		want        string
+	}{
+		{
+			name: "prometheus",
+			want: `# TYPE Alloc gauge
+Alloc 1.5
+Alloc{host="a\"1\\"} +Inf
+# TYPE Duration summary
+Duration{quantile="0.5"} 2
+Duration{quantile="0.99"} 3
+Duration_sum 6
+Duration_count 3
+# TYPE Latency histogram
+Latency_bucket{le="0.1"} 2
+Latency_bucket{le="1"} 3
+Latency_bucket{le="+Inf"} 4
+Latency_sum 3.2
+Latency_count 4
+# TYPE http_requests_total counter
+http_requests_total 7
+`,
+		},
+		{
+			name:        "openmetrics",
+			openMetrics: true,
+			want: `# TYPE Alloc gauge
+Alloc 1.5
+Alloc{host="a\"1\\"} +Inf
+# TYPE Duration summary
+Duration{quantile="0.5"} 2
+Duration{quantile="0.99"} 3
+Duration_sum 6
+Duration_count 3
+# TYPE Latency histogram
+Latency_bucket{le="0.1"} 2
+Latency_bucket{le="1"} 3
+Latency_bucket{le="+Inf"} 4
+Latency_sum 3.2
+Latency_count 4
+# TYPE http_requests counter
+http_requests_total 7
+# EOF
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := newExposition(tt.openMetrics)
+			for _, s := range series {
+				exp.add(s, nil)
+			}
+			exp.add(storage.Metrics{ID: "Duration", MType: constants.Summary}, stats)
+
+			var buf bytes.Buffer
+			require.NoError(t, exp.write(&buf))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "go_gc_duration:seconds", sanitizeMetricName("go.gc-duration:seconds"))
+	assert.Equal(t, "_1xx", sanitizeMetricName("1xx"))
+	assert.Equal(t, "_", sanitizeMetricName(""))
+	assert.Equal(t, "host_name", sanitizeLabelName("host:name"))
+	assert.Equal(t, "x__name__", sanitizeLabelName("__name__"))
+}
+
+func TestAcceptsOpenMetrics(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "*/*", want: false},
+		{accept: "application/openmetrics-text; version=1.0.0", want: true},
+		{accept: "application/openmetrics-text;version=1.0.0;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", want: true},
+		{accept: "application/openmetrics-text;q=0.3,text/plain", want: false},
+		{accept: "application/openmetrics-text;q=0", want: false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, acceptsOpenMetrics(tt.accept), tt.accept)
+	}
+}
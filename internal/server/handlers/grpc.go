@@ -3,18 +3,22 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	_ "google.golang.org/grpc/encoding/gzip" // для активации декомпрессора
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
 	pb "github.com/dnsoftware/go-metrics/internal/proto"
+	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
 type GRPCServer struct {
@@ -63,7 +67,7 @@ func NewGRPCServer(collector Collector, cryptoKey string, certificateKeyPath str
 func (g *GRPCServer) GetMetricValue(ctx context.Context, in *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
 	var response pb.GetMetricResponse
 
-	if in.MetricType != constants.Gauge && in.MetricType != constants.Counter {
+	if !isMetricType(in.MetricType) {
 		return nil, status.Errorf(codes.InvalidArgument, `Bad metric type: %s`, in.MetricType)
 	}
 
@@ -80,10 +84,14 @@ func (g *GRPCServer) GetMetricValue(ctx context.Context, in *pb.GetMetricRequest
 func (g *GRPCServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
 	var response pb.UpdateMetricResponse
 
-	if in.MetricType != constants.Gauge && in.MetricType != constants.Counter {
+	if !isMetricType(in.MetricType) {
 		return nil, status.Errorf(codes.InvalidArgument, `Bad metric type: %s`, in.MetricType)
 	}
 
+	if err := storage.ValidateName(in.MetricName); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, `Bad metric name: %s`, err.Error())
+	}
+
 	if in.MetricType == constants.Gauge {
 		gaugeVal, err := strconv.ParseFloat(in.MetricValue, 64)
 
@@ -93,7 +101,7 @@ func (g *GRPCServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricReques
 
 		err = g.collector.SetGaugeMetric(ctx, in.MetricName, gaugeVal)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, `Error when set gauge %s:, %s`, in.MetricName, err.Error())
+			return nil, status.Errorf(errorCode(err), `Error when set gauge %s:, %s`, in.MetricName, err.Error())
 		}
 
 	}
@@ -112,6 +120,32 @@ func (g *GRPCServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricReques
 
 	}
 
+	if in.MetricType == constants.Histogram {
+		observation, err := strconv.ParseFloat(in.MetricValue, 64)
+
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, `Incorrect metric value: %s`, in.MetricValue)
+		}
+
+		err = g.collector.ObserveHistogramMetric(ctx, in.MetricName, observation)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, `Error when set histogram %s:, %s`, in.MetricName, err.Error())
+		}
+	}
+
+	if in.MetricType == constants.Summary {
+		observation, err := strconv.ParseFloat(in.MetricValue, 64)
+
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, `Incorrect metric value: %s`, in.MetricValue)
+		}
+
+		err = g.collector.ObserveSummaryMetric(ctx, in.MetricName, observation)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, `Error when set summary %s:, %s`, in.MetricName, err.Error())
+		}
+	}
+
 	return &response, nil
 }
 
@@ -122,23 +156,51 @@ func (g *GRPCServer) GetMetricExt(ctx context.Context, in *pb.GetMetricExtReques
 
 	response.Mtype = in.Mtype
 	response.Id = in.Id
+	response.Labels = in.Labels
 
-	if in.Mtype != constants.Gauge && in.Mtype != constants.Counter {
+	if !isMetricType(in.Mtype) {
 		return nil, status.Errorf(codes.InvalidArgument, `Bad metric type: %s`, in.Mtype)
 	}
 
+	key, err := seriesKey(in.Id, in.Labels)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, `Bad labels: %s`, err.Error())
+	}
+
 	switch in.Mtype {
 	case constants.Gauge:
-		response.Value, err = g.collector.GetGaugeMetric(ctx, in.Id)
+		response.Value, err = g.collector.GetGaugeMetric(ctx, key)
 		if err != nil {
 			return nil, status.Errorf(codes.NotFound, `Error when set gauge %s:, %s`, in.Mtype, err.Error())
 		}
 
 	case constants.Counter:
-		response.Delta, err = g.collector.GetCounterMetric(ctx, in.Id)
+		response.Delta, err = g.collector.GetCounterMetric(ctx, key)
 		if err != nil {
 			return nil, status.Errorf(codes.NotFound, `Error when set gauge %s:, %s`, in.Mtype, err.Error())
 		}
+
+	case constants.Histogram:
+		h, errH := g.collector.GetHistogramMetric(ctx, key)
+		if errH != nil {
+			return nil, status.Errorf(codes.NotFound, `Error when get histogram %s:, %s`, in.Id, errH.Error())
+		}
+
+		response.Histogram = histogramToPb(h)
+
+	case constants.Summary:
+		sm, errS := g.collector.GetSummaryMetric(ctx, key)
+		if errS != nil {
+			return nil, status.Errorf(codes.NotFound, `Error when get summary %s:, %s`, in.Id, errS.Error())
+		}
+
+		stats, errS := g.collector.GetSummaryStats(ctx, key, in.Quantiles)
+		if errS != nil {
+			return nil, status.Errorf(codes.NotFound, `Error when get summary %s:, %s`, in.Id, errS.Error())
+		}
+
+		response.Summary = summaryToPb(sm)
+		response.Quantiles = stats.Quantiles
 	}
 
 	return &response, nil
@@ -148,61 +210,94 @@ func (g *GRPCServer) GetMetricExt(ctx context.Context, in *pb.GetMetricExtReques
 func (g *GRPCServer) UpdateMetricExt(ctx context.Context, in *pb.UpdateMetricExtRequest) (*pb.UpdateMetricExtResponse, error) {
 	var response pb.UpdateMetricExtResponse
 
-	if in.Mtype != constants.Gauge && in.Mtype != constants.Counter {
+	if !isMetricType(in.Mtype) {
 		return nil, status.Errorf(codes.InvalidArgument, `Bad metric type: %s`, in.Mtype)
 	}
 
+	key, err := seriesKey(in.Id, in.Labels)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, `Bad labels: %s`, err.Error())
+	}
+
 	if in.Mtype == constants.Gauge {
-		err := g.collector.SetGaugeMetric(ctx, in.Id, in.Value)
+		err = g.collector.SetGaugeMetric(ctx, key, in.Value)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, `Error when set gauge %s:, %s`, in.Id, err.Error())
+			return nil, status.Errorf(errorCode(err), `Error when set gauge %s:, %s`, in.Id, err.Error())
 		}
 	}
 
 	if in.Mtype == constants.Counter {
-		err := g.collector.SetCounterMetric(ctx, in.Id, in.Delta)
+		err = g.collector.SetCounterMetric(ctx, key, in.Delta)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, `Error when set counter %s:, %s`, in.Id, err.Error())
 		}
 
 	}
 
+	if in.Mtype == constants.Histogram {
+		err = g.setHistogram(ctx, key, in)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, `Error when set histogram %s:, %s`, in.Id, err.Error())
+		}
+	}
+
+	if in.Mtype == constants.Summary {
+		err = g.setSummary(ctx, key, in)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, `Error when set summary %s:, %s`, in.Id, err.Error())
+		}
+	}
+
 	return &response, nil
 }
 
+// GetAllMetrics все метрики, подходящие под селектор match (пусто - все метрики)
 func (g *GRPCServer) GetAllMetrics(ctx context.Context, in *pb.GetAllMetricsRequest) (*pb.GetAllMetricsResponse, error) {
+	selector, err := storage.ParseSelector(in.Match)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, `GetAllMetrics error %s`, err.Error())
+	}
 
-	gauges, counters, err := g.collector.GetAllByTypes(ctx)
-	var metrics = make([]*pb.GetMetricExtResponse, 0, len(gauges)+len(counters))
-
+	series, err := g.collector.FindSeries(ctx, selector)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, `GetAllMetrics error %s`, err.Error())
 	}
 
-	for key, val := range gauges {
-		metrics = append(metrics, &pb.GetMetricExtResponse{
-			Id:    key,
-			Mtype: constants.Gauge,
-			Value: val,
-		})
-	}
-	for key, val := range counters {
-		metrics = append(metrics, &pb.GetMetricExtResponse{
-			Id:    key,
-			Mtype: constants.Counter,
-			Delta: val,
-		})
+	var metrics = make([]*pb.GetMetricExtResponse, 0, len(series))
+
+	for _, s := range series {
+		m := &pb.GetMetricExtResponse{
+			Id:     s.ID,
+			Mtype:  s.MType,
+			Labels: s.Labels,
+		}
+
+		switch s.MType {
+		case constants.Gauge:
+			m.Value = *s.Value
+		case constants.Counter:
+			m.Delta = *s.Delta
+		case constants.Histogram:
+			m.Histogram = histogramToPb(*s.Histogram)
+		case constants.Summary:
+			m.Summary = summaryToPb(*s.Summary)
+		}
+
+		metrics = append(metrics, m)
 	}
 
 	return &pb.GetAllMetricsResponse{Metrics: metrics}, nil
 }
 
-// UpdateMetricsStream Потоковое обновления, двунаправленный поток
+// UpdateMetricsStream Потоковое обновления, двунаправленный поток.
+// Если поток помечен идентификатором пакета, сообщения отмечаются как примененные по порядковому номеру,
+// повторно отправленный поток подтверждается без применения уже примененных сообщений.
 func (g *GRPCServer) UpdateMetricsStream(stream pb.Metrics_UpdateMetricsStreamServer) error {
 
 	ctx := context.Background()
+	id := batchID(stream.Context())
 
-	for {
+	for seq := 0; ; seq++ {
 		metric, err := stream.Recv()
 		if err == io.EOF {
 			return nil
@@ -213,26 +308,28 @@ func (g *GRPCServer) UpdateMetricsStream(stream pb.Metrics_UpdateMetricsStreamSe
 		}
 
 		// заносим в базу
-		if metric.Mtype != constants.Gauge && metric.Mtype != constants.Counter {
+		if !isMetricType(metric.Mtype) {
 			_ = stream.Send(&pb.UpdateMetricExtResponse{Error: fmt.Sprintf(`Bad metric type: %v, name: %v, value: %v`, metric.Mtype, metric.Id, metric.Value)})
 			continue
 		}
 
-		if metric.Mtype == constants.Gauge {
-			err = g.collector.SetGaugeMetric(ctx, metric.Id, metric.Value)
-			if err != nil {
-				_ = stream.Send(&pb.UpdateMetricExtResponse{Error: fmt.Sprintf(`SetGaugeMetric error: %v, name: %v, value: %v`, metric.Mtype, metric.Id, metric.Value)})
-				continue
-			}
+		key, err := seriesKey(metric.Id, metric.Labels)
+		if err != nil {
+			_ = stream.Send(&pb.UpdateMetricExtResponse{Error: fmt.Sprintf(`Bad labels: %v, name: %v, error: %v`, metric.Mtype, metric.Id, err)})
+			continue
 		}
 
-		if metric.Mtype == constants.Counter {
-			err = g.collector.SetCounterMetric(ctx, metric.Id, metric.Delta)
-			if err != nil {
-				_ = stream.Send(&pb.UpdateMetricExtResponse{Error: fmt.Sprintf(`SetCounterMetric error: %v, name: %v, value: %v`, metric.Mtype, metric.Id, metric.Delta)})
-				continue
-			}
+		messageID := ""
+		if id != "" {
+			messageID = id + "/" + strconv.Itoa(seq)
+		}
 
+		_, err = g.collector.ApplyBatchOnce(ctx, messageID, func() error {
+			return g.setStreamMetric(ctx, key, metric)
+		})
+		if err != nil {
+			_ = stream.Send(&pb.UpdateMetricExtResponse{Error: err.Error()})
+			continue
 		}
 
 		err = stream.Send(&pb.UpdateMetricExtResponse{})
@@ -243,16 +340,47 @@ func (g *GRPCServer) UpdateMetricsStream(stream pb.Metrics_UpdateMetricsStreamSe
 
 }
 
+// setStreamMetric сохранение метрики из сообщения потока
+func (g *GRPCServer) setStreamMetric(ctx context.Context, key string, metric *pb.UpdateMetricExtRequest) error {
+	switch metric.Mtype {
+	case constants.Gauge:
+		err := g.collector.SetGaugeMetric(ctx, key, metric.Value)
+		if err != nil {
+			return fmt.Errorf(`SetGaugeMetric error: %v, name: %v, value: %v`, metric.Mtype, metric.Id, metric.Value)
+		}
+	case constants.Counter:
+		err := g.collector.SetCounterMetric(ctx, key, metric.Delta)
+		if err != nil {
+			return fmt.Errorf(`SetCounterMetric error: %v, name: %v, value: %v`, metric.Mtype, metric.Id, metric.Delta)
+		}
+	case constants.Histogram:
+		err := g.setHistogram(ctx, key, metric)
+		if err != nil {
+			return fmt.Errorf(`SetHistogramMetric error: %v, name: %v, error: %v`, metric.Mtype, metric.Id, err)
+		}
+	case constants.Summary:
+		err := g.setSummary(ctx, key, metric)
+		if err != nil {
+			return fmt.Errorf(`SetSummaryMetric error: %v, name: %v, error: %v`, metric.Mtype, metric.Id, err)
+		}
+	}
+
+	return nil
+}
+
 func (g *GRPCServer) UpdateMetricsBatch(ctx context.Context, in *pb.UpdateMetricBatchRequest) (*pb.UpdateMetricBatchResponse, error) {
 	var response pb.UpdateMetricBatchResponse
 
 	temp := make([]Metrics, 0, len(in.Metrics))
 	for _, m := range in.Metrics {
 		temp = append(temp, Metrics{
-			ID:    m.Id,
-			MType: m.Mtype,
-			Delta: &m.Delta,
-			Value: &m.Value,
+			ID:        m.Id,
+			MType:     m.Mtype,
+			Labels:    m.Labels,
+			Delta:     &m.Delta,
+			Value:     &m.Value,
+			Histogram: histogramFromPb(m.Histogram),
+			Summary:   summaryFromPb(m.Summary),
 		})
 	}
 
@@ -261,10 +389,220 @@ func (g *GRPCServer) UpdateMetricsBatch(ctx context.Context, in *pb.UpdateMetric
 		return nil, err
 	}
 
-	err = g.collector.SetBatchMetrics(ctx, data)
+	// повторно отправленный пакет подтверждаем без применения
+	_, err = g.collector.ApplyBatchOnce(ctx, batchID(ctx), func() error {
+		return g.collector.SetBatchMetrics(ctx, data)
+	})
+	if errors.Is(err, storage.ErrBadHistogram) || errors.Is(err, storage.ErrBadSummary) || errors.Is(err, storage.ErrBadLabels) {
+		return nil, status.Errorf(codes.InvalidArgument, `UpdateMetricsBatch error %s`, err.Error())
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	return &response, nil
 }
+
+// batchID идентификатор пакета из метаданных запроса, пустая строка - если не передан
+func batchID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(constants.BatchIDHeader)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// setHistogram сохранение гистограммы из запроса.
+// Если гистограмма не передана - значение value считается одиночным наблюдением
+func (g *GRPCServer) setHistogram(ctx context.Context, key string, in *pb.UpdateMetricExtRequest) error {
+	if in.Histogram == nil {
+		return g.collector.ObserveHistogramMetric(ctx, key, in.Value)
+	}
+
+	return g.collector.SetHistogramMetric(ctx, key, *histogramFromPb(in.Histogram))
+}
+
+// errorCode код ответа на ошибку записи: некорректное значение - ошибка клиента
+func errorCode(err error) codes.Code {
+	if errors.Is(err, storage.ErrBadValue) {
+		return codes.InvalidArgument
+	}
+
+	return codes.Internal
+}
+
+// seriesKey ключ серии из названия и меток запроса
+func seriesKey(id string, labels map[string]string) (string, error) {
+	err := storage.ValidateSeries(id, labels)
+	if err != nil {
+		return "", err
+	}
+
+	return storage.SeriesKey(id, labels), nil
+}
+
+// histogramToPb конвертация гистограммы в proto сообщение
+func histogramToPb(h storage.Histogram) *pb.HistogramData {
+	return &pb.HistogramData{
+		Bounds: h.Bounds,
+		Counts: h.Counts,
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// histogramFromPb конвертация proto сообщения в гистограмму, nil - если гистограммы нет
+func histogramFromPb(h *pb.HistogramData) *storage.Histogram {
+	if h == nil {
+		return nil
+	}
+
+	return &storage.Histogram{
+		Bounds: h.Bounds,
+		Counts: h.Counts,
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// setSummary сохранение скетча summary из запроса.
+// Если скетч не передан - значение value считается одиночным наблюдением
+func (g *GRPCServer) setSummary(ctx context.Context, key string, in *pb.UpdateMetricExtRequest) error {
+	if in.Summary == nil {
+		return g.collector.ObserveSummaryMetric(ctx, key, in.Value)
+	}
+
+	return g.collector.SetSummaryMetric(ctx, key, *summaryFromPb(in.Summary))
+}
+
+// summaryToPb конвертация скетча summary в proto сообщение
+func summaryToPb(s storage.Summary) *pb.SummaryData {
+	return &pb.SummaryData{
+		Alpha:    s.Alpha,
+		Positive: binsToPb(s.Positive),
+		Negative: binsToPb(s.Negative),
+		Zero:     s.Zero,
+		Sum:      s.Sum,
+		Count:    s.Count,
+		Min:      s.Min,
+		Max:      s.Max,
+	}
+}
+
+// summaryFromPb конвертация proto сообщения в скетч summary, nil - если скетча нет
+func summaryFromPb(s *pb.SummaryData) *storage.Summary {
+	if s == nil {
+		return nil
+	}
+
+	return &storage.Summary{
+		Alpha:    s.Alpha,
+		Positive: binsFromPb(s.Positive),
+		Negative: binsFromPb(s.Negative),
+		Zero:     s.Zero,
+		Sum:      s.Sum,
+		Count:    s.Count,
+		Min:      s.Min,
+		Max:      s.Max,
+	}
+}
+
+// binsToPb корзины скетча с индексами для proto
+func binsToPb(bins map[int]uint64) map[int32]uint64 {
+	res := make(map[int32]uint64, len(bins))
+	for i, c := range bins {
+		res[int32(i)] = c
+	}
+
+	return res
+}
+
+// binsFromPb корзины скетча из proto
+func binsFromPb(bins map[int32]uint64) map[int]uint64 {
+	res := make(map[int]uint64, len(bins))
+	for i, c := range bins {
+		res[int(i)] = c
+	}
+
+	return res
+}
+
+// GetAlerts список текущих алертов
+func (g *GRPCServer) GetAlerts(ctx context.Context, in *pb.GetAlertsRequest) (*pb.GetAlertsResponse, error) {
+	alerts, err := g.collector.GetAlerts(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, `GetAlerts error %s`, err.Error())
+	}
+
+	items := make([]*pb.AlertItem, 0, len(alerts))
+	for _, a := range alerts {
+		item := &pb.AlertItem{
+			Rule:        a.Rule,
+			Metric:      a.Metric,
+			Mtype:       a.MType,
+			Severity:    a.Severity,
+			State:       a.State,
+			Value:       a.Value,
+			Threshold:   a.Threshold,
+			ActiveSince: a.ActiveSince.Unix(),
+		}
+		if !a.FiredAt.IsZero() {
+			item.FiredAt = a.FiredAt.Unix()
+		}
+		if !a.ResolvedAt.IsZero() {
+			item.ResolvedAt = a.ResolvedAt.Unix()
+		}
+
+		items = append(items, item)
+	}
+
+	return &pb.GetAlertsResponse{Alerts: items}, nil
+}
+
+// QueryRange история значений серии gauge или counter за период
+func (g *GRPCServer) QueryRange(ctx context.Context, in *pb.QueryRangeRequest) (*pb.QueryRangeResponse, error) {
+	key, err := seriesKey(in.Id, in.Labels)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, `Bad labels: %s`, err.Error())
+	}
+
+	to := time.Now()
+	if in.To != 0 {
+		to = time.UnixMilli(in.To)
+	}
+
+	from := to.Add(-constants.QueryRangeDefault)
+	if in.From != 0 {
+		from = time.UnixMilli(in.From)
+	}
+
+	q := storage.RangeQuery{
+		MType: in.Mtype,
+		Name:  key,
+		From:  from,
+		To:    to,
+		Step:  time.Duration(in.Step) * time.Millisecond,
+		Agg:   in.Agg,
+	}
+
+	samples, resolution, err := g.collector.QueryRange(ctx, q)
+	if err != nil {
+		if errors.Is(err, storage.ErrBadRange) {
+			return nil, status.Errorf(codes.InvalidArgument, `QueryRange error %s`, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, `QueryRange error %s`, err.Error())
+	}
+
+	points := make([]*pb.SamplePoint, 0, len(samples))
+	for _, s := range samples {
+		points = append(points, &pb.SamplePoint{Timestamp: s.Timestamp, Value: s.Value})
+	}
+
+	return &pb.QueryRangeResponse{Points: points, Resolution: resolution.Milliseconds()}, nil
+}
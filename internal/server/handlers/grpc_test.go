@@ -9,6 +9,7 @@ import (
 	"log"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -327,6 +328,70 @@ func TestUpdateAndGetMetricStream(t *testing.T) {
 
 }
 
+// Повторно отправленный пакет с тем же идентификатором подтверждается без применения
+func TestBatchReplayGrpc(t *testing.T) {
+	setup("", "", "", "")
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	getCounter := func() int64 {
+		m, err := client.GetMetricExt(ctx, &pb.GetMetricExtRequest{Mtype: constants.Counter, Id: "ReplayCount"})
+		require.NoError(t, err)
+		return m.Delta
+	}
+
+	batch := &pb.UpdateMetricBatchRequest{Metrics: []*pb.UpdateMetricExtRequest{
+		{Id: "ReplayCount", Mtype: constants.Counter, Delta: 5},
+		{Id: "ReplayGauge", Mtype: constants.Gauge, Value: 1.5},
+	}}
+
+	// пакет
+	batchCtx := metadata.AppendToOutgoingContext(ctx, constants.BatchIDHeader, "batch-1")
+	for i := 0; i < 3; i++ {
+		_, err = client.UpdateMetricsBatch(batchCtx, batch)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(5), getCounter())
+
+	_, err = client.UpdateMetricsBatch(metadata.AppendToOutgoingContext(ctx, constants.BatchIDHeader, "batch-2"), batch)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), getCounter())
+
+	// без идентификатора каждый пакет применяется
+	_, err = client.UpdateMetricsBatch(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), getCounter())
+
+	// поток, повтор подтверждается по каждому сообщению
+	sendStream := func(id string, deltas ...int64) {
+		stream, err := client.UpdateMetricsStream(metadata.AppendToOutgoingContext(ctx, constants.BatchIDHeader, id))
+		require.NoError(t, err)
+
+		for _, d := range deltas {
+			err = stream.Send(&pb.UpdateMetricExtRequest{Id: "ReplayCount", Mtype: constants.Counter, Delta: d})
+			require.NoError(t, err)
+
+			resp, err := stream.Recv()
+			require.NoError(t, err)
+			require.Equal(t, "", resp.Error)
+		}
+		require.NoError(t, stream.CloseSend())
+	}
+
+	// первая отправка прервалась после одного сообщения, повтор досылает остальные
+	sendStream("stream-1", 1)
+	assert.Equal(t, int64(16), getCounter())
+
+	sendStream("stream-1", 1, 2, 3)
+	assert.Equal(t, int64(21), getCounter())
+
+	sendStream("stream-1", 1, 2, 3)
+	assert.Equal(t, int64(21), getCounter())
+}
+
 func TestGetAllMetrics(t *testing.T) {
 	setup("", "", "", "")
 	ctx := context.Background()
@@ -636,3 +701,234 @@ func TestNegative(t *testing.T) {
 
 	_ = server
 }
+
+func TestGetAlerts(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.ServerConfig{
+		StoreInterval:  constants.BackupPeriod,
+		RestoreSaved:   false,
+		AlertRulesPath: "../alerting/testdata/rules.yaml",
+		AlertInterval:  1,
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+
+	server := &GRPCServer{collector: collect}
+
+	resp, err := server.GetAlerts(ctx, &pb.GetAlertsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Alerts)
+
+	err = collect.SetGaugeMetric(ctx, "Alloc", 5000)
+	require.NoError(t, err)
+
+	// ждем проверки правил
+	time.Sleep(1500 * time.Millisecond)
+
+	resp, err = server.GetAlerts(ctx, &pb.GetAlertsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Alerts, 1)
+	assert.Equal(t, "HighAlloc", resp.Alerts[0].Rule)
+	assert.Equal(t, constants.AlertStatePending, resp.Alerts[0].State)
+	assert.Equal(t, float64(5000), resp.Alerts[0].Value)
+	assert.Greater(t, resp.Alerts[0].ActiveSince, int64(0))
+	assert.Equal(t, int64(0), resp.Alerts[0].FiredAt)
+}
+
+func TestHistogramGrpc(t *testing.T) {
+	setup("", "", "", "")
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial : %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	hist := &pb.HistogramData{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 1, 0}, Sum: 0.55, Count: 2}
+
+	_, err = client.UpdateMetricsBatch(ctx, &pb.UpdateMetricBatchRequest{Metrics: []*pb.UpdateMetricExtRequest{
+		{Id: "Latency", Mtype: constants.Histogram, Histogram: hist},
+		{Id: "Latency", Mtype: constants.Histogram, Histogram: hist},
+	}})
+	require.NoError(t, err)
+
+	// одиночное наблюдение попадает в существующие корзины
+	_, err = client.UpdateMetricExt(ctx, &pb.UpdateMetricExtRequest{Id: "Latency", Mtype: constants.Histogram, Value: 3})
+	require.NoError(t, err)
+
+	_, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{MetricType: constants.Histogram, MetricName: "Latency", MetricValue: "0.5"})
+	require.NoError(t, err)
+
+	m, err := client.GetMetricExt(ctx, &pb.GetMetricExtRequest{Mtype: constants.Histogram, Id: "Latency"})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 1}, m.Histogram.Bounds)
+	assert.Equal(t, []uint64{2, 3, 1}, m.Histogram.Counts)
+	assert.Equal(t, uint64(6), m.Histogram.Count)
+	assert.InDelta(t, 4.6, m.Histogram.Sum, 1e-9)
+
+	// несовпадающие границы
+	_, err = client.UpdateMetricsBatch(ctx, &pb.UpdateMetricBatchRequest{Metrics: []*pb.UpdateMetricExtRequest{
+		{Id: "Latency", Mtype: constants.Histogram, Histogram: &pb.HistogramData{Bounds: []float64{5}, Counts: []uint64{0, 1}, Sum: 6, Count: 1}},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	all, err := client.GetAllMetrics(ctx, &pb.GetAllMetricsRequest{})
+	require.NoError(t, err)
+
+	found := false
+	for _, metric := range all.Metrics {
+		if metric.Mtype == constants.Histogram && metric.Id == "Latency" {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestSummaryGrpc(t *testing.T) {
+	setup("", "", "", "")
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial : %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	sketch := storage.NewSummary(0)
+	for i := 1; i <= 99; i++ {
+		sketch.Observe(float64(i))
+	}
+
+	_, err = client.UpdateMetricsBatch(ctx, &pb.UpdateMetricBatchRequest{Metrics: []*pb.UpdateMetricExtRequest{
+		{Id: "Latency", Mtype: constants.Summary, Summary: summaryToPb(sketch)},
+	}})
+	require.NoError(t, err)
+
+	_, err = client.UpdateMetricExt(ctx, &pb.UpdateMetricExtRequest{Id: "Latency", Mtype: constants.Summary, Value: -5})
+	require.NoError(t, err)
+
+	_, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{MetricType: constants.Summary, MetricName: "Latency", MetricValue: "200"})
+	require.NoError(t, err)
+
+	m, err := client.GetMetricExt(ctx, &pb.GetMetricExtRequest{Mtype: constants.Summary, Id: "Latency", Quantiles: []float64{0, 0.5, 1}})
+	require.NoError(t, err)
+	require.NotNil(t, m.Summary)
+	assert.Equal(t, uint64(101), m.Summary.Count)
+	assert.Equal(t, float64(-5), m.Quantiles["0"])
+	assert.InEpsilon(t, 49, m.Quantiles["0.5"], 0.02)
+	assert.Equal(t, float64(200), m.Quantiles["1"])
+
+	// другая точность
+	_, err = client.UpdateMetricsBatch(ctx, &pb.UpdateMetricBatchRequest{Metrics: []*pb.UpdateMetricExtRequest{
+		{Id: "Latency", Mtype: constants.Summary, Summary: &pb.SummaryData{Alpha: 0.05, Zero: 1, Count: 1}},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	all, err := client.GetAllMetrics(ctx, &pb.GetAllMetricsRequest{})
+	require.NoError(t, err)
+
+	found := false
+	for _, metric := range all.Metrics {
+		if metric.Mtype == constants.Summary && metric.Id == "Latency" {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestLabelsGrpc(t *testing.T) {
+	setup("", "", "", "")
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial : %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	_, err = client.UpdateMetricExt(ctx, &pb.UpdateMetricExtRequest{Id: "Load", Mtype: constants.Gauge, Value: 1, Labels: map[string]string{"host": "web-1"}})
+	require.NoError(t, err)
+
+	_, err = client.UpdateMetricsBatch(ctx, &pb.UpdateMetricBatchRequest{Metrics: []*pb.UpdateMetricExtRequest{
+		{Id: "Load", Mtype: constants.Gauge, Value: 2, Labels: map[string]string{"host": "web-2"}},
+	}})
+	require.NoError(t, err)
+
+	m, err := client.GetMetricExt(ctx, &pb.GetMetricExtRequest{Id: "Load", Mtype: constants.Gauge, Labels: map[string]string{"host": "web-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), m.Value)
+	assert.Equal(t, map[string]string{"host": "web-1"}, m.Labels)
+
+	_, err = client.UpdateMetricExt(ctx, &pb.UpdateMetricExtRequest{Id: "Load", Mtype: constants.Gauge, Value: 1, Labels: map[string]string{"host name": "web-1"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// название в виде ключа серии перезаписало бы серию с метками
+	_, err = client.UpdateMetricExt(ctx, &pb.UpdateMetricExtRequest{Id: `Load{host="web-1"}`, Mtype: constants.Gauge, Value: 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{MetricName: `Load{host="web-1"}`, MetricType: constants.Gauge, MetricValue: "1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.UpdateMetricsBatch(ctx, &pb.UpdateMetricBatchRequest{Metrics: []*pb.UpdateMetricExtRequest{
+		{Id: `Load{host="web-2"}`, Mtype: constants.Gauge, Value: 2},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.UpdateMetricsBatch(ctx, &pb.UpdateMetricBatchRequest{Metrics: []*pb.UpdateMetricExtRequest{
+		{Id: "Load", Mtype: constants.Gauge, Value: 2, Labels: map[string]string{"": "web-2"}},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	all, err := client.GetAllMetrics(ctx, &pb.GetAllMetricsRequest{Match: `Load{host=~"web-.*"}`})
+	require.NoError(t, err)
+	require.Len(t, all.Metrics, 2)
+	assert.Equal(t, map[string]string{"host": "web-1"}, all.Metrics[0].Labels)
+	assert.Equal(t, float64(2), all.Metrics[1].Value)
+
+	_, err = client.GetAllMetrics(ctx, &pb.GetAllMetricsRequest{Match: `Load{host=`})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestQueryRangeGrpc(t *testing.T) {
+	setup("", "", "", "")
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial : %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	for _, v := range []float64{1, 2} {
+		_, err = client.UpdateMetricExt(ctx, &pb.UpdateMetricExtRequest{Id: "Temperature", Mtype: constants.Gauge, Value: v, Labels: map[string]string{"room": "1"}})
+		require.NoError(t, err)
+	}
+
+	resp, err := client.QueryRange(ctx, &pb.QueryRangeRequest{Id: "Temperature", Mtype: constants.Gauge, Labels: map[string]string{"room": "1"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Points, 2)
+	assert.Equal(t, float64(2), resp.Points[1].Value)
+
+	resp, err = client.QueryRange(ctx, &pb.QueryRangeRequest{Id: "Temperature", Mtype: constants.Gauge})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Points)
+
+	resp, err = client.QueryRange(ctx, &pb.QueryRangeRequest{Id: "Temperature", Mtype: constants.Gauge, Labels: map[string]string{"room": "1"}, Step: 30000, Agg: constants.AggMax})
+	require.NoError(t, err)
+	require.Len(t, resp.Points, 1)
+	assert.Equal(t, float64(2), resp.Points[0].Value)
+	assert.Equal(t, int64(0), resp.Resolution)
+
+	_, err = client.QueryRange(ctx, &pb.QueryRangeRequest{Id: "Temperature", Mtype: constants.Gauge, Agg: "median"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.QueryRange(ctx, &pb.QueryRangeRequest{Id: "Temperature", Mtype: constants.Histogram})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.QueryRange(ctx, &pb.QueryRangeRequest{Id: "Temperature", Mtype: constants.Gauge, From: 2000, To: 1000})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -6,11 +6,15 @@ import (
 	"crypto/rsa"
 	"net/http"
 	_ "net/http/pprof"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
+	"github.com/dnsoftware/go-metrics/internal/server/influx"
+	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
 // Collector сборщик метрик. Сохраняет метрики в хранилище. Получает метрики из  хранилища.
@@ -26,6 +30,17 @@ type Collector interface {
 	// SetBatchMetrics сохраняет метрики в базу пакетом из нескольких штук
 	SetBatchMetrics(ctx context.Context, batch []byte) error
 
+	// SetGaugeSamples сохранение значений метрик типа gauge с заданным временем по сериям:
+	// все значения добавляются в историю, значение с наибольшим временем становится текущим
+	SetGaugeSamples(ctx context.Context, series map[string][]storage.Sample) error
+
+	// ApplyBatchOnce применяет пакет функцией apply, если пакет с идентификатором batchID еще не применялся.
+	// Возвращает true для повторно отправленного пакета.
+	ApplyBatchOnce(ctx context.Context, batchID string, apply func() error) (bool, error)
+
+	// InfluxRules правила сопоставления полей InfluxDB line protocol метрикам
+	InfluxRules() *influx.Rules
+
 	// GetGaugeMetric получение значения метрики типа gauge.
 	// Параметры: name - название метрики.
 	GetGaugeMetric(ctx context.Context, name string) (float64, error)
@@ -34,6 +49,45 @@ type Collector interface {
 	// Параметры: name - название метрики.
 	GetCounterMetric(ctx context.Context, name string) (int64, error)
 
+	// SetHistogramMetric прибавление гистограммы к метрике типа histogram.
+	// Параметры: name - название метрики, value - гистограмма.
+	SetHistogramMetric(ctx context.Context, name string, value storage.Histogram) error
+
+	// ObserveHistogramMetric добавление одного наблюдения в метрику типа histogram.
+	ObserveHistogramMetric(ctx context.Context, name string, value float64) error
+
+	// GetHistogramMetric получение значения метрики типа histogram.
+	// Параметры: name - название метрики.
+	GetHistogramMetric(ctx context.Context, name string) (storage.Histogram, error)
+
+	// GetAllHistograms получение всех гистограмм картой
+	GetAllHistograms(ctx context.Context) (map[string]storage.Histogram, error)
+
+	// SetSummaryMetric прибавление скетча к метрике типа summary.
+	// Параметры: name - название метрики, value - скетч.
+	SetSummaryMetric(ctx context.Context, name string, value storage.Summary) error
+
+	// ObserveSummaryMetric добавление одного наблюдения в метрику типа summary.
+	ObserveSummaryMetric(ctx context.Context, name string, value float64) error
+
+	// GetSummaryMetric получение скетча метрики типа summary.
+	// Параметры: name - название метрики.
+	GetSummaryMetric(ctx context.Context, name string) (storage.Summary, error)
+
+	// GetSummaryStats получение квантилей и агрегатов метрики типа summary.
+	// Параметры: name - название метрики, quantiles - квантили (пусто - по умолчанию).
+	GetSummaryStats(ctx context.Context, name string, quantiles []float64) (storage.SummaryStats, error)
+
+	// GetAllSummaries получение всех скетчей summary картой
+	GetAllSummaries(ctx context.Context) (map[string]storage.Summary, error)
+
+	// FindSeries все серии, подходящие под селектор меток
+	FindSeries(ctx context.Context, selector storage.Selector) ([]storage.Metrics, error)
+
+	// QueryRange история значений серии типа gauge или counter за период.
+	// Возвращает также разрешение выбранного уровня хранения истории (0 - исходные значения)
+	QueryRange(ctx context.Context, q storage.RangeQuery) ([]storage.Sample, time.Duration, error)
+
 	// GetMetric получение метрики в текстовом виде
 	GetMetric(ctx context.Context, metricType string, metricName string) (string, error)
 
@@ -43,8 +97,23 @@ type Collector interface {
 	// GetAllByTypes получение всех метрик картами
 	GetAllByTypes(ctx context.Context) (map[string]float64, map[string]int64, error)
 
-	// DatabasePing проверка работоспособности СУБД
-	DatabasePing(ctx context.Context) bool
+	// Health состояние хранилища и доступность СУБД
+	Health(ctx context.Context) storage.Health
+
+	// GetAlerts список текущих алертов
+	GetAlerts(ctx context.Context) ([]alerting.Alert, error)
+
+	// AcknowledgeAlert подтверждение сработавшего алерта
+	AcknowledgeAlert(ctx context.Context, rule string, by string) error
+
+	// GetSilences список заглушек алертов
+	GetSilences(ctx context.Context) ([]alerting.Silence, error)
+
+	// AddSilence добавление заглушки алертов
+	AddSilence(ctx context.Context, silence alerting.Silence) (alerting.Silence, error)
+
+	// DeleteSilence удаление заглушки алертов
+	DeleteSilence(ctx context.Context, id string) error
 }
 
 type HTTPServer struct {
@@ -56,10 +125,36 @@ type HTTPServer struct {
 
 // Metrics структура для получения json данных от агента
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string                `json:"id"`                  // имя метрики
+	MType     string                `json:"type"`                // параметр, принимающий значение gauge, counter, histogram или summary
+	Labels    storage.Labels        `json:"labels,omitempty"`    // метки, вместе с именем определяют серию
+	Delta     *int64                `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64              `json:"value,omitempty"`     // значение метрики в случае передачи gauge (для histogram и summary - одиночное наблюдение)
+	Histogram *storage.Histogram    `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *storage.Summary      `json:"summary,omitempty"`   // скетч в случае передачи summary
+	Quantiles []float64             `json:"quantiles,omitempty"` // запрашиваемые квантили summary
+	Stats     *storage.SummaryStats `json:"stats,omitempty"`     // квантили и агрегаты summary в ответе
+}
+
+// RangeResult ответ на запрос истории значений серии
+type RangeResult struct {
+	ID         string           `json:"id"`               // имя метрики
+	MType      string           `json:"type"`             // gauge или counter
+	Labels     storage.Labels   `json:"labels,omitempty"` // метки серии
+	Agg        string           `json:"agg"`              // агрегат значений за шаг
+	Resolution int64            `json:"resolution"`       // разрешение уровня хранения истории в миллисекундах, 0 - исходные значения
+	Points     []storage.Sample `json:"points"`           // значения в порядке времени
+}
+
+// InfluxWriteResult ответ на запрос записи line protocol с ошибками в строках
+type InfluxWriteResult struct {
+	Error string             `json:"error"` // общее описание ошибки
+	Lines []influx.LineError `json:"lines"` // ошибки строк, строки с ошибками не сохранены
+}
+
+// key ключ серии метрики (имя и метки)
+func (m Metrics) key() string {
+	return storage.SeriesKey(m.ID, m.Labels)
 }
 
 type (
@@ -107,8 +202,21 @@ func NewServer(collector Collector, cryptoKey string, privateKey *rsa.PrivateKey
 	h.Router.Get("/"+constants.ValueAction+"/{metricType}", h.noMetricName)
 	h.Router.Get("/"+constants.ValueAction+"/{metricType}/{metricName}", h.getMetricValue)
 
+	h.Router.Get("/"+constants.SeriesAction, h.getSeries)
+	h.Router.Get("/"+constants.RangeAction, h.queryRange)
+	h.Router.Get("/"+constants.MetricsAction, h.getPrometheusMetrics)
+	h.Router.Post("/"+constants.RemoteWriteAction, h.remoteWrite)
+	h.Router.Post("/"+constants.InfluxWriteAction, h.influxWrite)
+
 	h.Router.Get("/ping", h.databasePing)
 
+	h.Router.Get("/"+constants.AlertsAction, h.getAlerts)
+	h.Router.Post("/"+constants.AlertsAction+"/{alertRule}/"+constants.AckAction, h.acknowledgeAlert)
+
+	h.Router.Get("/"+constants.SilencesAction, h.getSilences)
+	h.Router.Post("/"+constants.SilencesAction, h.addSilence)
+	h.Router.Delete("/"+constants.SilencesAction+"/{silenceID}", h.deleteSilence)
+
 	return h
 }
 
@@ -4,13 +4,24 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
+	"github.com/dnsoftware/go-metrics/internal/server/influx"
+	"github.com/dnsoftware/go-metrics/internal/server/remotewrite"
+	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
 func NewRouter() chi.Router {
@@ -18,6 +29,16 @@ func NewRouter() chi.Router {
 	return r
 }
 
+// isMetricType проверка на допустимый тип метрики
+func isMetricType(metricType string) bool {
+	switch metricType {
+	case constants.Gauge, constants.Counter, constants.Histogram, constants.Summary:
+		return true
+	}
+
+	return false
+}
+
 // getAllMetrics получение всех метрик простым списком
 func (h *HTTPServer) getAllMetrics(res http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
@@ -58,11 +79,16 @@ func (h *HTTPServer) UpdateMetric(res http.ResponseWriter, req *http.Request) {
 	metricName := chi.URLParam(req, constants.MetricName)
 	metricValue := chi.URLParam(req, constants.MetricValue)
 
-	if metricType != constants.Gauge && metricType != constants.Counter {
+	if !isMetricType(metricType) {
 		http.Error(res, "Bad metric type!", http.StatusBadRequest)
 		return
 	}
 
+	if err := storage.ValidateName(metricName); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if metricType == constants.Gauge {
 		gaugeVal, err := strconv.ParseFloat(metricValue, 64)
 
@@ -96,6 +122,42 @@ func (h *HTTPServer) UpdateMetric(res http.ResponseWriter, req *http.Request) {
 
 		res.WriteHeader(http.StatusOK)
 	}
+
+	// для гистограммы в URL передается одиночное наблюдение
+	if metricType == constants.Histogram {
+		observation, err := strconv.ParseFloat(metricValue, 64)
+
+		if err != nil {
+			http.Error(res, "Incorrect metric value!", http.StatusBadRequest)
+			return
+		}
+
+		err = h.collector.ObserveHistogramMetric(ctx, metricName, observation)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}
+
+	// для summary в URL передается одиночное наблюдение
+	if metricType == constants.Summary {
+		observation, err := strconv.ParseFloat(metricValue, 64)
+
+		if err != nil {
+			http.Error(res, "Incorrect metric value!", http.StatusBadRequest)
+			return
+		}
+
+		err = h.collector.ObserveSummaryMetric(ctx, metricName, observation)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}
 }
 
 // UpdateMetricJSON обновление одной метрики. Данные передаются в json формате
@@ -118,28 +180,34 @@ func (h *HTTPServer) UpdateMetricJSON(res http.ResponseWriter, req *http.Request
 		return
 	}
 
-	if metrics.MType != constants.Gauge && metrics.MType != constants.Counter {
+	if !isMetricType(metrics.MType) {
 		http.Error(res, "Bad metric type!", http.StatusBadRequest)
 		return
 	}
 
+	if err = storage.ValidateSeries(metrics.ID, metrics.Labels); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if metrics.MType == constants.Gauge {
-		errG := h.collector.SetGaugeMetric(ctx, metrics.ID, *metrics.Value)
+		errG := h.collector.SetGaugeMetric(ctx, metrics.key(), *metrics.Value)
 		if errG != nil {
 			http.Error(res, errG.Error(), http.StatusBadRequest)
 			return
 		}
 
-		newMetric, errG := h.collector.GetGaugeMetric(ctx, metrics.ID)
+		newMetric, errG := h.collector.GetGaugeMetric(ctx, metrics.key())
 		if errG != nil {
 			http.Error(res, errG.Error(), http.StatusBadRequest)
 			return
 		}
 
 		respMetric := Metrics{
-			ID:    metrics.ID,
-			MType: metrics.MType,
-			Value: &newMetric,
+			ID:     metrics.ID,
+			MType:  metrics.MType,
+			Labels: metrics.Labels,
+			Value:  &newMetric,
 		}
 
 		resp, errG := json.Marshal(respMetric)
@@ -154,22 +222,23 @@ func (h *HTTPServer) UpdateMetricJSON(res http.ResponseWriter, req *http.Request
 	}
 
 	if metrics.MType == constants.Counter {
-		errC := h.collector.SetCounterMetric(ctx, metrics.ID, *metrics.Delta)
+		errC := h.collector.SetCounterMetric(ctx, metrics.key(), *metrics.Delta)
 		if errC != nil {
 			http.Error(res, errC.Error(), http.StatusBadRequest)
 			return
 		}
 
-		newMetric, errC := h.collector.GetCounterMetric(ctx, metrics.ID)
+		newMetric, errC := h.collector.GetCounterMetric(ctx, metrics.key())
 		if errC != nil {
 			http.Error(res, errC.Error(), http.StatusBadRequest)
 			return
 		}
 
 		respMetric := Metrics{
-			ID:    metrics.ID,
-			MType: metrics.MType,
-			Delta: &newMetric,
+			ID:     metrics.ID,
+			MType:  metrics.MType,
+			Labels: metrics.Labels,
+			Delta:  &newMetric,
 		}
 
 		resp, errC := json.Marshal(respMetric)
@@ -182,6 +251,86 @@ func (h *HTTPServer) UpdateMetricJSON(res http.ResponseWriter, req *http.Request
 		res.WriteHeader(http.StatusOK)
 		res.Write(resp)
 	}
+
+	if metrics.MType == constants.Histogram {
+		var errH error
+
+		switch {
+		case metrics.Histogram != nil:
+			errH = h.collector.SetHistogramMetric(ctx, metrics.key(), *metrics.Histogram)
+		case metrics.Value != nil:
+			errH = h.collector.ObserveHistogramMetric(ctx, metrics.key(), *metrics.Value)
+		default:
+			errH = errors.New("histogram or value required")
+		}
+		if errH != nil {
+			http.Error(res, errH.Error(), http.StatusBadRequest)
+			return
+		}
+
+		newMetric, errH := h.collector.GetHistogramMetric(ctx, metrics.key())
+		if errH != nil {
+			http.Error(res, errH.Error(), http.StatusBadRequest)
+			return
+		}
+
+		respMetric := Metrics{
+			ID:        metrics.ID,
+			MType:     metrics.MType,
+			Labels:    metrics.Labels,
+			Histogram: &newMetric,
+		}
+
+		resp, errH := json.Marshal(respMetric)
+		if errH != nil {
+			http.Error(res, errH.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", constants.ApplicationJSON)
+		res.WriteHeader(http.StatusOK)
+		res.Write(resp)
+	}
+
+	if metrics.MType == constants.Summary {
+		var errS error
+
+		switch {
+		case metrics.Summary != nil:
+			errS = h.collector.SetSummaryMetric(ctx, metrics.key(), *metrics.Summary)
+		case metrics.Value != nil:
+			errS = h.collector.ObserveSummaryMetric(ctx, metrics.key(), *metrics.Value)
+		default:
+			errS = errors.New("summary or value required")
+		}
+		if errS != nil {
+			http.Error(res, errS.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stats, errS := h.collector.GetSummaryStats(ctx, metrics.key(), metrics.Quantiles)
+		if errS != nil {
+			http.Error(res, errS.Error(), http.StatusBadRequest)
+			return
+		}
+
+		respMetric := Metrics{
+			ID:     metrics.ID,
+			MType:  metrics.MType,
+			Labels: metrics.Labels,
+			Stats:  &stats,
+		}
+
+		resp, errS := json.Marshal(respMetric)
+		if errS != nil {
+			http.Error(res, errS.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", constants.ApplicationJSON)
+		res.WriteHeader(http.StatusOK)
+		res.Write(resp)
+	}
 }
 
 // UpdatesMetricJSON обновление метрик пакетом, json формат
@@ -197,7 +346,14 @@ func (h *HTTPServer) UpdatesMetricJSON(res http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	err = h.collector.SetBatchMetrics(ctx, buf.Bytes())
+	// повторно отправленный пакет подтверждаем без применения
+	_, err = h.collector.ApplyBatchOnce(ctx, req.Header.Get(constants.BatchIDHeader), func() error {
+		return h.collector.SetBatchMetrics(ctx, buf.Bytes())
+	})
+	if errors.Is(err, storage.ErrBadHistogram) || errors.Is(err, storage.ErrBadSummary) || errors.Is(err, storage.ErrBadLabels) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
@@ -216,7 +372,7 @@ func (h *HTTPServer) getMetricValue(res http.ResponseWriter, req *http.Request)
 	metricType := chi.URLParam(req, constants.MetricType)
 	metricName := chi.URLParam(req, constants.MetricName)
 
-	if metricType != constants.Gauge && metricType != constants.Counter {
+	if !isMetricType(metricType) {
 		http.Error(res, "Bad metric type!", http.StatusBadRequest)
 		return
 	}
@@ -250,26 +406,47 @@ func (h *HTTPServer) getMetricValueJSON(res http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	if metrics.MType != constants.Gauge && metrics.MType != constants.Counter {
+	if !isMetricType(metrics.MType) {
 		http.Error(res, "Bad metric type!", http.StatusBadRequest)
 		return
 	}
 
+	if err = storage.ValidateLabels(metrics.Labels); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	switch metrics.MType {
 	case constants.Gauge:
-		val, errG := h.collector.GetGaugeMetric(ctx, metrics.ID)
+		val, errG := h.collector.GetGaugeMetric(ctx, metrics.key())
 		if errG != nil {
 			http.Error(res, errG.Error(), http.StatusNotFound)
 		}
 
 		metrics.Value = &val
 	case constants.Counter:
-		val, errC := h.collector.GetCounterMetric(ctx, metrics.ID)
+		val, errC := h.collector.GetCounterMetric(ctx, metrics.key())
 		if errC != nil {
 			http.Error(res, errC.Error(), http.StatusNotFound)
 		}
 
 		metrics.Delta = &val
+	case constants.Histogram:
+		val, errH := h.collector.GetHistogramMetric(ctx, metrics.key())
+		if errH != nil {
+			http.Error(res, errH.Error(), http.StatusNotFound)
+			return
+		}
+
+		metrics.Histogram = &val
+	case constants.Summary:
+		val, errS := h.collector.GetSummaryStats(ctx, metrics.key(), metrics.Quantiles)
+		if errS != nil {
+			http.Error(res, errS.Error(), http.StatusNotFound)
+			return
+		}
+
+		metrics.Stats = &val
 	}
 
 	resp, err := json.Marshal(metrics)
@@ -283,6 +460,474 @@ func (h *HTTPServer) getMetricValueJSON(res http.ResponseWriter, req *http.Reque
 	res.Write(resp)
 }
 
+// getSeries поиск серий по селектору меток из параметра match, например Alloc{host=~"web-.*"}.
+// Пустой селектор - все серии. Для summary возвращаются квантили по умолчанию
+func (h *HTTPServer) getSeries(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	selector, err := storage.ParseSelector(req.URL.Query().Get(constants.MatchParam))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := h.collector.FindSeries(ctx, selector)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	metrics := make([]Metrics, 0, len(series))
+	for _, s := range series {
+		m := Metrics{
+			ID:        s.ID,
+			MType:     s.MType,
+			Labels:    s.Labels,
+			Delta:     s.Delta,
+			Value:     s.Value,
+			Histogram: s.Histogram,
+		}
+
+		if s.MType == constants.Summary {
+			stats, errS := h.collector.GetSummaryStats(ctx, s.Key(), nil)
+			if errS != nil {
+				http.Error(res, errS.Error(), http.StatusInternalServerError)
+				return
+			}
+			m.Stats = &stats
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	resp, err := json.Marshal(metrics)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", constants.ApplicationJSON)
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
+
+// getPrometheusMetrics все серии в текстовом формате экспозиции Prometheus.
+// Формат OpenMetrics отдается, если он предпочтительнее по заголовку Accept
+func (h *HTTPServer) getPrometheusMetrics(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	series, err := h.collector.FindSeries(ctx, storage.Selector{})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exp := newExposition(acceptsOpenMetrics(req.Header.Get("Accept")))
+	for _, s := range series {
+		var stats *storage.SummaryStats
+		if s.MType == constants.Summary {
+			st, errS := h.collector.GetSummaryStats(ctx, s.Key(), nil)
+			if errS != nil {
+				http.Error(res, errS.Error(), http.StatusInternalServerError)
+				return
+			}
+			stats = &st
+		}
+
+		exp.add(s, stats)
+	}
+
+	var buf bytes.Buffer
+	err = exp.write(&buf)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", exp.contentType())
+	res.WriteHeader(http.StatusOK)
+	res.Write(buf.Bytes())
+}
+
+// remoteWrite прием Prometheus remote_write: сжатый snappy protobuf WriteRequest.
+// Все значения серий сохраняются в историю gauge со своим временем, последнее по времени становится текущим.
+// Недопустимые серии пропускаются с записью в лог, остальные сохраняются одним пакетом.
+// Неразборчивый запрос - код 400 (Prometheus не повторяет отправку),
+// ошибка сохранения - код 500 (пакет не сохранен и повторяется целиком)
+func (h *HTTPServer) remoteWrite(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	if req.Header.Get("Content-Encoding") != constants.EncodingSnappy {
+		http.Error(res, "snappy encoding required", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(res, req.Body, int64(constants.RemoteWriteMaxSize)))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	wr, err := remotewrite.Decode(body, constants.RemoteWriteMaxSize)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, dropped := wr.Series()
+	if dropped > 0 {
+		logger.Log().Info("remote write: samples without metric name or finite value dropped", zap.Int("count", dropped))
+	}
+
+	// недопустимая серия не сохранится и при повторе: она пропускается, остальные сохраняются
+	samples := make(map[string][]storage.Sample, len(series))
+	for _, s := range series {
+		err = storage.ValidateSeries(s.Metric.ID, s.Metric.Labels)
+		if err != nil {
+			logger.Log().Info("remote write: series dropped", zap.String("error", err.Error()))
+			continue
+		}
+		samples[s.Metric.Key()] = s.Samples
+	}
+
+	if len(samples) > 0 {
+		err = h.collector.SetGaugeSamples(ctx, samples)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// influxWrite прием InfluxDB line protocol, например /write?precision=s.
+// Поля сохраняются метриками по правилам сопоставления. Строки без ошибок сохраняются,
+// при ошибках в строках - код 400 и список ошибок по строкам в формате json
+func (h *HTTPServer) influxWrite(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	precision, err := influx.ParsePrecision(req.URL.Query().Get(constants.PrecisionParam))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(res, req.Body, int64(constants.InfluxMaxSize)))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	points, lineErrs := influx.Parse(body, precision, time.Now())
+
+	metrics, mapErrs := h.collector.InfluxRules().Metrics(points)
+	lineErrs = append(lineErrs, mapErrs...)
+
+	// значения gauge со временем точек в историю, затем приращения counter:
+	// повтор запроса после ошибки записи counter не задваивает приращения
+	if len(metrics.Gauges) > 0 {
+		err = h.collector.SetGaugeSamples(ctx, metrics.Gauges)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(metrics.Counters) > 0 {
+		batch, errM := json.Marshal(metrics.Counters)
+		if errM != nil {
+			http.Error(res, errM.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = h.collector.SetBatchMetrics(ctx, batch)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(lineErrs) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	sort.Slice(lineErrs, func(i, j int) bool { return lineErrs[i].Line < lineErrs[j].Line })
+
+	resp, err := json.Marshal(InfluxWriteResult{
+		Error: fmt.Sprintf("partial write: %d lines rejected", len(lineErrs)),
+		Lines: lineErrs,
+	})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", constants.ApplicationJSON)
+	res.WriteHeader(http.StatusBadRequest)
+	res.Write(resp)
+}
+
+// queryRange история значений серии за период в формате json,
+// например /query_range?type=gauge&name=Alloc&labels=host=web-1&from=1700000000&to=1700003600&step=1m&agg=max
+func (h *HTTPServer) queryRange(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	query := req.URL.Query()
+
+	name := query.Get(constants.NameParam)
+	if name == "" {
+		http.Error(res, "metric name required", http.StatusBadRequest)
+		return
+	}
+
+	labels, err := storage.ParseLabels(query.Get(constants.LabelsParam))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseRangeTime(query.Get(constants.ToParam), time.Now())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseRangeTime(query.Get(constants.FromParam), to.Add(-constants.QueryRangeDefault))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	step, err := parseRangeStep(query.Get(constants.StepParam))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := storage.RangeQuery{
+		MType: query.Get(constants.TypeParam),
+		Name:  storage.SeriesKey(name, labels),
+		From:  from,
+		To:    to,
+		Step:  step,
+		Agg:   query.Get(constants.AggParam),
+	}
+	if q.Agg == "" {
+		q.Agg = constants.AggLast
+	}
+
+	points, resolution, err := h.collector.QueryRange(ctx, q)
+	if err != nil {
+		if errors.Is(err, storage.ErrBadRange) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := RangeResult{ID: name, MType: q.MType, Agg: q.Agg, Resolution: resolution.Milliseconds(), Points: points}
+	if len(labels) > 0 {
+		result.Labels = labels
+	}
+
+	resp, err := json.Marshal(result)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", constants.ApplicationJSON)
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
+
+// parseRangeTime момент времени из unix времени в секундах (допускается дробная часть) или RFC3339.
+// Пустая строка - значение по умолчанию def
+func parseRangeTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.UnixMilli(int64(sec * 1000)), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: bad time %q", storage.ErrBadRange, s)
+	}
+
+	return t, nil
+}
+
+// parseRangeStep шаг из длительности (15s, 1m) или количества секунд. Пустая строка - без шага
+func parseRangeStep(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad step %q", storage.ErrBadRange, s)
+	}
+
+	return d, nil
+}
+
+// getAlerts список текущих алертов в формате json
+func (h *HTTPServer) getAlerts(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	alerts, err := h.collector.GetAlerts(ctx)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(alerts)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", constants.ApplicationJSON)
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
+
+// acknowledgeAlert подтверждение сработавшего алерта.
+// Название правила берется из URL формата "/alerts/{alertRule}/ack", кто подтвердил - из json {"by": "..."}
+func (h *HTTPServer) acknowledgeAlert(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	var (
+		buf bytes.Buffer
+		ack struct {
+			By string `json:"by"`
+		}
+	)
+
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if buf.Len() > 0 {
+		if err = json.Unmarshal(buf.Bytes(), &ack); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.collector.AcknowledgeAlert(ctx, chi.URLParam(req, constants.AlertRule), ack.By)
+	switch {
+	case errors.Is(err, alerting.ErrAlertNotFound):
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, alerting.ErrAlertNotFiring):
+		http.Error(res, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+// getSilences список заглушек алертов в формате json
+func (h *HTTPServer) getSilences(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	silences, err := h.collector.GetSilences(ctx)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(silences)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", constants.ApplicationJSON)
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
+
+// addSilence добавление заглушки алертов. Данные передаются в json формате, в ответ - заглушка с ID
+func (h *HTTPServer) addSilence(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	var (
+		buf     bytes.Buffer
+		silence alerting.Silence
+	)
+
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &silence); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	silence, err = h.collector.AddSilence(ctx, silence)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := json.Marshal(silence)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", constants.ApplicationJSON)
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
+
+// deleteSilence удаление заглушки алертов. ID берется из URL формата "/silences/{silenceID}"
+func (h *HTTPServer) deleteSilence(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
+	defer cancel()
+
+	err := h.collector.DeleteSilence(ctx, chi.URLParam(req, constants.SilenceID))
+	if errors.Is(err, alerting.ErrSilenceNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
 // RootHandler Deprecated: версия из первого инкремента
 func (h *HTTPServer) RootHandler(res http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
@@ -385,16 +1030,26 @@ func (h *HTTPServer) unrecognized(res http.ResponseWriter, _ *http.Request) {
 	http.Error(res, "Not found!", http.StatusNotFound)
 }
 
-// databasePing пинг базы данных для проверки работоспособности
+// databasePing пинг базы данных для проверки работоспособности.
+// В теле ответа состояние хранилища. Код 200 - сервер принимает обновления: БД доступна (ok)
+// или обновления копятся в памяти до ее восстановления (degraded), код 500 - БД недоступна или не используется
 func (h *HTTPServer) databasePing(res http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(req.Context(), constants.DBContextTimeout)
 	defer cancel()
 
-	isConnected := h.collector.DatabasePing(ctx)
+	health := h.collector.Health(ctx)
 
-	if isConnected {
-		res.WriteHeader(http.StatusOK)
-	} else {
+	resp, err := json.Marshal(health)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", constants.ApplicationJSON)
+	if health.Status == constants.HealthUnavailable {
 		res.WriteHeader(http.StatusInternalServerError)
+	} else {
+		res.WriteHeader(http.StatusOK)
 	}
+	res.Write(resp)
 }
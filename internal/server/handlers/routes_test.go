@@ -1,18 +1,33 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/s2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
 	"github.com/dnsoftware/go-metrics/internal/server/collector"
 	"github.com/dnsoftware/go-metrics/internal/server/config"
+	"github.com/dnsoftware/go-metrics/internal/server/remotewrite"
 	"github.com/dnsoftware/go-metrics/internal/storage"
 )
 
@@ -196,16 +211,58 @@ func TestRouter(t *testing.T) {
 	defer respGet.Body.Close()
 	assert.Equal(t, http.StatusBadRequest, respGet.StatusCode)
 
+	// NaN и бесконечность отклоняются, список метрик остается доступен
+	for _, v := range []string{"NaN", "Inf", "-Inf"} {
+		respGet, _ = testRequest(t, ts, "POST", "/update/gauge/testGet33/"+v, nil)
+		defer respGet.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, respGet.StatusCode, v)
+	}
+
+	respGet, _ = testRequest(t, ts, "GET", "/", nil)
+	defer respGet.Body.Close()
+	assert.Equal(t, http.StatusOK, respGet.StatusCode)
+
 	respGet, _ = testRequest(t, ts, "POST", "/update/counter/testGet33/www", nil)
 	defer respGet.Body.Close()
 	assert.Equal(t, http.StatusBadRequest, respGet.StatusCode)
 
-	respGet, _ = testRequest(t, ts, "GET", "/ping", nil)
+	respGet, body := testRequest(t, ts, "GET", "/ping", nil)
 	defer respGet.Body.Close()
 	assert.Equal(t, http.StatusInternalServerError, respGet.StatusCode)
+	assert.JSONEq(t, `{"status":"unavailable"}`, body)
 
 }
 
+// degradedStorage хранилище в памяти, работающее без БД
+type degradedStorage struct {
+	*storage.MemStorage
+}
+
+func (s degradedStorage) Health(ctx context.Context) storage.Health {
+	return storage.Health{Status: constants.HealthDegraded, Pending: 3}
+}
+
+// Сервер без БД, копящий обновления в памяти, отвечает на пинг кодом 200 с состоянием в теле
+func TestPingDegraded(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval: constants.BackupPeriod,
+		RestoreSaved:  false,
+	}
+
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, degradedStorage{storage.NewMemStorage()}, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, "GET", "/ping", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, constants.ApplicationJSON, resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"status":"degraded","pending":3}`, body)
+}
+
 // Тестирование принадлежности IP адреса клиента к подсети
 func TestSubnet(t *testing.T) {
 	cfg := config.ServerConfig{
@@ -241,6 +298,417 @@ func TestSubnet(t *testing.T) {
 
 }
 
+func TestAlerts(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval:  constants.BackupPeriod,
+		RestoreSaved:   false,
+		AlertRulesPath: "../alerting/testdata/rules.yaml",
+		AlertInterval:  1,
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, "GET", "/alerts", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "[]", body)
+
+	resp, _ = testRequest(t, ts, "POST", "/update/counter/"+constants.PollCount+"/150", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// ждем проверки правил
+	time.Sleep(1500 * time.Millisecond)
+
+	resp, body = testRequest(t, ts, "GET", "/alerts", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, constants.ApplicationJSON, resp.Header.Get("Content-Type"))
+
+	var alerts []alerting.Alert
+	require.NoError(t, json.Unmarshal([]byte(body), &alerts))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "TooManyPolls", alerts[0].Rule)
+	assert.Equal(t, constants.AlertStateFiring, alerts[0].State)
+	assert.Equal(t, float64(150), alerts[0].Value)
+}
+
+func TestSilencesAndAck(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval:  constants.BackupPeriod,
+		RestoreSaved:   false,
+		AlertRulesPath: "../alerting/testdata/rules.yaml",
+		AlertInterval:  1,
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	// заглушки
+	resp, body := testRequestWithBody(t, ts, "POST", "/silences", `{"matcher":"Poll*","ends_at":"2999-01-01T00:00:00Z","created_by":"admin"}`)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var silence alerting.Silence
+	require.NoError(t, json.Unmarshal([]byte(body), &silence))
+	assert.NotEmpty(t, silence.ID)
+	assert.Equal(t, "Poll*", silence.Matcher)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/silences", `{"matcher":"Poll*"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = testRequest(t, ts, "GET", "/silences", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var silences []alerting.Silence
+	require.NoError(t, json.Unmarshal([]byte(body), &silences))
+	require.Len(t, silences, 1)
+	assert.Equal(t, silence.ID, silences[0].ID)
+
+	// подтверждение
+	resp, _ = testRequestWithBody(t, ts, "POST", "/alerts/TooManyPolls/ack", `{"by":"admin"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, "POST", "/update/counter/"+constants.PollCount+"/150", nil)
+	defer resp.Body.Close()
+
+	// ждем проверки правил
+	time.Sleep(1500 * time.Millisecond)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/alerts/TooManyPolls/ack", `{"by":"admin"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, body = testRequest(t, ts, "GET", "/alerts", nil)
+	var alerts []alerting.Alert
+	require.NoError(t, json.Unmarshal([]byte(body), &alerts))
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Silenced)
+	assert.Equal(t, "admin", alerts[0].AckedBy)
+
+	// удаление заглушки
+	resp, _ = testRequest(t, ts, "DELETE", "/silences/"+silence.ID, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, "DELETE", "/silences/"+silence.ID, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHistogram(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval:   constants.BackupPeriod,
+		RestoreSaved:    false,
+		HistogramBounds: "0.1,1",
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	// одиночное наблюдение через URL
+	resp, _ := testRequest(t, ts, "POST", "/update/histogram/Latency/0.05", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, "POST", "/update/histogram/Latency/bad", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// гистограмма в json
+	resp, body := testRequestWithBody(t, ts, "POST", "/update", `{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[0,1,1],"sum":5.5,"count":2}}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,1,1],"sum":5.55,"count":3}}`, body)
+
+	// одиночное наблюдение в json
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Latency","type":"histogram","value":0.5}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// несовпадающие границы
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Latency","type":"histogram","histogram":{"bounds":[1,2],"counts":[0,0,1],"sum":5,"count":1}}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Latency","type":"histogram"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// пакет
+	resp, _ = testRequestWithBody(t, ts, "POST", "/updates", `[{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,0,0],"sum":0.01,"count":1}},{"id":"PollCount","type":"counter","delta":1}]`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/updates", `[{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1],"sum":0.01,"count":1}}]`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// чтение
+	resp, body = testRequestWithBody(t, ts, "POST", "/value", `{"id":"Latency","type":"histogram"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var m Metrics
+	require.NoError(t, json.Unmarshal([]byte(body), &m))
+	require.NotNil(t, m.Histogram)
+	assert.Equal(t, []uint64{2, 2, 1}, m.Histogram.Counts)
+	assert.Equal(t, uint64(5), m.Histogram.Count)
+	assert.InDelta(t, 6.06, m.Histogram.Sum, 1e-9)
+
+	resp, body = testRequest(t, ts, "GET", "/value/histogram/Latency", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"count":5`)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/value", `{"id":"NoSuch","type":"histogram"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestSummary(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval: constants.BackupPeriod,
+		RestoreSaved:  false,
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	// одиночные наблюдения через URL
+	for i := 1; i <= 50; i++ {
+		resp, _ := testRequest(t, ts, "POST", "/update/summary/Latency/"+strconv.Itoa(i), nil)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, _ := testRequest(t, ts, "POST", "/update/summary/Latency/bad", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// скетч агента в json
+	sketch := storage.NewSummary(0)
+	for i := 51; i <= 100; i++ {
+		sketch.Observe(float64(i))
+	}
+	data, err := json.Marshal(Metrics{ID: "Latency", MType: constants.Summary, Summary: &sketch})
+	require.NoError(t, err)
+
+	resp, body := testRequestWithBody(t, ts, "POST", "/update", string(data))
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var m Metrics
+	require.NoError(t, json.Unmarshal([]byte(body), &m))
+	require.NotNil(t, m.Stats)
+	assert.Equal(t, uint64(100), m.Stats.Count)
+	assert.InEpsilon(t, 50, m.Stats.Quantiles["0.5"], 0.02)
+	assert.InEpsilon(t, 99, m.Stats.Quantiles["0.99"], 0.02)
+
+	// одиночное наблюдение в json
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Latency","type":"summary","value":0}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// другая точность
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Latency","type":"summary","summary":{"alpha":0.05,"zero":1,"count":1}}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Latency","type":"summary"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// пакет
+	resp, _ = testRequestWithBody(t, ts, "POST", "/updates", `[{"id":"Latency","type":"summary","value":1000},{"id":"PollCount","type":"counter","delta":1}]`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/updates", `[{"id":"Latency","type":"summary","summary":{"alpha":0.01,"count":3}}]`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// чтение с заданными квантилями
+	resp, body = testRequestWithBody(t, ts, "POST", "/value", `{"id":"Latency","type":"summary","quantiles":[0,1]}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	m = Metrics{}
+	require.NoError(t, json.Unmarshal([]byte(body), &m))
+	require.NotNil(t, m.Stats)
+	assert.Equal(t, uint64(102), m.Stats.Count)
+	assert.Equal(t, map[string]float64{"0": 0, "1": 1000}, m.Stats.Quantiles)
+
+	resp, body = testRequest(t, ts, "GET", "/value/summary/Latency", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"0.99":`)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/value", `{"id":"NoSuch","type":"summary"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestConcurrentCounterUpdates(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval:   constants.BackupPeriod,
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics-db.json"),
+		RestoreSaved:    true,
+	}
+
+	backupStorage, err := storage.NewBackupStorage(cfg.FileStoragePath)
+	require.NoError(t, err)
+	collect, err := collector.NewCollector(&cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	const (
+		workers  = 50
+		requests = 100
+	)
+
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Int64
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < requests; j++ {
+				resp, errP := ts.Client().Post(ts.URL+"/update/counter/"+constants.PollCount+"/1", constants.TextPlain, nil)
+				if errP != nil {
+					failed.Add(1)
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusOK {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Zero(t, failed.Load())
+
+	resp, body := testRequest(t, ts, "GET", "/value/counter/"+constants.PollCount, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, strconv.Itoa(workers*requests), body)
+
+	// приращения в журнале восстанавливаются с тем же итогом
+	require.NoError(t, backupStorage.Close())
+	backupStorage, err = storage.NewBackupStorage(cfg.FileStoragePath)
+	require.NoError(t, err)
+	defer backupStorage.Close()
+
+	collect, err = collector.NewCollector(&cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+	val, err := collect.GetCounterMetric(context.Background(), constants.PollCount)
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*requests), val)
+}
+
+// Повторно отправленный пакет с тем же идентификатором подтверждается без применения
+func TestBatchReplay(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval: constants.BackupPeriod,
+		RestoreSaved:  false,
+	}
+
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, storage.NewMemStorage(), backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	send := func(batchID string, body string) int {
+		req, err := http.NewRequestWithContext(context.Background(), "POST", ts.URL+"/updates", strings.NewReader(body))
+		require.NoError(t, err)
+		if batchID != "" {
+			req.Header.Set(constants.BatchIDHeader, batchID)
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		return resp.StatusCode
+	}
+
+	counter := func() string {
+		resp, body := testRequest(t, ts, "GET", "/value/counter/"+constants.PollCount, nil)
+		defer resp.Body.Close()
+		return body
+	}
+
+	batch := `[{"id":"PollCount","type":"counter","delta":3},{"id":"Alloc","type":"gauge","value":1}]`
+
+	assert.Equal(t, http.StatusOK, send("a1", batch))
+	assert.Equal(t, http.StatusOK, send("a1", batch))
+	assert.Equal(t, "3", counter())
+
+	assert.Equal(t, http.StatusOK, send("a2", batch))
+	assert.Equal(t, "6", counter())
+
+	// без идентификатора пакеты не отбрасываются
+	assert.Equal(t, http.StatusOK, send("", batch))
+	assert.Equal(t, http.StatusOK, send("", batch))
+	assert.Equal(t, "12", counter())
+
+	// отклоненный пакет не запоминается, исправленный повтор применяется
+	assert.Equal(t, http.StatusBadRequest, send("a3", `[{"id":"PollCount","type":"counter","delta":1,"labels":{"host-name":"a"}}]`))
+	assert.Equal(t, http.StatusOK, send("a3", batch))
+	assert.Equal(t, "15", counter())
+}
+
+func testRequestWithBody(t *testing.T, ts *httptest.Server, method, path string, body string) (*http.Response, string) {
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, method, ts.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(respBody)
+}
+
 func testRequest(t *testing.T, ts *httptest.Server, method, path string, headers map[string]string) (*http.Response, string) {
 	ctx := context.Background()
 	req, err := http.NewRequestWithContext(ctx, method, ts.URL+path, nil)
@@ -259,3 +727,364 @@ func testRequest(t *testing.T, ts *httptest.Server, method, path string, headers
 
 	return resp, string(respBody)
 }
+
+func TestLabels(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval: constants.BackupPeriod,
+		RestoreSaved:  false,
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	// одна метрика с разными метками - разные серии
+	resp, body := testRequestWithBody(t, ts, "POST", "/update", `{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"a1"}}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"id":"Alloc","type":"gauge","labels":{"host":"a1"},"value":1}`, body)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"b1"}}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Alloc","type":"gauge","value":3,"labels":{"1host":"a1"}}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// название в виде ключа серии перезаписало бы серию с метками
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update", `{"id":"Alloc{host=\"a1\"}","type":"gauge","value":3}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/update/gauge/"+url.PathEscape(`Alloc{host="a1"}`)+"/3", "")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = testRequestWithBody(t, ts, "POST", "/value", `{"id":"Alloc","type":"gauge","labels":{"host":"a1"}}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"id":"Alloc","type":"gauge","labels":{"host":"a1"},"value":1}`, body)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/value", `{"id":"Alloc","type":"gauge"}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// пакет
+	resp, _ = testRequestWithBody(t, ts, "POST", "/updates", `[{"id":"PollCount","type":"counter","delta":5,"labels":{"host":"a2"}}]`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/updates", `[{"id":"PollCount","type":"counter","delta":5,"labels":{"host-name":"a2"}}]`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/updates", `[{"id":"PollCount{host=\"a2\"}","type":"counter","delta":5}]`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// выборка серий по селектору
+	resp, body = testRequest(t, ts, "GET", "/series?match="+url.QueryEscape(`{host=~"a.*"}`), nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var series []Metrics
+	require.NoError(t, json.Unmarshal([]byte(body), &series))
+	require.Len(t, series, 2)
+	assert.Equal(t, "PollCount", series[0].ID)
+	assert.Equal(t, int64(5), *series[0].Delta)
+	assert.Equal(t, "Alloc", series[1].ID)
+	assert.Equal(t, storage.Labels{"host": "a1"}, series[1].Labels)
+
+	resp, body = testRequest(t, ts, "GET", "/series?match="+url.QueryEscape(`Alloc{host!="a1"}`), nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `[{"id":"Alloc","type":"gauge","labels":{"host":"b1"},"value":2}]`, body)
+
+	resp, _ = testRequest(t, ts, "GET", "/series?match="+url.QueryEscape(`Alloc{host=a1}`), nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestQueryRange(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval: constants.BackupPeriod,
+		RestoreSaved:  false,
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	for _, v := range []string{"1", "2", "3"} {
+		resp, _ := testRequest(t, ts, "POST", "/update/gauge/Alloc/"+v, nil)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, _ := testRequestWithBody(t, ts, "POST", "/update", `{"id":"Alloc","type":"gauge","value":10,"labels":{"host":"a"}}`)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// по умолчанию - все значения за последний час
+	resp, body := testRequest(t, ts, "GET", "/query_range?type=gauge&name=Alloc", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result RangeResult
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	require.Len(t, result.Points, 3)
+	assert.Equal(t, float64(3), result.Points[2].Value)
+	assert.Equal(t, constants.AggLast, result.Agg)
+	assert.Equal(t, int64(0), result.Resolution)
+
+	// агрегат за шаг по исходным значениям
+	resp, body = testRequest(t, ts, "GET", "/query_range?type=gauge&name=Alloc&step=30s&agg=avg", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	result = RangeResult{}
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	require.Len(t, result.Points, 1)
+	assert.Equal(t, float64(2), result.Points[0].Value)
+
+	// шаг больше часа - уровень часовых агрегатов
+	resp, body = testRequest(t, ts, "GET", "/query_range?type=gauge&name=Alloc&step=2h", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"resolution":3600000,`)
+
+	// серия с метками, период и шаг
+	from := time.Now().Add(-time.Minute)
+	query := url.Values{
+		"type":   {"gauge"},
+		"name":   {"Alloc"},
+		"labels": {"host=a"},
+		"from":   {strconv.FormatInt(from.Unix(), 10)},
+		"to":     {time.Now().Add(time.Minute).Format(time.RFC3339)},
+		"step":   {"30s"},
+	}
+	resp, body = testRequest(t, ts, "GET", "/query_range?"+query.Encode(), nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	result = RangeResult{}
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	assert.Equal(t, storage.Labels{"host": "a"}, result.Labels)
+	require.Len(t, result.Points, 1)
+	assert.Equal(t, float64(10), result.Points[0].Value)
+
+	for _, q := range []string{
+		"type=gauge",
+		"type=summary&name=Alloc",
+		"type=gauge&name=Alloc&from=yesterday",
+		"type=gauge&name=Alloc&step=often",
+		"type=gauge&name=Alloc&from=200&to=100",
+		"type=gauge&name=Alloc&labels=bad",
+		"type=gauge&name=Alloc&agg=median",
+	} {
+		resp, _ = testRequest(t, ts, "GET", "/query_range?"+q, nil)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, q)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval: constants.BackupPeriod,
+		RestoreSaved:  false,
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	resp, _ := testRequestWithBody(t, ts, "POST", "/updates", `[{"id":"Alloc","type":"gauge","value":1.5,"labels":{"host":"a1"}},{"id":"PollCount","type":"counter","delta":5},{"id":"Latency","type":"summary","value":2}]`)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := testRequest(t, ts, "GET", "/metrics", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, constants.PrometheusText, resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE Alloc gauge\nAlloc{host=\"a1\"} 1.5\n")
+	assert.Contains(t, body, "# TYPE PollCount counter\nPollCount 5\n")
+	assert.Contains(t, body, "# TYPE Latency summary\n")
+	assert.Contains(t, body, "Latency_count 1\n")
+	assert.NotContains(t, body, "# EOF")
+
+	resp, body = testRequest(t, ts, "GET", "/metrics", map[string]string{"Accept": "application/openmetrics-text;version=1.0.0,text/plain;q=0.5"})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, constants.OpenMetricsText, resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE PollCount counter\nPollCount_total 5\n")
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+}
+
+func TestRemoteWrite(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval: constants.BackupPeriod,
+		RestoreSaved:  false,
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	payload, err := os.ReadFile("../remotewrite/testdata/write_request.snappy")
+	require.NoError(t, err)
+
+	write := func(body []byte, encoding string) int {
+		req, errR := http.NewRequestWithContext(context.Background(), "POST", ts.URL+"/api/v1/write", bytes.NewReader(body))
+		require.NoError(t, errR)
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", encoding)
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+		resp, errR := ts.Client().Do(req)
+		require.NoError(t, errR)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusNoContent, write(payload, "snappy"))
+
+	resp, body := testRequest(t, ts, "GET", "/series?match="+url.QueryEscape(`http_requests_total{method="GET"}`), nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `[{"id":"http_requests_total","type":"gauge","labels":{"instance":"api-1:9090","job":"api","method":"GET"},"value":12}]`, body)
+
+	resp, body = testRequest(t, ts, "GET", "/series?match="+url.QueryEscape(`{job="api"}`), nil)
+	defer resp.Body.Close()
+	var series []Metrics
+	require.NoError(t, json.Unmarshal([]byte(body), &series))
+	assert.Len(t, series, 3)
+
+	// все значения серии - в истории со своим временем
+	samples, err := repository.QueryRange(context.Background(), constants.Gauge,
+		`http_requests_total{instance="api-1:9090",job="api",method="GET"}`, time.UnixMilli(0), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Sample{{Timestamp: 1700000000000, Value: 10}, {Timestamp: 1700000015000, Value: 12}}, samples)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, write(payload, ""))
+	assert.Equal(t, http.StatusBadRequest, write(payload[:len(payload)-5], "snappy"))
+
+	// недопустимые серии пропускаются, остальные сохраняются: повтор запроса их не исправит
+	long := strings.Repeat("a", constants.MetricNameMaxLen+1)
+	payload = encodeWriteRequest(
+		remotewrite.TimeSeries{Labels: []remotewrite.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "batch"}}},
+		remotewrite.TimeSeries{Labels: []remotewrite.Label{{Name: "__name__", Value: "up"}, {Name: "1job", Value: "batch"}}},
+		remotewrite.TimeSeries{Labels: []remotewrite.Label{{Name: "__name__", Value: long}, {Name: "job", Value: "batch"}}},
+	)
+	assert.Equal(t, http.StatusNoContent, write(payload, "snappy"))
+
+	resp, body = testRequest(t, ts, "GET", "/series?match="+url.QueryEscape(`{job="batch"}`), nil)
+	defer resp.Body.Close()
+	assert.Equal(t, `[{"id":"up","type":"gauge","labels":{"job":"batch"},"value":1}]`, body)
+}
+
+// encodeWriteRequest сжатый snappy WriteRequest из серий со значением 1
+func encodeWriteRequest(series ...remotewrite.TimeSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(1))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(time.Now().UnixMilli()))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+
+	return s2.EncodeSnappy(nil, req)
+}
+
+func TestInfluxWrite(t *testing.T) {
+	cfg := config.ServerConfig{
+		StoreInterval:   constants.BackupPeriod,
+		RestoreSaved:    false,
+		InfluxRulesPath: "../influx/testdata/rules.yaml",
+	}
+
+	repository := storage.NewMemStorage()
+	backupStorage, _ := storage.NewBackupStorage(constants.FileStoragePath)
+	collect, err := collector.NewCollector(&cfg, repository, backupStorage)
+	require.NoError(t, err)
+	server := NewServer(collect, "key", nil, "")
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	lines := "net,host=gw-1 bytes_recv=100i,signal=-67.5 1700000000\n" +
+		"net,host=gw-1 bytes_recv=50i 1700000010\n"
+	resp, body := testRequestWithBody(t, ts, "POST", "/write?precision=s", lines)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, body)
+
+	resp, body = testRequest(t, ts, "GET", "/series?match="+url.QueryEscape(`{host="gw-1"}`), nil)
+	defer resp.Body.Close()
+	assert.Equal(t, `[{"id":"net_bytes_recv","type":"counter","labels":{"host":"gw-1"},"delta":150},{"id":"net_signal","type":"gauge","labels":{"host":"gw-1"},"value":-67.5}]`, body)
+
+	// значения gauge - в истории со временем точки
+	samples, err := repository.QueryRange(context.Background(), constants.Gauge,
+		storage.SeriesKey("net_signal", storage.Labels{"host": "gw-1"}), time.UnixMilli(0), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Sample{{Timestamp: 1700000000000, Value: -67.5}}, samples)
+
+	// строки с ошибками не сохраняются, остальные сохраняются
+	lines = "net,host=gw-2 signal=-70\n" +
+		"net,host=gw-2 signal=\n" +
+		"net,host=gw-2 bytes_sent=1.5\n"
+	resp, body = testRequestWithBody(t, ts, "POST", "/write", lines)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, constants.ApplicationJSON, resp.Header.Get("Content-Type"))
+
+	var result InfluxWriteResult
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	assert.Equal(t, "partial write: 2 lines rejected", result.Error)
+	require.Len(t, result.Lines, 2)
+	assert.Equal(t, 2, result.Lines[0].Line)
+	assert.Equal(t, 3, result.Lines[1].Line)
+	assert.Equal(t, "field bytes_sent: counter requires integer value", result.Lines[1].Err)
+
+	val, err := collect.GetGaugeMetric(context.Background(), storage.SeriesKey("net_signal", storage.Labels{"host": "gw-2"}))
+	require.NoError(t, err)
+	assert.Equal(t, float64(-70), val)
+
+	resp, _ = testRequestWithBody(t, ts, "POST", "/write?precision=d", lines)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,249 @@
+// Package influx прием данных в формате InfluxDB line protocol и сопоставление полей метрикам
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadPrecision неизвестная точность времени
+var ErrBadPrecision = errors.New("bad precision")
+
+// Типы значений полей.
+const (
+	FieldFloat  = "float"
+	FieldInt    = "integer"
+	FieldUint   = "unsigned"
+	FieldBool   = "boolean"
+	FieldString = "string"
+)
+
+// Field поле точки
+type Field struct {
+	Key   string
+	Kind  string  // тип значения
+	Float float64 // числовое значение (для boolean - 1 или 0)
+	Int   int64   // значение целочисленного поля без потери точности
+	Str   string  // значение строкового поля
+}
+
+// Point точка: измерение, теги, поля и время
+type Point struct {
+	Line        int // номер строки запроса, начиная с 1
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	Time        time.Time
+}
+
+// LineError ошибка разбора или сопоставления строки, строка не сохраняется
+type LineError struct {
+	Line int    `json:"line"`
+	Err  string `json:"error"`
+}
+
+// ParsePrecision единица времени точек по параметру precision запроса (форматы API v1 и v2), пусто - наносекунды
+func ParsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrBadPrecision, s)
+}
+
+// Parse разбор строк line protocol. Пустые строки и комментарии пропускаются.
+// Параметры: precision - единица времени точек, now - время точек без метки времени.
+// Возвращает разобранные точки и ошибки остальных строк.
+func Parse(data []byte, precision time.Duration, now time.Time) ([]Point, []LineError) {
+	var (
+		points []Point
+		errs   []LineError
+	)
+
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		s := strings.TrimSpace(string(line))
+		if s == "" || s[0] == '#' {
+			continue
+		}
+
+		p, err := parseLine(s, precision, now)
+		if err != nil {
+			errs = append(errs, LineError{Line: i + 1, Err: err.Error()})
+			continue
+		}
+
+		p.Line = i + 1
+		points = append(points, p)
+	}
+
+	return points, errs
+}
+
+// parseLine разбор строки вида measurement[,tag=value...] field=value[,field=value...] [timestamp]
+func parseLine(s string, precision time.Duration, now time.Time) (Point, error) {
+	p := Point{Tags: map[string]string{}, Time: now}
+
+	var rest string
+
+	p.Measurement, rest = readToken(s, ", ", ", ")
+	if p.Measurement == "" {
+		return Point{}, errors.New("measurement required")
+	}
+
+	for strings.HasPrefix(rest, ",") {
+		var key, value string
+
+		key, rest = readToken(rest[1:], ",= ", ",= ")
+		if key == "" || !strings.HasPrefix(rest, "=") {
+			return Point{}, errors.New("bad tag")
+		}
+
+		value, rest = readToken(rest[1:], ", ", ",= ")
+		if value == "" {
+			return Point{}, fmt.Errorf("tag %s: empty value", key)
+		}
+		p.Tags[key] = value
+	}
+
+	rest = strings.TrimLeft(rest, " ")
+	if rest == "" {
+		return Point{}, errors.New("fields required")
+	}
+
+	for {
+		var (
+			f   Field
+			err error
+		)
+
+		f.Key, rest = readToken(rest, ",= ", ",= ")
+		if f.Key == "" || !strings.HasPrefix(rest, "=") {
+			return Point{}, errors.New("bad field")
+		}
+
+		f, rest, err = readFieldValue(f, rest[1:])
+		if err != nil {
+			return Point{}, fmt.Errorf("field %s: %w", f.Key, err)
+		}
+		p.Fields = append(p.Fields, f)
+
+		if !strings.HasPrefix(rest, ",") {
+			break
+		}
+		rest = rest[1:]
+	}
+
+	rest = strings.TrimSpace(rest)
+	if rest != "" {
+		ts, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("bad timestamp %q", rest)
+		}
+		p.Time = time.Unix(0, ts*int64(precision))
+	}
+
+	return p, nil
+}
+
+// readToken чтение до первого неэкранированного символа из stops.
+// Обратная косая черта экранирует символы escapes и саму себя, перед остальными символами остается как есть
+func readToken(s string, stops string, escapes string) (string, string) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if c == '\\' && i+1 < len(s) && strings.IndexByte(escapes+"\\", s[i+1]) >= 0 {
+			i++
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if strings.IndexByte(stops, c) >= 0 {
+			return b.String(), s[i:]
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String(), ""
+}
+
+// readFieldValue чтение значения поля: "строка", целое 1i, беззнаковое 1u, логическое t/false или число с плавающей точкой
+func readFieldValue(f Field, s string) (Field, string, error) {
+	if strings.HasPrefix(s, `"`) {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			c := s[i]
+			switch {
+			case c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+				i++
+				b.WriteByte(s[i])
+			case c == '"':
+				f.Kind = FieldString
+				f.Str = b.String()
+				return f, s[i+1:], nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+
+		return f, "", errors.New("unterminated string")
+	}
+
+	value, rest := readToken(s, ", ", "")
+	if value == "" {
+		return f, "", errors.New("empty value")
+	}
+
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		f.Kind, f.Float, f.Int = FieldBool, 1, 1
+		return f, rest, nil
+	case "f", "F", "false", "False", "FALSE":
+		f.Kind = FieldBool
+		return f, rest, nil
+	}
+
+	switch value[len(value)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		if err != nil {
+			return f, "", fmt.Errorf("bad integer %q", value)
+		}
+		f.Kind, f.Float, f.Int = FieldInt, float64(v), v
+		return f, rest, nil
+
+	case 'u':
+		v, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+		if err != nil || v > math.MaxInt64 {
+			return f, "", fmt.Errorf("bad unsigned %q", value)
+		}
+		f.Kind, f.Float, f.Int = FieldUint, float64(v), int64(v)
+		return f, rest, nil
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return f, "", fmt.Errorf("bad float %q", value)
+	}
+	f.Kind, f.Float = FieldFloat, v
+
+	return f, rest, nil
+}
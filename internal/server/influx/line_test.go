@@ -0,0 +1,112 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name   string
+		line   string
+		prec   time.Duration
+		want   Point
+		errMsg string
+	}{
+		{
+			name: "all types",
+			line: `cpu,host=server01,region=us-west usage_idle=92.5,procs=120i,threads=7u,online=t,state="running" 1700000000000000000`,
+			prec: time.Nanosecond,
+			want: Point{
+				Line:        1,
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "server01", "region": "us-west"},
+				Fields: []Field{
+					{Key: "usage_idle", Kind: FieldFloat, Float: 92.5},
+					{Key: "procs", Kind: FieldInt, Float: 120, Int: 120},
+					{Key: "threads", Kind: FieldUint, Float: 7, Int: 7},
+					{Key: "online", Kind: FieldBool, Float: 1, Int: 1},
+					{Key: "state", Kind: FieldString, Str: "running"},
+				},
+				Time: time.Unix(1700000000, 0),
+			},
+		},
+		{
+			name: "escapes and precision",
+			line: `disk\ io,path=C:\\data,mount\=point=a\,b bytes\ read=1e3,note="say \"hi\", ok" 1700000001`,
+			prec: time.Second,
+			want: Point{
+				Line:        1,
+				Measurement: "disk io",
+				Tags:        map[string]string{"path": `C:\data`, "mount=point": "a,b"},
+				Fields: []Field{
+					{Key: "bytes read", Kind: FieldFloat, Float: 1000},
+					{Key: "note", Kind: FieldString, Str: `say "hi", ok`},
+				},
+				Time: time.Unix(1700000001, 0),
+			},
+		},
+		{
+			name: "no timestamp",
+			line: `mem free=-1.5`,
+			prec: time.Nanosecond,
+			want: Point{Line: 1, Measurement: "mem", Tags: map[string]string{}, Fields: []Field{{Key: "free", Kind: FieldFloat, Float: -1.5}}, Time: now},
+		},
+		{name: "no fields", line: `cpu,host=a`, errMsg: "fields required"},
+		{name: "bad tag", line: `cpu,host usage=1`, errMsg: "bad tag"},
+		{name: "empty tag value", line: `cpu,host= usage=1`, errMsg: "tag host: empty value"},
+		{name: "bad field", line: `cpu usage`, errMsg: "bad field"},
+		{name: "bad integer", line: `cpu procs=1.5i`, errMsg: `field procs: bad integer "1.5i"`},
+		{name: "bad unsigned", line: `cpu procs=-1u`, errMsg: `field procs: bad unsigned "-1u"`},
+		{name: "bad float", line: `cpu usage=abc`, errMsg: `field usage: bad float "abc"`},
+		{name: "nan", line: `cpu usage=NaN`, errMsg: `field usage: bad float "NaN"`},
+		{name: "unterminated string", line: `cpu state="run`, errMsg: "field state: unterminated string"},
+		{name: "bad timestamp", line: `cpu usage=1 12:00`, errMsg: `bad timestamp "12:00"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, errs := Parse([]byte(tt.line), tt.prec, now)
+			if tt.errMsg != "" {
+				assert.Empty(t, points)
+				assert.Equal(t, []LineError{{Line: 1, Err: tt.errMsg}}, errs)
+				return
+			}
+
+			require.Empty(t, errs)
+			require.Len(t, points, 1)
+			assert.Equal(t, tt.want.Measurement, points[0].Measurement)
+			assert.Equal(t, tt.want.Tags, points[0].Tags)
+			assert.Equal(t, tt.want.Fields, points[0].Fields)
+			assert.True(t, tt.want.Time.Equal(points[0].Time), points[0].Time)
+			assert.Equal(t, tt.want.Line, points[0].Line)
+		})
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	data := "# comment\n\ncpu usage=1\r\ncpu usage=\nmem free=2i 1700000000000\n"
+
+	points, errs := Parse([]byte(data), time.Millisecond, time.Now())
+	require.Len(t, points, 2)
+	assert.Equal(t, 3, points[0].Line)
+	assert.Equal(t, 5, points[1].Line)
+	assert.True(t, time.Unix(1700000000, 0).Equal(points[1].Time))
+	assert.Equal(t, []LineError{{Line: 4, Err: "field usage: empty value"}}, errs)
+}
+
+func TestParsePrecision(t *testing.T) {
+	for s, want := range map[string]time.Duration{"": time.Nanosecond, "n": time.Nanosecond, "ns": time.Nanosecond, "u": time.Microsecond, "us": time.Microsecond, "ms": time.Millisecond, "s": time.Second, "m": time.Minute, "h": time.Hour} {
+		got, err := ParsePrecision(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+
+	_, err := ParsePrecision("d")
+	assert.ErrorIs(t, err, ErrBadPrecision)
+}
@@ -0,0 +1,218 @@
+package influx
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// Rule правило сопоставления полей метрикам.
+// Поле Field измерения Measurement сохраняется метрикой типа MType с названием measurement_field.
+type Rule struct {
+	Measurement string `json:"measurement" yaml:"measurement"` // регулярное выражение названия измерения, пусто - любое
+	Field       string `json:"field" yaml:"field"`             // регулярное выражение ключа поля, пусто - любое
+	MType       string `json:"type" yaml:"type"`               // gauge или counter (значение поля прибавляется к счетчику)
+}
+
+// rule правило с разобранными регулярными выражениями
+type rule struct {
+	measurement *regexp.Regexp
+	field       *regexp.Regexp
+	mType       string
+}
+
+// Rules правила сопоставления, применяется первое подходящее.
+// Поля, не подошедшие ни под одно правило, сохраняются как gauge. Строковые поля не сохраняются
+type Rules struct {
+	rules []rule
+}
+
+// rulesFile структура файла правил
+type rulesFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// NewRules проверка и разбор правил
+func NewRules(rules []Rule) (*Rules, error) {
+	r := &Rules{rules: make([]rule, 0, len(rules))}
+
+	for i, rl := range rules {
+		if rl.MType != constants.Gauge && rl.MType != constants.Counter {
+			return nil, fmt.Errorf("rule %d: bad type %q", i+1, rl.MType)
+		}
+
+		measurement, err := compileMatch(rl.Measurement)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: measurement: %w", i+1, err)
+		}
+
+		field, err := compileMatch(rl.Field)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: field: %w", i+1, err)
+		}
+
+		r.rules = append(r.rules, rule{measurement: measurement, field: field, mType: rl.MType})
+	}
+
+	return r, nil
+}
+
+// compileMatch регулярное выражение, совпадающее со всей строкой, пусто - любая строка
+func compileMatch(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// LoadRules загрузка правил из файла. Формат определяется по расширению: .json - json, иначе yaml
+func LoadRules(filename string) (*Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("influx LoadRules | ReadFile: %w", err)
+	}
+
+	var rf rulesFile
+
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = json.Unmarshal(data, &rf)
+	} else {
+		err = yaml.Unmarshal(data, &rf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("influx LoadRules | Unmarshal: %w", err)
+	}
+
+	rules, err := NewRules(rf.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("influx LoadRules | NewRules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// Type тип метрики для поля field измерения measurement. Для nil правил - gauge
+func (r *Rules) Type(measurement string, field string) string {
+	if r == nil {
+		return constants.Gauge
+	}
+
+	for _, rl := range r.rules {
+		if rl.measurement != nil && !rl.measurement.MatchString(measurement) {
+			continue
+		}
+		if rl.field != nil && !rl.field.MatchString(field) {
+			continue
+		}
+
+		return rl.mType
+	}
+
+	return constants.Gauge
+}
+
+// Batch метрики точек для записи: значения gauge со временем точек и приращения counter
+type Batch struct {
+	Gauges   map[string][]storage.Sample // значения gauge по ключам серий
+	Counters []storage.Metrics           // приращения counter, по одному на серию
+}
+
+// Metrics метрики точек для записи.
+// Название метрики - measurement_field, теги становятся метками, недопустимые символы в названиях заменяются на _.
+// Значения gauge сохраняются в историю со временем точки, значения counter одной серии суммируются.
+// Строка, поле которой нельзя сохранить метрикой своего типа, пропускается целиком с ошибкой.
+func (r *Rules) Metrics(points []Point) (Batch, []LineError) {
+	var errs []LineError
+	batch := Batch{Gauges: make(map[string][]storage.Sample)}
+	counters := make(map[string]*storage.Metrics)
+
+	for _, p := range points {
+		var labels storage.Labels
+		if len(p.Tags) > 0 {
+			labels = make(storage.Labels, len(p.Tags))
+			for k, v := range p.Tags {
+				labels[storage.SanitizeName(k, false)] = v
+			}
+		}
+
+		var err error
+
+		metrics := make([]storage.Metrics, 0, len(p.Fields))
+		for _, f := range p.Fields {
+			if f.Kind == FieldString {
+				continue
+			}
+
+			mt := storage.Metrics{
+				ID:     storage.SanitizeName(p.Measurement+"_"+f.Key, true),
+				MType:  r.Type(p.Measurement, f.Key),
+				Labels: labels,
+			}
+
+			if mt.MType == constants.Counter {
+				if f.Kind == FieldBool || (f.Kind == FieldFloat && (f.Float != math.Trunc(f.Float) || math.Abs(f.Float) > math.MaxInt64)) {
+					err = fmt.Errorf("field %s: counter requires integer value", f.Key)
+					break
+				}
+
+				delta := f.Int
+				if f.Kind == FieldFloat {
+					delta = int64(f.Float)
+				}
+				mt.Delta = &delta
+			} else {
+				value := f.Float
+				mt.Value = &value
+			}
+
+			metrics = append(metrics, mt)
+		}
+
+		if err != nil {
+			errs = append(errs, LineError{Line: p.Line, Err: err.Error()})
+			continue
+		}
+
+		ts := p.Time.UnixMilli()
+		for _, mt := range metrics {
+			key := mt.Key()
+
+			if mt.MType == constants.Gauge {
+				batch.Gauges[key] = append(batch.Gauges[key], storage.Sample{Timestamp: ts, Value: *mt.Value})
+				continue
+			}
+
+			prev, ok := counters[key]
+			if !ok {
+				counter := mt
+				counters[key] = &counter
+				continue
+			}
+			sum := *prev.Delta + *mt.Delta
+			prev.Delta = &sum
+		}
+	}
+
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		batch.Counters = append(batch.Counters, *counters[key])
+	}
+
+	return batch, errs
+}
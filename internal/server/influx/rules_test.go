@@ -0,0 +1,68 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestLoadRules(t *testing.T) {
+	for _, file := range []string{"testdata/rules.yaml", "testdata/rules.json"} {
+		rules, err := LoadRules(file)
+		require.NoError(t, err, file)
+
+		assert.Equal(t, constants.Counter, rules.Type("net", "bytes_recv"), file)
+		assert.Equal(t, constants.Gauge, rules.Type("net", "bytes_recv_rate"), file)
+		assert.Equal(t, constants.Counter, rules.Type("http", "requests_total"), file)
+		assert.Equal(t, constants.Gauge, rules.Type("cpu", "usage_idle"), file)
+	}
+
+	_, err := LoadRules("testdata/bad_rules.yaml")
+	assert.Error(t, err)
+
+	_, err = LoadRules("testdata/no_such.yaml")
+	assert.Error(t, err)
+
+	_, err = NewRules([]Rule{{Field: "x", MType: constants.Histogram}})
+	assert.Error(t, err)
+
+	var none *Rules
+	assert.Equal(t, constants.Gauge, none.Type("net", "bytes_recv"))
+}
+
+func TestRulesMetrics(t *testing.T) {
+	rules, err := NewRules([]Rule{{Measurement: "net", Field: "bytes_.*", MType: constants.Counter}})
+	require.NoError(t, err)
+
+	data := `net,host=a,iface-name=eth0 bytes_recv=100i,bytes_sent=5,speed=1000,up=true,name="eth0" 1700000000
+net,host=a,iface-name=eth0 bytes_recv=20i,speed=100 1699999999
+net,host=b bytes_recv=1.5
+3d.printer temp=210.5
+`
+	points, errs := Parse([]byte(data), time.Second, time.Unix(1700000000, 0))
+	require.Empty(t, errs)
+
+	batch, errs := rules.Metrics(points)
+	assert.Equal(t, []LineError{{Line: 3, Err: "field bytes_recv: counter requires integer value"}}, errs)
+
+	delta := func(v int64) *int64 { return &v }
+	labels := storage.Labels{"host": "a", "iface_name": "eth0"}
+
+	// значения counter суммируются
+	assert.Equal(t, []storage.Metrics{
+		{ID: "net_bytes_recv", MType: constants.Counter, Labels: labels, Delta: delta(120)},
+		{ID: "net_bytes_sent", MType: constants.Counter, Labels: labels, Delta: delta(5)},
+	}, batch.Counters)
+
+	// все значения gauge сохраняются со временем своей точки
+	assert.Equal(t, map[string][]storage.Sample{
+		"_3d_printer_temp": {{Timestamp: 1700000000000, Value: 210.5}},
+		storage.SeriesKey("net_speed", labels): {{Timestamp: 1700000000000, Value: 1000}, {Timestamp: 1699999999000, Value: 100}},
+		storage.SeriesKey("net_up", labels):    {{Timestamp: 1700000000000, Value: 1}},
+	}, batch.Gauges)
+}
@@ -0,0 +1,169 @@
+// Package notifier доставка уведомлений об изменении состояния алертов по разным каналам
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
+)
+
+// Notification уведомление - группа алертов, изменивших состояние
+type Notification struct {
+	Group  string           `json:"group"`  // ключ группировки (важность алертов)
+	Status string           `json:"status"` // firing, если в группе есть сработавшие алерты, иначе resolved
+	Alerts []alerting.Alert `json:"alerts"`
+}
+
+// Channel канал доставки уведомлений
+type Channel interface {
+	// Name название канала (для логов)
+	Name() string
+
+	// Send отправка уведомления
+	Send(ctx context.Context, n Notification) error
+}
+
+// WebhookChannel отправляет уведомление в формате json POST запросом на указанный url
+type WebhookChannel struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func NewWebhookChannel(name string, url string) *WebhookChannel {
+	return &WebhookChannel{
+		name:   name,
+		url:    url,
+		client: &http.Client{Timeout: constants.HTTPContextTimeout},
+	}
+}
+
+// Name название канала
+func (w *WebhookChannel) Name() string {
+	return w.name
+}
+
+// Send отправка уведомления
+func (w *WebhookChannel) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, w.client, w.url, constants.ApplicationJSON, body)
+}
+
+// TemplateChannel отправляет POST запросом тело, сформированное по шаблону text/template
+type TemplateChannel struct {
+	name        string
+	url         string
+	contentType string
+	tmpl        *template.Template
+	client      *http.Client
+}
+
+func NewTemplateChannel(name string, url string, contentType string, tmpl string) (*TemplateChannel, error) {
+	t, err := template.New(name).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("NewTemplateChannel | Parse: %w", err)
+	}
+
+	if contentType == "" {
+		contentType = constants.TextPlain
+	}
+
+	return &TemplateChannel{
+		name:        name,
+		url:         url,
+		contentType: contentType,
+		tmpl:        t,
+		client:      &http.Client{Timeout: constants.HTTPContextTimeout},
+	}, nil
+}
+
+// Name название канала
+func (t *TemplateChannel) Name() string {
+	return t.name
+}
+
+// Send отправка уведомления
+func (t *TemplateChannel) Send(ctx context.Context, n Notification) error {
+	var buf bytes.Buffer
+
+	err := t.tmpl.Execute(&buf, n)
+	if err != nil {
+		return fmt.Errorf("TemplateChannel | Execute: %w", err)
+	}
+
+	return post(ctx, t.client, t.url, t.contentType, buf.Bytes())
+}
+
+// FileChannel дописывает уведомления в файл, по одному json объекту на строку (NDJSON)
+type FileChannel struct {
+	mutex sync.Mutex
+	name  string
+	path  string
+}
+
+func NewFileChannel(name string, path string) *FileChannel {
+	return &FileChannel{
+		name: name,
+		path: path,
+	}
+}
+
+// Name название канала
+func (f *FileChannel) Name() string {
+	return f.name
+}
+
+// Send запись уведомления в файл
+func (f *FileChannel) Send(_ context.Context, n Notification) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	line, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+
+	return err
+}
+
+// post POST запрос, ответ с кодом не 2xx считается ошибкой
+func post(ctx context.Context, client *http.Client, url string, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
+)
+
+func testNotification() Notification {
+	return Notification{
+		Group:  "critical",
+		Status: constants.AlertStateFiring,
+		Alerts: []alerting.Alert{
+			{Rule: "TooManyPolls", Metric: constants.PollCount, MType: constants.Counter, Severity: "critical", State: constants.AlertStateFiring, Value: 150, Threshold: 100},
+		},
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	var received Notification
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, constants.ApplicationJSON, r.Header.Get("Content-Type"))
+
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer ts.Close()
+
+	ch := NewWebhookChannel("ops", ts.URL)
+	assert.Equal(t, "ops", ch.Name())
+
+	err := ch.Send(context.Background(), testNotification())
+	require.NoError(t, err)
+	assert.Equal(t, testNotification(), received)
+
+	// код ответа не 2xx - ошибка
+	tsBad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer tsBad.Close()
+
+	err = NewWebhookChannel("bad", tsBad.URL).Send(context.Background(), testNotification())
+	assert.Error(t, err)
+}
+
+func TestTemplateChannel(t *testing.T) {
+	var received string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, constants.TextPlain, r.Header.Get("Content-Type"))
+
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+	defer ts.Close()
+
+	ch, err := NewTemplateChannel("chat", ts.URL, "", "[{{.Status}}]{{range .Alerts}} {{.Rule}}={{.Value}}{{end}}")
+	require.NoError(t, err)
+
+	err = ch.Send(context.Background(), testNotification())
+	require.NoError(t, err)
+	assert.Equal(t, "[firing] TooManyPolls=150", received)
+
+	_, err = NewTemplateChannel("bad", ts.URL, "", "{{.Status")
+	assert.Error(t, err)
+}
+
+func TestFileChannel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.ndjson")
+	ch := NewFileChannel("journal", path)
+
+	require.NoError(t, ch.Send(context.Background(), testNotification()))
+	require.NoError(t, ch.Send(context.Background(), testNotification()))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var n Notification
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &n))
+		assert.Equal(t, testNotification(), n)
+		lines++
+	}
+	assert.Equal(t, 2, lines)
+}
@@ -0,0 +1,290 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
+)
+
+// ChannelConfig настройки одного канала уведомлений
+type ChannelConfig struct {
+	Name        string `json:"name" yaml:"name"`
+	Type        string `json:"type" yaml:"type"`                 // webhook, template или file
+	URL         string `json:"url" yaml:"url"`                   // для webhook и template
+	ContentType string `json:"content_type" yaml:"content_type"` // для template
+	Template    string `json:"template" yaml:"template"`         // для template, синтаксис text/template
+	Path        string `json:"path" yaml:"path"`                 // для file
+	Retry       string `json:"retry" yaml:"retry"`               // периоды повтора при ошибке отправки, например "1s,2s,5s"
+}
+
+// Config настройки уведомлений
+type Config struct {
+	GroupWait alerting.Duration `json:"group_wait" yaml:"group_wait"` // окно, в течение которого изменения алертов собираются в одно уведомление
+	Channels  []ChannelConfig   `json:"channels" yaml:"channels"`
+}
+
+// LoadConfig загрузка настроек уведомлений из файла. Формат определяется по расширению: .json - json, иначе yaml
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("notifier LoadConfig | ReadFile: %w", err)
+	}
+
+	var cfg Config
+
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = json.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("notifier LoadConfig | Unmarshal: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// target канал вместе с периодами повтора отправки
+type target struct {
+	channel Channel
+	retry   []time.Duration
+}
+
+// Notifier собирает изменения состояния алертов, убирает повторы, группирует их
+// и рассылает уведомления по всем каналам.
+type Notifier struct {
+	mutex     sync.Mutex
+	targets   []target
+	groupWait time.Duration
+	pending   map[string]alerting.Alert // последнее состояние алерта за окно группировки, ключ - Alert.Key
+	lastSent  map[string]string         // последнее отправленное состояние, ключ - Alert.Key
+
+	done chan struct{} // закрывается при остановке периодической отправки
+	wg   sync.WaitGroup
+}
+
+func NewNotifier(groupWait time.Duration) *Notifier {
+	if groupWait <= 0 {
+		groupWait = constants.NotifyGroupWait
+	}
+
+	return &Notifier{
+		groupWait: groupWait,
+		pending:   make(map[string]alerting.Alert),
+		lastSent:  make(map[string]string),
+		done:      make(chan struct{}),
+	}
+}
+
+// NewNotifierFromConfig создание уведомителя с каналами из настроек
+func NewNotifierFromConfig(cfg *Config) (*Notifier, error) {
+	n := NewNotifier(time.Duration(cfg.GroupWait))
+
+	for _, cc := range cfg.Channels {
+		var (
+			ch  Channel
+			err error
+		)
+
+		switch cc.Type {
+		case constants.NotifyChannelWebhook:
+			ch = NewWebhookChannel(cc.Name, cc.URL)
+		case constants.NotifyChannelTemplate:
+			ch, err = NewTemplateChannel(cc.Name, cc.URL, cc.ContentType, cc.Template)
+		case constants.NotifyChannelFile:
+			ch = NewFileChannel(cc.Name, cc.Path)
+		default:
+			err = fmt.Errorf("bad notification channel type: %s", cc.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		periods := cc.Retry
+		if periods == "" {
+			periods = constants.NotifyAttemptPeriods
+		}
+
+		retry, err := parseRetry(periods)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", cc.Name, err)
+		}
+
+		n.AddChannel(ch, retry)
+	}
+
+	return n, nil
+}
+
+// AddChannel добавление канала. retry - периоды, через которые делается повторная попытка отправки
+func (n *Notifier) AddChannel(ch Channel, retry []time.Duration) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.targets = append(n.targets, target{channel: ch, retry: retry})
+}
+
+// Notify регистрация изменения состояния алерта. Не блокирует вызывающего,
+// отправка происходит при очередном Flush.
+func (n *Notifier) Notify(alert alerting.Alert) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.pending[alert.Key()] = alert
+}
+
+// Start периодическая отправка накопленных уведомлений до остановки Close
+func (n *Notifier) Start() {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		ticker := time.NewTicker(n.groupWait)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-n.done:
+				return
+			case <-ticker.C:
+				n.Flush(context.Background())
+			}
+		}
+	}()
+}
+
+// Close остановка периодической отправки и отправка уведомлений,
+// накопленных с последнего Flush. Повторный вызов ничего не делает
+func (n *Notifier) Close() {
+	select {
+	case <-n.done:
+		return
+	default:
+		close(n.done)
+	}
+
+	n.wg.Wait()
+	n.Flush(context.Background())
+}
+
+// Flush отправка накопленных уведомлений.
+// Алерты, состояние которых не изменилось с прошлой отправки (например, алерт успел
+// разрешиться и снова сработать внутри окна группировки), не отправляются.
+// Остальные группируются по важности, по одному уведомлению на группу.
+func (n *Notifier) Flush(ctx context.Context) {
+	n.mutex.Lock()
+
+	groups := make(map[string][]alerting.Alert)
+	for key, alert := range n.pending {
+		if n.lastSent[key] == alert.State {
+			continue
+		}
+
+		// о сработавшем алерте не сообщали - сообщать о разрешении не нужно
+		if n.lastSent[key] == "" && alert.State == constants.AlertStateResolved {
+			continue
+		}
+
+		n.lastSent[key] = alert.State
+		groups[alert.Severity] = append(groups[alert.Severity], alert)
+	}
+	n.pending = make(map[string]alerting.Alert)
+
+	targets := n.targets
+
+	n.mutex.Unlock()
+
+	notifications := make([]Notification, 0, len(groups))
+	for group, alerts := range groups {
+		sort.Slice(alerts, func(i, j int) bool {
+			if alerts[i].Rule != alerts[j].Rule {
+				return alerts[i].Rule < alerts[j].Rule
+			}
+
+			return alerts[i].Metric < alerts[j].Metric
+		})
+
+		status := constants.AlertStateResolved
+		for _, a := range alerts {
+			if a.State == constants.AlertStateFiring {
+				status = constants.AlertStateFiring
+				break
+			}
+		}
+
+		notifications = append(notifications, Notification{Group: group, Status: status, Alerts: alerts})
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].Group < notifications[j].Group
+	})
+
+	// каналы отправляют независимо, чтобы медленный канал не задерживал остальные
+	var wg sync.WaitGroup
+
+	for _, t := range targets {
+		wg.Add(1)
+
+		go func(t target) {
+			defer wg.Done()
+
+			for _, notification := range notifications {
+				err := sendWithRetry(ctx, t, notification)
+				if err != nil {
+					logger.Log().Error(fmt.Sprintf("notification channel %s: %s", t.channel.Name(), err.Error()))
+				}
+			}
+		}(t)
+	}
+
+	wg.Wait()
+}
+
+// sendWithRetry отправка уведомления несколькими попытками, если необходимо
+func sendWithRetry(ctx context.Context, t target, notification Notification) error {
+	err := t.channel.Send(ctx, notification)
+
+	for _, d := range t.retry {
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+
+		err = t.channel.Send(ctx, notification)
+	}
+
+	return err
+}
+
+// parseRetry разбор строки периодов повтора вида "1s,2s,5s"
+func parseRetry(periods string) ([]time.Duration, error) {
+	var retry []time.Duration
+
+	for _, p := range strings.Split(periods, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("bad retry period %s: %w", p, err)
+		}
+
+		retry = append(retry, d)
+	}
+
+	return retry, nil
+}
@@ -0,0 +1,178 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/server/alerting"
+)
+
+// testChannel запоминает отправленные уведомления
+type testChannel struct {
+	mutex         sync.Mutex
+	notifications []Notification
+}
+
+func (c *testChannel) Name() string {
+	return "test"
+}
+
+func (c *testChannel) Send(_ context.Context, n Notification) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.notifications = append(c.notifications, n)
+
+	return nil
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig("testdata/notifiers.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, alerting.Duration(10*time.Second), cfg.GroupWait)
+	require.Len(t, cfg.Channels, 3)
+	assert.Equal(t, constants.NotifyChannelTemplate, cfg.Channels[1].Type)
+
+	n, err := NewNotifierFromConfig(cfg)
+	require.NoError(t, err)
+	assert.Len(t, n.targets, 3)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 5 * time.Second}, n.targets[0].retry)
+
+	cfg, err = LoadConfig("testdata/bad_notifiers.yaml")
+	require.NoError(t, err)
+	_, err = NewNotifierFromConfig(cfg)
+	assert.Error(t, err)
+
+	_, err = LoadConfig("testdata/nofile.yaml")
+	assert.Error(t, err)
+
+	_, err = parseRetry("1s,bad")
+	assert.Error(t, err)
+}
+
+func TestNotifier_GroupAndDedup(t *testing.T) {
+	ctx := context.Background()
+	ch := &testChannel{}
+
+	n := NewNotifier(time.Second)
+	n.AddChannel(ch, nil)
+
+	firing := alerting.Alert{Rule: "HighAlloc", Severity: "warning", State: constants.AlertStateFiring}
+	resolved := alerting.Alert{Rule: "HighAlloc", Severity: "warning", State: constants.AlertStateResolved}
+
+	// разрешение алерта, о котором не сообщали - не отправляется
+	n.Notify(resolved)
+	n.Flush(ctx)
+	assert.Empty(t, ch.notifications)
+
+	// два алерта одной важности - одно уведомление, третий алерт - отдельная группа
+	n.Notify(firing)
+	n.Notify(alerting.Alert{Rule: "LowFree", Severity: "warning", State: constants.AlertStateFiring})
+	n.Notify(alerting.Alert{Rule: "TooManyPolls", Severity: "critical", State: constants.AlertStateFiring})
+	n.Flush(ctx)
+
+	require.Len(t, ch.notifications, 2)
+	assert.Equal(t, "critical", ch.notifications[0].Group)
+	assert.Len(t, ch.notifications[0].Alerts, 1)
+	assert.Equal(t, "warning", ch.notifications[1].Group)
+	assert.Equal(t, constants.AlertStateFiring, ch.notifications[1].Status)
+	assert.Len(t, ch.notifications[1].Alerts, 2)
+
+	// мигающий алерт: разрешился и снова сработал внутри окна - повторно не отправляется
+	n.Notify(resolved)
+	n.Notify(firing)
+	n.Flush(ctx)
+	assert.Len(t, ch.notifications, 2)
+
+	// разрешение отправляется
+	n.Notify(resolved)
+	n.Flush(ctx)
+	require.Len(t, ch.notifications, 3)
+	assert.Equal(t, constants.AlertStateResolved, ch.notifications[2].Status)
+}
+
+func TestNotifier_Series(t *testing.T) {
+	ch := &testChannel{}
+
+	n := NewNotifier(time.Second)
+	n.AddChannel(ch, nil)
+
+	// серии одного правила не вытесняют друг друга
+	n.Notify(alerting.Alert{Rule: "HighAlloc", Metric: `Alloc{host="web-1"}`, Severity: "warning", State: constants.AlertStateFiring})
+	n.Notify(alerting.Alert{Rule: "HighAlloc", Metric: `Alloc{host="web-2"}`, Severity: "warning", State: constants.AlertStateFiring})
+	n.Flush(context.Background())
+
+	require.Len(t, ch.notifications, 1)
+	require.Len(t, ch.notifications[0].Alerts, 2)
+	assert.Equal(t, `Alloc{host="web-1"}`, ch.notifications[0].Alerts[0].Metric)
+	assert.Equal(t, `Alloc{host="web-2"}`, ch.notifications[0].Alerts[1].Metric)
+}
+
+func TestNotifier_Close(t *testing.T) {
+	ch := &testChannel{}
+
+	n := NewNotifier(time.Hour)
+	n.AddChannel(ch, nil)
+	n.Start()
+
+	// уведомление, не дождавшееся окна группировки, отправляется при остановке
+	n.Notify(alerting.Alert{Rule: "HighAlloc", Severity: "warning", State: constants.AlertStateFiring})
+	n.Close()
+	require.Len(t, ch.notifications, 1)
+	assert.Equal(t, constants.AlertStateFiring, ch.notifications[0].Status)
+
+	// повторная остановка ничего не делает
+	n.Close()
+	assert.Len(t, ch.notifications, 1)
+}
+
+func TestNotifier_WebhookRetry(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		attempts int
+		received Notification
+	)
+
+	// первые две попытки сервер отвечает ошибкой
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer ts.Close()
+
+	n := NewNotifier(time.Second)
+	n.AddChannel(NewWebhookChannel("ops", ts.URL), []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond})
+
+	n.Notify(alerting.Alert{Rule: "HighAlloc", Severity: "warning", State: constants.AlertStateFiring, Value: 2000})
+	n.Flush(context.Background())
+
+	assert.Equal(t, 3, attempts)
+	require.Len(t, received.Alerts, 1)
+	assert.Equal(t, "HighAlloc", received.Alerts[0].Rule)
+	assert.Equal(t, float64(2000), received.Alerts[0].Value)
+
+	// попытки закончились - уведомление теряется, но отправка не зависает
+	attempts = -10
+	n.Notify(alerting.Alert{Rule: "HighAlloc", Severity: "warning", State: constants.AlertStateResolved})
+	n.Flush(context.Background())
+	assert.Equal(t, -6, attempts)
+}
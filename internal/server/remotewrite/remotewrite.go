@@ -0,0 +1,252 @@
+// Package remotewrite прием данных по протоколу Prometheus remote_write (версия 1):
+// сжатый snappy protobuf WriteRequest.
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// ErrBadPayload тело запроса не является сжатым WriteRequest
+var ErrBadPayload = errors.New("bad remote write payload")
+
+// nameLabel метка с названием метрики
+const nameLabel = "__name__"
+
+// Номера полей сообщений prometheus.WriteRequest.
+const (
+	fieldTimeseries = 1 // WriteRequest.timeseries
+
+	fieldLabels  = 1 // TimeSeries.labels
+	fieldSamples = 2 // TimeSeries.samples
+
+	fieldLabelName  = 1 // Label.name
+	fieldLabelValue = 2 // Label.value
+
+	fieldSampleValue     = 1 // Sample.value
+	fieldSampleTimestamp = 2 // Sample.timestamp
+)
+
+// Label метка серии
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample значение серии и время в миллисекундах
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries серия: метки, включая __name__, и значения.
+// Экземпляры (exemplars), нативные гистограммы и метаданные не разбираются
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// WriteRequest запрос remote_write
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+// Decode распаковка и разбор тела запроса remote_write.
+// Параметры: maxSize - наибольший размер распакованного сообщения.
+func Decode(body []byte, maxSize int) (WriteRequest, error) {
+	data, err := decodeSnappy(body, maxSize)
+	if err != nil {
+		return WriteRequest{}, err
+	}
+
+	return Unmarshal(data)
+}
+
+// Unmarshal разбор protobuf сообщения WriteRequest
+func Unmarshal(data []byte) (WriteRequest, error) {
+	var req WriteRequest
+
+	err := walk(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != fieldTimeseries || typ != protowire.BytesType {
+			return nil
+		}
+
+		ts, err := unmarshalTimeSeries(value)
+		if err != nil {
+			return err
+		}
+		req.Timeseries = append(req.Timeseries, ts)
+
+		return nil
+	})
+	if err != nil {
+		return WriteRequest{}, err
+	}
+
+	return req, nil
+}
+
+func unmarshalTimeSeries(data []byte) (TimeSeries, error) {
+	var ts TimeSeries
+
+	err := walk(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+
+		switch num {
+		case fieldLabels:
+			var l Label
+			err := walk(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if typ != protowire.BytesType {
+					return nil
+				}
+
+				switch num {
+				case fieldLabelName:
+					l.Name = string(value)
+				case fieldLabelValue:
+					l.Value = string(value)
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, l)
+
+		case fieldSamples:
+			var s Sample
+			err := walk(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				switch {
+				case num == fieldSampleValue && typ == protowire.Fixed64Type:
+					bits, _ := protowire.ConsumeFixed64(value)
+					s.Value = math.Float64frombits(bits)
+				case num == fieldSampleTimestamp && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(value)
+					s.Timestamp = int64(v)
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+
+		return nil
+	})
+
+	return ts, err
+}
+
+// walk обход полей protobuf сообщения. Для полей с длиной value - содержимое поля,
+// для остальных - закодированное значение.
+func walk(data []byte, field func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrBadPayload, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrBadPayload, protowire.ParseError(n))
+		}
+
+		value := data[:n]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(value)
+		}
+		data = data[n:]
+
+		err := field(num, typ, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Series серия запроса: метрика типа gauge и ее значения
+type Series struct {
+	Metric  storage.Metrics  // название и метки, без значения
+	Samples []storage.Sample // конечные значения в порядке получения
+}
+
+// Series серии запроса для сохранения всех значений в историю как gauge.
+// Счетчики Prometheus передаются накопленными значениями с плавающей точкой, поэтому тоже сохраняются как gauge.
+// Части одной серии объединяются. Значения серий без названия и бесконечные значения
+// (в т.ч. маркеры устаревания - NaN) отбрасываются, их количество возвращается вторым значением.
+func (r WriteRequest) Series() ([]Series, int) {
+	series := make(map[string]*Series, len(r.Timeseries))
+	dropped := 0
+
+	for _, ts := range r.Timeseries {
+		var name string
+		labels := storage.Labels{}
+		for _, l := range ts.Labels {
+			switch {
+			case l.Name == nameLabel:
+				name = l.Value
+			case l.Value != "":
+				labels[l.Name] = l.Value
+			}
+		}
+
+		if name == "" {
+			dropped += len(ts.Samples)
+			continue
+		}
+
+		samples := make([]storage.Sample, 0, len(ts.Samples))
+		for _, s := range ts.Samples {
+			if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+				dropped++
+				continue
+			}
+			samples = append(samples, storage.Sample{Timestamp: s.Timestamp, Value: s.Value})
+		}
+
+		if len(samples) == 0 {
+			continue
+		}
+
+		if len(labels) == 0 {
+			labels = nil
+		}
+
+		mt := storage.Metrics{ID: name, MType: constants.Gauge, Labels: labels}
+
+		// одна серия может прийти несколькими частями
+		if prev, ok := series[mt.Key()]; ok {
+			prev.Samples = append(prev.Samples, samples...)
+			continue
+		}
+		series[mt.Key()] = &Series{Metric: mt, Samples: samples}
+	}
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]Series, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, *series[key])
+	}
+
+	return res, dropped
+}
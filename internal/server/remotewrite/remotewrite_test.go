@@ -0,0 +1,154 @@
+package remotewrite
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// testdata/write_request.pb - WriteRequest с сериями разных видов,
+// testdata/write_request.snappy - он же, сжатый блоком snappy с литералами и копиями
+func readFixture(t testing.TB, name string) []byte {
+	data, err := os.ReadFile("testdata/" + name)
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestDecodeSnappy(t *testing.T) {
+	data, err := decodeSnappy(readFixture(t, "write_request.snappy"), constants.RemoteWriteMaxSize)
+	require.NoError(t, err)
+	assert.Equal(t, readFixture(t, "write_request.pb"), data)
+
+	tests := []struct {
+		name string
+		src  []byte
+		want string
+		err  bool
+	}{
+		{name: "literal", src: []byte{3, 2 << 2, 'a', 'b', 'c'}, want: "abc"},
+		{name: "overlapping copy1", src: []byte{7, 1 << 2, 'a', 'b', 1<<2 | 1, 2}, want: "abababa"},
+		{name: "copy2", src: []byte{5, 1 << 2, 'x', 'y', 2<<2 | 2, 2, 0}, want: "xyxyx"},
+		{name: "copy4", src: []byte{4, 1 << 2, 'x', 'y', 1<<2 | 3, 2, 0, 0, 0}, want: "xyxy"},
+		{name: "empty", src: []byte{0}, want: ""},
+		{name: "no length", src: []byte{}, err: true},
+		{name: "too large", src: []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, err: true},
+		{name: "short literal", src: []byte{3, 2 << 2, 'a'}, err: true},
+		{name: "bad offset", src: []byte{6, 1 << 2, 'a', 'b', 0<<2 | 1, 3}, err: true},
+		{name: "zero offset", src: []byte{6, 1 << 2, 'a', 'b', 0<<2 | 1, 0}, err: true},
+		{name: "length mismatch", src: []byte{5, 1 << 2, 'a', 'b'}, err: true},
+		{name: "overflow", src: []byte{2, 2 << 2, 'a', 'b', 'c'}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := decodeSnappy(tt.src, 1024)
+			if tt.err {
+				assert.ErrorIs(t, err, ErrBadPayload)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(data))
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	wr, err := Decode(readFixture(t, "write_request.snappy"), constants.RemoteWriteMaxSize)
+	require.NoError(t, err)
+	require.Len(t, wr.Timeseries, 7)
+
+	assert.Equal(t, []Label{
+		{Name: "__name__", Value: "http_requests_total"},
+		{Name: "instance", Value: "api-1:9090"},
+		{Name: "job", Value: "api"},
+		{Name: "method", Value: "GET"},
+	}, wr.Timeseries[0].Labels)
+	assert.Equal(t, []Sample{{Value: 10, Timestamp: 1700000000000}, {Value: 12, Timestamp: 1700000015000}}, wr.Timeseries[0].Samples)
+	assert.True(t, math.IsNaN(wr.Timeseries[5].Samples[0].Value))
+
+	series, dropped := wr.Series()
+	assert.Equal(t, 3, dropped)
+
+	assert.Equal(t, []Series{
+		{
+			Metric:  storage.Metrics{ID: "http_requests_total", MType: constants.Gauge, Labels: storage.Labels{"instance": "api-1:9090", "job": "api", "method": "GET"}},
+			Samples: []storage.Sample{{Timestamp: 1700000000000, Value: 10}, {Timestamp: 1700000015000, Value: 12}},
+		},
+		{
+			Metric:  storage.Metrics{ID: "http_requests_total", MType: constants.Gauge, Labels: storage.Labels{"instance": "api-1:9090", "job": "api", "method": "POST"}},
+			Samples: []storage.Sample{{Timestamp: 1700000015000, Value: 3}},
+		},
+		{
+			Metric:  storage.Metrics{ID: "node_memory_MemFree_bytes", MType: constants.Gauge, Labels: storage.Labels{"instance": "node-1:9100", "job": "node"}},
+			Samples: []storage.Sample{{Timestamp: 1700000015000, Value: 1.5e9}, {Timestamp: 1700000000000, Value: 2e9}},
+		},
+		{
+			Metric:  storage.Metrics{ID: "up", MType: constants.Gauge, Labels: storage.Labels{"instance": "api-1:9090", "job": "api"}},
+			Samples: []storage.Sample{{Timestamp: 1700000015000, Value: 1}},
+		},
+	}, series)
+
+	// обрезанное сообщение
+	pb := readFixture(t, "write_request.pb")
+	_, err = Unmarshal(pb[:len(pb)-3])
+	assert.ErrorIs(t, err, ErrBadPayload)
+
+	_, err = Decode(pb, constants.RemoteWriteMaxSize)
+	assert.ErrorIs(t, err, ErrBadPayload)
+}
+
+// FuzzDecode тело запроса приходит из сети: разбор не должен паниковать
+// и выходить за ограничение размера распакованного сообщения
+func FuzzDecode(f *testing.F) {
+	f.Add(readFixture(f, "write_request.snappy"))
+	f.Add([]byte{3, 2 << 2, 'a', 'b', 'c'})
+	f.Add([]byte{7, 1 << 2, 'a', 'b', 1<<2 | 1, 2})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		data, err := decodeSnappy(body, 1<<16)
+		if err != nil {
+			assert.ErrorIs(t, err, ErrBadPayload)
+			return
+		}
+		assert.LessOrEqual(t, len(data), 1<<16)
+
+		wr, err := Decode(body, 1<<16)
+		if err != nil {
+			assert.ErrorIs(t, err, ErrBadPayload)
+			return
+		}
+		wr.Series()
+	})
+}
+
+// FuzzUnmarshal разбор protobuf сообщения не должен паниковать, серии содержат только конечные значения
+func FuzzUnmarshal(f *testing.F) {
+	pb := readFixture(f, "write_request.pb")
+	f.Add(pb)
+	f.Add(pb[:len(pb)-3])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		wr, err := Unmarshal(data)
+		if err != nil {
+			assert.ErrorIs(t, err, ErrBadPayload)
+			return
+		}
+
+		series, _ := wr.Series()
+		for _, s := range series {
+			assert.NotEmpty(t, s.Metric.ID)
+			for _, sm := range s.Samples {
+				assert.False(t, math.IsNaN(sm.Value) || math.IsInf(sm.Value, 0))
+			}
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package remotewrite
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// decodeSnappy распаковка блока snappy (без кадров потокового формата), как его отправляет remote_write.
+// Параметры: maxSize - наибольший размер распакованных данных.
+func decodeSnappy(src []byte, maxSize int) ([]byte, error) {
+	size, err := s2.DecodedLen(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: snappy: %w", ErrBadPayload, err)
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("%w: snappy: decoded size %d exceeds %d", ErrBadPayload, size, maxSize)
+	}
+
+	data, err := s2.Decode(nil, src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: snappy: %w", ErrBadPayload, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,114 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// Типы метрик StatsD.
+const (
+	typeCounter   = "c"
+	typeGauge     = "g"
+	typeTimer     = "ms"
+	typeHistogram = "h" // синоним таймера
+)
+
+// line разобранная строка вида name:value|type[|@rate][|#tag:value,...]
+type line struct {
+	name   string
+	labels storage.Labels // теги в формате DogStatsD
+	value  float64
+	mType  string
+	delta  bool    // для gauge: значение со знаком + или - изменяет текущее
+	rate   float64 // частота выборки (0, 1]
+}
+
+// key ключ серии
+func (l line) key() string {
+	return storage.SeriesKey(l.name, l.labels)
+}
+
+// parseLine разбор одной строки StatsD
+func parseLine(s string) (line, error) {
+	l := line{rate: 1}
+
+	name, rest, ok := strings.Cut(s, ":")
+	if !ok || name == "" || storage.ValidateName(name) != nil {
+		return line{}, errors.New("bad metric name")
+	}
+	l.name = name
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return line{}, errors.New("metric type required")
+	}
+
+	l.mType = parts[1]
+	switch l.mType {
+	case typeCounter, typeGauge, typeTimer, typeHistogram:
+	default:
+		return line{}, fmt.Errorf("unsupported metric type %q", l.mType)
+	}
+
+	value := parts[0]
+	if l.mType == typeGauge && (strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")) {
+		l.delta = true
+	}
+
+	var err error
+
+	l.value, err = strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(l.value) || math.IsInf(l.value, 0) {
+		return line{}, fmt.Errorf("bad value %q", value)
+	}
+
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			l.rate, err = strconv.ParseFloat(p[1:], 64)
+			if err != nil || !(l.rate > 0 && l.rate <= 1) {
+				return line{}, fmt.Errorf("bad sample rate %q", p)
+			}
+
+		case strings.HasPrefix(p, "#"):
+			l.labels, err = parseTags(p[1:])
+			if err != nil {
+				return line{}, err
+			}
+
+		default:
+			return line{}, fmt.Errorf("bad section %q", p)
+		}
+	}
+
+	return l, nil
+}
+
+// parseTags разбор тегов вида tag:value,tag2:value2. Теги без значения пропускаются
+func parseTags(s string) (storage.Labels, error) {
+	labels := storage.Labels{}
+
+	for _, tag := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(tag, ":")
+		if !ok || value == "" {
+			continue
+		}
+		labels[name] = value
+	}
+
+	err := storage.ValidateLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,52 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+func TestParseLine(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want line
+	}{
+		{"requests:1|c", line{name: "requests", value: 1, mType: typeCounter, rate: 1}},
+		{"requests:3|c|@0.1", line{name: "requests", value: 3, mType: typeCounter, rate: 0.1}},
+		{"queue:42|g", line{name: "queue", value: 42, mType: typeGauge, rate: 1}},
+		{"queue:-5|g", line{name: "queue", value: -5, mType: typeGauge, delta: true, rate: 1}},
+		{"queue:+2.5|g", line{name: "queue", value: 2.5, mType: typeGauge, delta: true, rate: 1}},
+		{"latency:320|ms|@0.5", line{name: "latency", value: 320, mType: typeTimer, rate: 0.5}},
+		{"size:12|h", line{name: "size", value: 12, mType: typeHistogram, rate: 1}},
+		{"latency:10|ms|#host:a,env:prod,flag", line{name: "latency", value: 10, mType: typeTimer, rate: 1,
+			labels: storage.Labels{"host": "a", "env": "prod"}}},
+		{"latency:10|ms|@0.5|#host:a", line{name: "latency", value: 10, mType: typeTimer, rate: 0.5,
+			labels: storage.Labels{"host": "a"}}},
+	} {
+		l, err := parseLine(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, l, tt.in)
+	}
+
+	for _, bad := range []string{
+		"requests", ":1|c", "requests:1", "requests:1|s", "requests:x|c", "requests:NaN|g", "requests:+Inf|g",
+		"requests:1|c|@0", "requests:1|c|@1.5", "requests:1|c|@x", "requests:1|c|x", "req{a=1}:1|c", "requests:1|c|#a-b:1",
+	} {
+		_, err := parseLine(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestLineKey(t *testing.T) {
+	l, err := parseLine("latency:10|ms|#host:a")
+	require.NoError(t, err)
+	assert.Equal(t, storage.SeriesKey("latency", storage.Labels{"host": "a"}), l.key())
+
+	l, err = parseLine("latency:10|ms|#flag")
+	require.NoError(t, err)
+	assert.Nil(t, l.labels)
+	assert.Equal(t, "latency", l.key())
+}
@@ -0,0 +1,393 @@
+// Package statsd прием метрик по протоколу StatsD (UDP и TCP) с агрегацией за окно и записью в сборщик метрик
+package statsd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// Sink получатель агрегированных метрик
+type Sink interface {
+	// SetBatchMetrics сохраняет метрики пакетом в формате json
+	SetBatchMetrics(ctx context.Context, batch []byte) error
+}
+
+// Config параметры приема StatsD
+type Config struct {
+	UDPAddress      string        // адрес:порт UDP, пусто - не слушать
+	TCPAddress      string        // адрес:порт TCP, пусто - не слушать
+	FlushInterval   time.Duration // окно агрегации, 0 - запись только вызовом Flush
+	HistogramBounds []float64     // границы корзин гистограмм таймеров в секундах
+}
+
+// Server прием StatsD. Значения агрегируются в памяти и записываются пакетом раз в окно:
+// counter - сумма приращений с учетом частоты выборки, gauge - последнее значение,
+// таймеры (ms, h) - гистограмма наблюдений в секундах
+type Server struct {
+	cfg  Config
+	sink Sink
+
+	mutex    sync.Mutex
+	counters map[string]float64           // накопленные приращения, дробный остаток переносится в следующее окно
+	gauges   map[string]float64           // последние значения, изменения со знаком применяются к ним
+	updated  map[string]bool              // gauge, обновленные в текущем окне
+	timers   map[string]storage.Histogram // наблюдения таймеров текущего окна
+
+	udp   net.PacketConn
+	tcp   net.Listener
+	conns map[net.Conn]struct{} // открытые TCP соединения, закрываются при остановке
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer прием StatsD с записью в sink, слушать адреса начинает Start
+func NewServer(cfg Config, sink Sink) *Server {
+	return &Server{
+		cfg:      cfg,
+		sink:     sink,
+		counters: make(map[string]float64),
+		gauges:   make(map[string]float64),
+		updated:  make(map[string]bool),
+		timers:   make(map[string]storage.Histogram),
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start открытие адресов UDP и TCP и запуск периодической записи
+func (s *Server) Start() error {
+	var err error
+
+	if s.cfg.UDPAddress != "" {
+		s.udp, err = net.ListenPacket("udp", s.cfg.UDPAddress)
+		if err != nil {
+			return err
+		}
+
+		s.wg.Add(1)
+		go s.serveUDP()
+	}
+
+	if s.cfg.TCPAddress != "" {
+		s.tcp, err = net.Listen("tcp", s.cfg.TCPAddress)
+		if err != nil {
+			if s.udp != nil {
+				_ = s.udp.Close()
+			}
+			return err
+		}
+
+		s.wg.Add(1)
+		go s.serveTCP()
+	}
+
+	if s.cfg.FlushInterval > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+
+	return nil
+}
+
+// UDPAddr адрес UDP, nil - не слушается
+func (s *Server) UDPAddr() net.Addr {
+	if s.udp == nil {
+		return nil
+	}
+
+	return s.udp.LocalAddr()
+}
+
+// TCPAddr адрес TCP, nil - не слушается
+func (s *Server) TCPAddr() net.Addr {
+	if s.tcp == nil {
+		return nil
+	}
+
+	return s.tcp.Addr()
+}
+
+// Close остановка приема и запись накопленных значений
+func (s *Server) Close() error {
+	close(s.done)
+
+	if s.udp != nil {
+		_ = s.udp.Close()
+	}
+	if s.tcp != nil {
+		_ = s.tcp.Close()
+	}
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
+	defer cancel()
+
+	return s.Flush(ctx)
+}
+
+func (s *Server) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
+			err := s.Flush(ctx)
+			cancel()
+
+			if err != nil {
+				logger.Log().Error("statsd flush: " + err.Error())
+			}
+		}
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, constants.StatsdMaxPacket)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Log().Error("statsd udp: " + err.Error())
+			continue
+		}
+
+		for _, l := range bytes.Split(buf[:n], []byte("\n")) {
+			s.handle(string(l))
+		}
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Log().Error("statsd tcp: " + err.Error())
+			continue
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				s.handle(scanner.Text())
+			}
+
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+// handle разбор строки и добавление значения в текущее окно. Ошибочные строки выводятся в лог
+func (s *Server) handle(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	l, err := parseLine(text)
+	if err != nil {
+		logger.Log().Info("statsd: bad line", zap.String("line", text), zap.Error(err))
+		return
+	}
+
+	s.add(l)
+}
+
+// add добавление значения в текущее окно
+func (s *Server) add(l line) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := l.key()
+
+	switch l.mType {
+	case typeCounter:
+		s.counters[key] += l.value / l.rate
+
+	case typeGauge:
+		if l.delta {
+			s.gauges[key] += l.value
+		} else {
+			s.gauges[key] = l.value
+		}
+		s.updated[key] = true
+
+	case typeTimer, typeHistogram:
+		h, ok := s.timers[key]
+		if !ok {
+			h = storage.NewHistogram(s.cfg.HistogramBounds)
+		}
+
+		// наблюдение при частоте выборки rate учитывается 1/rate раз
+		weight := uint64(math.Max(1, math.Round(1/l.rate)))
+		value := l.value / 1000
+
+		h.Counts[sort.SearchFloat64s(h.Bounds, value)] += weight
+		h.Sum += value * float64(weight)
+		h.Count += weight
+		s.timers[key] = h
+	}
+}
+
+// Flush запись значений текущего окна. Если пакет не записан, серии записываются по одной:
+// серии с недопустимым названием или значением пропускаются с записью в лог,
+// при прочих ошибках (хранилище недоступно) незаписанные значения возвращаются в окно
+func (s *Server) Flush(ctx context.Context) error {
+	s.mutex.Lock()
+
+	var metrics []storage.Metrics
+
+	for key, sum := range s.counters {
+		delta := int64(sum)
+		if delta == 0 {
+			continue
+		}
+
+		s.counters[key] = sum - float64(delta)
+		if s.counters[key] == 0 {
+			delete(s.counters, key)
+		}
+
+		metrics = append(metrics, newMetric(key, constants.Counter, func(mt *storage.Metrics) { mt.Delta = &delta }))
+	}
+
+	gauges := s.updated
+	s.updated = make(map[string]bool)
+	for key := range gauges {
+		value := s.gauges[key]
+		metrics = append(metrics, newMetric(key, constants.Gauge, func(mt *storage.Metrics) { mt.Value = &value }))
+	}
+
+	timers := s.timers
+	s.timers = make(map[string]storage.Histogram)
+	for key, h := range timers {
+		h := h
+		metrics = append(metrics, newMetric(key, constants.Histogram, func(mt *storage.Metrics) { mt.Histogram = &h }))
+	}
+
+	s.mutex.Unlock()
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	err := s.write(ctx, metrics)
+	if err == nil {
+		return nil
+	}
+
+	for i, mt := range metrics {
+		if len(metrics) > 1 {
+			err = s.write(ctx, metrics[i:i+1])
+		}
+		if err == nil {
+			continue
+		}
+
+		if !rejected(err) {
+			// хранилище недоступно: серия и оставшиеся серии возвращаются в окно
+			s.putBack(metrics[i:])
+			return err
+		}
+		logger.Log().Error("statsd: series dropped", zap.String("series", mt.Key()), zap.Error(err))
+	}
+
+	return nil
+}
+
+// write запись метрик пакетом
+func (s *Server) write(ctx context.Context, metrics []storage.Metrics) error {
+	batch, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+
+	return s.sink.SetBatchMetrics(ctx, batch)
+}
+
+// rejected серия не будет записана и при повторе: недопустимое название, метки или значение
+func rejected(err error) bool {
+	return errors.Is(err, storage.ErrBadLabels) || errors.Is(err, storage.ErrBadValue)
+}
+
+// putBack возврат незаписанных значений в текущее окно
+func (s *Server) putBack(metrics []storage.Metrics) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, mt := range metrics {
+		key := mt.Key()
+
+		switch mt.MType {
+		case constants.Counter:
+			s.counters[key] += float64(*mt.Delta)
+		case constants.Gauge:
+			s.updated[key] = true
+		case constants.Histogram:
+			h := *mt.Histogram
+			if cur, ok := s.timers[key]; ok {
+				_ = h.Merge(cur)
+			}
+			s.timers[key] = h
+		}
+	}
+}
+
+// newMetric метрика серии key типа mType, значение задает set
+func newMetric(key string, mType string, set func(mt *storage.Metrics)) storage.Metrics {
+	name, labels := storage.SplitSeriesKey(key)
+	if len(labels) == 0 {
+		labels = nil
+	}
+
+	mt := storage.Metrics{ID: name, MType: mType, Labels: labels}
+	set(&mt)
+
+	return mt
+}
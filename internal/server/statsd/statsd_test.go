@@ -0,0 +1,222 @@
+package statsd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/storage"
+)
+
+// sink получатель пакетов для тестов
+type sink struct {
+	mutex   sync.Mutex
+	metrics []storage.Metrics
+	err     error
+	reject  string // пакет с метрикой с этим названием отвергается как недопустимый
+}
+
+func (s *sink) SetBatchMetrics(_ context.Context, batch []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	var metrics []storage.Metrics
+	err := json.Unmarshal(batch, &metrics)
+	if err != nil {
+		return err
+	}
+	for _, mt := range metrics {
+		if mt.ID == s.reject {
+			return fmt.Errorf("%w: %s", storage.ErrBadLabels, mt.ID)
+		}
+	}
+	s.metrics = append(s.metrics, metrics...)
+
+	return nil
+}
+
+// take метрики, полученные с прошлого вызова, по ключу тип:серия
+func (s *sink) take() map[string]storage.Metrics {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := make(map[string]storage.Metrics)
+	for _, mt := range s.metrics {
+		res[mt.MType+":"+mt.Key()] = mt
+	}
+	s.metrics = nil
+
+	return res
+}
+
+func TestServer_Flush(t *testing.T) {
+	ctx := context.Background()
+	out := &sink{}
+	s := NewServer(Config{HistogramBounds: []float64{0.1, 1}}, out)
+
+	for _, l := range []string{
+		"requests:1|c",
+		"requests:2|c|@0.5",
+		"requests:1|c|@0.3",
+		"queue:10|g",
+		"queue:-3|g",
+		"level:+2|g|#host:a",
+		"latency:50|ms",
+		"latency:500|ms|@0.5",
+		"latency:2000|h",
+		"bad line",
+		"",
+	} {
+		s.handle(l)
+	}
+
+	require.NoError(t, s.Flush(ctx))
+	metrics := out.take()
+	require.Len(t, metrics, 4)
+
+	// 1 + 2/0.5 + 1/0.3 = 8.33, дробная часть переносится в следующее окно
+	assert.Equal(t, int64(8), *metrics["counter:requests"].Delta)
+	assert.Equal(t, float64(7), *metrics["gauge:queue"].Value)
+	level := metrics["gauge:"+storage.SeriesKey("level", storage.Labels{"host": "a"})]
+	assert.Equal(t, float64(2), *level.Value)
+	assert.Equal(t, storage.Labels{"host": "a"}, level.Labels)
+
+	h := metrics["histogram:latency"].Histogram
+	require.NotNil(t, h)
+	assert.Equal(t, []float64{0.1, 1}, h.Bounds)
+	assert.Equal(t, []uint64{1, 2, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.InDelta(t, 0.05+1+2, h.Sum, 1e-9)
+
+	// пустое окно ничего не пишет, gauge не повторяется без обновления
+	require.NoError(t, s.Flush(ctx))
+	assert.Empty(t, out.take())
+
+	// изменение gauge применяется к последнему значению, остаток счетчика добавляется
+	s.handle("queue:+1|g")
+	s.handle("requests:1|c|@0.6")
+	require.NoError(t, s.Flush(ctx))
+	metrics = out.take()
+	assert.Equal(t, float64(8), *metrics["gauge:queue"].Value)
+	assert.Equal(t, int64(2), *metrics["counter:requests"].Delta)
+}
+
+func TestServer_FlushError(t *testing.T) {
+	ctx := context.Background()
+	out := &sink{err: errors.New("storage down")}
+	s := NewServer(Config{HistogramBounds: []float64{0.1, 1}}, out)
+
+	s.handle("requests:3|c")
+	s.handle("queue:10|g")
+	s.handle("latency:50|ms")
+	assert.Error(t, s.Flush(ctx))
+
+	// значения окна с ошибкой записываются вместе со следующим окном
+	out.err = nil
+	s.handle("requests:2|c")
+	s.handle("latency:50|ms")
+	require.NoError(t, s.Flush(ctx))
+
+	metrics := out.take()
+	require.Len(t, metrics, 3)
+	assert.Equal(t, int64(5), *metrics["counter:requests"].Delta)
+	assert.Equal(t, float64(10), *metrics["gauge:queue"].Value)
+	assert.Equal(t, uint64(2), metrics["histogram:latency"].Histogram.Count)
+}
+
+func TestServer_FlushRejected(t *testing.T) {
+	ctx := context.Background()
+	out := &sink{reject: "bad"}
+	s := NewServer(Config{}, out)
+
+	// недопустимая серия пропускается, остальные записываются
+	s.handle("bad:1|c")
+	s.handle("requests:3|c")
+	s.handle("queue:10|g")
+	require.NoError(t, s.Flush(ctx))
+
+	metrics := out.take()
+	require.Len(t, metrics, 2)
+	assert.Equal(t, int64(3), *metrics["counter:requests"].Delta)
+	assert.Equal(t, float64(10), *metrics["gauge:queue"].Value)
+
+	// и не возвращается в окно
+	s.handle("requests:1|c")
+	require.NoError(t, s.Flush(ctx))
+	metrics = out.take()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, int64(1), *metrics["counter:requests"].Delta)
+
+	s.handle("bad:1|c")
+	require.NoError(t, s.Flush(ctx))
+	assert.Empty(t, out.take())
+}
+
+func TestServer_Loopback(t *testing.T) {
+	out := &sink{}
+	s := NewServer(Config{
+		UDPAddress:      "127.0.0.1:0",
+		TCPAddress:      "127.0.0.1:0",
+		FlushInterval:   10 * time.Millisecond,
+		HistogramBounds: []float64{0.1, 1},
+	}, out)
+	require.NoError(t, s.Start())
+
+	udp, err := net.Dial("udp", s.UDPAddr().String())
+	require.NoError(t, err)
+	defer udp.Close()
+
+	_, err = fmt.Fprint(udp, "udp_requests:1|c\nudp_requests:1|c\nudp_queue:5|g")
+	require.NoError(t, err)
+
+	tcp, err := net.Dial("tcp", s.TCPAddr().String())
+	require.NoError(t, err)
+
+	_, err = fmt.Fprint(tcp, "tcp_latency:20|ms\ntcp_latency:200|ms\n")
+	require.NoError(t, err)
+
+	received := make(map[string]storage.Metrics)
+	assert.Eventually(t, func() bool {
+		for key, mt := range out.take() {
+			received[key] = mt
+		}
+		return len(received) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, int64(2), *received["counter:udp_requests"].Delta)
+	assert.Equal(t, float64(5), *received["gauge:udp_queue"].Value)
+	assert.Equal(t, uint64(2), received["histogram:tcp_latency"].Histogram.Count)
+
+	// незакрытое клиентом соединение не мешает остановке
+	require.NoError(t, s.Close())
+}
+
+func TestServer_Close(t *testing.T) {
+	out := &sink{}
+	s := NewServer(Config{FlushInterval: time.Hour}, out)
+	require.NoError(t, s.Start())
+	assert.Nil(t, s.UDPAddr())
+	assert.Nil(t, s.TCPAddr())
+
+	// при остановке записывается остаток окна
+	s.handle("requests:4|c")
+	require.NoError(t, s.Close())
+
+	metrics := out.take()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, int64(4), *metrics["counter:requests"].Delta)
+	assert.Equal(t, constants.Counter, metrics["counter:requests"].MType)
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// benchSizes количества серий в бенчмарках дампа
+var benchSizes = []int{10_000, 100_000, 1_000_000}
+
+// benchStorage хранилище с series сериями gauge и counter поровну
+func benchStorage(series int) *MemStorage {
+	ctx := context.Background()
+
+	m := NewMemStorage()
+	m.SetHistorySize(0)
+	for i := 0; i < series/2; i++ {
+		name := `Alloc{host="web-` + strconv.Itoa(i) + `"}`
+		_ = m.SetGauge(ctx, name, float64(i)/3)
+		_ = m.SetCounter(ctx, name, int64(i))
+	}
+
+	return m
+}
+
+// heapPeak фоновое измерение пикового HeapInuse сверх уровня на момент запуска.
+// Возвращаемая функция останавливает измерение и возвращает пик в байтах
+func heapPeak() func() uint64 {
+	var ms runtime.MemStats
+
+	// частая сборка мусора, чтобы пик отражал живые данные, а не запас кучи до следующей сборки
+	gcPercent := debug.SetGCPercent(1)
+
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	base := ms.HeapInuse
+	peak := base
+
+	done := make(chan struct{})
+	result := make(chan uint64)
+
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			runtime.ReadMemStats(&ms)
+			peak = max(peak, ms.HeapInuse)
+
+			select {
+			case <-done:
+				debug.SetGCPercent(gcPercent)
+				result <- peak - base
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() uint64 {
+		close(done)
+		return <-result
+	}
+}
+
+// reportPerSeries пиковая занятая куча и кол-во выделений на серию за одну операцию
+func reportPerSeries(b *testing.B, before, after runtime.MemStats, peak uint64, series int) {
+	b.ReportMetric(float64(peak)/float64(series), "peak-B/series")
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(b.N)/float64(series), "allocs/series")
+}
+
+// Дамп пишется по записи на серию, без построения всего дампа в памяти,
+// поэтому пиковая куча при записи в поток почти не растет с размером хранилища (на серию - падает),
+// а при сборке дампа в буфере (buffered) растет вместе с дампом.
+// Пик измеряется при частой сборке мусора, поэтому время операции завышено.
+// go test -bench BenchmarkMemStorage_WriteDump -benchmem -run ^$ ./internal/storage/
+// result:
+// series=10000                 76    15409005 ns/op    5.074 allocs/series    118.8 peak-B/series      4236301 B/op     50739 allocs/op
+// series=10000/buffered        68    19786524 ns/op    5.076 allocs/series    370.3 peak-B/series      7379868 B/op     50757 allocs/op
+// series=100000                 3   427940952 ns/op    5.012 allocs/series    50.79 peak-B/series     41234389 B/op    501213 allocs/op
+// series=100000/buffered        3   466382387 ns/op    5.012 allocs/series    221.9 peak-B/series     66399480 B/op    501225 allocs/op
+// series=1000000                1  7674155200 ns/op    5.002 allocs/series    46.37 peak-B/series    516771864 B/op   5002121 allocs/op
+// series=1000000/buffered       1  6273022681 ns/op    5.002 allocs/series    185.1 peak-B/series    718097376 B/op   5002133 allocs/op
+func BenchmarkMemStorage_WriteDump(b *testing.B) {
+	for _, series := range benchSizes {
+		m := benchStorage(series)
+
+		for _, buffered := range []bool{false, true} {
+			name := "series=" + strconv.Itoa(series)
+			if buffered {
+				name += "/buffered"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				ctx := context.Background()
+
+				var before, after runtime.MemStats
+
+				b.ReportAllocs()
+				stop := heapPeak()
+				b.ResetTimer()
+				runtime.ReadMemStats(&before)
+
+				for i := 0; i < b.N; i++ {
+					var w io.Writer = io.Discard
+					if buffered {
+						w = &bytes.Buffer{}
+					}
+
+					err := m.WriteDump(ctx, w)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				runtime.ReadMemStats(&after)
+				reportPerSeries(b, before, after, stop(), series)
+			})
+		}
+	}
+}
+
+// Восстановление читает дамп по записи, пиковая куча уходит на сами серии.
+// go test -bench BenchmarkMemStorage_RestoreFromDump -benchmem -run ^$ ./internal/storage/
+// result:
+// series=10000          39     31143686 ns/op    5.265 allocs/series    281.8 peak-B/series      4560846 B/op     52651 allocs/op
+// series=100000          3    403757694 ns/op    5.048 allocs/series    160.0 peak-B/series     39500482 B/op    504758 allocs/op
+// series=1000000         1  10287589106 ns/op    5.028 allocs/series    237.9 peak-B/series    537389736 B/op   5028148 allocs/op
+func BenchmarkMemStorage_RestoreFromDump(b *testing.B) {
+	for _, series := range benchSizes {
+		b.Run("series="+strconv.Itoa(series), func(b *testing.B) {
+			var dump bytes.Buffer
+
+			err := benchStorage(series).WriteDump(context.Background(), &dump)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ctx := context.Background()
+
+			var before, after runtime.MemStats
+
+			b.ReportAllocs()
+			stop := heapPeak()
+			b.ResetTimer()
+			runtime.ReadMemStats(&before)
+
+			for i := 0; i < b.N; i++ {
+				err = NewMemStorage().RestoreFromDump(ctx, bytes.NewReader(dump.Bytes()))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			runtime.ReadMemStats(&after)
+			reportPerSeries(b, before, after, stop(), series)
+		})
+	}
+}
@@ -0,0 +1,536 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+)
+
+// CacheBackend хранилище, перед которым работает кеш чтения
+type CacheBackend interface {
+	SetGauge(ctx context.Context, name string, value float64) error
+	SetGaugeSamples(ctx context.Context, series map[string][]Sample) error
+	SetCounter(ctx context.Context, name string, value int64) error
+	IncrementCounter(ctx context.Context, name string, delta int64) error
+	IncrementCounters(ctx context.Context, deltas map[string]int64) error
+	SetBatch(ctx context.Context, batch []byte) error
+	GetGauge(ctx context.Context, name string) (float64, error)
+	GetCounter(ctx context.Context, name string) (int64, error)
+	AddHistogram(ctx context.Context, name string, value Histogram) error
+	GetHistogram(ctx context.Context, name string) (Histogram, error)
+	AddSummary(ctx context.Context, name string, value Summary) error
+	GetSummary(ctx context.Context, name string) (Summary, error)
+	GetAll(ctx context.Context) (map[string]float64, map[string]int64, error)
+	GetAllHistograms(ctx context.Context) (map[string]Histogram, error)
+	GetAllSummaries(ctx context.Context) (map[string]Summary, error)
+	QueryRange(ctx context.Context, mType string, name string, from, to time.Time) ([]Sample, error)
+	AddRollups(ctx context.Context, resolution time.Duration, mType string, name string, rollups []Rollup) error
+	QueryRollups(ctx context.Context, resolution time.Duration, mType string, name string, from, to time.Time) ([]Rollup, error)
+	PruneHistory(ctx context.Context, resolution time.Duration, before time.Time) error
+	ListSeries(ctx context.Context) ([]SeriesInfo, error)
+	DeleteSeries(ctx context.Context, mType string, name string, updatedBefore time.Time) (bool, error)
+	WriteDump(ctx context.Context, w io.Writer) error
+	RestoreFromDump(ctx context.Context, r io.Reader) error
+	DatabasePing(ctx context.Context) bool
+}
+
+// CacheNotifier рассылка сброса кеша между экземплярами сервера (LISTEN/NOTIFY Postgresql)
+type CacheNotifier interface {
+	// Notify отправка уведомления payload в канал channel
+	Notify(ctx context.Context, channel string, payload string) error
+
+	// Listen подписка на канал channel: после подписки вызывается ready, на каждое уведомление - handle.
+	// Возвращает ошибку при потере соединения или отмене ctx
+	Listen(ctx context.Context, channel string, ready func(), handle func(payload string)) error
+}
+
+// CacheConfig параметры кеша чтения
+type CacheConfig struct {
+	Size int           // кол-во серий в кеше
+	TTL  time.Duration // срок жизни значения, 0 - без ограничения
+}
+
+// cacheEntry значение серии в кеше
+type cacheEntry struct {
+	key     historyKey
+	value   any
+	expires time.Time
+}
+
+// cacheMessage уведомление о сбросе кеша
+type cacheMessage struct {
+	From   string        `json:"from"`          // экземпляр-отправитель, свои уведомления пропускаются
+	All    bool          `json:"all,omitempty"` // сброс всего кеша
+	Series []cacheSeries `json:"series,omitempty"`
+}
+
+type cacheSeries struct {
+	MType string `json:"type"`
+	Key   string `json:"key"`
+}
+
+// CachedStorage кеш чтения значений серий перед хранилищем, остальные запросы идут в хранилище напрямую.
+// Итоговые значения обновлений пишутся в хранилище и в кеш, приращения сбрасывают серию в кеше.
+// Другим экземплярам сервера с той же БД рассылается уведомление о сбросе обновленных серий,
+// без подписки на уведомления кеш не используется
+type CachedStorage struct {
+	CacheBackend
+
+	notifier CacheNotifier // nil - единственный экземпляр сервера
+	id       string
+
+	mutex   sync.Mutex
+	entries map[historyKey]*list.Element
+	lru     *list.List // от недавно использованных к давно
+	gen     uint64     // поколение кеша, меняется при каждом сбросе и записи
+	live    bool       // кеш используется: подписка на уведомления активна
+	size    int
+	ttl     time.Duration
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewCachedStorage кеш чтения перед backend, сброс кеша рассылается через notifier (nil - без рассылки)
+func NewCachedStorage(backend CacheBackend, cfg CacheConfig, notifier CacheNotifier) *CachedStorage {
+	c := &CachedStorage{
+		CacheBackend: backend,
+		notifier:     notifier,
+		id:           newInstanceID(),
+		entries:      make(map[historyKey]*list.Element),
+		lru:          list.New(),
+		live:         notifier == nil,
+		size:         cfg.Size,
+		ttl:          cfg.TTL,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	if notifier != nil {
+		c.wg.Add(1)
+		go c.listen(ctx)
+	}
+
+	return c
+}
+
+// newInstanceID случайный идентификатор экземпляра сервера
+func newInstanceID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Close остановка подписки на уведомления
+func (c *CachedStorage) Close() error {
+	c.cancel()
+	c.wg.Wait()
+
+	return nil
+}
+
+// SelfMetrics счетчики попаданий и промахов кеша
+func (c *CachedStorage) SelfMetrics() map[string]int64 {
+	return map[string]int64{
+		constants.CacheHitsMetric:   c.hits.Load(),
+		constants.CacheMissesMetric: c.misses.Load(),
+	}
+}
+
+// listen подписка на уведомления о сбросе кеша с переподпиской при потере соединения
+func (c *CachedStorage) listen(ctx context.Context) {
+	defer c.wg.Done()
+
+	for {
+		err := c.notifier.Listen(ctx, constants.CacheChannel, func() {
+			// уведомления до подписки пропущены
+			c.mutex.Lock()
+			c.reset()
+			c.live = true
+			c.mutex.Unlock()
+		}, c.handle)
+
+		c.mutex.Lock()
+		c.reset()
+		c.live = false
+		c.mutex.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Log().Error("cache: " + err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(constants.CacheListenRetry):
+		}
+	}
+}
+
+// handle обработка уведомления о сбросе кеша
+func (c *CachedStorage) handle(payload string) {
+	var msg cacheMessage
+
+	err := json.Unmarshal([]byte(payload), &msg)
+	if err != nil {
+		logger.Log().Error("cache: bad notification: " + err.Error())
+		return
+	}
+
+	if msg.From == c.id {
+		return
+	}
+
+	if msg.All {
+		c.mutex.Lock()
+		c.reset()
+		c.mutex.Unlock()
+		return
+	}
+
+	keys := make([]historyKey, 0, len(msg.Series))
+	for _, s := range msg.Series {
+		keys = append(keys, historyKey{mType: s.MType, key: s.Key})
+	}
+	c.invalidate(keys...)
+}
+
+// notify рассылка сброса серий keys другим экземплярам, all - сброс всего кеша.
+// Серии разбиваются на уведомления не больше CacheNotifySize
+func (c *CachedStorage) notify(ctx context.Context, all bool, keys ...historyKey) {
+	if c.notifier == nil {
+		return
+	}
+
+	send := func(msg cacheMessage) {
+		payload, err := json.Marshal(msg)
+		if err == nil {
+			err = c.notifier.Notify(ctx, constants.CacheChannel, string(payload))
+		}
+		if err != nil {
+			logger.Log().Error("cache: notify: " + err.Error())
+		}
+	}
+
+	if all {
+		send(cacheMessage{From: c.id, All: true})
+		return
+	}
+
+	// место под отправителя и разметку уведомления
+	limit := constants.CacheNotifySize - 64
+
+	msg := cacheMessage{From: c.id}
+	size := 0
+	for _, k := range keys {
+		s := cacheSeries{MType: k.mType, Key: k.key}
+		item, _ := json.Marshal(s)
+
+		// серия не помещается в уведомление - сбрасывается весь кеш
+		if len(item) > limit {
+			send(cacheMessage{From: c.id, All: true})
+			return
+		}
+
+		if size+len(item) > limit && len(msg.Series) > 0 {
+			send(msg)
+			msg.Series, size = nil, 0
+		}
+		msg.Series = append(msg.Series, s)
+		size += len(item) + 1
+	}
+
+	if len(msg.Series) > 0 {
+		send(msg)
+	}
+}
+
+// get значение серии из кеша или из хранилища с сохранением в кеш.
+// Значение не сохраняется, если кеш изменился за время чтения из хранилища
+func (c *CachedStorage) get(key historyKey, load func() (any, error)) (any, error) {
+	c.mutex.Lock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if c.ttl == 0 || time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(el)
+			c.mutex.Unlock()
+			c.hits.Add(1)
+
+			return entry.value, nil
+		}
+		c.remove(el)
+	}
+	gen := c.gen
+	c.mutex.Unlock()
+
+	c.misses.Add(1)
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if c.gen == gen {
+		c.store(key, value)
+	}
+	c.mutex.Unlock()
+
+	return value, nil
+}
+
+// store сохранение значения в кеш с вытеснением давно использованных серий, вызывается под блокировкой
+func (c *CachedStorage) store(key historyKey, value any) {
+	if !c.live || c.size <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{key: key, value: value, expires: time.Now().Add(c.ttl)}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove удаление элемента кеша, вызывается под блокировкой
+func (c *CachedStorage) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	c.lru.Remove(el)
+}
+
+// reset сброс всего кеша, вызывается под блокировкой
+func (c *CachedStorage) reset() {
+	c.entries = make(map[historyKey]*list.Element)
+	c.lru.Init()
+	c.gen++
+}
+
+// invalidate сброс серий в кеше, возвращает новое поколение кеша
+func (c *CachedStorage) invalidate(keys ...historyKey) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+	c.gen++
+
+	return c.gen
+}
+
+// settle завершение записи итогового значения серии, начатой в поколении gen.
+// Значение кешируется, только если запись успешна и кеш за время записи не менялся
+func (c *CachedStorage) settle(gen uint64, key historyKey, value any, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if ok && c.gen == gen {
+		c.store(key, value)
+	} else if el, found := c.entries[key]; found {
+		c.remove(el)
+	}
+	c.gen++
+}
+
+// change запись в хранилище со сбросом серий keys в кеше до и после записи и рассылкой сброса
+func (c *CachedStorage) change(ctx context.Context, write func() error, keys ...historyKey) error {
+	c.invalidate(keys...)
+	err := write()
+	c.invalidate(keys...)
+
+	if err != nil {
+		return err
+	}
+	c.notify(ctx, false, keys...)
+
+	return nil
+}
+
+func (c *CachedStorage) GetGauge(ctx context.Context, name string) (float64, error) {
+	value, err := c.get(historyKey{mType: constants.Gauge, key: name}, func() (any, error) {
+		return c.CacheBackend.GetGauge(ctx, name)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value.(float64), nil
+}
+
+func (c *CachedStorage) GetCounter(ctx context.Context, name string) (int64, error) {
+	value, err := c.get(historyKey{mType: constants.Counter, key: name}, func() (any, error) {
+		return c.CacheBackend.GetCounter(ctx, name)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value.(int64), nil
+}
+
+// GetHistogram копия гистограммы, сохраненная в кеше не изменяется
+func (c *CachedStorage) GetHistogram(ctx context.Context, name string) (Histogram, error) {
+	value, err := c.get(historyKey{mType: constants.Histogram, key: name}, func() (any, error) {
+		return c.CacheBackend.GetHistogram(ctx, name)
+	})
+	if err != nil {
+		return Histogram{}, err
+	}
+
+	return value.(Histogram).Clone(), nil
+}
+
+// GetSummary копия скетча, сохраненный в кеше не изменяется
+func (c *CachedStorage) GetSummary(ctx context.Context, name string) (Summary, error) {
+	value, err := c.get(historyKey{mType: constants.Summary, key: name}, func() (any, error) {
+		return c.CacheBackend.GetSummary(ctx, name)
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+
+	return value.(Summary).Clone(), nil
+}
+
+func (c *CachedStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	key := historyKey{mType: constants.Gauge, key: name}
+
+	gen := c.invalidate(key)
+	err := c.CacheBackend.SetGauge(ctx, name, value)
+	c.settle(gen, key, value, err == nil)
+
+	if err != nil {
+		return err
+	}
+	c.notify(ctx, false, key)
+
+	return nil
+}
+
+func (c *CachedStorage) SetGaugeSamples(ctx context.Context, series map[string][]Sample) error {
+	keys := make([]historyKey, 0, len(series))
+	for name := range series {
+		keys = append(keys, historyKey{mType: constants.Gauge, key: name})
+	}
+
+	return c.change(ctx, func() error {
+		return c.CacheBackend.SetGaugeSamples(ctx, series)
+	}, keys...)
+}
+
+func (c *CachedStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	key := historyKey{mType: constants.Counter, key: name}
+
+	gen := c.invalidate(key)
+	err := c.CacheBackend.SetCounter(ctx, name, value)
+	c.settle(gen, key, value, err == nil)
+
+	if err != nil {
+		return err
+	}
+	c.notify(ctx, false, key)
+
+	return nil
+}
+
+func (c *CachedStorage) IncrementCounter(ctx context.Context, name string, delta int64) error {
+	return c.change(ctx, func() error {
+		return c.CacheBackend.IncrementCounter(ctx, name, delta)
+	}, historyKey{mType: constants.Counter, key: name})
+}
+
+func (c *CachedStorage) IncrementCounters(ctx context.Context, deltas map[string]int64) error {
+	keys := make([]historyKey, 0, len(deltas))
+	for name := range deltas {
+		keys = append(keys, historyKey{mType: constants.Counter, key: name})
+	}
+
+	return c.change(ctx, func() error {
+		return c.CacheBackend.IncrementCounters(ctx, deltas)
+	}, keys...)
+}
+
+// SetBatch запись пакета со сбросом его серий в кеше
+func (c *CachedStorage) SetBatch(ctx context.Context, batch []byte) error {
+	var metrics []Metrics
+
+	// некорректный пакет отвергнет хранилище
+	_ = json.Unmarshal(batch, &metrics)
+
+	keys := make([]historyKey, 0, len(metrics))
+	for _, mt := range metrics {
+		keys = append(keys, historyKey{mType: mt.MType, key: mt.Key()})
+	}
+
+	return c.change(ctx, func() error {
+		return c.CacheBackend.SetBatch(ctx, batch)
+	}, keys...)
+}
+
+func (c *CachedStorage) AddHistogram(ctx context.Context, name string, value Histogram) error {
+	return c.change(ctx, func() error {
+		return c.CacheBackend.AddHistogram(ctx, name, value)
+	}, historyKey{mType: constants.Histogram, key: name})
+}
+
+func (c *CachedStorage) AddSummary(ctx context.Context, name string, value Summary) error {
+	return c.change(ctx, func() error {
+		return c.CacheBackend.AddSummary(ctx, name, value)
+	}, historyKey{mType: constants.Summary, key: name})
+}
+
+func (c *CachedStorage) DeleteSeries(ctx context.Context, mType string, name string, updatedBefore time.Time) (bool, error) {
+	var deleted bool
+
+	err := c.change(ctx, func() error {
+		var err error
+		deleted, err = c.CacheBackend.DeleteSeries(ctx, mType, name, updatedBefore)
+		return err
+	}, historyKey{mType: mType, key: name})
+
+	return deleted, err
+}
+
+// RestoreFromDump восстановление хранилища из дампа со сбросом всего кеша
+func (c *CachedStorage) RestoreFromDump(ctx context.Context, r io.Reader) error {
+	c.mutex.Lock()
+	c.reset()
+	c.mutex.Unlock()
+
+	err := c.CacheBackend.RestoreFromDump(ctx, r)
+
+	c.mutex.Lock()
+	c.reset()
+	c.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	c.notify(ctx, true)
+
+	return nil
+}
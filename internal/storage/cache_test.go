@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+// countingBackend хранилище в памяти со счетчиком чтений значений серий
+type countingBackend struct {
+	*MemStorage
+	reads atomic.Int64
+}
+
+func (b *countingBackend) GetGauge(ctx context.Context, name string) (float64, error) {
+	b.reads.Add(1)
+	return b.MemStorage.GetGauge(ctx, name)
+}
+
+func (b *countingBackend) GetCounter(ctx context.Context, name string) (int64, error) {
+	b.reads.Add(1)
+	return b.MemStorage.GetCounter(ctx, name)
+}
+
+func (b *countingBackend) GetHistogram(ctx context.Context, name string) (Histogram, error) {
+	b.reads.Add(1)
+	return b.MemStorage.GetHistogram(ctx, name)
+}
+
+// cacheBus канал уведомлений в памяти вместо LISTEN/NOTIFY, доставка синхронная
+type cacheBus struct {
+	mutex     sync.Mutex
+	handlers  map[int]func(payload string)
+	next      int
+	subscribe bool // подписка выполняется
+}
+
+func newCacheBus() *cacheBus {
+	return &cacheBus{handlers: make(map[int]func(payload string)), subscribe: true}
+}
+
+func (b *cacheBus) Notify(ctx context.Context, channel string, payload string) error {
+	b.mutex.Lock()
+	handlers := make([]func(payload string), 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mutex.Unlock()
+
+	for _, h := range handlers {
+		h(payload)
+	}
+
+	return nil
+}
+
+func (b *cacheBus) Listen(ctx context.Context, channel string, ready func(), handle func(payload string)) error {
+	b.mutex.Lock()
+	if !b.subscribe {
+		b.mutex.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	id := b.next
+	b.next++
+	b.handlers[id] = handle
+	b.mutex.Unlock()
+
+	ready()
+	<-ctx.Done()
+
+	b.mutex.Lock()
+	delete(b.handlers, id)
+	b.mutex.Unlock()
+
+	return ctx.Err()
+}
+
+// newTestCache кеш перед backend, с notifier - после подписки на уведомления
+func newTestCache(t *testing.T, backend CacheBackend, cfg CacheConfig, notifier CacheNotifier) *CachedStorage {
+	c := NewCachedStorage(backend, cfg, notifier)
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+func waitLive(t *testing.T, c *CachedStorage) {
+	require.Eventually(t, func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.live
+	}, time.Second, time.Millisecond)
+}
+
+func TestCachedStorage(t *testing.T) {
+	ctx := context.Background()
+	cfg := CacheConfig{Size: 100, TTL: time.Minute}
+
+	t.Run("read through", func(t *testing.T) {
+		backend := &countingBackend{MemStorage: NewMemStorage()}
+		require.NoError(t, backend.MemStorage.SetGauge(ctx, "Alloc", 1))
+		c := newTestCache(t, backend, cfg, nil)
+
+		for i := 0; i < 3; i++ {
+			val, err := c.GetGauge(ctx, "Alloc")
+			require.NoError(t, err)
+			assert.Equal(t, float64(1), val)
+		}
+		assert.Equal(t, int64(1), backend.reads.Load())
+
+		// отсутствующая серия не кешируется
+		_, err := c.GetGauge(ctx, "Missing")
+		assert.Error(t, err)
+		_, err = c.GetGauge(ctx, "Missing")
+		assert.Error(t, err)
+		assert.Equal(t, int64(3), backend.reads.Load())
+
+		assert.Equal(t, map[string]int64{constants.CacheHitsMetric: 2, constants.CacheMissesMetric: 3}, c.SelfMetrics())
+	})
+
+	t.Run("write through", func(t *testing.T) {
+		backend := &countingBackend{MemStorage: NewMemStorage()}
+		c := newTestCache(t, backend, cfg, nil)
+
+		require.NoError(t, c.SetGauge(ctx, "Alloc", 5))
+		require.NoError(t, c.SetCounter(ctx, "PollCount", 10))
+
+		val, err := c.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, float64(5), val)
+		counter, err := c.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), counter)
+		assert.Equal(t, int64(0), backend.reads.Load())
+
+		// приращения сбрасывают серию
+		require.NoError(t, c.IncrementCounter(ctx, "PollCount", 2))
+		counter, err = c.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(12), counter)
+
+		require.NoError(t, c.SetBatch(ctx, []byte(`[{"id":"PollCount","type":"counter","delta":3},{"id":"Alloc","type":"gauge","value":6}]`)))
+		counter, _ = c.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(15), counter)
+		val, _ = c.GetGauge(ctx, "Alloc")
+		assert.Equal(t, float64(6), val)
+		assert.Equal(t, int64(3), backend.reads.Load())
+
+		require.NoError(t, c.SetGaugeSamples(ctx, map[string][]Sample{"Alloc": {{Timestamp: 2000, Value: 8}, {Timestamp: 1000, Value: 7}}}))
+		val, _ = c.GetGauge(ctx, "Alloc")
+		assert.Equal(t, float64(8), val)
+		assert.Equal(t, int64(4), backend.reads.Load())
+
+		deleted, err := c.DeleteSeries(ctx, constants.Gauge, "Alloc", time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.True(t, deleted)
+		_, err = c.GetGauge(ctx, "Alloc")
+		assert.Error(t, err)
+
+		dump := `{"op":"set","type":"counter","name":"PollCount","value":2}` + "\n"
+		require.NoError(t, c.RestoreFromDump(ctx, strings.NewReader(dump)))
+		counter, _ = c.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(2), counter)
+	})
+
+	t.Run("histogram copy", func(t *testing.T) {
+		backend := &countingBackend{MemStorage: NewMemStorage()}
+		c := newTestCache(t, backend, cfg, nil)
+
+		require.NoError(t, c.AddHistogram(ctx, "Latency", Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}))
+
+		h, err := c.GetHistogram(ctx, "Latency")
+		require.NoError(t, err)
+		h.Counts[0] = 100
+
+		h, err = c.GetHistogram(ctx, "Latency")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), h.Counts[0])
+		assert.Equal(t, int64(1), backend.reads.Load())
+	})
+
+	t.Run("ttl and size", func(t *testing.T) {
+		backend := &countingBackend{MemStorage: NewMemStorage()}
+		for _, name := range []string{"A", "B", "C"} {
+			require.NoError(t, backend.MemStorage.SetGauge(ctx, name, 1))
+		}
+
+		c := newTestCache(t, backend, CacheConfig{Size: 2, TTL: time.Minute}, nil)
+		for _, name := range []string{"A", "B", "A", "C", "A", "B"} {
+			_, err := c.GetGauge(ctx, name)
+			require.NoError(t, err)
+		}
+		// C вытеснила B как давно использованную
+		assert.Equal(t, int64(4), backend.reads.Load())
+
+		c = newTestCache(t, backend, CacheConfig{Size: 2, TTL: time.Millisecond}, nil)
+		_, _ = c.GetGauge(ctx, "A")
+		time.Sleep(5 * time.Millisecond)
+		_, _ = c.GetGauge(ctx, "A")
+		assert.Equal(t, int64(6), backend.reads.Load())
+	})
+
+	t.Run("instances", func(t *testing.T) {
+		backend := &countingBackend{MemStorage: NewMemStorage()}
+		require.NoError(t, backend.MemStorage.SetGauge(ctx, "Alloc", 1))
+		require.NoError(t, backend.MemStorage.SetCounter(ctx, "PollCount", 1))
+
+		bus := newCacheBus()
+		a := newTestCache(t, backend, cfg, bus)
+		b := newTestCache(t, backend, cfg, bus)
+		waitLive(t, a)
+		waitLive(t, b)
+
+		_, _ = b.GetGauge(ctx, "Alloc")
+		_, _ = b.GetCounter(ctx, "PollCount")
+
+		// обновление на одном экземпляре сбрасывает серию в кеше другого
+		require.NoError(t, a.SetGauge(ctx, "Alloc", 2))
+		require.NoError(t, a.IncrementCounter(ctx, "PollCount", 5))
+
+		val, _ := b.GetGauge(ctx, "Alloc")
+		assert.Equal(t, float64(2), val)
+		counter, _ := b.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(6), counter)
+
+		// собственное уведомление не сбрасывает записанное значение
+		reads := backend.reads.Load()
+		val, _ = a.GetGauge(ctx, "Alloc")
+		assert.Equal(t, float64(2), val)
+		assert.Equal(t, reads, backend.reads.Load())
+	})
+
+	t.Run("not listening", func(t *testing.T) {
+		backend := &countingBackend{MemStorage: NewMemStorage()}
+		require.NoError(t, backend.MemStorage.SetGauge(ctx, "Alloc", 1))
+
+		bus := newCacheBus()
+		bus.subscribe = false
+		c := newTestCache(t, backend, cfg, bus)
+
+		// без подписки сброс с других экземпляров не придет, значения не кешируются
+		_, _ = c.GetGauge(ctx, "Alloc")
+		_, _ = c.GetGauge(ctx, "Alloc")
+		assert.Equal(t, int64(2), backend.reads.Load())
+	})
+}
+
+func TestCachedStorageNotifySplit(t *testing.T) {
+	var payloads []string
+	notifier := &recordingNotifier{notify: func(payload string) { payloads = append(payloads, payload) }}
+
+	c := &CachedStorage{notifier: notifier, id: "test"}
+
+	keys := make([]historyKey, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, historyKey{mType: constants.Gauge, key: strings.Repeat("x", 20) + string(rune('a'+i%26))})
+	}
+	c.notify(context.Background(), false, keys...)
+
+	require.Greater(t, len(payloads), 1)
+	for _, p := range payloads {
+		assert.LessOrEqual(t, len(p), constants.CacheNotifySize)
+	}
+
+	// серия длиннее уведомления - сброс всего кеша
+	payloads = nil
+	c.notify(context.Background(), false, historyKey{mType: constants.Gauge, key: strings.Repeat("x", constants.CacheNotifySize)})
+	assert.Equal(t, []string{`{"from":"test","all":true}`}, payloads)
+}
+
+// recordingNotifier запоминает отправленные уведомления
+type recordingNotifier struct {
+	notify func(payload string)
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, channel string, payload string) error {
+	n.notify(payload)
+	return nil
+}
+
+func (n *recordingNotifier) Listen(ctx context.Context, channel string, ready func(), handle func(payload string)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
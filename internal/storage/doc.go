@@ -1,5 +1,14 @@
 // Package storage содержит разные типы хранилищ
+// cache - кеш чтения значений серий перед хранилищем, сброс кеша на других экземплярах через LISTEN/NOTIFY
+// dump - потоковый дамп и журнал обновлений в формате NDJSON (записи с итоговыми значениями серий)
 // filebackup - хранилище резервной копии БД
-// memory - хранилище в оперативной памяти
-// postgresql - хранилище в СУДБ Postgresql
+// histogram - метрика типа histogram (гистограмма с заданными границами корзин)
+// hybrid - память с отложенной записью в Postgresql, очередь обновлений копится, пока БД недоступна
+// history - история значений серий (кольцевой буфер в памяти, уровни агрегатов, прореживание с шагом)
+// labels - метки метрик, ключи серий и селекторы меток
+// migrate - версионные миграции схемы Postgresql (встроенные sql файлы migrations/)
+// memory - хранилище в оперативной памяти, серии разбиты на сегменты со своими блокировками
+// pgpool - пул соединений pgx, запись пакетов конвейером запросов (pgx.Batch) или через COPY во временные таблицы, LISTEN/NOTIFY
+// postgresql - хранилище в СУДБ Postgresql, пакеты пишутся в транзакции с повтором при ошибках сериализации и соединения
+// summary - метрика типа summary (скетч для оценки квантилей p50/p90/p99 на сервере)
 package storage
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+// ErrBadDump некорректная запись дампа или журнала обновлений
+var ErrBadDump = errors.New("bad dump record")
+
+// Операции записей дампа и журнала обновлений.
+const (
+	OpSet    = "set"    // значение серии
+	OpAdd    = "add"    // прибавление к значению счетчика (counter), читается из журналов прежних версий
+	OpDelete = "delete" // удаление серии
+	OpExtra  = "extra"  // дополнительные данные дампа (например, заглушки алертов), хранилищами метрик пропускаются
+)
+
+// dumpSections разделы json дампа (формат до NDJSON) с сериями каждого типа
+var dumpSections = map[string]string{
+	"gauges":     constants.Gauge,
+	"counters":   constants.Counter,
+	"histograms": constants.Histogram,
+	"summaries":  constants.Summary,
+}
+
+// DumpRecord запись дампа в формате NDJSON (по записи на строку).
+// Журнал обновлений состоит из таких же записей и применяется как продолжение дампа.
+// Записи хранят итоговое значение серии, поэтому повторное применение записи не меняет результат
+type DumpRecord struct {
+	Op    string          `json:"op"`
+	MType string          `json:"type"`            // тип метрики, для дополнительных данных - их раздел
+	Name  string          `json:"name,omitempty"`  // ключ серии
+	Value json.RawMessage `json:"value,omitempty"` // значение в формате json
+}
+
+// NewSetRecord запись со значением серии типа mType
+func NewSetRecord(mType string, name string, value any) (DumpRecord, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return DumpRecord{}, err
+	}
+
+	return DumpRecord{Op: OpSet, MType: mType, Name: name, Value: data}, nil
+}
+
+// NewDeleteRecord запись об удалении серии типа mType
+func NewDeleteRecord(mType string, name string) DumpRecord {
+	return DumpRecord{Op: OpDelete, MType: mType, Name: name}
+}
+
+// NewExtraRecord запись с дополнительными данными раздела section
+func NewExtraRecord(section string, value any) (DumpRecord, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return DumpRecord{}, err
+	}
+
+	return DumpRecord{Op: OpExtra, MType: section, Value: data}, nil
+}
+
+// DecodeDump последовательное чтение записей дампа из r, для каждой вызывается f
+func DecodeDump(r io.Reader, f func(rec DumpRecord) error) error {
+	dec := json.NewDecoder(r)
+
+	for n := 1; ; n++ {
+		var rec DumpRecord
+
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: record %d: %s", ErrBadDump, n, err.Error())
+		}
+
+		err = f(rec)
+		if err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+	}
+}
+
+// extraFilter читатель дампа, передающий записи с дополнительными данными в extra, а остальные - дальше
+type extraFilter struct {
+	src   *bufio.Reader
+	extra func(rec DumpRecord) error
+	buf   []byte
+	err   error
+}
+
+// extraPrefix начало записи с дополнительными данными, записи кодируются с полем op первым
+var extraPrefix = []byte(`{"op":"` + OpExtra + `"`)
+
+// FilterExtra отделение дополнительных данных от записей о метриках: записи с дополнительными данными
+// передаются в extra, а читатель возвращает дамп без них
+func FilterExtra(r io.Reader, extra func(rec DumpRecord) error) io.Reader {
+	return &extraFilter{src: bufio.NewReader(r), extra: extra}
+}
+
+func (f *extraFilter) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+
+		line, err := f.src.ReadBytes('\n')
+		f.err = err
+
+		if !bytes.HasPrefix(line, extraPrefix) {
+			f.buf = line
+			continue
+		}
+
+		var rec DumpRecord
+
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			f.err = fmt.Errorf("%w: %s", ErrBadDump, err.Error())
+			continue
+		}
+
+		err = f.extra(rec)
+		if err != nil {
+			f.err = err
+		}
+	}
+
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+
+	return n, nil
+}
+
+// legacyDump преобразование json дампа (формат до NDJSON) в записи.
+// Разделы-массивы, кроме разделов с метриками, становятся дополнительными данными
+func legacyDump(data []byte) ([]byte, error) {
+	sections := make(map[string]json.RawMessage)
+
+	err := json.Unmarshal(data, &sections)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadDump, err.Error())
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	for section, raw := range sections {
+		mType, ok := dumpSections[section]
+		if !ok {
+			var items []json.RawMessage
+			if json.Unmarshal(raw, &items) != nil {
+				continue
+			}
+
+			for _, item := range items {
+				err = enc.Encode(DumpRecord{Op: OpExtra, MType: section, Value: item})
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			continue
+		}
+
+		values := make(map[string]json.RawMessage)
+
+		err = json.Unmarshal(raw, &values)
+		if err != nil {
+			return nil, fmt.Errorf("%w: section %s: %s", ErrBadDump, section, err.Error())
+		}
+
+		for name, value := range values {
+			err = enc.Encode(DumpRecord{Op: OpSet, MType: mType, Name: name, Value: value})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
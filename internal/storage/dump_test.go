@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+func TestDecodeDump(t *testing.T) {
+	var records []DumpRecord
+
+	collect := func(rec DumpRecord) error {
+		records = append(records, rec)
+		return nil
+	}
+
+	err := DecodeDump(strings.NewReader(`{"op":"set","type":"gauge","name":"Alloc","value":1}`+"\n"+`{"op":"delete","type":"gauge","name":"Frees"}`+"\n"), collect)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, DumpRecord{Op: OpSet, MType: constants.Gauge, Name: "Alloc", Value: []byte("1")}, records[0])
+	assert.Equal(t, NewDeleteRecord(constants.Gauge, "Frees"), records[1])
+
+	records = nil
+	err = DecodeDump(strings.NewReader(""), collect)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	err = DecodeDump(strings.NewReader("{bad\n"), collect)
+	assert.ErrorIs(t, err, ErrBadDump)
+	err = DecodeDump(strings.NewReader(`{"op":"se`), collect)
+	assert.ErrorIs(t, err, ErrBadDump)
+}
+
+func TestFilterExtra(t *testing.T) {
+	gauge, err := NewSetRecord(constants.Gauge, "Alloc", 1.5)
+	require.NoError(t, err)
+	extra, err := NewExtraRecord("silences", map[string]string{"id": "1"})
+	require.NoError(t, err)
+
+	var buf strings.Builder
+
+	enc := json.NewEncoder(&buf)
+	for _, rec := range []DumpRecord{gauge, extra, NewDeleteRecord(constants.Gauge, "Frees")} {
+		require.NoError(t, enc.Encode(rec))
+	}
+
+	var extras []DumpRecord
+
+	data, err := io.ReadAll(FilterExtra(strings.NewReader(buf.String()), func(rec DumpRecord) error {
+		extras = append(extras, rec)
+		return nil
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, `{"op":"set","type":"gauge","name":"Alloc","value":1.5}`+"\n"+`{"op":"delete","type":"gauge","name":"Frees"}`+"\n", string(data))
+	require.Len(t, extras, 1)
+	assert.JSONEq(t, `{"id":"1"}`, string(extras[0].Value))
+
+	_, err = io.ReadAll(FilterExtra(strings.NewReader(`{"op":"extra",bad`+"\n"), func(rec DumpRecord) error {
+		return nil
+	}))
+	assert.ErrorIs(t, err, ErrBadDump)
+}
+
+func TestLegacyDump(t *testing.T) {
+	data, err := legacyDump([]byte(`{"gauges":{"Alloc":1},"counters":{"PollCount":5},"silences":[{"id":"1"}]}`))
+	require.NoError(t, err)
+
+	records := make(map[string]DumpRecord)
+	err = DecodeDump(strings.NewReader(string(data)), func(rec DumpRecord) error {
+		records[rec.MType] = rec
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, DumpRecord{Op: OpSet, MType: constants.Gauge, Name: "Alloc", Value: []byte("1")}, records[constants.Gauge])
+	assert.Equal(t, DumpRecord{Op: OpSet, MType: constants.Counter, Name: "PollCount", Value: []byte("5")}, records[constants.Counter])
+	assert.Equal(t, DumpRecord{Op: OpExtra, MType: "silences", Value: []byte(`{"id":"1"}`)}, records["silences"])
+
+	_, err = legacyDump([]byte(`{"gauges":[1]}`))
+	assert.ErrorIs(t, err, ErrBadDump)
+}
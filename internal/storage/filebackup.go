@@ -2,18 +2,44 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
 )
 
-// BackupStorage работает с файловым хранилищем резервной копии базы данных
+// ErrBadSnapshot поврежденный снимок резервной копии
+var ErrBadSnapshot = errors.New("bad backup snapshot")
+
+// snapshotMagic начало заголовка снимка. Заголовок - первая строка файла: "go-metrics-backup <версия формата>",
+// затем записи дампа в формате NDJSON и последней строкой "sha256 <контрольная сумма записей>".
+// В формате версии 1 контрольная сумма была в заголовке, а данные - json дампом
+const snapshotMagic = "go-metrics-backup"
+
+// snapshotTrailerLen длина последней строки снимка с контрольной суммой
+const snapshotTrailerLen = len("sha256 ") + sha256.Size*2 + 1
+
+// BackupStorage работает с файловым хранилищем резервной копии базы данных:
+// снимками базы и журналом (WAL) обновлений, сделанных после последнего снимка.
+// Хранится несколько поколений снимков: filename - последний, filename.1 - предыдущий и т.д.
 type BackupStorage struct {
-	mutex      sync.Mutex
-	Gauges     map[string]float64 `json:"gauges"`
-	Counters   map[string]int64   `json:"counters"`
-	backupFile *os.File
-	scanner    *bufio.Scanner
+	saveMutex   sync.Mutex // снимки сохраняются по одному
+	mutex       sync.Mutex
+	filename    string    // файл последнего снимка
+	generations int       // количество хранимых поколений снимков
+	wal         *os.File  // журнал обновлений, дописывается в конец
+	walSync     string    // политика fsync журнала
+	synced      time.Time // время последнего fsync журнала
 }
 
 func NewBackupStorage(filename string) (*BackupStorage, error) {
@@ -22,45 +48,545 @@ func NewBackupStorage(filename string) (*BackupStorage, error) {
 	if err != nil {
 		return nil, err
 	}
+	file.Close()
+
+	wal, err := os.OpenFile(filename+constants.WALSuffix, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = truncateTornRecord(wal)
+	if err != nil {
+		wal.Close()
+		return nil, err
+	}
 
 	return &BackupStorage{
-		backupFile: file,
-		scanner:    bufio.NewScanner(file),
+		filename:    filename,
+		generations: constants.BackupGenerations,
+		wal:         wal,
+		walSync:     constants.WALSync,
+		synced:      time.Now(),
 	}, nil
 }
 
-// Save сохранение в файл
-func (b *BackupStorage) Save(dump string) error {
+// SetGenerations количество хранимых поколений снимков, не меньше одного
+func (b *BackupStorage) SetGenerations(n int) error {
+	if n < 1 {
+		return fmt.Errorf("bad backup generations %d", n)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.generations = n
+
+	return nil
+}
+
+// Generations количество хранимых поколений снимков
+func (b *BackupStorage) Generations() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.generations
+}
+
+// generationFile файл снимка поколения gen, 0 - последний снимок
+func (b *BackupStorage) generationFile(gen int) string {
+	if gen == 0 {
+		return b.filename
+	}
+
+	return b.filename + "." + strconv.Itoa(gen)
+}
+
+// SetWALSync политика fsync журнала: always, interval или never
+func (b *BackupStorage) SetWALSync(policy string) error {
+	switch policy {
+	case constants.WALSyncAlways, constants.WALSyncInterval, constants.WALSyncNever:
+	default:
+		return fmt.Errorf("bad wal fsync policy %q", policy)
+	}
+
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	_, err := b.backupFile.Seek(0, io.SeekStart)
+	b.walSync = policy
+
+	return nil
+}
+
+// Append дописывание записей в журнал одной операцией записи
+func (b *BackupStorage) Append(records ...DumpRecord) error {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		err := enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	_, err := b.wal.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if b.walSync == constants.WALSyncAlways || (b.walSync == constants.WALSyncInterval && time.Since(b.synced) >= constants.WALSyncPeriod) {
+		err = b.wal.Sync()
+		if err != nil {
+			return err
+		}
+		b.synced = time.Now()
+	}
+
+	return nil
+}
+
+// Save сохранение снимка в файл и очистка журнала. Записи дампа пишет функция write.
+// Перед записью журнал закрывается и начинается новый, write вызывается без блокировки Append:
+// обновления, сделанные во время записи снимка, попадают в новый журнал. Записи журнала хранят итоговые значения,
+// поэтому применение их к снимку, уже содержащему обновление, результат не меняет.
+// Снимок с заголовком и контрольной суммой пишется во временный файл, предыдущие поколения сдвигаются,
+// и временный файл переименовывается в последний снимок, поэтому сбой во время записи не портит предыдущие снимки.
+// Закрытый журнал удаляется после замены снимка, при ошибке он остается и применяется к прежнему снимку
+func (b *BackupStorage) Save(write func(w io.Writer) error) error {
+	b.saveMutex.Lock()
+	defer b.saveMutex.Unlock()
+
+	err := b.checkpoint()
+	if err != nil {
+		return err
+	}
+
+	tmp := b.filename + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = writeSnapshot(file, write)
+	if err == nil {
+		err = file.Sync()
+	}
+	if errC := file.Close(); err == nil {
+		err = errC
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	err = b.rotate()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, b.filename)
+	if err != nil {
+		return err
+	}
+
+	// все обновления из закрытого журнала вошли в снимок
+	err = os.Remove(b.prevWALFile())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// prevWALFile журнал, закрытый на время сохранения снимка
+func (b *BackupStorage) prevWALFile() string {
+	return b.filename + constants.WALPrevSuffix
+}
+
+// checkpoint закрытие журнала перед сохранением снимка: журнал переименовывается в filename.wal.prev
+// и открывается новый. Если закрытый журнал остался от неудачного сохранения - журнал дописывается к нему
+func (b *BackupStorage) checkpoint() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	prev := b.prevWALFile()
+
+	err := b.wal.Sync()
+	if err != nil {
+		return err
+	}
+	b.synced = time.Now()
+
+	_, err = os.Stat(prev)
+	if err == nil {
+		return b.appendPrevWAL(prev)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	name := b.wal.Name()
+
+	err = os.Rename(name, prev)
+	if err != nil {
+		return err
+	}
+
+	wal, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		// журнал остается прежним
+		return errors.Join(err, os.Rename(prev, name))
+	}
+
+	old := b.wal
+	b.wal = wal
+
+	return old.Close()
+}
+
+// appendPrevWAL перенос записей журнала в конец закрытого журнала prev и очистка журнала
+func (b *BackupStorage) appendPrevWAL(prev string) error {
+	file, err := os.OpenFile(prev, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = truncateTornRecord(file)
+	if err != nil {
+		return err
+	}
+
+	info, err := b.wal.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, io.NewSectionReader(b.wal, 0, info.Size()))
 	if err != nil {
 		return err
 	}
 
-	err = b.backupFile.Truncate(0)
+	err = file.Sync()
 	if err != nil {
 		return err
 	}
 
-	_, err = b.backupFile.Write([]byte(dump))
+	err = b.wal.Truncate(0)
 	if err != nil {
 		return err
 	}
 
+	return b.wal.Sync()
+}
+
+// rotate сдвиг поколений снимков: самое старое удаляется, последний снимок становится предыдущим
+func (b *BackupStorage) rotate() error {
+	// пустой файл - снимок еще не сохранялся
+	info, err := os.Stat(b.filename)
+	if err != nil || info.Size() == 0 {
+		return nil
+	}
+
+	for gen := b.generations - 1; gen > 0; gen-- {
+		err = os.Rename(b.generationFile(gen-1), b.generationFile(gen))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Load получение данных из файла
-func (b *BackupStorage) Load() (string, error) {
+// rotated есть ли предыдущие поколения снимков
+func (b *BackupStorage) rotated() bool {
+	_, err := os.Stat(b.generationFile(1))
+
+	return err == nil
+}
+
+// Load чтение записей дампа поколения gen (0 - последний снимок). За последним снимком следуют записи
+// журнала, закрытого при неудачном сохранении снимка, и текущего журнала. Журнал записан после последнего снимка,
+// поэтому предыдущие поколения читаются без него.
+// Контрольная сумма снимка проверяется до возврата читателя.
+// Для отсутствующего поколения возвращается os.ErrNotExist, для поврежденного - ErrBadSnapshot
+func (b *BackupStorage) Load(gen int) (io.ReadCloser, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if gen < 0 || gen >= b.generations {
+		return nil, fmt.Errorf("generation %d: %w", gen, os.ErrNotExist)
+	}
+
+	name := b.generationFile(gen)
+
+	var (
+		snapshot io.Reader = bytes.NewReader(nil)
+		closers  []io.Closer
+	)
+
+	file, err := os.Open(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var size int64
+	if err == nil {
+		closers = append(closers, file)
+
+		info, errS := file.Stat()
+		if errS != nil {
+			closeAll(closers)
+			return nil, errS
+		}
+		size = info.Size()
+	}
+
+	if size > 0 {
+		snapshot, err = readSnapshot(file, size)
+		if err != nil {
+			closeAll(closers)
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	} else if gen > 0 || b.rotated() {
+		// нет снимка, хотя предыдущие поколения есть - последний снимок потерян при сбое во время сдвига поколений
+		closeAll(closers)
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+
+	readers := []io.Reader{snapshot}
+	walSize := int64(0)
+
+	walNames := []string{b.prevWALFile(), b.wal.Name()}
+	if gen > 0 {
+		walNames = nil
+	}
+
+	// журналы читаются через отдельные дескрипторы до текущего конца, дописываемые дальше записи не попадают
+	for _, walName := range walNames {
+		wal, errW := os.Open(walName)
+		if errors.Is(errW, os.ErrNotExist) {
+			continue
+		}
+		if errW != nil {
+			closeAll(closers)
+			return nil, errW
+		}
+		closers = append(closers, wal)
+
+		info, errW := wal.Stat()
+		if errW != nil {
+			closeAll(closers)
+			return nil, errW
+		}
+		readers = append(readers, io.NewSectionReader(wal, 0, info.Size()))
+		walSize += info.Size()
+	}
+
+	// снимок еще не сохранялся и журнал пуст
+	if size == 0 && walSize == 0 {
+		closeAll(closers)
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+
+	return &dumpReader{
+		Reader:  io.MultiReader(readers...),
+		closers: closers,
+	}, nil
+}
+
+// Close закрытие журнала с fsync
+func (b *BackupStorage) Close() error {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	data, err := io.ReadAll(b.backupFile)
+	err := b.wal.Sync()
+	if err != nil {
+		return err
+	}
+
+	return b.wal.Close()
+}
+
+// dumpReader чтение снимка и журнала с закрытием их файлов
+type dumpReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *dumpReader) Close() error {
+	return closeAll(r.closers)
+}
+
+// closeAll закрытие всех файлов, возвращается первая ошибка
+func closeAll(closers []io.Closer) error {
+	var first error
+
+	for _, c := range closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// truncateTornRecord удаление прерванной сбоем последней записи журнала,
+// иначе следующая запись будет дописана к ней в ту же строку.
+// Журнал просматривается с конца до последнего перевода строки
+func truncateTornRecord(wal *os.File) error {
+	info, err := wal.Stat()
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := max(end-int64(len(buf)), 0)
+
+		n, err := wal.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			if start+int64(i)+1 == info.Size() {
+				return nil
+			}
+
+			return wal.Truncate(start + int64(i) + 1)
+		}
+
+		end = start
+	}
+
+	if info.Size() > 0 {
+		return wal.Truncate(0)
+	}
+
+	return nil
+}
+
+// writeSnapshot запись снимка: заголовок с версией формата, записи дампа и контрольная сумма записей
+func writeSnapshot(w io.Writer, write func(w io.Writer) error) error {
+	bw := bufio.NewWriter(w)
+
+	_, err := fmt.Fprintf(bw, "%s %d\n", snapshotMagic, constants.BackupFormatVersion)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+
+	err = write(io.MultiWriter(bw, h))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(bw, "sha256 %s\n", hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// readSnapshot проверка заголовка и контрольной суммы снимка размером size, возвращает читатель записей дампа.
+// Снимок версии 1 и файл без заголовка (json дамп) читаются в память и преобразуются в записи
+func readSnapshot(file *os.File, size int64) (io.Reader, error) {
+	header, err := bufio.NewReader(io.NewSectionReader(file, 0, size)).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(header, []byte(snapshotMagic+" ")) {
+		data, errR := io.ReadAll(io.NewSectionReader(file, 0, size))
+		if errR != nil {
+			return nil, errR
+		}
+
+		return readLegacySnapshot(data)
+	}
+
+	fields := strings.Fields(string(header))
+	if len(fields) == 3 && fields[1] == "1" {
+		data, errR := io.ReadAll(io.NewSectionReader(file, 0, size))
+		if errR != nil {
+			return nil, errR
+		}
+
+		return readLegacySnapshot(data)
+	}
+
+	if len(fields) != 2 || fields[1] != strconv.Itoa(constants.BackupFormatVersion) {
+		return nil, fmt.Errorf("%w: unsupported header %q", ErrBadSnapshot, bytes.TrimSpace(header))
+	}
+
+	start := int64(len(header))
+	end := size - int64(snapshotTrailerLen)
+	if end < start {
+		return nil, fmt.Errorf("%w: truncated", ErrBadSnapshot)
+	}
+
+	trailer := make([]byte, snapshotTrailerLen)
+
+	_, err = file.ReadAt(trailer, end)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, ok := bytes.CutPrefix(bytes.TrimSuffix(trailer, []byte("\n")), []byte("sha256 "))
+	if !ok {
+		return nil, fmt.Errorf("%w: no checksum", ErrBadSnapshot)
+	}
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, io.NewSectionReader(file, start, end-start))
+	if err != nil {
+		return nil, err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != string(sum) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	return io.NewSectionReader(file, start, end-start), nil
+}
+
+// readLegacySnapshot снимок версии 1 (заголовок с контрольной суммой и json дамп) или json дамп без заголовка
+func readLegacySnapshot(data []byte) (io.Reader, error) {
+	if bytes.HasPrefix(data, []byte(snapshotMagic+" ")) {
+		header, dump, ok := bytes.Cut(data, []byte("\n"))
+		if !ok {
+			return nil, fmt.Errorf("%w: truncated header", ErrBadSnapshot)
+		}
+
+		fields := strings.Fields(string(header))
+		sum := sha256.Sum256(dump)
+		if len(fields) != 3 || hex.EncodeToString(sum[:]) != fields[2] {
+			return nil, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+		}
+
+		data = dump
+	}
+
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%w: not a json dump", ErrBadSnapshot)
+	}
+
+	records, err := legacyDump(data)
+	if err != nil {
+		return nil, err
 	}
 
-	return string(data), nil
+	return bytes.NewReader(records), nil
 }
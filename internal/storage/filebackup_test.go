@@ -1,14 +1,43 @@
 package storage
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
 )
 
+// writeString функция записи дампа для Save
+func writeString(dump string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, dump)
+		return err
+	}
+}
+
+// loadString чтение поколения gen целиком
+func loadString(bs *BackupStorage, gen int) (string, error) {
+	r, err := bs.Load(gen)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+
+	return string(data), err
+}
+
 func TestBackupStorage_Save(t *testing.T) {
 	ex, err := os.Executable()
 	if err != nil {
@@ -19,16 +48,216 @@ func TestBackupStorage_Save(t *testing.T) {
 	dbFile := exPath + "/test_backup"
 	bs, _ := NewBackupStorage(dbFile)
 
-	testStr := "text data"
-	bs.Save(testStr)
-	bs.backupFile.Close()
+	testStr := `{"op":"set","type":"gauge","name":"Alloc","value":1}` + "\n"
+	bs.Save(writeString(testStr))
+	bs.Close()
 
 	bs, _ = NewBackupStorage(dbFile)
-	backup, err := bs.Load()
+	backup, err := loadString(bs, 0)
 	if err != nil {
 		fmt.Println(err)
 	}
 
+	bs.Close()
 	os.Remove(dbFile)
+	os.Remove(dbFile + constants.WALSuffix)
 	assert.Equal(t, testStr, backup)
 }
+
+func TestBackupStorage_WAL(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "backup.json")
+
+	bs, err := NewBackupStorage(dbFile)
+	require.NoError(t, err)
+	require.NoError(t, bs.SetWALSync(constants.WALSyncAlways))
+	assert.Error(t, bs.SetWALSync("sometimes"))
+
+	snapshot := `{"op":"set","type":"gauge","name":"Alloc","value":1}` + "\n" + `{"op":"set","type":"gauge","name":"Frees","value":2}` + "\n"
+	err = bs.Save(writeString(snapshot))
+	require.NoError(t, err)
+
+	gauge, err := NewSetRecord(constants.Gauge, "Alloc", 5.5)
+	require.NoError(t, err)
+	counter, err := NewSetRecord(constants.Counter, "PollCount", int64(3))
+	require.NoError(t, err)
+	err = bs.Append(gauge, counter, NewDeleteRecord(constants.Gauge, "Frees"))
+	require.NoError(t, err)
+	bs.Close()
+
+	// сбой во время записи: последняя запись не дописана
+	f, err := os.OpenFile(dbFile+constants.WALSuffix, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"set","type":"gauge","na`)
+	require.NoError(t, err)
+	f.Close()
+
+	bs, err = NewBackupStorage(dbFile)
+	require.NoError(t, err)
+	defer bs.Close()
+
+	gauge, err = NewSetRecord(constants.Gauge, "Alloc", 6.5)
+	require.NoError(t, err)
+	require.NoError(t, bs.Append(gauge))
+
+	// записи журнала следуют за снимком
+	r, err := bs.Load(0)
+	require.NoError(t, err)
+	m := NewMemStorage()
+	require.NoError(t, m.RestoreFromDump(context.Background(), r))
+	require.NoError(t, r.Close())
+	gauges, counters, _ := m.GetAll(context.Background())
+	assert.Equal(t, map[string]float64{"Alloc": 6.5}, gauges)
+	assert.Equal(t, map[string]int64{"PollCount": 3}, counters)
+
+	// снимок очищает журнал
+	err = bs.Save(func(w io.Writer) error {
+		return m.WriteDump(context.Background(), w)
+	})
+	require.NoError(t, err)
+	data, err := os.ReadFile(dbFile + constants.WALSuffix)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	r, err = bs.Load(0)
+	require.NoError(t, err)
+	restored := NewMemStorage()
+	require.NoError(t, restored.RestoreFromDump(context.Background(), r))
+	require.NoError(t, r.Close())
+	restoredGauges, restoredCounters, _ := restored.GetAll(context.Background())
+	assert.Equal(t, gauges, restoredGauges)
+	assert.Equal(t, counters, restoredCounters)
+
+	assert.NoFileExists(t, dbFile+constants.WALPrevSuffix)
+
+	// ошибка записи дампа не затрагивает сохраненный снимок, закрытый журнал остается
+	gauge, err = NewSetRecord(constants.Gauge, "Alloc", 7.5)
+	require.NoError(t, err)
+	require.NoError(t, bs.Append(gauge))
+	err = bs.Save(func(w io.Writer) error {
+		return io.ErrShortWrite
+	})
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+	assert.NoFileExists(t, dbFile+".tmp")
+	assert.NoFileExists(t, dbFile+".1.1")
+	assert.FileExists(t, dbFile+constants.WALPrevSuffix)
+	backup, err := loadString(bs, 0)
+	require.NoError(t, err)
+	assert.Contains(t, backup, `"PollCount"`)
+	assert.Contains(t, backup, `"value":7.5`)
+
+	// следующая ошибка дописывает журнал к закрытому
+	counter, err = NewSetRecord(constants.Counter, "PollCount", int64(4))
+	require.NoError(t, err)
+	require.NoError(t, bs.Append(counter))
+	err = bs.Save(func(w io.Writer) error {
+		return io.ErrShortWrite
+	})
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+	data, err = os.ReadFile(dbFile + constants.WALPrevSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	// обновления во время записи снимка попадают в новый журнал
+	err = bs.Save(func(w io.Writer) error {
+		gauge, err := NewSetRecord(constants.Gauge, "Alloc", 8.5)
+		if err != nil {
+			return err
+		}
+		if err = bs.Append(gauge); err != nil {
+			return err
+		}
+
+		return m.WriteDump(context.Background(), w)
+	})
+	require.NoError(t, err)
+	assert.NoFileExists(t, dbFile+constants.WALPrevSuffix)
+
+	r, err = bs.Load(0)
+	require.NoError(t, err)
+	restored = NewMemStorage()
+	require.NoError(t, restored.RestoreFromDump(context.Background(), r))
+	require.NoError(t, r.Close())
+	restoredGauges, _, _ = restored.GetAll(context.Background())
+	assert.Equal(t, map[string]float64{"Alloc": 8.5}, restoredGauges)
+}
+
+func TestBackupStorage_Generations(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "backup.json")
+
+	bs, err := NewBackupStorage(dbFile)
+	require.NoError(t, err)
+	defer bs.Close()
+
+	assert.Error(t, bs.SetGenerations(0))
+	require.NoError(t, bs.SetGenerations(3))
+	assert.Equal(t, 3, bs.Generations())
+
+	// еще ничего не сохранялось
+	_, err = bs.Load(0)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = bs.Load(1)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	record := func(n int) string {
+		return fmt.Sprintf(`{"op":"set","type":"counter","name":"n","value":%d}`+"\n", n)
+	}
+
+	for n := 1; n <= 4; n++ {
+		require.NoError(t, bs.Save(writeString(record(n))))
+	}
+
+	for gen, want := range []string{record(4), record(3), record(2)} {
+		backup, errL := loadString(bs, gen)
+		require.NoError(t, errL)
+		assert.Equal(t, want, backup)
+	}
+	_, err = bs.Load(3)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoFileExists(t, dbFile+".3")
+
+	// журнал записан после последнего снимка и к предыдущим поколениям не применяется
+	rec, err := NewSetRecord(constants.Counter, "n", int64(5))
+	require.NoError(t, err)
+	require.NoError(t, bs.Append(rec))
+	backup, err := loadString(bs, 0)
+	require.NoError(t, err)
+	assert.Equal(t, record(4)+record(5), backup)
+	backup, err = loadString(bs, 1)
+	require.NoError(t, err)
+	assert.Equal(t, record(3), backup)
+	require.NoError(t, bs.Save(writeString(record(5))))
+
+	data, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "go-metrics-backup 2\n"))
+	assert.Contains(t, string(data), "\nsha256 ")
+
+	// поврежденный снимок
+	data[len("go-metrics-backup 2\n")+1] = '['
+	require.NoError(t, os.WriteFile(dbFile, data, 0644))
+	_, err = bs.Load(0)
+	assert.ErrorIs(t, err, ErrBadSnapshot)
+
+	for _, bad := range []string{"go-metrics-backup 1 abc", "go-metrics-backup 1 abc\n{}", "go-metrics-backup 3\n{}", "go-metrics-backup 2\n", "go-metrics-backup 2\n" + strings.Repeat("x", 80), `{"n":`} {
+		require.NoError(t, os.WriteFile(dbFile, []byte(bad), 0644))
+		_, err = bs.Load(0)
+		assert.ErrorIs(t, err, ErrBadSnapshot, bad)
+	}
+
+	// снимки прежних форматов: json дамп с заголовком версии 1 и без заголовка
+	sum := sha256.Sum256([]byte(`{"counters":{"n":5}}`))
+	for _, legacy := range []string{
+		"go-metrics-backup 1 " + hex.EncodeToString(sum[:]) + "\n" + `{"counters":{"n":5}}`,
+		`{"counters":{"n":5}}`,
+	} {
+		require.NoError(t, os.WriteFile(dbFile, []byte(legacy), 0644))
+		backup, errL := loadString(bs, 0)
+		require.NoError(t, errL)
+		assert.Equal(t, record(5), backup)
+	}
+
+	// последний снимок потерян при сдвиге поколений
+	require.NoError(t, os.Remove(dbFile))
+	_, err = bs.Load(0)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
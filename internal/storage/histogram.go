@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrBadHistogram некорректная гистограмма или несовпадение границ корзин при слиянии
+var ErrBadHistogram = errors.New("bad histogram")
+
+// Histogram гистограмма с заданными границами корзин.
+// Counts[i] - количество наблюдений v, для которых Bounds[i-1] < v <= Bounds[i],
+// последний элемент Counts - наблюдения больше последней границы (+Inf).
+type Histogram struct {
+	Bounds []float64 `json:"bounds"` // верхние границы корзин по возрастанию
+	Counts []uint64  `json:"counts"` // количество наблюдений в корзинах, на одну больше, чем границ
+	Sum    float64   `json:"sum"`    // сумма всех наблюдений
+	Count  uint64    `json:"count"`  // количество всех наблюдений
+}
+
+// NewHistogram пустая гистограмма с границами bounds
+func NewHistogram(bounds []float64) Histogram {
+	return Histogram{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// ParseBounds разбор границ корзин из строки вида "0.1,0.5,1"
+func ParseBounds(s string) ([]float64, error) {
+	var bounds []float64
+
+	for _, b := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad bound %s", ErrBadHistogram, b)
+		}
+
+		bounds = append(bounds, v)
+	}
+
+	err := validateBounds(bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	return bounds, nil
+}
+
+// Validate проверка корректности гистограммы
+func (h Histogram) Validate() error {
+	err := validateBounds(h.Bounds)
+	if err != nil {
+		return err
+	}
+
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: %d counts for %d bounds", ErrBadHistogram, len(h.Counts), len(h.Bounds))
+	}
+
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+
+	if count != h.Count {
+		return fmt.Errorf("%w: count %d differs from buckets total %d", ErrBadHistogram, h.Count, count)
+	}
+
+	return nil
+}
+
+// Observe добавление одного наблюдения
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.Bounds, value)
+
+	h.Counts[i]++
+	h.Sum += value
+	h.Count++
+}
+
+// Merge прибавление к гистограмме другой гистограммы с такими же границами корзин
+func (h *Histogram) Merge(other Histogram) error {
+	if !h.SameBounds(other) {
+		return fmt.Errorf("%w: bucket bounds mismatch", ErrBadHistogram)
+	}
+
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+
+	return nil
+}
+
+// SameBounds совпадают ли границы корзин
+func (h Histogram) SameBounds(other Histogram) bool {
+	if len(h.Bounds) != len(other.Bounds) {
+		return false
+	}
+
+	for i, b := range h.Bounds {
+		if b != other.Bounds[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Clone копия гистограммы, не разделяющая с исходной срезы
+func (h Histogram) Clone() Histogram {
+	return Histogram{
+		Bounds: append([]float64(nil), h.Bounds...),
+		Counts: append([]uint64(nil), h.Counts...),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// validateBounds границы должны быть конечными и строго возрастать
+func validateBounds(bounds []float64) error {
+	if len(bounds) == 0 {
+		return fmt.Errorf("%w: bucket bounds required", ErrBadHistogram)
+	}
+
+	for i, b := range bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("%w: bound must be finite", ErrBadHistogram)
+		}
+
+		if i > 0 && b <= bounds[i-1] {
+			return fmt.Errorf("%w: bounds must be increasing", ErrBadHistogram)
+		}
+	}
+
+	return nil
+}
+
+// mergeHistogram прибавление гистограммы метрики mt из пакета к h.
+// exists - false, если h еще нет, тогда результат - гистограмма mt
+func mergeHistogram(h Histogram, exists bool, mt Metrics) (Histogram, error) {
+	if mt.Histogram == nil {
+		return Histogram{}, fmt.Errorf("%w: histogram %s: no data", ErrBadHistogram, mt.ID)
+	}
+
+	err := mt.Histogram.Validate()
+	if err != nil {
+		return Histogram{}, fmt.Errorf("histogram %s: %w", mt.ID, err)
+	}
+
+	if !exists {
+		return mt.Histogram.Clone(), nil
+	}
+
+	err = h.Merge(*mt.Histogram)
+	if err != nil {
+		return Histogram{}, fmt.Errorf("histogram %s: %w", mt.ID, err)
+	}
+
+	return h, nil
+}
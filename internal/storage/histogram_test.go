@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramObserveMerge(t *testing.T) {
+	h := NewHistogram([]float64{1, 5})
+	h.Observe(0.5)
+	h.Observe(1)
+	h.Observe(3)
+	h.Observe(10)
+
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.Equal(t, 14.5, h.Sum)
+	assert.NoError(t, h.Validate())
+
+	other := NewHistogram([]float64{1, 5})
+	other.Observe(2)
+
+	err := h.Merge(other)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2, 2, 1}, h.Counts)
+	assert.Equal(t, uint64(5), h.Count)
+
+	err = h.Merge(NewHistogram([]float64{1, 2}))
+	assert.ErrorIs(t, err, ErrBadHistogram)
+}
+
+func TestHistogramValidate(t *testing.T) {
+	assert.ErrorIs(t, Histogram{}.Validate(), ErrBadHistogram)
+	assert.ErrorIs(t, Histogram{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}}.Validate(), ErrBadHistogram)
+	assert.ErrorIs(t, Histogram{Bounds: []float64{1}, Counts: []uint64{0}}.Validate(), ErrBadHistogram)
+	assert.ErrorIs(t, Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 3}.Validate(), ErrBadHistogram)
+}
+
+func TestParseBounds(t *testing.T) {
+	bounds, err := ParseBounds("0.1, 1,10")
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 1, 10}, bounds)
+
+	_, err = ParseBounds("1,a")
+	assert.Error(t, err)
+
+	_, err = ParseBounds("10,1")
+	assert.Error(t, err)
+}
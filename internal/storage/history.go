@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+// ErrBadRange некорректный запрос истории значений
+var ErrBadRange = errors.New("bad range query")
+
+// ErrBadTiers некорректные уровни хранения истории
+var ErrBadTiers = errors.New("bad history tiers")
+
+// Sample значение серии в момент времени
+type Sample struct {
+	Timestamp int64   `json:"timestamp"` // unix время в миллисекундах
+	Value     float64 `json:"value"`
+}
+
+// Rollup агрегат значений серии за интервал [Timestamp-разрешение, Timestamp)
+type Rollup struct {
+	Timestamp int64   `json:"timestamp"` // конец интервала, unix время в миллисекундах
+	Count     int64   `json:"count"`     // количество значений
+	Sum       float64 `json:"sum"`       // сумма значений
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Last      float64 `json:"last"` // последнее значение
+}
+
+// Tier уровень хранения истории: разрешение (0 - исходные значения) и срок хранения
+type Tier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// RangeQuery запрос истории значений серии
+type RangeQuery struct {
+	MType string        // gauge или counter
+	Name  string        // ключ серии
+	From  time.Time     // начало периода
+	To    time.Time     // конец периода
+	Step  time.Duration // шаг, 0 - все значения выбранного уровня
+	Agg   string        // агрегат значений за шаг: last (по умолчанию), avg, min, max, sum или count
+}
+
+// historyKey серия в истории значений: тип метрики и ключ серии
+type historyKey struct {
+	mType string
+	key   string
+}
+
+// rollupKey агрегаты серии с заданным разрешением
+type rollupKey struct {
+	historyKey
+	resolution time.Duration
+}
+
+// ring кольцевой буфер последних значений серии.
+// После заполнения новые значения записываются поверх самых старых
+type ring struct {
+	samples []Sample
+	next    int // позиция самого старого значения (и следующей записи) в заполненном буфере
+}
+
+func newRing(size int) *ring {
+	return &ring{samples: make([]Sample, 0, size)}
+}
+
+// add добавление значения в буфер
+func (r *ring) add(s Sample) {
+	if len(r.samples) < cap(r.samples) {
+		r.samples = append(r.samples, s)
+		return
+	}
+
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+}
+
+// between значения с from по to включительно в порядке добавления
+func (r *ring) between(from, to time.Time) []Sample {
+	res := make([]Sample, 0)
+
+	for _, part := range [][]Sample{r.samples[r.next:], r.samples[:r.next]} {
+		for _, s := range part {
+			if s.Timestamp >= from.UnixMilli() && s.Timestamp <= to.UnixMilli() {
+				res = append(res, s)
+			}
+		}
+	}
+
+	return res
+}
+
+// dropBefore удаление значений старше before
+func (r *ring) dropBefore(before time.Time) {
+	keep := r.between(before, time.UnixMilli(math.MaxInt64))
+
+	r.samples = r.samples[:0]
+	r.next = 0
+	for _, s := range keep {
+		r.add(s)
+	}
+}
+
+// sortSamples копия значений в порядке времени
+func sortSamples(samples []Sample) []Sample {
+	sorted := append([]Sample(nil), samples...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	return sorted
+}
+
+// ParseTiers разбор уровней хранения истории из строки вида "raw:24h,1m:30d,1h:365d".
+// Первый уровень - исходные значения, разрешение каждого следующего кратно разрешению предыдущего
+func ParseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+
+	for i, item := range strings.Split(s, ",") {
+		res, ret, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: bad tier %q", ErrBadTiers, item)
+		}
+
+		var (
+			tier Tier
+			err  error
+		)
+
+		if res != "raw" {
+			tier.Resolution, err = ParseLongDuration(res)
+			if err != nil || tier.Resolution < time.Millisecond {
+				return nil, fmt.Errorf("%w: bad resolution %q", ErrBadTiers, res)
+			}
+		}
+
+		tier.Retention, err = ParseLongDuration(ret)
+		if err != nil || tier.Retention <= 0 {
+			return nil, fmt.Errorf("%w: bad retention %q", ErrBadTiers, ret)
+		}
+
+		switch {
+		case i == 0 && tier.Resolution != 0:
+			return nil, fmt.Errorf("%w: first tier must be raw", ErrBadTiers)
+		case i > 0 && tier.Resolution == 0:
+			return nil, fmt.Errorf("%w: only first tier can be raw", ErrBadTiers)
+		case i > 1 && (tier.Resolution <= tiers[i-1].Resolution || tier.Resolution%tiers[i-1].Resolution != 0):
+			return nil, fmt.Errorf("%w: resolution %s is not a multiple of %s", ErrBadTiers, tier.Resolution, tiers[i-1].Resolution)
+		}
+
+		tiers = append(tiers, tier)
+	}
+
+	return tiers, nil
+}
+
+// ParseLongDuration длительность в формате time.ParseDuration, дополнительно - в днях (30d)
+func ParseLongDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		d, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(d * float64(24*time.Hour)), nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// IsAgg проверка на допустимый агрегат значений
+func IsAgg(agg string) bool {
+	switch agg {
+	case constants.AggLast, constants.AggAvg, constants.AggMin, constants.AggMax, constants.AggSum, constants.AggCount:
+		return true
+	}
+
+	return false
+}
+
+// Merge добавление агрегата более позднего интервала
+func (r *Rollup) Merge(o Rollup) {
+	if r.Count == 0 {
+		*r = o
+		return
+	}
+
+	r.Timestamp = o.Timestamp
+	r.Count += o.Count
+	r.Sum += o.Sum
+	r.Min = math.Min(r.Min, o.Min)
+	r.Max = math.Max(r.Max, o.Max)
+	r.Last = o.Last
+}
+
+// Value значение агрегата agg
+func (r Rollup) Value(agg string) float64 {
+	switch agg {
+	case constants.AggAvg:
+		return r.Sum / float64(r.Count)
+	case constants.AggMin:
+		return r.Min
+	case constants.AggMax:
+		return r.Max
+	case constants.AggSum:
+		return r.Sum
+	case constants.AggCount:
+		return float64(r.Count)
+	}
+
+	return r.Last
+}
+
+// SamplesToRollups исходные значения в виде агрегатов из одного значения
+func SamplesToRollups(samples []Sample) []Rollup {
+	rollups := make([]Rollup, 0, len(samples))
+	for _, s := range samples {
+		rollups = append(rollups, Rollup{Timestamp: s.Timestamp, Count: 1, Sum: s.Value, Min: s.Value, Max: s.Value, Last: s.Value})
+	}
+
+	return rollups
+}
+
+// AggregateSamples объединение упорядоченных по времени исходных значений в интервалы [end-resolution, end),
+// отсчитываемые от начала unix времени. Значение на границе относится к следующему интервалу
+func AggregateSamples(samples []Sample, resolution time.Duration) []Rollup {
+	res := make([]Rollup, 0)
+	step := resolution.Milliseconds()
+
+	for _, r := range SamplesToRollups(samples) {
+		end := r.Timestamp/step*step + step
+
+		if len(res) == 0 || res[len(res)-1].Timestamp != end {
+			res = append(res, Rollup{})
+		}
+
+		res[len(res)-1].Merge(r)
+		res[len(res)-1].Timestamp = end
+	}
+
+	return res
+}
+
+// Aggregate объединение упорядоченных по времени агрегатов в интервалы длиной resolution,
+// отсчитываемые от начала unix времени. Агрегат с концом интервала на границе относится к интервалу, который она завершает
+func Aggregate(src []Rollup, resolution time.Duration) []Rollup {
+	res := make([]Rollup, 0)
+	step := resolution.Milliseconds()
+
+	for _, r := range src {
+		end := (r.Timestamp + step - 1) / step * step
+
+		if len(res) == 0 || res[len(res)-1].Timestamp != end {
+			res = append(res, Rollup{})
+		}
+
+		res[len(res)-1].Merge(r)
+		res[len(res)-1].Timestamp = end
+	}
+
+	return res
+}
+
+// Downsample значения агрегата agg с шагом step: для каждой точки from, from+step, ... (не позже to)
+// объединяются агрегаты за предшествующий ей шаг (t-step, t]. Точки без значений пропускаются.
+// Агрегаты должны быть упорядочены по времени, при шаге меньше миллисекунды возвращаются все
+func Downsample(src []Rollup, from, to time.Time, step time.Duration, agg string) []Sample {
+	res := make([]Sample, 0)
+
+	if step < time.Millisecond {
+		for _, r := range src {
+			res = append(res, Sample{Timestamp: r.Timestamp, Value: r.Value(agg)})
+		}
+
+		return res
+	}
+
+	i := 0
+	for t := from.UnixMilli(); t <= to.UnixMilli(); t += step.Milliseconds() {
+		var window Rollup
+
+		for i < len(src) && src[i].Timestamp <= t {
+			if src[i].Timestamp > t-step.Milliseconds() {
+				window.Merge(src[i])
+			}
+			i++
+		}
+
+		if window.Count > 0 {
+			res = append(res, Sample{Timestamp: t, Value: window.Value(agg)})
+		}
+	}
+
+	return res
+}
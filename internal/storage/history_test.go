@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+	for i := int64(1); i <= 5; i++ {
+		r.add(Sample{Timestamp: i * 1000, Value: float64(i)})
+	}
+
+	// в буфере остаются три последних значения в порядке добавления
+	assert.Equal(t, []Sample{{3000, 3}, {4000, 4}, {5000, 5}}, r.between(time.UnixMilli(0), time.UnixMilli(10000)))
+	assert.Equal(t, []Sample{{4000, 4}}, r.between(time.UnixMilli(3500), time.UnixMilli(4000)))
+	assert.Empty(t, r.between(time.UnixMilli(6000), time.UnixMilli(7000)))
+}
+
+func TestDownsample(t *testing.T) {
+	samples := SamplesToRollups([]Sample{{1000, 1}, {2500, 2}, {2900, 3}, {7000, 4}})
+	from, to := time.UnixMilli(1000), time.UnixMilli(8000)
+
+	assert.Equal(t, []Sample{{1000, 1}, {2500, 2}, {2900, 3}, {7000, 4}}, Downsample(samples, from, to, 0, constants.AggLast))
+
+	// последнее значение за шаг перед точкой, точки без значений пропускаются
+	assert.Equal(t, []Sample{{1000, 1}, {3000, 3}, {7000, 4}}, Downsample(samples, from, to, time.Second, constants.AggLast))
+	assert.Equal(t, []Sample{{1000, 1}, {4000, 3}, {7000, 4}}, Downsample(samples, from, to, 3*time.Second, constants.AggLast))
+
+	// агрегаты за шаг
+	assert.Equal(t, []Sample{{1000, 1}, {4000, 2.5}, {7000, 4}}, Downsample(samples, from, to, 3*time.Second, constants.AggAvg))
+	assert.Equal(t, []Sample{{1000, 1}, {4000, 2}, {7000, 4}}, Downsample(samples, from, to, 3*time.Second, constants.AggMin))
+	assert.Equal(t, []Sample{{1000, 1}, {4000, 2}, {7000, 1}}, Downsample(samples, from, to, 3*time.Second, constants.AggCount))
+}
+
+func TestAggregate(t *testing.T) {
+	samples := SamplesToRollups([]Sample{{1000, 1}, {60000, 5}, {60001, 2}, {90000, 4}, {130000, 3}})
+
+	rollups := Aggregate(samples, time.Minute)
+	assert.Equal(t, []Rollup{
+		{Timestamp: 60000, Count: 2, Sum: 6, Min: 1, Max: 5, Last: 5},
+		{Timestamp: 120000, Count: 2, Sum: 6, Min: 2, Max: 4, Last: 4},
+		{Timestamp: 180000, Count: 1, Sum: 3, Min: 3, Max: 3, Last: 3},
+	}, rollups)
+
+	// агрегаты следующего уровня из агрегатов предыдущего
+	assert.Equal(t, []Rollup{
+		{Timestamp: 180000, Count: 5, Sum: 15, Min: 1, Max: 5, Last: 3},
+	}, Aggregate(rollups, 3*time.Minute))
+
+	// исходные значения: интервалы [end-resolution, end)
+	assert.Equal(t, []Rollup{
+		{Timestamp: 60000, Count: 1, Sum: 1, Min: 1, Max: 1, Last: 1},
+		{Timestamp: 120000, Count: 3, Sum: 11, Min: 2, Max: 5, Last: 4},
+		{Timestamp: 180000, Count: 1, Sum: 3, Min: 3, Max: 3, Last: 3},
+	}, AggregateSamples([]Sample{{1000, 1}, {60000, 5}, {60001, 2}, {90000, 4}, {130000, 3}}, time.Minute))
+
+	assert.Equal(t, 3.0, rollups[1].Value(constants.AggAvg))
+	assert.Equal(t, 4.0, rollups[1].Value(constants.AggMax))
+	assert.Equal(t, 6.0, rollups[1].Value(constants.AggSum))
+	assert.True(t, IsAgg(constants.AggMax))
+	assert.False(t, IsAgg("median"))
+}
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("raw:24h, 1m:30d,1h:365d")
+	require.NoError(t, err)
+	assert.Equal(t, []Tier{
+		{Resolution: 0, Retention: 24 * time.Hour},
+		{Resolution: time.Minute, Retention: 30 * 24 * time.Hour},
+		{Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+	}, tiers)
+
+	for _, bad := range []string{"", "1m:30d", "raw:24h,raw:48h", "raw:24h,1m:1d,90s:2d", "raw:24h,1m:1d,30s:2d", "raw:forever", "raw:24h,1x:1d", "raw:-1h"} {
+		_, err = ParseTiers(bad)
+		assert.ErrorIs(t, err, ErrBadTiers, bad)
+	}
+}
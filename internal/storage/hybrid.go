@@ -0,0 +1,1051 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+	"github.com/dnsoftware/go-metrics/internal/logger"
+)
+
+// ErrDatabaseDown БД гибридного хранилища недоступна, обновления копятся в памяти
+var ErrDatabaseDown = errors.New("database is unavailable")
+
+// HybridBackend БД, в которую гибридное хранилище записывает обновления (PgStorage)
+type HybridBackend interface {
+	CreateDatabaseTables(ctx context.Context) error
+	SetBatch(ctx context.Context, batch []byte) error
+	SetGaugeSamples(ctx context.Context, series map[string][]Sample) error
+	SetCounter(ctx context.Context, name string, value int64) error
+	SetHistogram(ctx context.Context, name string, value Histogram) error
+	SetSummary(ctx context.Context, name string, value Summary) error
+	AddRollups(ctx context.Context, resolution time.Duration, mType string, name string, rollups []Rollup) error
+	PruneHistory(ctx context.Context, resolution time.Duration, before time.Time) error
+	DeleteSeries(ctx context.Context, mType string, name string, updatedBefore time.Time) (bool, error)
+	QueryRange(ctx context.Context, mType string, name string, from, to time.Time) ([]Sample, error)
+	QueryRollups(ctx context.Context, resolution time.Duration, mType string, name string, from, to time.Time) ([]Rollup, error)
+	WriteDump(ctx context.Context, w io.Writer) error
+	RestoreFromDump(ctx context.Context, r io.Reader) error
+	DatabasePing(ctx context.Context) bool
+}
+
+// Health состояние хранилища
+type Health struct {
+	Status  string     `json:"status"`            // constants.HealthOK, HealthDegraded или HealthUnavailable
+	Pending int        `json:"pending,omitempty"` // кол-во серий, обновления которых еще не записаны в БД
+	Since   *time.Time `json:"since,omitempty"`   // начало работы без БД
+	Error   string     `json:"error,omitempty"`   // последняя ошибка записи в БД
+}
+
+// HybridStorage хранилище в памяти с отложенной записью в БД.
+// Чтение текущих значений обслуживает память, история читается из БД, пока она доступна. Обновления применяются к памяти и ставятся в очередь на запись в БД,
+// фоновая запись раз в HybridFlushInterval отправляет очередь в БД.
+// Пока БД недоступна, обновления копятся в очереди (серия занимает одну запись независимо от кол-ва обновлений),
+// доступность БД проверяется раз в HybridRetryInterval, после восстановления очередь записывается в БД
+type HybridStorage struct {
+	*MemStorage
+
+	db HybridBackend
+
+	// обновления памяти с постановкой в очередь идут под RLock, снятие очереди и синхронизация с БД - под Lock
+	sync sync.RWMutex
+
+	flush sync.Mutex // записи очереди в БД не выполняются параллельно
+
+	mutex    sync.Mutex // защищает поля ниже
+	dirty    *hybridDirty
+	fullSync bool      // содержимое БД нужно заменить содержимым памяти (после восстановления памяти из дампа)
+	loaded   bool      // память содержит данные БД
+	healthy  bool      // БД доступна
+	since    time.Time // начало работы без БД
+	lastTry  time.Time // последняя проверка доступности БД
+	lastErr  error
+
+	flushInterval time.Duration // 0 - без фоновой записи
+	retryInterval time.Duration
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// hybridDirty очередь обновлений, еще не записанных в БД
+type hybridDirty struct {
+	gauges     map[string]float64          // значения берутся из памяти при снятии очереди
+	samples    map[string][]Sample         // значения gauge с заданным временем
+	counters   map[string]int64            // сумма приращений
+	counterSet map[string]int64            // счетчики, записываемые итоговым значением из памяти при снятии очереди
+	histograms map[string]Histogram        // сумма прибавленных гистограмм
+	histSet    map[string]Histogram        // гистограммы, записываемые итоговым значением из памяти при снятии очереди
+	summaries  map[string]Summary          // сумма прибавленных скетчей
+	summarySet map[string]Summary          // скетчи, записываемые итоговым значением из памяти при снятии очереди
+	deletes    map[historyKey]struct{}     // удаленные серии
+	rollups    map[rollupKey][]Rollup      // агрегаты истории
+	prunes     map[time.Duration]time.Time // удаление истории старше момента
+}
+
+func newHybridDirty() *hybridDirty {
+	return &hybridDirty{
+		gauges:     make(map[string]float64),
+		samples:    make(map[string][]Sample),
+		counters:   make(map[string]int64),
+		counterSet: make(map[string]int64),
+		histograms: make(map[string]Histogram),
+		histSet:    make(map[string]Histogram),
+		summaries:  make(map[string]Summary),
+		summarySet: make(map[string]Summary),
+		deletes:    make(map[historyKey]struct{}),
+		rollups:    make(map[rollupKey][]Rollup),
+		prunes:     make(map[time.Duration]time.Time),
+	}
+}
+
+// len кол-во записей очереди
+func (d *hybridDirty) len() int {
+	return len(d.gauges) + len(d.samples) + len(d.counters) + len(d.counterSet) + len(d.histograms) + len(d.histSet) +
+		len(d.summaries) + len(d.summarySet) + len(d.deletes) + len(d.rollups) + len(d.prunes)
+}
+
+// addCounter приращение счетчика, для счетчика с итоговым значением не нужно
+func (d *hybridDirty) addCounter(name string, delta int64) {
+	if _, ok := d.counterSet[name]; !ok {
+		d.counters[name] += delta
+	}
+}
+
+// setCounter итоговое значение счетчика заменяет накопленные приращения
+func (d *hybridDirty) setCounter(name string) {
+	d.counterSet[name] = 0
+	delete(d.counters, name)
+}
+
+// setHistogram итоговое значение гистограммы заменяет накопленные прибавления
+func (d *hybridDirty) setHistogram(name string) {
+	d.histSet[name] = Histogram{}
+	delete(d.histograms, name)
+}
+
+// setSummary итоговое значение скетча заменяет накопленные прибавления
+func (d *hybridDirty) setSummary(name string) {
+	d.summarySet[name] = Summary{}
+	delete(d.summaries, name)
+}
+
+// addHistogram прибавление гистограммы, для гистограммы с итоговым значением не нужно
+func (d *hybridDirty) addHistogram(mt Metrics) {
+	if _, ok := d.histSet[mt.Key()]; ok {
+		return
+	}
+
+	h, ok := d.histograms[mt.Key()]
+	h, err := mergeHistogram(h, ok, mt)
+	if err != nil {
+		// память приняла гистограмму, ошибка слияния в очереди только пишется в лог
+		logger.Log().Error("hybrid storage: " + err.Error())
+		return
+	}
+	d.histograms[mt.Key()] = h
+}
+
+// addSummary прибавление скетча, для скетча с итоговым значением не нужно
+func (d *hybridDirty) addSummary(mt Metrics) {
+	if _, ok := d.summarySet[mt.Key()]; ok {
+		return
+	}
+
+	sm, ok := d.summaries[mt.Key()]
+	sm, err := mergeSummary(sm, ok, mt)
+	if err != nil {
+		logger.Log().Error("hybrid storage: " + err.Error())
+		return
+	}
+	d.summaries[mt.Key()] = sm
+}
+
+// deleteSeries удаление серии отменяет ее обновления в очереди
+func (d *hybridDirty) deleteSeries(mType string, name string) {
+	switch mType {
+	case constants.Gauge:
+		delete(d.gauges, name)
+		delete(d.samples, name)
+	case constants.Counter:
+		delete(d.counters, name)
+		delete(d.counterSet, name)
+	case constants.Histogram:
+		delete(d.histograms, name)
+		delete(d.histSet, name)
+	case constants.Summary:
+		delete(d.summaries, name)
+		delete(d.summarySet, name)
+	}
+
+	for rk := range d.rollups {
+		if rk.mType == mType && rk.key == name {
+			delete(d.rollups, rk)
+		}
+	}
+
+	d.deletes[historyKey{mType: mType, key: name}] = struct{}{}
+}
+
+// prune удаление истории отменяет более старые агрегаты в очереди
+func (d *hybridDirty) prune(resolution time.Duration, before time.Time) {
+	if before.After(d.prunes[resolution]) {
+		d.prunes[resolution] = before
+	}
+
+	if resolution == 0 {
+		return
+	}
+
+	for rk, rollups := range d.rollups {
+		if rk.resolution != resolution {
+			continue
+		}
+
+		kept := rollups[:0]
+		for _, r := range rollups {
+			if r.Timestamp >= before.UnixMilli() {
+				kept = append(kept, r)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(d.rollups, rk)
+		} else {
+			d.rollups[rk] = kept
+		}
+	}
+}
+
+// resetValues очистка значений серий, когда БД заменяется содержимым памяти целиком
+func (d *hybridDirty) resetValues() {
+	history := newHybridDirty()
+	history.rollups = d.rollups
+	history.prunes = d.prunes
+	*d = *history
+}
+
+// metrics значения серий очереди для записи пакетом
+func (d *hybridDirty) metrics() []Metrics {
+	metrics := make([]Metrics, 0, len(d.gauges)+len(d.counters)+len(d.histograms)+len(d.summaries))
+
+	for _, key := range seriesKeys(d.gauges) {
+		name, labels := SplitSeriesKey(key)
+		value := d.gauges[key]
+		metrics = append(metrics, Metrics{ID: name, MType: constants.Gauge, Labels: labels, Value: &value})
+	}
+
+	for _, key := range seriesKeys(d.counters) {
+		name, labels := SplitSeriesKey(key)
+		delta := d.counters[key]
+		metrics = append(metrics, Metrics{ID: name, MType: constants.Counter, Labels: labels, Delta: &delta})
+	}
+
+	for _, key := range seriesKeys(d.histograms) {
+		name, labels := SplitSeriesKey(key)
+		h := d.histograms[key]
+		metrics = append(metrics, Metrics{ID: name, MType: constants.Histogram, Labels: labels, Histogram: &h})
+	}
+
+	for _, key := range seriesKeys(d.summaries) {
+		name, labels := SplitSeriesKey(key)
+		sm := d.summaries[key]
+		metrics = append(metrics, Metrics{ID: name, MType: constants.Summary, Labels: labels, Summary: &sm})
+	}
+
+	return metrics
+}
+
+// remove удаление записанных серий из очереди
+func (d *hybridDirty) remove(metrics []Metrics) {
+	for _, mt := range metrics {
+		switch mt.MType {
+		case constants.Gauge:
+			delete(d.gauges, mt.Key())
+		case constants.Counter:
+			delete(d.counters, mt.Key())
+		case constants.Histogram:
+			delete(d.histograms, mt.Key())
+		case constants.Summary:
+			delete(d.summaries, mt.Key())
+		}
+	}
+}
+
+// NewHybridStorage гибридное хранилище поверх памяти mem и БД db.
+// Если БД доступна, память загружается из нее, иначе хранилище начинает работу без БД
+func NewHybridStorage(mem *MemStorage, db HybridBackend) *HybridStorage {
+	h := newHybridStorage(mem, db, constants.HybridFlushInterval, constants.HybridRetryInterval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
+	defer cancel()
+
+	err := h.Flush(ctx)
+	if err != nil {
+		logger.Log().Error("hybrid storage: starting without database: " + err.Error())
+	}
+
+	return h
+}
+
+// newHybridStorage гибридное хранилище без начальной синхронизации, при flushInterval 0 - без фоновой записи
+func newHybridStorage(mem *MemStorage, db HybridBackend, flushInterval, retryInterval time.Duration) *HybridStorage {
+	h := &HybridStorage{
+		MemStorage:    mem,
+		db:            db,
+		dirty:         newHybridDirty(),
+		since:         time.Now(),
+		flushInterval: flushInterval,
+		retryInterval: retryInterval,
+		done:          make(chan struct{}),
+	}
+
+	if flushInterval > 0 {
+		h.wg.Add(1)
+		go h.run()
+	}
+
+	return h
+}
+
+// run фоновая запись очереди в БД
+func (h *HybridStorage) run() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(h.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			// смена состояния пишется в лог при записи
+			ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
+			_ = h.Flush(ctx)
+			cancel()
+		}
+	}
+}
+
+// Close остановка фоновой записи и запись очереди в БД, если она доступна
+func (h *HybridStorage) Close() error {
+	select {
+	case <-h.done:
+		return nil
+	default:
+		close(h.done)
+	}
+	h.wg.Wait()
+
+	h.mutex.Lock()
+	h.lastTry = time.Time{}
+	h.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
+	defer cancel()
+
+	err := h.Flush(ctx)
+	if err != nil {
+		pending := h.Health(ctx).Pending
+		return fmt.Errorf("hybrid storage: %d pending series are not written: %w", pending, err)
+	}
+
+	return nil
+}
+
+// update обновление памяти и постановка его в очередь на запись в БД
+func (h *HybridStorage) update(apply func() error, mark func(d *hybridDirty)) error {
+	h.sync.RLock()
+	defer h.sync.RUnlock()
+
+	err := apply()
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	mark(h.dirty)
+	h.mutex.Unlock()
+
+	return nil
+}
+
+func (h *HybridStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	return h.update(func() error {
+		return h.MemStorage.SetGauge(ctx, name, value)
+	}, func(d *hybridDirty) {
+		d.gauges[name] = 0
+	})
+}
+
+func (h *HybridStorage) SetGaugeSamples(ctx context.Context, series map[string][]Sample) error {
+	return h.update(func() error {
+		return h.MemStorage.SetGaugeSamples(ctx, series)
+	}, func(d *hybridDirty) {
+		for name, samples := range series {
+			d.samples[name] = append(d.samples[name], samples...)
+		}
+	})
+}
+
+func (h *HybridStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	return h.update(func() error {
+		return h.MemStorage.SetCounter(ctx, name, value)
+	}, func(d *hybridDirty) {
+		d.setCounter(name)
+	})
+}
+
+func (h *HybridStorage) IncrementCounter(ctx context.Context, name string, delta int64) error {
+	return h.update(func() error {
+		return h.MemStorage.IncrementCounter(ctx, name, delta)
+	}, func(d *hybridDirty) {
+		d.addCounter(name, delta)
+	})
+}
+
+func (h *HybridStorage) IncrementCounters(ctx context.Context, deltas map[string]int64) error {
+	return h.update(func() error {
+		return h.MemStorage.IncrementCounters(ctx, deltas)
+	}, func(d *hybridDirty) {
+		for name, delta := range deltas {
+			d.addCounter(name, delta)
+		}
+	})
+}
+
+// SetBatch сохранение пакета в память, серии пакета ставятся в очередь
+func (h *HybridStorage) SetBatch(ctx context.Context, batch []byte) error {
+	var metrics []Metrics
+
+	return h.update(func() error {
+		err := h.MemStorage.SetBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		// пакет уже разобран памятью без ошибок
+		return json.Unmarshal(batch, &metrics)
+	}, func(d *hybridDirty) {
+		for _, mt := range metrics {
+			switch mt.MType {
+			case constants.Gauge:
+				d.gauges[mt.Key()] = 0
+			case constants.Counter:
+				d.addCounter(mt.Key(), *mt.Delta)
+			case constants.Histogram:
+				d.addHistogram(mt)
+			case constants.Summary:
+				d.addSummary(mt)
+			}
+		}
+	})
+}
+
+func (h *HybridStorage) AddHistogram(ctx context.Context, name string, value Histogram) error {
+	return h.update(func() error {
+		return h.MemStorage.AddHistogram(ctx, name, value)
+	}, func(d *hybridDirty) {
+		id, labels := SplitSeriesKey(name)
+		d.addHistogram(Metrics{ID: id, MType: constants.Histogram, Labels: labels, Histogram: &value})
+	})
+}
+
+func (h *HybridStorage) AddSummary(ctx context.Context, name string, value Summary) error {
+	return h.update(func() error {
+		return h.MemStorage.AddSummary(ctx, name, value)
+	}, func(d *hybridDirty) {
+		id, labels := SplitSeriesKey(name)
+		d.addSummary(Metrics{ID: id, MType: constants.Summary, Labels: labels, Summary: &value})
+	})
+}
+
+func (h *HybridStorage) AddRollups(ctx context.Context, resolution time.Duration, mType string, name string, rollups []Rollup) error {
+	return h.update(func() error {
+		return h.MemStorage.AddRollups(ctx, resolution, mType, name, rollups)
+	}, func(d *hybridDirty) {
+		rk := rollupKey{historyKey: historyKey{mType: mType, key: name}, resolution: resolution}
+		d.rollups[rk] = append(d.rollups[rk], rollups...)
+	})
+}
+
+func (h *HybridStorage) PruneHistory(ctx context.Context, resolution time.Duration, before time.Time) error {
+	return h.update(func() error {
+		return h.MemStorage.PruneHistory(ctx, resolution, before)
+	}, func(d *hybridDirty) {
+		d.prune(resolution, before)
+	})
+}
+
+func (h *HybridStorage) DeleteSeries(ctx context.Context, mType string, name string, updatedBefore time.Time) (bool, error) {
+	var deleted bool
+
+	err := h.update(func() error {
+		var err error
+		deleted, err = h.MemStorage.DeleteSeries(ctx, mType, name, updatedBefore)
+		return err
+	}, func(d *hybridDirty) {
+		if deleted {
+			d.deleteSeries(mType, name)
+		}
+	})
+
+	return deleted, err
+}
+
+// RestoreFromDump восстановление памяти из дампа, БД заменяется содержимым памяти при следующей записи
+func (h *HybridStorage) RestoreFromDump(ctx context.Context, r io.Reader) error {
+	h.sync.Lock()
+	defer h.sync.Unlock()
+
+	// память могла измениться и при ошибке
+	defer func() {
+		h.mutex.Lock()
+		h.fullSync = true
+		h.dirty.resetValues()
+		h.mutex.Unlock()
+	}()
+
+	return h.MemStorage.RestoreFromDump(ctx, r)
+}
+
+// QueryRange история значений серии из БД, пока она доступна, дополненная значениями из памяти,
+// еще не записанными в БД. Без БД или при ошибке чтения из нее - история из памяти
+func (h *HybridStorage) QueryRange(ctx context.Context, mType string, name string, from, to time.Time) ([]Sample, error) {
+	mem, err := h.MemStorage.QueryRange(ctx, mType, name, from, to)
+	if err != nil || !h.readsDB() {
+		return mem, err
+	}
+
+	samples, err := h.db.QueryRange(ctx, mType, name, from, to)
+	if err != nil {
+		logger.Log().Error("hybrid storage: history is read from memory: " + err.Error())
+		return mem, nil
+	}
+
+	last := int64(math.MinInt64)
+	if len(samples) > 0 {
+		last = samples[len(samples)-1].Timestamp
+	}
+
+	for _, sm := range mem {
+		if sm.Timestamp > last {
+			samples = append(samples, sm)
+		}
+	}
+
+	return samples, nil
+}
+
+// QueryRollups агрегаты истории серии из БД, пока она доступна, дополненные агрегатами из памяти,
+// еще не записанными в БД. Без БД или при ошибке чтения из нее - агрегаты из памяти
+func (h *HybridStorage) QueryRollups(ctx context.Context, resolution time.Duration, mType string, name string, from, to time.Time) ([]Rollup, error) {
+	mem, err := h.MemStorage.QueryRollups(ctx, resolution, mType, name, from, to)
+	if err != nil || !h.readsDB() {
+		return mem, err
+	}
+
+	rollups, err := h.db.QueryRollups(ctx, resolution, mType, name, from, to)
+	if err != nil {
+		logger.Log().Error("hybrid storage: rollups are read from memory: " + err.Error())
+		return mem, nil
+	}
+
+	last := int64(math.MinInt64)
+	if len(rollups) > 0 {
+		last = rollups[len(rollups)-1].Timestamp
+	}
+
+	for _, r := range mem {
+		if r.Timestamp > last {
+			rollups = append(rollups, r)
+		}
+	}
+
+	return rollups, nil
+}
+
+// readsDB читается ли история из БД: БД доступна и ее содержимое не заменяется памятью
+func (h *HybridStorage) readsDB() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.healthy && !h.fullSync
+}
+
+// DatabasePing проверка доступности БД
+func (h *HybridStorage) DatabasePing(ctx context.Context) bool {
+	return h.db.DatabasePing(ctx)
+}
+
+// Health состояние хранилища: режим работы и размер очереди
+func (h *HybridStorage) Health(ctx context.Context) Health {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	health := Health{Status: constants.HealthOK, Pending: h.dirty.len()}
+	if !h.healthy {
+		since := h.since
+		health.Status = constants.HealthDegraded
+		health.Since = &since
+	}
+
+	if h.lastErr != nil {
+		health.Error = h.lastErr.Error()
+	}
+
+	return health
+}
+
+// Flush запись очереди в БД. Без БД - проверка ее доступности не чаще HybridRetryInterval
+// и при восстановлении синхронизация с ней
+func (h *HybridStorage) Flush(ctx context.Context) error {
+	h.flush.Lock()
+	defer h.flush.Unlock()
+
+	h.mutex.Lock()
+	healthy := h.healthy
+	wait := !h.lastTry.IsZero() && time.Since(h.lastTry) < h.retryInterval
+	h.mutex.Unlock()
+
+	if !healthy {
+		if wait {
+			return ErrDatabaseDown
+		}
+		return h.recover(ctx)
+	}
+
+	h.sync.Lock()
+	d := h.takeDirty(ctx)
+	h.sync.Unlock()
+
+	err := h.write(ctx, d)
+	if err != nil {
+		h.degrade(err)
+		return err
+	}
+
+	h.mutex.Lock()
+	h.lastErr = nil
+	h.mutex.Unlock()
+
+	return nil
+}
+
+// recover синхронизация с БД после ее восстановления или при запуске и возврат в режим с БД
+func (h *HybridStorage) recover(ctx context.Context) error {
+	h.mutex.Lock()
+	h.lastTry = time.Now()
+	h.mutex.Unlock()
+
+	if !h.db.DatabasePing(ctx) {
+		h.degrade(ErrDatabaseDown)
+		return ErrDatabaseDown
+	}
+
+	// обновления памяти приостанавливаются на время синхронизации
+	h.sync.Lock()
+	defer h.sync.Unlock()
+
+	err := h.sync2db(ctx)
+	if err != nil {
+		h.degrade(err)
+		return err
+	}
+
+	h.mutex.Lock()
+	h.loaded = true
+	h.healthy = true
+	h.lastErr = nil
+	h.mutex.Unlock()
+
+	logger.Log().Info("hybrid storage: database is available")
+
+	return nil
+}
+
+// sync2db синхронизация памяти и БД, вызывается под блокировкой h.sync
+func (h *HybridStorage) sync2db(ctx context.Context) error {
+	err := h.db.CreateDatabaseTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	fullSync, loaded := h.fullSync, h.loaded
+	h.mutex.Unlock()
+
+	// память восстановлена из дампа: она заменяет БД целиком вместе с очередью значений
+	if fullSync {
+		err = pipeDump(ctx, h.MemStorage.WriteDump, h.db.RestoreFromDump)
+		if err != nil {
+			return err
+		}
+
+		h.mutex.Lock()
+		h.fullSync = false
+		h.dirty.resetValues()
+		h.mutex.Unlock()
+
+		loaded = true
+	}
+
+	err = h.write(ctx, h.takeDirty(ctx))
+	if err != nil {
+		return err
+	}
+
+	// память начала работу без БД: обновления очереди уже в БД, итоговые значения берутся из нее
+	if !loaded {
+		err = pipeDump(ctx, h.db.WriteDump, h.MemStorage.RestoreFromDump)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pipeDump передача дампа из write в read по мере записи, без сборки всего дампа в памяти.
+// Дамп из канала не перечитывается, поэтому транзакция восстановления в БД при ошибке не повторяется
+func pipeDump(ctx context.Context, write func(ctx context.Context, w io.Writer) error, read func(ctx context.Context, r io.Reader) error) error {
+	pr, pw := io.Pipe()
+
+	written := make(chan error, 1)
+	go func() {
+		err := write(ctx, pw)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	err := read(ctx, pr)
+
+	// чтение могло закончиться раньше записи, запись прерывается
+	pr.Close()
+	errW := <-written
+
+	if errW != nil && !errors.Is(errW, io.ErrClosedPipe) {
+		return errW
+	}
+
+	return err
+}
+
+// degrade переход в режим без БД
+func (h *HybridStorage) degrade(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastErr = err
+	h.lastTry = time.Now()
+
+	if h.healthy {
+		h.healthy = false
+		h.since = time.Now()
+		logger.Log().Error("hybrid storage: database is unavailable, updates are kept in memory: " + err.Error())
+	}
+}
+
+// takeDirty снятие очереди с итоговыми значениями из памяти, вызывается под блокировкой h.sync
+func (h *HybridStorage) takeDirty(ctx context.Context) *hybridDirty {
+	h.mutex.Lock()
+	d := h.dirty
+	h.dirty = newHybridDirty()
+	h.mutex.Unlock()
+
+	for key := range d.gauges {
+		value, err := h.MemStorage.GetGauge(ctx, key)
+		if err != nil {
+			delete(d.gauges, key)
+			continue
+		}
+		d.gauges[key] = value
+	}
+
+	for key := range d.counterSet {
+		value, err := h.MemStorage.GetCounter(ctx, key)
+		if err != nil {
+			delete(d.counterSet, key)
+			continue
+		}
+		d.counterSet[key] = value
+	}
+
+	for key := range d.histSet {
+		value, err := h.MemStorage.GetHistogram(ctx, key)
+		if err != nil {
+			delete(d.histSet, key)
+			continue
+		}
+		d.histSet[key] = value
+	}
+
+	for key := range d.summarySet {
+		value, err := h.MemStorage.GetSummary(ctx, key)
+		if err != nil {
+			delete(d.summarySet, key)
+			continue
+		}
+		d.summarySet[key] = value
+	}
+
+	return d
+}
+
+// write запись снятой очереди в БД. Если БД отвергла запись, но доступна, серии пишутся по одной,
+// отвергнутые пропускаются с записью в лог. Незаписанное при недоступной БД возвращается в очередь.
+// После ошибки фиксации транзакции неизвестно, применились ли приращения, поэтому запись не повторяется,
+// а счетчики, гистограммы и скетчи возвращаются в очередь для записи итоговым значением из памяти
+func (h *HybridStorage) write(ctx context.Context, d *hybridDirty) error {
+	err := h.writeDirty(ctx, d, false)
+	if err != nil && !errors.Is(err, errCommit) && h.db.DatabasePing(ctx) {
+		err = h.writeDirty(ctx, d, true)
+	}
+
+	if err != nil {
+		h.putBack(d, errors.Is(err, errCommit))
+	}
+
+	return err
+}
+
+// writeDirty запись очереди в БД: удаления, итоговые значения счетчиков, значения gauge с заданным временем, значения серий пакетом
+// (при each - по одной серии), агрегаты и удаление истории. Записанное удаляется из d
+func (h *HybridStorage) writeDirty(ctx context.Context, d *hybridDirty, each bool) error {
+	// ошибка доступной БД при записи по одной серии пропускается, кроме ошибки фиксации транзакции
+	fail := func(what string, err error) error {
+		if !each || errors.Is(err, errCommit) || !h.db.DatabasePing(ctx) {
+			return err
+		}
+		logger.Log().Error(fmt.Sprintf("hybrid storage: %s is rejected by database: %s", what, err.Error()))
+		return nil
+	}
+
+	for hk := range d.deletes {
+		_, err := h.db.DeleteSeries(ctx, hk.mType, hk.key, time.Now())
+		if err != nil {
+			if err = fail("delete "+hk.key, err); err != nil {
+				return err
+			}
+		}
+		delete(d.deletes, hk)
+	}
+
+	for name, value := range d.counterSet {
+		err := h.db.SetCounter(ctx, name, value)
+		if err != nil {
+			if err = fail(name, err); err != nil {
+				return err
+			}
+		}
+		delete(d.counterSet, name)
+	}
+
+	for name, value := range d.histSet {
+		err := h.db.SetHistogram(ctx, name, value)
+		if err != nil {
+			if err = fail(name, err); err != nil {
+				return err
+			}
+		}
+		delete(d.histSet, name)
+	}
+
+	for name, value := range d.summarySet {
+		err := h.db.SetSummary(ctx, name, value)
+		if err != nil {
+			if err = fail(name, err); err != nil {
+				return err
+			}
+		}
+		delete(d.summarySet, name)
+	}
+
+	// значения из памяти пакетом пишутся после, итоговым остается значение из памяти
+	if each {
+		for name, samples := range d.samples {
+			err := h.db.SetGaugeSamples(ctx, map[string][]Sample{name: samples})
+			if err != nil {
+				if err = fail("samples "+name, err); err != nil {
+					return err
+				}
+			}
+			delete(d.samples, name)
+		}
+	} else if len(d.samples) > 0 {
+		err := h.db.SetGaugeSamples(ctx, d.samples)
+		if err != nil {
+			return err
+		}
+		clear(d.samples)
+	}
+
+	metrics := d.metrics()
+	batches := [][]Metrics{metrics}
+	if each {
+		batches = batches[:0]
+		for i := range metrics {
+			batches = append(batches, metrics[i:i+1])
+		}
+	}
+
+	for _, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+
+		data, err := json.Marshal(batch)
+		if err == nil {
+			err = h.db.SetBatch(ctx, data)
+		}
+		if err != nil {
+			if err = fail(batch[0].Key(), err); err != nil {
+				return err
+			}
+		}
+		d.remove(batch)
+	}
+
+	for rk, rollups := range d.rollups {
+		err := h.db.AddRollups(ctx, rk.resolution, rk.mType, rk.key, rollups)
+		if err != nil {
+			if err = fail("rollups "+rk.key, err); err != nil {
+				return err
+			}
+		}
+		delete(d.rollups, rk)
+	}
+
+	for resolution, before := range d.prunes {
+		err := h.db.PruneHistory(ctx, resolution, before)
+		if err != nil {
+			if err = fail("prune "+resolution.String(), err); err != nil {
+				return err
+			}
+		}
+		delete(d.prunes, resolution)
+	}
+
+	return nil
+}
+
+// putBack возврат незаписанных обновлений old в очередь, более новые обновления очереди применяются после них.
+// Параметры: unknown - неизвестно, применились ли приращения old в БД; счетчики, гистограммы и скетчи
+// тогда записываются итоговыми значениями из памяти, как при синхронизации с БД
+func (h *HybridStorage) putBack(old *hybridDirty, unknown bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	cur := h.dirty
+	// серии, удаленные после снятия очереди, не записываются
+	deleted := func(mType string, key string) bool {
+		_, ok := cur.deletes[historyKey{mType: mType, key: key}]
+		return ok
+	}
+
+	for hk := range old.deletes {
+		cur.deletes[hk] = struct{}{}
+	}
+
+	for key := range old.gauges {
+		if !deleted(constants.Gauge, key) {
+			cur.gauges[key] = 0
+		}
+	}
+
+	// после ошибки фиксации история не повторяется, чтобы не записать значения дважды,
+	// в БД пишется только значение из памяти
+	for key, samples := range old.samples {
+		if deleted(constants.Gauge, key) {
+			continue
+		}
+		if unknown {
+			cur.gauges[key] = 0
+			continue
+		}
+		cur.samples[key] = append(samples, cur.samples[key]...)
+	}
+
+	for key := range old.counterSet {
+		if !deleted(constants.Counter, key) {
+			cur.setCounter(key)
+		}
+	}
+
+	for key, delta := range old.counters {
+		if deleted(constants.Counter, key) {
+			continue
+		}
+		if unknown {
+			cur.setCounter(key)
+			continue
+		}
+		cur.addCounter(key, delta)
+	}
+
+	for key := range old.histSet {
+		if !deleted(constants.Histogram, key) {
+			cur.setHistogram(key)
+		}
+	}
+
+	for key, hist := range old.histograms {
+		if deleted(constants.Histogram, key) {
+			continue
+		}
+		if _, ok := cur.histSet[key]; ok || unknown {
+			cur.setHistogram(key)
+			continue
+		}
+		if newer, ok := cur.histograms[key]; ok {
+			err := hist.Merge(newer)
+			if err != nil {
+				logger.Log().Error("hybrid storage: histogram " + key + ": " + err.Error())
+				continue
+			}
+		}
+		cur.histograms[key] = hist
+	}
+
+	for key := range old.summarySet {
+		if !deleted(constants.Summary, key) {
+			cur.setSummary(key)
+		}
+	}
+
+	for key, sm := range old.summaries {
+		if deleted(constants.Summary, key) {
+			continue
+		}
+		if _, ok := cur.summarySet[key]; ok || unknown {
+			cur.setSummary(key)
+			continue
+		}
+		if newer, ok := cur.summaries[key]; ok {
+			err := sm.Merge(newer)
+			if err != nil {
+				logger.Log().Error("hybrid storage: summary " + key + ": " + err.Error())
+				continue
+			}
+		}
+		cur.summaries[key] = sm
+	}
+
+	for rk, rollups := range old.rollups {
+		if !deleted(rk.mType, rk.key) {
+			cur.rollups[rk] = append(rollups, cur.rollups[rk]...)
+		}
+	}
+
+	for resolution, before := range old.prunes {
+		if before.After(cur.prunes[resolution]) {
+			cur.prunes[resolution] = before
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+var errBackendDown = errors.New("connection refused")
+
+// flakyBackend БД гибридного хранилища поверх памяти, которую можно "выключить"
+type flakyBackend struct {
+	*MemStorage
+	down    atomic.Bool
+	reject  string // пакеты, содержащие эту строку, БД отвергает
+	batches atomic.Int64
+	lost    atomic.Int64 // кол-во пакетов, которые БД применяет, но ответ о фиксации теряется
+}
+
+func newFlakyBackend(down bool) *flakyBackend {
+	b := &flakyBackend{MemStorage: NewMemStorage()}
+	b.down.Store(down)
+
+	return b
+}
+
+func (b *flakyBackend) check() error {
+	if b.down.Load() {
+		return errBackendDown
+	}
+	return nil
+}
+
+func (b *flakyBackend) CreateDatabaseTables(ctx context.Context) error {
+	return b.check()
+}
+
+func (b *flakyBackend) SetBatch(ctx context.Context, batch []byte) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	if b.reject != "" && bytes.Contains(batch, []byte(b.reject)) {
+		return errors.New("rejected")
+	}
+	b.batches.Add(1)
+
+	err := b.MemStorage.SetBatch(ctx, batch)
+	if err == nil && b.lost.Add(-1) >= 0 {
+		return fmt.Errorf("%w: %w", errCommit, errBackendDown)
+	}
+
+	return err
+}
+
+// setRecord запись итогового значения серии, как при восстановлении из дампа
+func (b *flakyBackend) setRecord(ctx context.Context, mType string, name string, value any) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+
+	rec, err := NewSetRecord(mType, name, value)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return b.MemStorage.RestoreFromDump(ctx, bytes.NewReader(data))
+}
+
+func (b *flakyBackend) SetHistogram(ctx context.Context, name string, value Histogram) error {
+	return b.setRecord(ctx, constants.Histogram, name, value)
+}
+
+func (b *flakyBackend) SetSummary(ctx context.Context, name string, value Summary) error {
+	return b.setRecord(ctx, constants.Summary, name, value)
+}
+
+func (b *flakyBackend) SetGaugeSamples(ctx context.Context, series map[string][]Sample) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+
+	err := b.MemStorage.SetGaugeSamples(ctx, series)
+	if err == nil && b.lost.Add(-1) >= 0 {
+		return fmt.Errorf("%w: %w", errCommit, errBackendDown)
+	}
+
+	return err
+}
+
+func (b *flakyBackend) SetCounter(ctx context.Context, name string, value int64) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemStorage.SetCounter(ctx, name, value)
+}
+
+func (b *flakyBackend) AddRollups(ctx context.Context, resolution time.Duration, mType string, name string, rollups []Rollup) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemStorage.AddRollups(ctx, resolution, mType, name, rollups)
+}
+
+func (b *flakyBackend) PruneHistory(ctx context.Context, resolution time.Duration, before time.Time) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemStorage.PruneHistory(ctx, resolution, before)
+}
+
+func (b *flakyBackend) DeleteSeries(ctx context.Context, mType string, name string, updatedBefore time.Time) (bool, error) {
+	if err := b.check(); err != nil {
+		return false, err
+	}
+	return b.MemStorage.DeleteSeries(ctx, mType, name, updatedBefore)
+}
+
+func (b *flakyBackend) QueryRange(ctx context.Context, mType string, name string, from, to time.Time) ([]Sample, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return b.MemStorage.QueryRange(ctx, mType, name, from, to)
+}
+
+func (b *flakyBackend) QueryRollups(ctx context.Context, resolution time.Duration, mType string, name string, from, to time.Time) ([]Rollup, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return b.MemStorage.QueryRollups(ctx, resolution, mType, name, from, to)
+}
+
+func (b *flakyBackend) WriteDump(ctx context.Context, w io.Writer) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemStorage.WriteDump(ctx, w)
+}
+
+// RestoreFromDump как в Postgresql: содержимое БД заменяется дампом
+func (b *flakyBackend) RestoreFromDump(ctx context.Context, r io.Reader) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	b.MemStorage = NewMemStorage()
+	return b.MemStorage.RestoreFromDump(ctx, r)
+}
+
+func (b *flakyBackend) DatabasePing(ctx context.Context) bool {
+	return !b.down.Load()
+}
+
+// newTestHybrid гибридное хранилище без фоновой записи и паузы между проверками БД
+func newTestHybrid(t *testing.T, db *flakyBackend) *HybridStorage {
+	h := newHybridStorage(NewMemStorage(), db, 0, 0)
+	_ = h.Flush(context.Background())
+	t.Cleanup(func() { _ = h.Close() })
+
+	return h
+}
+
+func TestHybridStorage(t *testing.T) {
+	ctx := context.Background()
+	hist := Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}
+
+	t.Run("write through", func(t *testing.T) {
+		db := newFlakyBackend(false)
+		require.NoError(t, db.MemStorage.SetGauge(ctx, "Old", 1))
+
+		h := newTestHybrid(t, db)
+		assert.Equal(t, constants.HealthOK, h.Health(ctx).Status)
+
+		// память загружена из БД
+		val, err := h.GetGauge(ctx, "Old")
+		require.NoError(t, err)
+		assert.Equal(t, float64(1), val)
+
+		require.NoError(t, h.SetGauge(ctx, "Alloc", 5))
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 2))
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 3))
+		require.NoError(t, h.AddHistogram(ctx, "Latency", hist))
+		require.NoError(t, h.AddHistogram(ctx, "Latency", hist))
+		assert.Equal(t, 3, h.Health(ctx).Pending)
+
+		require.NoError(t, h.Flush(ctx))
+		assert.Equal(t, Health{Status: constants.HealthOK}, h.Health(ctx))
+		assert.Equal(t, int64(1), db.batches.Load())
+
+		gauge, _ := db.MemStorage.GetGauge(ctx, "Alloc")
+		assert.Equal(t, float64(5), gauge)
+		counter, _ := db.MemStorage.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(5), counter)
+		histogram, _ := db.MemStorage.GetHistogram(ctx, "Latency")
+		assert.Equal(t, uint64(2), histogram.Count)
+
+		// пустая очередь в БД не пишется
+		require.NoError(t, h.Flush(ctx))
+		assert.Equal(t, int64(1), db.batches.Load())
+	})
+
+	t.Run("outage", func(t *testing.T) {
+		db := newFlakyBackend(false)
+		h := newTestHybrid(t, db)
+
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 1))
+		require.NoError(t, h.Flush(ctx))
+
+		db.down.Store(true)
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 2))
+		require.NoError(t, h.SetGauge(ctx, "Alloc", 7))
+		require.NoError(t, h.SetGaugeSamples(ctx, map[string][]Sample{"Free": {{Timestamp: 1000, Value: 1}}}))
+
+		err := h.Flush(ctx)
+		assert.ErrorIs(t, err, errBackendDown)
+
+		health := h.Health(ctx)
+		assert.Equal(t, constants.HealthDegraded, health.Status)
+		assert.Equal(t, 3, health.Pending)
+		assert.NotNil(t, health.Since)
+		assert.NotEmpty(t, health.Error)
+
+		// обновления без БД копятся в очереди, чтение - из памяти
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 4))
+		require.NoError(t, h.SetGaugeSamples(ctx, map[string][]Sample{"Free": {{Timestamp: 2000, Value: 2}}}))
+		assert.ErrorIs(t, h.Flush(ctx), ErrDatabaseDown)
+		counter, _ := h.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(7), counter)
+
+		db.down.Store(false)
+		require.NoError(t, h.Flush(ctx))
+		assert.Equal(t, Health{Status: constants.HealthOK}, h.Health(ctx))
+
+		// приращения записаны ровно один раз
+		counter, _ = db.MemStorage.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(7), counter)
+		gauge, _ := db.MemStorage.GetGauge(ctx, "Alloc")
+		assert.Equal(t, float64(7), gauge)
+
+		// значения с заданным временем записаны в историю БД
+		samples, _ := db.MemStorage.QueryRange(ctx, constants.Gauge, "Free", time.UnixMilli(0), time.Now())
+		assert.Equal(t, []Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}, samples)
+		gauge, _ = db.MemStorage.GetGauge(ctx, "Free")
+		assert.Equal(t, float64(2), gauge)
+	})
+
+	t.Run("lost commit", func(t *testing.T) {
+		db := newFlakyBackend(false)
+		h := newTestHybrid(t, db)
+
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 1))
+		require.NoError(t, h.AddHistogram(ctx, "Latency", hist))
+		require.NoError(t, h.Flush(ctx))
+
+		// БД применила пакет, но ответ о фиксации потерян: пакет не повторяется
+		db.lost.Store(1)
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 2))
+		require.NoError(t, h.AddHistogram(ctx, "Latency", hist))
+		err := h.Flush(ctx)
+		assert.ErrorIs(t, err, errCommit)
+		assert.Equal(t, int64(2), db.batches.Load())
+
+		// следующие обновления и неизвестный результат записываются итоговыми значениями из памяти
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 4))
+		require.NoError(t, h.Flush(ctx))
+		assert.Equal(t, Health{Status: constants.HealthOK}, h.Health(ctx))
+
+		counter, _ := db.MemStorage.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(7), counter)
+		histogram, _ := db.MemStorage.GetHistogram(ctx, "Latency")
+		assert.Equal(t, uint64(2), histogram.Count)
+
+		// история с неизвестным результатом не повторяется, записывается только значение
+		db.lost.Store(1)
+		require.NoError(t, h.SetGaugeSamples(ctx, map[string][]Sample{"Free": {{Timestamp: 1000, Value: 1}}}))
+		assert.ErrorIs(t, h.Flush(ctx), errCommit)
+		require.NoError(t, h.Flush(ctx))
+
+		samples, _ := db.MemStorage.QueryRange(ctx, constants.Gauge, "Free", time.UnixMilli(0), time.Now())
+		// значение с заданным временем и значение из памяти с временем записи
+		require.Len(t, samples, 2)
+		assert.Equal(t, Sample{Timestamp: 1000, Value: 1}, samples[0])
+		gauge, _ := db.MemStorage.GetGauge(ctx, "Free")
+		assert.Equal(t, float64(1), gauge)
+	})
+
+	t.Run("start without database", func(t *testing.T) {
+		db := newFlakyBackend(true)
+		require.NoError(t, db.MemStorage.SetCounter(ctx, "PollCount", 10))
+		require.NoError(t, db.MemStorage.SetGauge(ctx, "Old", 1))
+
+		h := newTestHybrid(t, db)
+		assert.Equal(t, constants.HealthDegraded, h.Health(ctx).Status)
+
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 5))
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 5))
+
+		db.down.Store(false)
+		require.NoError(t, h.Flush(ctx))
+		assert.Equal(t, constants.HealthOK, h.Health(ctx).Status)
+
+		// приращения добавлены к значению БД, память загружена из БД
+		counter, _ := db.MemStorage.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(20), counter)
+		counter, _ = h.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(20), counter)
+		gauge, err := h.GetGauge(ctx, "Old")
+		require.NoError(t, err)
+		assert.Equal(t, float64(1), gauge)
+	})
+
+	t.Run("restore from dump", func(t *testing.T) {
+		db := newFlakyBackend(true)
+		require.NoError(t, db.MemStorage.SetGauge(ctx, "Old", 1))
+
+		h := newTestHybrid(t, db)
+
+		dump := `{"op":"set","type":"counter","name":"PollCount","value":2}` + "\n"
+		require.NoError(t, h.RestoreFromDump(ctx, strings.NewReader(dump)))
+		require.NoError(t, h.IncrementCounter(ctx, "PollCount", 3))
+
+		db.down.Store(false)
+		require.NoError(t, h.Flush(ctx))
+
+		// БД заменена содержимым памяти, приращение после восстановления не задвоено
+		counter, _ := db.MemStorage.GetCounter(ctx, "PollCount")
+		assert.Equal(t, int64(5), counter)
+		_, err := db.MemStorage.GetGauge(ctx, "Old")
+		assert.Error(t, err)
+	})
+
+	t.Run("history", func(t *testing.T) {
+		db := newFlakyBackend(false)
+		h := newTestHybrid(t, db)
+		from, to := time.UnixMilli(0), time.Now()
+
+		// история в БД, которой нет в памяти (например, после перезапуска)
+		require.NoError(t, db.MemStorage.SetGaugeSamples(ctx, map[string][]Sample{"Free": {{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}}))
+		require.NoError(t, db.MemStorage.AddRollups(ctx, time.Minute, constants.Gauge, "Free", []Rollup{{Timestamp: 60000, Count: 2, Sum: 3, Min: 1, Max: 2, Last: 2}}))
+
+		// значения, еще не записанные в БД
+		require.NoError(t, h.SetGaugeSamples(ctx, map[string][]Sample{"Free": {{Timestamp: 3000, Value: 3}}}))
+		require.NoError(t, h.AddRollups(ctx, time.Minute, constants.Gauge, "Free", []Rollup{{Timestamp: 120000, Count: 1, Sum: 3, Min: 3, Max: 3, Last: 3}}))
+
+		samples, err := h.QueryRange(ctx, constants.Gauge, "Free", from, to)
+		require.NoError(t, err)
+		assert.Equal(t, []Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}, {Timestamp: 3000, Value: 3}}, samples)
+
+		rollups, err := h.QueryRollups(ctx, time.Minute, constants.Gauge, "Free", from, to)
+		require.NoError(t, err)
+		require.Len(t, rollups, 2)
+		assert.Equal(t, []int64{60000, 120000}, []int64{rollups[0].Timestamp, rollups[1].Timestamp})
+
+		// без БД история читается из памяти
+		db.down.Store(true)
+		samples, err = h.QueryRange(ctx, constants.Gauge, "Free", from, to)
+		require.NoError(t, err)
+		assert.Equal(t, []Sample{{Timestamp: 3000, Value: 3}}, samples)
+
+		assert.Error(t, h.Flush(ctx))
+		rollups, err = h.QueryRollups(ctx, time.Minute, constants.Gauge, "Free", from, to)
+		require.NoError(t, err)
+		require.Len(t, rollups, 1)
+		assert.Equal(t, int64(120000), rollups[0].Timestamp)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		db := newFlakyBackend(false)
+		h := newTestHybrid(t, db)
+
+		require.NoError(t, h.SetGauge(ctx, "Alloc", 1))
+		require.NoError(t, h.Flush(ctx))
+
+		db.down.Store(true)
+		deleted, err := h.DeleteSeries(ctx, constants.Gauge, "Alloc", time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.True(t, deleted)
+		assert.Error(t, h.Flush(ctx))
+
+		db.down.Store(false)
+		require.NoError(t, h.Flush(ctx))
+		_, err = db.MemStorage.GetGauge(ctx, "Alloc")
+		assert.Error(t, err)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		db := newFlakyBackend(false)
+		h := newTestHybrid(t, db)
+
+		db.reject = "Bad"
+		require.NoError(t, h.SetGauge(ctx, "Good", 1))
+		require.NoError(t, h.SetGauge(ctx, "Bad", 2))
+
+		// отвергнутая доступной БД серия пропускается, остальные записываются
+		require.NoError(t, h.Flush(ctx))
+		assert.Equal(t, Health{Status: constants.HealthOK}, h.Health(ctx))
+
+		gauge, err := db.MemStorage.GetGauge(ctx, "Good")
+		require.NoError(t, err)
+		assert.Equal(t, float64(1), gauge)
+		_, err = db.MemStorage.GetGauge(ctx, "Bad")
+		assert.Error(t, err)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		db := newFlakyBackend(false)
+		h := newTestHybrid(t, db)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					_ = h.IncrementCounter(ctx, "PollCount", 1)
+					_ = h.SetBatch(ctx, []byte(`[{"id":"Batch","type":"counter","delta":1}]`))
+				}
+			}()
+		}
+
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_ = h.Flush(ctx)
+				}
+			}
+		}()
+
+		wg.Wait()
+		close(done)
+		require.NoError(t, h.Flush(ctx))
+
+		for _, name := range []string{"PollCount", "Batch"} {
+			counter, _ := db.MemStorage.GetCounter(ctx, name)
+			assert.Equal(t, int64(400), counter, name)
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+// ErrBadLabels некорректные метки или селектор меток
+var ErrBadLabels = errors.New("bad labels")
+
+// labelNameRe допустимое имя метки
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels метки (измерения) метрики: имя метки - значение
+type Labels map[string]string
+
+// Операции сравнения меток в селекторе.
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// LabelMatcher условие на значение одной метки.
+// Отсутствующая метка считается меткой с пустым значением.
+type LabelMatcher struct {
+	Name  string
+	Op    string
+	Value string
+	re    *regexp.Regexp
+}
+
+// Selector выбор серий по названию метрики (пусто - любое) и условиям на метки,
+// например Alloc{host=~"web-.*",env!="dev"}
+type Selector struct {
+	Name     string
+	Matchers []LabelMatcher
+}
+
+// ValidateName проверка названия метрики. Символы {}", зарезервированы за ключом серии:
+// название foo{a="1"} совпало бы с ключом серии foo с меткой a=1.
+// Длина названия ограничена полем name в таблицах БД
+func ValidateName(name string) error {
+	if strings.ContainsAny(name, `{}",`) {
+		return fmt.Errorf("%w: metric name %q contains reserved characters", ErrBadLabels, name)
+	}
+	if len(name) > constants.MetricNameMaxLen {
+		return fmt.Errorf("%w: metric name %q is longer than %d characters", ErrBadLabels, name, constants.MetricNameMaxLen)
+	}
+
+	return nil
+}
+
+// ValidateSeries проверка названия и имен меток серии
+func ValidateSeries(name string, labels Labels) error {
+	err := ValidateName(name)
+	if err != nil {
+		return err
+	}
+
+	return ValidateLabels(labels)
+}
+
+// ValidateLabels проверка имен меток
+func ValidateLabels(labels Labels) error {
+	for name := range labels {
+		if !labelNameRe.MatchString(name) {
+			return fmt.Errorf("%w: bad label name %q", ErrBadLabels, name)
+		}
+	}
+
+	return nil
+}
+
+// ParseLabels разбор меток из строки вида "host=web-1,env=prod" (пустая строка - без меток)
+func ParseLabels(s string) (Labels, error) {
+	labels := Labels{}
+
+	if strings.TrimSpace(s) == "" {
+		return labels, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: bad label %q", ErrBadLabels, pair)
+		}
+
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	err := ValidateLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// SeriesKey ключ серии: название метрики и метки, отсортированные по имени, вида name{a="1",b="2"}.
+// Без меток ключ совпадает с названием метрики
+func SeriesKey(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[n]))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// SplitSeriesKey разбор ключа серии на название метрики и метки.
+// Ключ, который не разбирается как name{...}, целиком считается названием метрики без меток
+func SplitSeriesKey(key string) (string, Labels) {
+	i := strings.IndexByte(key, '{')
+	if i < 0 || !strings.HasSuffix(key, "}") {
+		return key, Labels{}
+	}
+
+	matchers, err := parseMatchers(key[i+1 : len(key)-1])
+	if err != nil {
+		return key, Labels{}
+	}
+
+	labels := make(Labels, len(matchers))
+	for _, m := range matchers {
+		if m.Op != MatchEqual {
+			return key, Labels{}
+		}
+		labels[m.Name] = m.Value
+	}
+
+	return key[:i], labels
+}
+
+// ParseSelector разбор селектора вида name{label="v",label=~"re"}, {label!="v"} или name
+func ParseSelector(s string) (Selector, error) {
+	s = strings.TrimSpace(s)
+
+	i := strings.IndexByte(s, '{')
+	if i < 0 {
+		return Selector{Name: s}, nil
+	}
+
+	if !strings.HasSuffix(s, "}") {
+		return Selector{}, fmt.Errorf("%w: selector %q: missing closing brace", ErrBadLabels, s)
+	}
+
+	matchers, err := parseMatchers(s[i+1 : len(s)-1])
+	if err != nil {
+		return Selector{}, fmt.Errorf("selector %q: %w", s, err)
+	}
+
+	return Selector{Name: strings.TrimSpace(s[:i]), Matchers: matchers}, nil
+}
+
+// Match подходит ли серия с названием name и метками labels под селектор
+func (s Selector) Match(name string, labels Labels) bool {
+	if s.Name != "" && s.Name != name {
+		return false
+	}
+
+	for _, m := range s.Matchers {
+		if !m.Match(labels[m.Name]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchKey подходит ли серия с ключом key под селектор
+func (s Selector) MatchKey(key string) bool {
+	name, labels := SplitSeriesKey(key)
+
+	return s.Match(name, labels)
+}
+
+// Match подходит ли значение метки под условие
+func (m LabelMatcher) Match(value string) bool {
+	switch m.Op {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+
+	return false
+}
+
+// parseMatchers разбор списка условий вида a="1",b=~"re" (без фигурных скобок)
+func parseMatchers(s string) ([]LabelMatcher, error) {
+	var matchers []LabelMatcher
+
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return matchers, nil
+		}
+
+		// имя метки
+		i := strings.IndexAny(s, "=!")
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: bad matcher %q", ErrBadLabels, s)
+		}
+
+		m := LabelMatcher{Name: strings.TrimSpace(s[:i])}
+		if !labelNameRe.MatchString(m.Name) {
+			return nil, fmt.Errorf("%w: bad label name %q", ErrBadLabels, m.Name)
+		}
+		s = s[i:]
+
+		// операция
+		switch {
+		case strings.HasPrefix(s, MatchRegexp), strings.HasPrefix(s, MatchNotRegexp), strings.HasPrefix(s, MatchNotEqual):
+			m.Op = s[:2]
+		case strings.HasPrefix(s, MatchEqual):
+			m.Op = MatchEqual
+		default:
+			return nil, fmt.Errorf("%w: bad operator in %q", ErrBadLabels, s)
+		}
+		s = strings.TrimLeft(s[len(m.Op):], " ")
+
+		// значение в кавычках
+		end := quotedEnd(s)
+		if end < 0 {
+			return nil, fmt.Errorf("%w: label %s: value must be quoted", ErrBadLabels, m.Name)
+		}
+
+		value, err := strconv.Unquote(s[:end])
+		if err != nil {
+			return nil, fmt.Errorf("%w: label %s: %s", ErrBadLabels, m.Name, err.Error())
+		}
+		m.Value = value
+		s = strings.TrimLeft(s[end:], " ")
+
+		if m.Op == MatchRegexp || m.Op == MatchNotRegexp {
+			m.re, err = regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%w: label %s: %s", ErrBadLabels, m.Name, err.Error())
+			}
+		}
+
+		matchers = append(matchers, m)
+
+		if s == "" {
+			return matchers, nil
+		}
+		if s[0] != ',' {
+			return nil, fmt.Errorf("%w: expected comma in %q", ErrBadLabels, s)
+		}
+		s = s[1:]
+	}
+}
+
+// quotedEnd позиция сразу после закрывающей кавычки строки, начинающейся с кавычки, -1 - если строка не закрыта
+func quotedEnd(s string) int {
+	if s == "" || s[0] != '"' {
+		return -1
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// SanitizeName замена символов, недопустимых в названии метрики (или имени метки без colon), на _.
+// Название, начинающееся с цифры, дополняется _ в начале
+func SanitizeName(name string, colon bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			(colon && c == ':')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+func TestSeriesKey(t *testing.T) {
+	assert.Equal(t, "Alloc", SeriesKey("Alloc", nil))
+	assert.Equal(t, `Alloc{env="prod",host="web-1"}`, SeriesKey("Alloc", Labels{"host": "web-1", "env": "prod"}))
+
+	key := SeriesKey("Alloc", Labels{"path": `/a "b"`, "z": "x,y}"})
+	name, labels := SplitSeriesKey(key)
+	assert.Equal(t, "Alloc", name)
+	assert.Equal(t, Labels{"path": `/a "b"`, "z": "x,y}"}, labels)
+
+	// ключ без меток и ключ, не разбирающийся как серия, - название целиком
+	name, labels = SplitSeriesKey("Alloc")
+	assert.Equal(t, "Alloc", name)
+	assert.Empty(t, labels)
+
+	name, labels = SplitSeriesKey("weird{name}")
+	assert.Equal(t, "weird{name}", name)
+	assert.Empty(t, labels)
+}
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, ValidateLabels(nil))
+	assert.NoError(t, ValidateLabels(Labels{"host": "a", "_x1": ""}))
+	assert.ErrorIs(t, ValidateLabels(Labels{"1host": "a"}), ErrBadLabels)
+	assert.ErrorIs(t, ValidateLabels(Labels{"ho st": "a"}), ErrBadLabels)
+}
+
+func TestValidateSeries(t *testing.T) {
+	assert.NoError(t, ValidateSeries("Alloc", Labels{"host": "a"}))
+	assert.NoError(t, ValidateName("http.requests-total"))
+
+	// название совпало бы с ключом серии с метками
+	for _, name := range []string{`Alloc{host="a"}`, "Alloc{", "Alloc}", `Al"loc`, "Alloc,x"} {
+		assert.ErrorIs(t, ValidateName(name), ErrBadLabels, name)
+	}
+	// название длиннее поля name в БД
+	assert.NoError(t, ValidateName(strings.Repeat("a", constants.MetricNameMaxLen)))
+	assert.ErrorIs(t, ValidateName(strings.Repeat("a", constants.MetricNameMaxLen+1)), ErrBadLabels)
+
+	assert.ErrorIs(t, ValidateSeries("Alloc{}", nil), ErrBadLabels)
+	assert.ErrorIs(t, ValidateSeries("Alloc", Labels{"1host": "a"}), ErrBadLabels)
+}
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "go_gc_duration:seconds", SanitizeName("go.gc-duration:seconds", true))
+	assert.Equal(t, "host_name", SanitizeName("host:name", false))
+	assert.Equal(t, "_3d_printer", SanitizeName("3d.printer", true))
+	assert.Equal(t, "", SanitizeName("", true))
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels(" host=web-1, env=prod ")
+	require.NoError(t, err)
+	assert.Equal(t, Labels{"host": "web-1", "env": "prod"}, labels)
+
+	labels, err = ParseLabels("")
+	require.NoError(t, err)
+	assert.Empty(t, labels)
+
+	_, err = ParseLabels("host")
+	assert.ErrorIs(t, err, ErrBadLabels)
+
+	_, err = ParseLabels("1host=a")
+	assert.ErrorIs(t, err, ErrBadLabels)
+}
+
+func TestSelector(t *testing.T) {
+	s, err := ParseSelector(`Alloc{host=~"web-.*", env!="dev"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "Alloc", s.Name)
+	assert.Len(t, s.Matchers, 2)
+
+	assert.True(t, s.MatchKey(`Alloc{env="prod",host="web-1"}`))
+	assert.True(t, s.MatchKey(`Alloc{host="web-2"}`))
+	assert.False(t, s.MatchKey(`Alloc{env="dev",host="web-1"}`))
+	assert.False(t, s.MatchKey(`Alloc{host="db-1"}`))
+	assert.False(t, s.MatchKey(`Frees{host="web-1"}`))
+	assert.False(t, s.MatchKey("Alloc"))
+
+	// без названия - любые метрики
+	s, err = ParseSelector(`{host="web-1"}`)
+	require.NoError(t, err)
+	assert.True(t, s.MatchKey(`Frees{host="web-1"}`))
+	assert.False(t, s.MatchKey("Frees"))
+
+	s, err = ParseSelector(`{host!~"web-.*"}`)
+	require.NoError(t, err)
+	assert.True(t, s.MatchKey("Frees"))
+
+	s, err = ParseSelector("Alloc")
+	require.NoError(t, err)
+	assert.True(t, s.MatchKey(`Alloc{host="a"}`))
+
+	for _, bad := range []string{`Alloc{host="a"`, `{host=a}`, `{host~"a"}`, `{1host="a"}`, `{host=~"("}`, `{a="1" b="2"}`} {
+		_, err = ParseSelector(bad)
+		assert.ErrorIs(t, err, ErrBadLabels, bad)
+	}
+}
@@ -4,43 +4,191 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
 )
 
-// MemStorage работает с хранилищем в оперативной памяти
+// MemStorage работает с хранилищем в оперативной памяти.
+// Серии распределены по сегментам со своими блокировками, обновления серий разных сегментов не блокируют друг друга.
+// Чтение всех серий возвращает копии, снятые посегментно
 type MemStorage struct {
-	mutex    sync.Mutex
-	Gauges   map[string]float64 `json:"gauges"`
-	Counters map[string]int64   `json:"counters"`
+	shards      []*memShard
+	seed        maphash.Seed
+	historySize atomic.Int64 // количество хранимых значений каждой серии
+}
+
+// memShard сегмент хранилища, серия попадает в сегмент по хешу своего ключа
+type memShard struct {
+	mutex      sync.RWMutex
+	gauges     map[string]float64
+	counters   map[string]int64
+	histograms map[string]Histogram // значения заменяются целиком, сохраненные не изменяются
+	summaries  map[string]Summary   // значения заменяются целиком, сохраненные не изменяются
+
+	history map[historyKey]*ring           // история значений gauge и counter, в дамп не попадает
+	rollups map[rollupKey]map[int64]Rollup // агрегаты истории по концу интервала, в дамп не попадают
+	updated map[historyKey]time.Time       // время последнего обновления серий, в дамп не попадает
 }
 
 func NewMemStorage() *MemStorage {
-	return &MemStorage{
-		Gauges:   make(map[string]float64),
-		Counters: make(map[string]int64),
+	m := &MemStorage{
+		shards: make([]*memShard, constants.MemStorageShards),
+		seed:   maphash.MakeSeed(),
+	}
+
+	for i := range m.shards {
+		m.shards[i] = &memShard{
+			gauges:     make(map[string]float64),
+			counters:   make(map[string]int64),
+			histograms: make(map[string]Histogram),
+			summaries:  make(map[string]Summary),
+			history:    make(map[historyKey]*ring),
+			rollups:    make(map[rollupKey]map[int64]Rollup),
+			updated:    make(map[historyKey]time.Time),
+		}
+	}
+	m.historySize.Store(int64(constants.HistorySize))
+
+	return m
+}
+
+// SetHistorySize количество хранимых значений для новых серий, 0 - история не хранится
+func (m *MemStorage) SetHistorySize(size int) {
+	m.historySize.Store(int64(size))
+}
+
+// shardIndex номер сегмента серии с ключом key
+func (m *MemStorage) shardIndex(key string) int {
+	return int(maphash.String(m.seed, key) % uint64(len(m.shards)))
+}
+
+// shard сегмент серии с ключом key
+func (m *MemStorage) shard(key string) *memShard {
+	return m.shards[m.shardIndex(key)]
+}
+
+// lockShards блокировка сегментов серий keys по возрастанию номера, чтобы параллельные пакеты не блокировали друг друга взаимно.
+// Возвращает функцию разблокировки
+func (m *MemStorage) lockShards(keys []string) func() {
+	used := make([]bool, len(m.shards))
+	for _, key := range keys {
+		used[m.shardIndex(key)] = true
+	}
+
+	locked := make([]*memShard, 0, len(keys))
+	for i, ok := range used {
+		if ok {
+			m.shards[i].mutex.Lock()
+			locked = append(locked, m.shards[i])
+		}
+	}
+
+	return func() {
+		for _, s := range locked {
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// record добавление значения серии в историю, вызывается под блокировкой сегмента
+func (m *MemStorage) record(s *memShard, mType string, key string, value float64, ts time.Time) {
+	size := int(m.historySize.Load())
+	if size <= 0 {
+		return
+	}
+
+	hk := historyKey{mType: mType, key: key}
+
+	r, ok := s.history[hk]
+	if !ok {
+		r = newRing(size)
+		s.history[hk] = r
+	}
+
+	r.add(Sample{Timestamp: ts.UnixMilli(), Value: value})
+}
+
+// QueryRange история значений серии типа mType с from по to включительно в порядке времени.
+// Параметры: name - ключ серии.
+func (m *MemStorage) QueryRange(ctx context.Context, mType string, name string, from, to time.Time) ([]Sample, error) {
+	s := m.shard(name)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	r, ok := s.history[historyKey{mType: mType, key: name}]
+	if !ok {
+		return []Sample{}, nil
 	}
+
+	return r.between(from, to), nil
 }
 
 // SetGauge сохранение метрики типа gauge в хранилище.
 // Параметры: name - название метрики, value - ее значение.
 func (m *MemStorage) SetGauge(ctx context.Context, name string, value float64) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	err := validateGauge(value)
+	if err != nil {
+		return err
+	}
+
+	s := m.shard(name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.gauges[name] = value
+	m.record(s, constants.Gauge, name, value, now)
+	s.updated[historyKey{mType: constants.Gauge, key: name}] = now
+
+	return nil
+}
+
+// SetGaugeSamples сохранение значений метрик типа gauge с заданным временем по сериям: все значения добавляются в историю,
+// значение с наибольшим временем становится текущим. При недопустимом значении не сохраняется ни одна серия.
+// Параметры: series - значения по ключам серий.
+func (m *MemStorage) SetGaugeSamples(ctx context.Context, series map[string][]Sample) error {
+	err := validateSamples(series)
+	if err != nil {
+		return err
+	}
 
-	m.Gauges[name] = value
+	for name, samples := range series {
+		if len(samples) > 0 {
+			m.setGaugeSamples(name, sortSamples(samples))
+		}
+	}
 
 	return nil
 }
 
+// setGaugeSamples сохранение упорядоченных по времени значений серии
+func (m *MemStorage) setGaugeSamples(name string, samples []Sample) {
+	s := m.shard(name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, sm := range samples {
+		m.record(s, constants.Gauge, name, sm.Value, time.UnixMilli(sm.Timestamp))
+	}
+	s.gauges[name] = samples[len(samples)-1].Value
+	s.updated[historyKey{mType: constants.Gauge, key: name}] = time.Now()
+}
+
 // GetGauge получение значения метрики типа gauge из хранилища.
 // Параметры: name - название метрики.
 func (m *MemStorage) GetGauge(ctx context.Context, name string) (float64, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	s := m.shard(name)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	if value, ok := m.Gauges[name]; ok {
+	if value, ok := s.gauges[name]; ok {
 		return value, nil
 	}
 
@@ -50,86 +198,604 @@ func (m *MemStorage) GetGauge(ctx context.Context, name string) (float64, error)
 // SetCounter сохранение метрики типа counter в хранилище.
 // Параметры: name - название метрики, value - ее значение.
 func (m *MemStorage) SetCounter(ctx context.Context, name string, value int64) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	s := m.shard(name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.counters[name] = value
+	m.record(s, constants.Counter, name, float64(value), now)
+	s.updated[historyKey{mType: constants.Counter, key: name}] = now
+
+	return nil
+}
+
+// IncrementCounter атомарное прибавление delta к метрике типа counter.
+// Если метрики еще нет - она создается со значением delta
+func (m *MemStorage) IncrementCounter(ctx context.Context, name string, delta int64) error {
+	s := m.shard(name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m.incrementCounter(s, name, delta, time.Now())
+
+	return nil
+}
+
+// IncrementCounters атомарное прибавление приращений к нескольким метрикам типа counter
+func (m *MemStorage) IncrementCounters(ctx context.Context, deltas map[string]int64) error {
+	keys := make([]string, 0, len(deltas))
+	for name := range deltas {
+		keys = append(keys, name)
+	}
+
+	unlock := m.lockShards(keys)
+	defer unlock()
 
-	m.Counters[name] = value
+	now := time.Now()
+	for name, delta := range deltas {
+		m.incrementCounter(m.shard(name), name, delta, now)
+	}
 
 	return nil
 }
 
-// SetBatch сохраняет метрики в базу пакетом из нескольких штук
+// incrementCounter прибавление delta к счетчику, вызывается под блокировкой сегмента
+func (m *MemStorage) incrementCounter(s *memShard, name string, delta int64, now time.Time) {
+	s.counters[name] += delta
+	m.record(s, constants.Counter, name, float64(s.counters[name]), now)
+	s.updated[historyKey{mType: constants.Counter, key: name}] = now
+}
+
+// SetBatch сохраняет метрики в базу пакетом из нескольких штук.
+// Сегменты всех серий пакета блокируются на время сохранения, пакет виден читателям целиком
 func (m *MemStorage) SetBatch(ctx context.Context, batch []byte) error {
 	var metrics []Metrics
 
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
 	err := json.Unmarshal(batch, &metrics)
 	if err != nil {
 		return err
 	}
 
+	keys := make([]string, 0, len(metrics))
+	for _, mt := range metrics {
+		err = ValidateSeries(mt.ID, mt.Labels)
+		if err != nil {
+			return fmt.Errorf("metric %s: %w", mt.ID, err)
+		}
+		keys = append(keys, mt.Key())
+	}
+
+	unlock := m.lockShards(keys)
+	defer unlock()
+
+	// гистограммы и summary сливаем заранее, чтобы при ошибке не сохранить пакет частично
+	histograms := make(map[string]Histogram)
+	summaries := make(map[string]Summary)
+	for _, mt := range metrics {
+		switch mt.MType {
+		case constants.Histogram:
+			h, ok := histograms[mt.Key()]
+			if !ok {
+				h, ok = m.shard(mt.Key()).histograms[mt.Key()]
+				h = h.Clone()
+			}
+
+			h, err = mergeHistogram(h, ok, mt)
+			if err != nil {
+				return err
+			}
+			histograms[mt.Key()] = h
+
+		case constants.Summary:
+			sm, ok := summaries[mt.Key()]
+			if !ok {
+				sm, ok = m.shard(mt.Key()).summaries[mt.Key()]
+				sm = sm.Clone()
+			}
+
+			sm, err = mergeSummary(sm, ok, mt)
+			if err != nil {
+				return err
+			}
+			summaries[mt.Key()] = sm
+		}
+	}
+
+	now := time.Now()
 	for _, mt := range metrics {
+		s := m.shard(mt.Key())
+		s.updated[historyKey{mType: mt.MType, key: mt.Key()}] = now
+
 		if mt.MType == constants.Gauge {
-			m.Gauges[mt.ID] = *mt.Value
+			s.gauges[mt.Key()] = *mt.Value
+			m.record(s, constants.Gauge, mt.Key(), *mt.Value, now)
 		}
 
 		if mt.MType == constants.Counter {
-			m.Counters[mt.ID] = *mt.Delta
+			m.incrementCounter(s, mt.Key(), *mt.Delta, now)
 		}
 	}
 
+	for name, h := range histograms {
+		m.shard(name).histograms[name] = h
+	}
+
+	for name, sm := range summaries {
+		m.shard(name).summaries[name] = sm
+	}
+
+	return nil
+}
+
+// AddHistogram прибавление гистограммы к сохраненной.
+// Если гистограммы еще нет - сохраняется переданная.
+func (m *MemStorage) AddHistogram(ctx context.Context, name string, value Histogram) error {
+	err := value.Validate()
+	if err != nil {
+		return err
+	}
+
+	s := m.shard(name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.updated[historyKey{mType: constants.Histogram, key: name}] = time.Now()
+
+	h, ok := s.histograms[name]
+	if !ok {
+		s.histograms[name] = value.Clone()
+		return nil
+	}
+
+	h = h.Clone()
+
+	err = h.Merge(value)
+	if err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+	s.histograms[name] = h
+
 	return nil
 }
 
+// GetHistogram получение метрики типа histogram из хранилища.
+// Параметры: name - название метрики.
+func (m *MemStorage) GetHistogram(ctx context.Context, name string) (Histogram, error) {
+	s := m.shard(name)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if value, ok := s.histograms[name]; ok {
+		return value.Clone(), nil
+	}
+
+	return Histogram{}, errors.New("no such metric")
+}
+
+// AddSummary прибавление скетча summary к сохраненному.
+// Если метрики еще нет - сохраняется переданный скетч.
+func (m *MemStorage) AddSummary(ctx context.Context, name string, value Summary) error {
+	err := value.Validate()
+	if err != nil {
+		return err
+	}
+
+	s := m.shard(name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.updated[historyKey{mType: constants.Summary, key: name}] = time.Now()
+
+	sm, ok := s.summaries[name]
+	if !ok {
+		s.summaries[name] = value.Clone()
+		return nil
+	}
+
+	sm = sm.Clone()
+
+	err = sm.Merge(value)
+	if err != nil {
+		return fmt.Errorf("summary %s: %w", name, err)
+	}
+	s.summaries[name] = sm
+
+	return nil
+}
+
+// GetSummary получение скетча метрики типа summary из хранилища.
+// Параметры: name - название метрики.
+func (m *MemStorage) GetSummary(ctx context.Context, name string) (Summary, error) {
+	s := m.shard(name)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if value, ok := s.summaries[name]; ok {
+		return value.Clone(), nil
+	}
+
+	return Summary{}, errors.New("no such metric")
+}
+
+// GetAllSummaries возврат карты скетчей summary
+func (m *MemStorage) GetAllSummaries(ctx context.Context) (map[string]Summary, error) {
+	summaries := make(map[string]Summary)
+
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		for name, sm := range s.summaries {
+			summaries[name] = sm.Clone()
+		}
+		s.mutex.RUnlock()
+	}
+
+	return summaries, nil
+}
+
+// GetAllHistograms возврат карты гистограмм
+func (m *MemStorage) GetAllHistograms(ctx context.Context) (map[string]Histogram, error) {
+	histograms := make(map[string]Histogram)
+
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		for name, h := range s.histograms {
+			histograms[name] = h.Clone()
+		}
+		s.mutex.RUnlock()
+	}
+
+	return histograms, nil
+}
+
 // GetCounter получение значения метрики типа counter из хранилища.
 // Параметры: name - название метрики.
 func (m *MemStorage) GetCounter(ctx context.Context, name string) (int64, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	s := m.shard(name)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	if value, ok := m.Counters[name]; ok {
+	if value, ok := s.counters[name]; ok {
 		return value, nil
 	}
 
 	return 0, errors.New("no such metric")
 }
 
-// GetAll возврат карт gauge и counters
+// AddRollups сохранение агрегатов истории серии с разрешением resolution.
+// Агрегаты с тем же концом интервала перезаписываются
+func (m *MemStorage) AddRollups(ctx context.Context, resolution time.Duration, mType string, name string, rollups []Rollup) error {
+	s := m.shard(name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rk := rollupKey{historyKey: historyKey{mType: mType, key: name}, resolution: resolution}
+
+	series, ok := s.rollups[rk]
+	if !ok {
+		series = make(map[int64]Rollup)
+		s.rollups[rk] = series
+	}
+
+	for _, r := range rollups {
+		series[r.Timestamp] = r
+	}
+
+	return nil
+}
+
+// QueryRollups агрегаты истории серии с разрешением resolution с from по to включительно в порядке времени
+func (m *MemStorage) QueryRollups(ctx context.Context, resolution time.Duration, mType string, name string, from, to time.Time) ([]Rollup, error) {
+	s := m.shard(name)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	res := make([]Rollup, 0)
+	for ts, r := range s.rollups[rollupKey{historyKey: historyKey{mType: mType, key: name}, resolution: resolution}] {
+		if ts >= from.UnixMilli() && ts <= to.UnixMilli() {
+			res = append(res, r)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Timestamp < res[j].Timestamp
+	})
+
+	return res, nil
+}
+
+// PruneHistory удаление истории старше before: исходных значений (resolution 0) или агрегатов с разрешением resolution
+func (m *MemStorage) PruneHistory(ctx context.Context, resolution time.Duration, before time.Time) error {
+	for _, s := range m.shards {
+		s.mutex.Lock()
+		s.pruneHistory(resolution, before)
+		s.mutex.Unlock()
+	}
+
+	return nil
+}
+
+// pruneHistory удаление истории сегмента старше before, вызывается под блокировкой сегмента
+func (s *memShard) pruneHistory(resolution time.Duration, before time.Time) {
+	if resolution == 0 {
+		for hk, r := range s.history {
+			r.dropBefore(before)
+
+			// история удаленных серий
+			if len(r.samples) == 0 {
+				delete(s.history, hk)
+			}
+		}
+
+		return
+	}
+
+	for rk, series := range s.rollups {
+		if rk.resolution != resolution {
+			continue
+		}
+
+		for ts := range series {
+			if ts < before.UnixMilli() {
+				delete(series, ts)
+			}
+		}
+
+		if len(series) == 0 {
+			delete(s.rollups, rk)
+		}
+	}
+}
+
+// GetAll возврат копий карт gauge и counters
 func (m *MemStorage) GetAll(ctx context.Context) (map[string]float64, map[string]int64, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	gauges := make(map[string]float64)
+	counters := make(map[string]int64)
 
-	return m.Gauges, m.Counters, nil
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		for name, value := range s.gauges {
+			gauges[name] = value
+		}
+		for name, value := range s.counters {
+			counters[name] = value
+		}
+		s.mutex.RUnlock()
+	}
+
+	return gauges, counters, nil
 }
 
-// GetDump получение json дампа
-func (m *MemStorage) GetDump(ctx context.Context) (string, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// WriteDump запись дампа в w в формате NDJSON.
+// Сегменты копируются по очереди и записываются без блокировки, обновления на время записи не останавливаются
+func (m *MemStorage) WriteDump(ctx context.Context, w io.Writer) error {
+	enc := json.NewEncoder(w)
 
-	data, err := json.Marshal(m)
-	if err != nil {
-		return "", err
+	// записи идут по типам метрик, как и до разбиения на сегменты
+	for _, mType := range []string{constants.Gauge, constants.Counter, constants.Histogram, constants.Summary} {
+		for _, s := range m.shards {
+			records, err := s.dumpRecords(mType)
+			if err != nil {
+				return err
+			}
+
+			for _, rec := range records {
+				err = enc.Encode(rec)
+				if err != nil {
+					return err
+				}
+			}
+		}
 	}
 
-	return string(data), nil
+	return nil
+}
+
+// dumpRecords записи дампа всех серий сегмента типа mType
+func (s *memShard) dumpRecords(mType string) ([]DumpRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var records []DumpRecord
+
+	add := func(name string, value any) error {
+		rec, err := NewSetRecord(mType, name, value)
+		if err != nil {
+			return err
+		}
+		records = append(records, rec)
+
+		return nil
+	}
+
+	switch mType {
+	case constants.Gauge:
+		for name, value := range s.gauges {
+			if err := add(name, value); err != nil {
+				return nil, err
+			}
+		}
+	case constants.Counter:
+		for name, value := range s.counters {
+			if err := add(name, value); err != nil {
+				return nil, err
+			}
+		}
+	case constants.Histogram:
+		for name, value := range s.histograms {
+			if err := add(name, value); err != nil {
+				return nil, err
+			}
+		}
+	case constants.Summary:
+		for name, value := range s.summaries {
+			if err := add(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return records, nil
 }
 
-// RestoreFromDump восстановление из json дампа
-func (m *MemStorage) RestoreFromDump(ctx context.Context, dump string) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// RestoreFromDump восстановление из дампа в формате NDJSON.
+// Время обновления в дамп не попадает - восстановленные серии считаются обновленными сейчас
+func (m *MemStorage) RestoreFromDump(ctx context.Context, r io.Reader) error {
+	now := time.Now()
+
+	return DecodeDump(r, func(rec DumpRecord) error {
+		if rec.Op == OpExtra {
+			return nil
+		}
+
+		s := m.shard(rec.Name)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		return s.restore(rec, now)
+	})
+}
+
+// restore применение записи дампа к сегменту, вызывается под блокировкой сегмента
+func (s *memShard) restore(rec DumpRecord, now time.Time) error {
+	hk := historyKey{mType: rec.MType, key: rec.Name}
+
+	if rec.Op == OpDelete {
+		switch rec.MType {
+		case constants.Gauge:
+			delete(s.gauges, rec.Name)
+		case constants.Counter:
+			delete(s.counters, rec.Name)
+		case constants.Histogram:
+			delete(s.histograms, rec.Name)
+		case constants.Summary:
+			delete(s.summaries, rec.Name)
+		}
+		delete(s.updated, hk)
+
+		return nil
+	}
+
+	if rec.Op == OpAdd && rec.MType == constants.Counter {
+		var delta int64
+
+		err := json.Unmarshal(rec.Value, &delta)
+		if err != nil {
+			return fmt.Errorf("%w: %s %s: %s", ErrBadDump, rec.MType, rec.Name, err.Error())
+		}
+		s.counters[rec.Name] += delta
+		s.updated[hk] = now
+
+		return nil
+	}
 
-	err := json.Unmarshal([]byte(dump), m)
+	if rec.Op != OpSet {
+		return fmt.Errorf("%w: bad operation %q", ErrBadDump, rec.Op)
+	}
+
+	var err error
+
+	switch rec.MType {
+	case constants.Gauge:
+		var v float64
+		err = json.Unmarshal(rec.Value, &v)
+		s.gauges[rec.Name] = v
+	case constants.Counter:
+		var v int64
+		err = json.Unmarshal(rec.Value, &v)
+		s.counters[rec.Name] = v
+	case constants.Histogram:
+		var v Histogram
+		err = json.Unmarshal(rec.Value, &v)
+		s.histograms[rec.Name] = v
+	case constants.Summary:
+		var v Summary
+		err = json.Unmarshal(rec.Value, &v)
+		s.summaries[rec.Name] = v
+	default:
+		return fmt.Errorf("%w: bad metric type %q", ErrBadDump, rec.MType)
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %s %s: %s", ErrBadDump, rec.MType, rec.Name, err.Error())
 	}
 
+	s.updated[hk] = now
+
 	return nil
 }
 
+// ListSeries все серии и время их последнего обновления
+func (m *MemStorage) ListSeries(ctx context.Context) ([]SeriesInfo, error) {
+	series := make([]SeriesInfo, 0)
+
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		series = s.appendSeries(series)
+		s.mutex.RUnlock()
+	}
+
+	return series, nil
+}
+
+// appendSeries добавление в series всех серий сегмента, вызывается под блокировкой сегмента
+func (s *memShard) appendSeries(series []SeriesInfo) []SeriesInfo {
+	add := func(mType string, key string) {
+		series = append(series, SeriesInfo{MType: mType, Name: key, UpdatedAt: s.updated[historyKey{mType: mType, key: key}]})
+	}
+
+	for key := range s.gauges {
+		add(constants.Gauge, key)
+	}
+	for key := range s.counters {
+		add(constants.Counter, key)
+	}
+	for key := range s.histograms {
+		add(constants.Histogram, key)
+	}
+	for key := range s.summaries {
+		add(constants.Summary, key)
+	}
+
+	return series
+}
+
+// DeleteSeries удаление серии типа mType, если она не обновлялась с момента updatedBefore.
+// Возвращает, была ли серия удалена. История значений серии удаляется по срокам хранения уровней
+func (m *MemStorage) DeleteSeries(ctx context.Context, mType string, name string, updatedBefore time.Time) (bool, error) {
+	s := m.shard(name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hk := historyKey{mType: mType, key: name}
+
+	updated, ok := s.updated[hk]
+	if ok && !updated.Before(updatedBefore) {
+		return false, nil
+	}
+
+	var found bool
+
+	switch mType {
+	case constants.Gauge:
+		_, found = s.gauges[name]
+		delete(s.gauges, name)
+	case constants.Counter:
+		_, found = s.counters[name]
+		delete(s.counters, name)
+	case constants.Histogram:
+		_, found = s.histograms[name]
+		delete(s.histograms, name)
+	case constants.Summary:
+		_, found = s.summaries[name]
+		delete(s.summaries, name)
+	default:
+		return false, errors.New("bad metric type")
+	}
+	delete(s.updated, hk)
+
+	return found, nil
+}
+
 // DatabasePing проверяет работоспособность БД
 func (m *MemStorage) DatabasePing(ctx context.Context) bool {
 	return false
@@ -1,11 +1,20 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
 )
 
 func TestSetGetGauge(t *testing.T) {
@@ -17,6 +26,18 @@ func TestSetGetGauge(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, 123.456, val)
+
+	// NaN и бесконечность не сохраняются, дамп остается корректным json
+	assert.ErrorIs(t, m.SetGauge(ctx, "test", math.NaN()), ErrBadValue)
+	assert.ErrorIs(t, m.SetGauge(ctx, "test", math.Inf(-1)), ErrBadValue)
+	assert.ErrorIs(t, m.SetGaugeSamples(ctx, map[string][]Sample{"test": {{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: math.Inf(1)}}}), ErrBadValue)
+
+	val, err = m.GetGauge(ctx, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 123.456, val)
+
+	var buf bytes.Buffer
+	require.NoError(t, m.WriteDump(ctx, &buf))
 }
 
 func TestSetGetCounter(t *testing.T) {
@@ -44,24 +65,26 @@ func TestSetGetAll(t *testing.T) {
 	assert.Equal(t, int64(123), counters["Counter"])
 }
 
-func TestSetGetDump(t *testing.T) {
+func TestWriteDump(t *testing.T) {
 	m := NewMemStorage()
 	ctx := context.Background()
 
 	m.SetGauge(ctx, "Gauge", 123.456)
 	m.SetCounter(ctx, "Counter", 123)
-	dump, err := m.GetDump(ctx)
-	fmt.Println(dump)
+	var dump strings.Builder
+	err := m.WriteDump(ctx, &dump)
+	fmt.Println(dump.String())
 
 	assert.NoError(t, err)
-	assert.Equal(t, `{"gauges":{"Gauge":123.456},"counters":{"Counter":123}}`, dump)
+	assert.Equal(t, `{"op":"set","type":"gauge","name":"Gauge","value":123.456}`+"\n"+`{"op":"set","type":"counter","name":"Counter","value":123}`+"\n", dump.String())
 }
 
 func TestRestoreFromDump(t *testing.T) {
 	m := NewMemStorage()
 	ctx := context.Background()
 
-	err := m.RestoreFromDump(ctx, `{"gauges":{"Gauge":123.456},"counters":{"Counter":123}}`)
+	err := m.RestoreFromDump(ctx, strings.NewReader(`{"op":"set","type":"gauge","name":"Gauge","value":123.456}`+"\n"+`{"op":"set","type":"counter","name":"Counter","value":123}`+"\n"+
+		`{"op":"set","type":"gauge","name":"Deleted","value":1}`+"\n"+`{"op":"delete","type":"gauge","name":"Deleted"}`+"\n"+`{"op":"extra","type":"silences","value":{}}`))
 	assert.NoError(t, err)
 
 	gauges, counters, err := m.GetAll(ctx)
@@ -69,6 +92,14 @@ func TestRestoreFromDump(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 123.456, gauges["Gauge"])
 	assert.Equal(t, int64(123), counters["Counter"])
+	assert.NotContains(t, gauges, "Deleted")
+
+	err = m.RestoreFromDump(ctx, strings.NewReader(`{"op":"set","type":"gauge","name":"Gauge","value":"x"}`))
+	assert.ErrorIs(t, err, ErrBadDump)
+	err = m.RestoreFromDump(ctx, strings.NewReader(`{"op":"set","type":"bad","name":"Gauge","value":1}`))
+	assert.ErrorIs(t, err, ErrBadDump)
+	err = m.RestoreFromDump(ctx, strings.NewReader(`{"op":"bad","type":"gauge","name":"Gauge"}`))
+	assert.ErrorIs(t, err, ErrBadDump)
 }
 
 func TestSetBatch(t *testing.T) {
@@ -80,14 +111,406 @@ func TestSetBatch(t *testing.T) {
 	err := m.SetBatch(ctx, []byte(batch))
 	assert.NoError(t, err)
 
-	assert.Equal(t, float64(343728), m.Gauges["Alloc"])
-	assert.Equal(t, float64(2490368), m.Gauges["HeapIdle"])
-	assert.Equal(t, float64(7166992), m.Gauges["Sys"])
-	assert.Equal(t, float64(46.487603307343086), m.Gauges["CPUutilization2"])
+	gauges, counters, err := m.GetAll(ctx)
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(343728), gauges["Alloc"])
+	assert.Equal(t, float64(2490368), gauges["HeapIdle"])
+	assert.Equal(t, float64(7166992), gauges["Sys"])
+	assert.Equal(t, float64(46.487603307343086), gauges["CPUutilization2"])
+
+	assert.Equal(t, int64(62), counters["PollCount"])
+}
+
+func TestIncrementCounter(t *testing.T) {
+	m := NewMemStorage()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				_ = m.IncrementCounter(ctx, "PollCount", 1)
+			}
+			_ = m.IncrementCounters(ctx, map[string]int64{"PollCount": 2, `PollCount{host="a"}`: 1})
+		}()
+	}
+	wg.Wait()
+
+	val, err := m.GetCounter(ctx, "PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100*100+100*2), val)
+
+	val, err = m.GetCounter(ctx, `PollCount{host="a"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), val)
+
+	// пакет прибавляет приращения к сохраненным значениям
+	err = m.SetBatch(ctx, []byte(`[{"id":"PollCount","type":"counter","delta":5},{"id":"PollCount","type":"counter","delta":5}]`))
+	assert.NoError(t, err)
+	val, _ = m.GetCounter(ctx, "PollCount")
+	assert.Equal(t, int64(10210), val)
+
+	samples, err := m.QueryRange(ctx, constants.Counter, "PollCount", time.Now().Add(-time.Minute), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, float64(10210), samples[len(samples)-1].Value)
+
+	// приращения из журнала
+	m = NewMemStorage()
+	err = m.RestoreFromDump(ctx, strings.NewReader(`{"op":"set","type":"counter","name":"PollCount","value":1}`+"\n"+`{"op":"add","type":"counter","name":"PollCount","value":2}`+"\n"+`{"op":"add","type":"counter","name":"New","value":3}`))
+	assert.NoError(t, err)
+	_, counters, _ := m.GetAll(ctx)
+	assert.Equal(t, map[string]int64{"PollCount": 3, "New": 3}, counters)
+
+	err = m.RestoreFromDump(ctx, strings.NewReader(`{"op":"add","type":"gauge","name":"Alloc","value":2}`))
+	assert.ErrorIs(t, err, ErrBadDump)
+}
+
+// Параллельные обновления и чтения всех серий: чтение возвращает копии, обновления не теряются
+func TestConcurrentAccess(t *testing.T) {
+	m := NewMemStorage()
+	ctx := context.Background()
+
+	const (
+		writers = 8
+		updates = 200
+	)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			host := fmt.Sprintf(`{"host":"h%d"}`, i)
+			batch := `[{"id":"Alloc","type":"gauge","value":1,"labels":` + host + `},{"id":"PollCount","type":"counter","delta":1,"labels":` + host + `},{"id":"PollCount","type":"counter","delta":1}]`
+
+			for j := 0; j < updates; j++ {
+				assert.NoError(t, m.SetBatch(ctx, []byte(batch)))
+				assert.NoError(t, m.IncrementCounter(ctx, "Total", 1))
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			gauges, counters, err := m.GetAll(ctx)
+			assert.NoError(t, err)
+
+			// полученные карты можно изменять и обходить без блокировок хранилища
+			for name := range gauges {
+				gauges[name]++
+			}
+			for name := range counters {
+				counters[name]++
+			}
+
+			assert.NoError(t, m.WriteDump(ctx, io.Discard))
+		}
+	}()
+
+	// ждем только писателей
+	for {
+		val, _ := m.GetCounter(ctx, "Total")
+		if val == writers*updates {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	gauges, counters, err := m.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, gauges, writers)
+	assert.Equal(t, int64(writers*updates), counters["PollCount"])
+	assert.Equal(t, int64(updates), counters[`PollCount{host="h0"}`])
+	assert.Equal(t, float64(1), gauges[`Alloc{host="h0"}`])
+}
+
+func TestSetBatchLabels(t *testing.T) {
+	m := NewMemStorage()
+	ctx := context.Background()
+
+	batch := `[{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"a"}},{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"b"}},{"id":"Alloc","type":"gauge","value":3}]`
+
+	err := m.SetBatch(ctx, []byte(batch))
+	assert.NoError(t, err)
+
+	val, err := m.GetGauge(ctx, `Alloc{host="a"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), val)
+
+	val, err = m.GetGauge(ctx, `Alloc{host="b"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), val)
+
+	val, err = m.GetGauge(ctx, "Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), val)
+
+	err = m.SetBatch(ctx, []byte(`[{"id":"Alloc","type":"gauge","value":1,"labels":{"bad name":"a"}}]`))
+	assert.ErrorIs(t, err, ErrBadLabels)
+
+	err = m.SetBatch(ctx, []byte(`[{"id":"Alloc{host=\"a\"}","type":"gauge","value":1}]`))
+	assert.ErrorIs(t, err, ErrBadLabels)
+}
+
+func TestQueryRange(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemStorage()
+	m.SetHistorySize(3)
+
+	from := time.Now().Add(-time.Minute)
+	for i := 1; i <= 4; i++ {
+		err := m.SetGauge(ctx, "Alloc", float64(i))
+		assert.NoError(t, err)
+	}
+	err := m.SetBatch(ctx, []byte(`[{"id":"PollCount","type":"counter","delta":7,"labels":{"host":"a"}}]`))
+	assert.NoError(t, err)
+	to := time.Now().Add(time.Minute)
+
+	samples, err := m.QueryRange(ctx, constants.Gauge, "Alloc", from, to)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 3)
+	assert.Equal(t, float64(2), samples[0].Value)
+	assert.Equal(t, float64(4), samples[2].Value)
+
+	samples, err = m.QueryRange(ctx, constants.Counter, `PollCount{host="a"}`, from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(7), samples[0].Value)
+
+	// другой тип - другая серия
+	samples, err = m.QueryRange(ctx, constants.Counter, "Alloc", from, to)
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+
+	// значения с заданным временем в порядке времени, текущим становится самое позднее
+	err = m.SetGaugeSamples(ctx, map[string][]Sample{`Free{host="a"}`: {{Timestamp: 3000, Value: 3}, {Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}})
+	assert.NoError(t, err)
+	samples, err = m.QueryRange(ctx, constants.Gauge, `Free{host="a"}`, time.UnixMilli(0), to)
+	assert.NoError(t, err)
+	assert.Equal(t, []Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}, {Timestamp: 3000, Value: 3}}, samples)
+	gauge, err := m.GetGauge(ctx, `Free{host="a"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), gauge)
+
+	// история выключена
+	m.SetHistorySize(0)
+	err = m.SetGauge(ctx, "Sys", 1)
+	assert.NoError(t, err)
+	samples, err = m.QueryRange(ctx, constants.Gauge, "Sys", from, to)
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestRollups(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemStorage()
+
+	err := m.AddRollups(ctx, time.Minute, constants.Gauge, "Alloc", []Rollup{{Timestamp: 120000, Count: 1}, {Timestamp: 60000, Count: 2}})
+	assert.NoError(t, err)
+	err = m.AddRollups(ctx, time.Minute, constants.Gauge, "Alloc", []Rollup{{Timestamp: 120000, Count: 3}})
+	assert.NoError(t, err)
+	err = m.AddRollups(ctx, time.Hour, constants.Gauge, "Alloc", []Rollup{{Timestamp: 3600000, Count: 4}})
+	assert.NoError(t, err)
+
+	rollups, err := m.QueryRollups(ctx, time.Minute, constants.Gauge, "Alloc", time.UnixMilli(0), time.UnixMilli(200000))
+	assert.NoError(t, err)
+	assert.Equal(t, []Rollup{{Timestamp: 60000, Count: 2}, {Timestamp: 120000, Count: 3}}, rollups)
+
+	err = m.PruneHistory(ctx, time.Minute, time.UnixMilli(100000))
+	assert.NoError(t, err)
+
+	rollups, err = m.QueryRollups(ctx, time.Minute, constants.Gauge, "Alloc", time.UnixMilli(0), time.UnixMilli(200000))
+	assert.NoError(t, err)
+	assert.Equal(t, []Rollup{{Timestamp: 120000, Count: 3}}, rollups)
+
+	rollups, err = m.QueryRollups(ctx, time.Hour, constants.Gauge, "Alloc", time.UnixMilli(0), time.UnixMilli(3600000))
+	assert.NoError(t, err)
+	assert.Len(t, rollups, 1)
+
+	// исходные значения
+	err = m.SetGauge(ctx, "Alloc", 1)
+	assert.NoError(t, err)
+
+	err = m.PruneHistory(ctx, 0, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	samples, _ := m.QueryRange(ctx, constants.Gauge, "Alloc", time.UnixMilli(0), time.Now().Add(time.Minute))
+	assert.Len(t, samples, 1)
+
+	err = m.PruneHistory(ctx, 0, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	samples, _ = m.QueryRange(ctx, constants.Gauge, "Alloc", time.UnixMilli(0), time.Now().Add(time.Minute))
+	assert.Empty(t, samples)
+}
+
+func TestDeleteSeries(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemStorage()
+
+	err := m.SetGauge(ctx, `Alloc{host="a"}`, 1)
+	assert.NoError(t, err)
+	err = m.SetCounter(ctx, "PollCount", 2)
+	assert.NoError(t, err)
+	err = m.AddHistogram(ctx, "latency", NewHistogram([]float64{1}))
+	assert.NoError(t, err)
+
+	series, err := m.ListSeries(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, series, 3)
+	for _, s := range series {
+		assert.WithinDuration(t, time.Now(), s.UpdatedAt, time.Minute)
+	}
+
+	// серия обновлялась позже - не удаляется
+	deleted, err := m.DeleteSeries(ctx, constants.Gauge, `Alloc{host="a"}`, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+
+	deleted, err = m.DeleteSeries(ctx, constants.Gauge, `Alloc{host="a"}`, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	_, err = m.GetGauge(ctx, `Alloc{host="a"}`)
+	assert.Error(t, err)
+
+	deleted, err = m.DeleteSeries(ctx, constants.Histogram, "latency", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	deleted, err = m.DeleteSeries(ctx, constants.Gauge, "Frees", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+
+	_, err = m.DeleteSeries(ctx, "bad", "PollCount", time.Now())
+	assert.Error(t, err)
 
-	assert.Equal(t, int64(62), m.Counters["PollCount"])
+	series, err = m.ListSeries(ctx)
+	assert.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Equal(t, "PollCount", series[0].Name)
+
+	// история удаленной серии удаляется по сроку хранения
+	err = m.PruneHistory(ctx, 0, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	for _, s := range m.shards {
+		assert.Empty(t, s.history)
+	}
 
-	//	fmt.Println(m.Gauges, res)
+	// восстановленные из дампа серии считаются обновленными при восстановлении
+	err = m.RestoreFromDump(ctx, strings.NewReader(`{"op":"set","type":"gauge","name":"Alloc","value":1}`))
+	assert.NoError(t, err)
+	series, err = m.ListSeries(ctx)
+	assert.NoError(t, err)
+	for _, s := range series {
+		assert.False(t, s.UpdatedAt.IsZero())
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	m := NewMemStorage()
+	ctx := context.Background()
+
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.5)
+	h.Observe(0.1)
+	err := m.AddHistogram(ctx, "Latency", h)
+	assert.NoError(t, err)
+
+	batch := `[{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[0,0,1],"sum":2,"count":1}},{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[0,1,0],"sum":0.5,"count":1}}]`
+	err = m.SetBatch(ctx, []byte(batch))
+	assert.NoError(t, err)
+
+	val, err := m.GetHistogram(ctx, "Latency")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 1}, val.Counts)
+	assert.Equal(t, uint64(4), val.Count)
+	assert.InDelta(t, 3.1, val.Sum, 1e-9)
+
+	// пакет с несовпадающими границами не сохраняется целиком
+	batch = `[{"id":"Alloc","type":"gauge","value":1},{"id":"Latency","type":"histogram","histogram":{"bounds":[1,2],"counts":[0,0,1],"sum":3,"count":1}}]`
+	err = m.SetBatch(ctx, []byte(batch))
+	assert.ErrorIs(t, err, ErrBadHistogram)
+	_, err = m.GetGauge(ctx, "Alloc")
+	assert.Error(t, err)
+
+	err = m.AddHistogram(ctx, "Latency", Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1}})
+	assert.ErrorIs(t, err, ErrBadHistogram)
+
+	var dump bytes.Buffer
+	err = m.WriteDump(ctx, &dump)
+	assert.NoError(t, err)
+
+	restored := NewMemStorage()
+	err = restored.RestoreFromDump(ctx, &dump)
+	assert.NoError(t, err)
+
+	histograms, err := restored.GetAllHistograms(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, val, histograms["Latency"])
+
+	_, err = m.GetHistogram(ctx, "nometric")
+	assert.Error(t, err)
+}
+
+func TestSummary(t *testing.T) {
+	m := NewMemStorage()
+	ctx := context.Background()
+
+	sm := NewSummary(0)
+	sm.Observe(1)
+	sm.Observe(2)
+	err := m.AddSummary(ctx, "Latency", sm)
+	assert.NoError(t, err)
+
+	// сырые наблюдения и готовый скетч в одном пакете
+	batch := `[{"id":"Latency","type":"summary","value":3},{"id":"Latency","type":"summary","summary":{"alpha":0.01,"zero":1,"sum":0,"count":1,"min":0,"max":0}}]`
+	err = m.SetBatch(ctx, []byte(batch))
+	assert.NoError(t, err)
+
+	val, err := m.GetSummary(ctx, "Latency")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), val.Count)
+	assert.Equal(t, float64(6), val.Sum)
+	assert.Equal(t, float64(0), val.Min)
+	assert.Equal(t, float64(3), val.Max)
+
+	batch = `[{"id":"Latency","type":"summary","summary":{"alpha":0.05,"zero":1,"count":1}}]`
+	err = m.SetBatch(ctx, []byte(batch))
+	assert.ErrorIs(t, err, ErrBadSummary)
+
+	batch = `[{"id":"Latency","type":"summary"}]`
+	err = m.SetBatch(ctx, []byte(batch))
+	assert.ErrorIs(t, err, ErrBadSummary)
+
+	var dump bytes.Buffer
+	err = m.WriteDump(ctx, &dump)
+	assert.NoError(t, err)
+
+	restored := NewMemStorage()
+	err = restored.RestoreFromDump(ctx, &dump)
+	assert.NoError(t, err)
+
+	summaries, err := restored.GetAllSummaries(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, val, summaries["Latency"])
+
+	_, err = m.GetSummary(ctx, "nometric")
+	assert.Error(t, err)
 }
 
 func TestNegative(t *testing.T) {
@@ -104,7 +527,7 @@ func TestNegative(t *testing.T) {
 	_, err = m.GetCounter(ctx, "nometric")
 	assert.Error(t, err)
 
-	err = m.RestoreFromDump(ctx, `["badid":"_Alloc"}]`)
+	err = m.RestoreFromDump(ctx, strings.NewReader(`["badid":"_Alloc"}]`))
 	assert.Error(t, err)
 
 	ok := m.DatabasePing(ctx)
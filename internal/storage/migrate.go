@@ -0,0 +1,383 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+// migrationFiles миграции схемы БД вида 0001_name.up.sql и 0001_name.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrBadMigration некорректные файлы миграций или неизвестная версия схемы
+var ErrBadMigration = errors.New("bad migration")
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration версия схемы БД: запросы перехода на нее (Up) и отката с нее (Down)
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus миграция и время ее применения (нулевое, если не применена)
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+// Applied применена ли миграция
+func (s MigrationStatus) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// Migrations встроенные миграции по возрастанию версии
+func Migrations() ([]Migration, error) {
+	return parseMigrations(migrationFiles, "migrations")
+}
+
+// parseMigrations миграции из каталога dir, у каждой версии должны быть оба файла
+func parseMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, e := range entries {
+		parts := migrationName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("%w: bad file name %q", ErrBadMigration, e.Name())
+		}
+
+		version, _ := strconv.Atoi(parts[1])
+		if version <= 0 {
+			return nil, fmt.Errorf("%w: bad version in %q", ErrBadMigration, e.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("%w: version %d has different names %q and %q", ErrBadMigration, version, m.Name, parts[2])
+		}
+
+		data, err := fs.ReadFile(fsys, dir+"/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if parts[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	if len(byVersion) == 0 {
+		return nil, fmt.Errorf("%w: no migrations in %s", ErrBadMigration, dir)
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%w: version %d needs both up and down", ErrBadMigration, m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// MigrateUp применение всех непримененных миграций
+func (p *PgStorage) MigrateUp(ctx context.Context) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	return p.migrate(ctx, migrations, migrations[len(migrations)-1].Version)
+}
+
+// MigrateDown откат steps последних примененных миграций
+func (p *PgStorage) MigrateDown(ctx context.Context, steps int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	return p.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			if _, ok := applied[migrations[i].Version]; !ok {
+				continue
+			}
+
+			err = revertMigration(ctx, conn, migrations[i])
+			if err != nil {
+				return err
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// MigrateTo переход на версию схемы version: применение миграций до нее и откат миграций после нее.
+// Версия 0 - откат всех миграций
+func (p *PgStorage) MigrateTo(ctx context.Context, version int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	return p.migrate(ctx, migrations, version)
+}
+
+// SchemaVersion наибольшая примененная версия схемы, 0 - миграции не применялись
+func (p *PgStorage) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+
+	err := p.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for v := range applied {
+			version = max(version, v)
+		}
+
+		return nil
+	})
+
+	return version, err
+}
+
+// MigrationsStatus все встроенные миграции с отметкой о применении
+func (p *PgStorage) MigrationsStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+
+	err = p.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			status = append(status, MigrationStatus{Migration: m, AppliedAt: applied[m.Version]})
+		}
+
+		return nil
+	})
+
+	return status, err
+}
+
+// migrate применение миграций до версии version и откат миграций после нее
+func (p *PgStorage) migrate(ctx context.Context, migrations []Migration, version int) error {
+	known := version == 0
+	for _, m := range migrations {
+		known = known || m.Version == version
+	}
+	if !known {
+		return fmt.Errorf("%w: unknown version %d", ErrBadMigration, version)
+	}
+
+	return p.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok || m.Version > version {
+				continue
+			}
+
+			err = applyMigration(ctx, conn, m)
+			if err != nil {
+				return err
+			}
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[migrations[i].Version]; !ok || migrations[i].Version <= version {
+				continue
+			}
+
+			err = revertMigration(ctx, conn, migrations[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// withMigrationLock выполнение f под сессионной advisory блокировкой, чтобы параллельно запущенные серверы
+// не применяли миграции одновременно. Таблица версий создается при необходимости
+func (p *PgStorage) withMigrationLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("PgStorage | migrate | Conn: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, constants.MigrationLockID)
+	if err != nil {
+		return fmt.Errorf("PgStorage | migrate | lock: %w", err)
+	}
+	defer func() {
+		// соединение возвращается в пул вместе с сессионной блокировкой,
+		// если снять ее не удалось - соединение закрывается, что снимает блокировку.
+		// Соединение пула pgx при закрытии в database/sql только возвращается в пул, поэтому закрывается явно
+		_, errUnlock := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, constants.MigrationLockID)
+		if errUnlock != nil {
+			_ = conn.Raw(func(dc any) error {
+				if c, ok := dc.(*stdlib.Conn); ok {
+					c.Conn().Close(context.Background())
+				}
+				return driver.ErrBadConn
+			})
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations
+			(
+			    version bigint PRIMARY KEY,
+			    name character varying(128) NOT NULL,
+			    applied_at timestamp with time zone NOT NULL
+			)`)
+	if err != nil {
+		return fmt.Errorf("PgStorage | migrate | schema_migrations: %w", err)
+	}
+
+	return f(conn)
+}
+
+// appliedMigrations примененные версии и время их применения
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | migrate | applied: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("PgStorage | migrate | applied: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | migrate | applied: %w", err)
+	}
+
+	return applied, nil
+}
+
+// applyMigration применение миграции вместе с записью версии в одной транзакции
+func applyMigration(ctx context.Context, conn *sql.Conn, m Migration) error {
+	return migrationTx(ctx, conn, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, m.Up)
+		if err != nil {
+			return fmt.Errorf("PgStorage | migrate | up %d_%s: %w", m.Version, m.Name, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now())`, m.Version, m.Name)
+		if err != nil {
+			return fmt.Errorf("PgStorage | migrate | up %d_%s: %w", m.Version, m.Name, err)
+		}
+
+		return nil
+	})
+}
+
+// revertMigration откат миграции вместе с удалением версии в одной транзакции
+func revertMigration(ctx context.Context, conn *sql.Conn, m Migration) error {
+	return migrationTx(ctx, conn, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, m.Down)
+		if err != nil {
+			return fmt.Errorf("PgStorage | migrate | down %d_%s: %w", m.Version, m.Name, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+		if err != nil {
+			return fmt.Errorf("PgStorage | migrate | down %d_%s: %w", m.Version, m.Name, err)
+		}
+
+		return nil
+	})
+}
+
+// migrationTx выполнение f в транзакции на соединении с блокировкой миграций
+func migrationTx(ctx context.Context, conn *sql.Conn, f func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("PgStorage | migrate | Begin: %w", err)
+	}
+
+	// долгие миграции не ограничиваются statement_timeout пула, действует только до конца транзакции
+	_, err = tx.ExecContext(ctx, `SET LOCAL statement_timeout = 0`)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("PgStorage | migrate | statement_timeout: %w", err)
+	}
+
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("PgStorage | migrate | Commit: %w", err)
+	}
+
+	return nil
+}
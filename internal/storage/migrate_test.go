@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	// версии идут подряд с первой
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func TestParseMigrations(t *testing.T) {
+	file := func(data string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(data)}
+	}
+
+	migrations, err := parseMigrations(fstest.MapFS{
+		"m/0002_b.up.sql":   file("up b"),
+		"m/0002_b.down.sql": file("down b"),
+		"m/0001_a.up.sql":   file("up a"),
+		"m/0001_a.down.sql": file("down a"),
+	}, "m")
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "a", Up: "up a", Down: "down a"},
+		{Version: 2, Name: "b", Up: "up b", Down: "down b"},
+	}, migrations)
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"no down":    {"m/0001_a.up.sql": file("up")},
+		"bad name":   {"m/0001_a.sql": file("up")},
+		"names":      {"m/0001_a.up.sql": file("up"), "m/0001_b.down.sql": file("down")},
+		"version 0":  {"m/0000_a.up.sql": file("up"), "m/0000_a.down.sql": file("down")},
+		"empty":      {"m": &fstest.MapFile{Mode: fs.ModeDir}},
+		"empty file": {"m/0001_a.up.sql": file(""), "m/0001_a.down.sql": file("down")},
+	} {
+		_, err = parseMigrations(fsys, "m")
+		assert.ErrorIs(t, err, ErrBadMigration, name)
+	}
+}
@@ -0,0 +1,14 @@
+-- исходная схема: gauge и counter с ключом по названию метрики
+CREATE TABLE IF NOT EXISTS gauges
+(
+    id character varying(64) PRIMARY KEY,
+    val double precision NOT NULL,
+    updated_at timestamp with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS counters
+(
+    id character varying(64) PRIMARY KEY,
+    val bigint NOT NULL,
+    updated_at timestamp with time zone NOT NULL
+);
@@ -0,0 +1,14 @@
+-- гистограммы и скетчи summary хранятся в jsonb
+CREATE TABLE IF NOT EXISTS histograms
+(
+    id character varying(64) PRIMARY KEY,
+    val jsonb NOT NULL,
+    updated_at timestamp with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS summaries
+(
+    id character varying(64) PRIMARY KEY,
+    val jsonb NOT NULL,
+    updated_at timestamp with time zone NOT NULL
+);
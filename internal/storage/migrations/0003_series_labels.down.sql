@@ -0,0 +1,14 @@
+-- серии с метками в ключ id не помещаются и удаляются
+DO $$
+DECLARE
+    t text;
+BEGIN
+    FOREACH t IN ARRAY ARRAY['gauges', 'counters', 'histograms', 'summaries'] LOOP
+        EXECUTE format('DELETE FROM %I WHERE labels <> ''{}''', t);
+        EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I', t, t || '_pkey');
+        EXECUTE format('ALTER TABLE %I DROP COLUMN labels', t);
+        EXECUTE format('ALTER TABLE %I RENAME COLUMN name TO id', t);
+        EXECUTE format('ALTER TABLE %I ADD PRIMARY KEY (id)', t);
+    END LOOP;
+END
+$$;
@@ -0,0 +1,18 @@
+-- ключ серии (name, labels) вместо id. Таблицы, уже созданные с метками, не изменяются
+DO $$
+DECLARE
+    t text;
+BEGIN
+    FOREACH t IN ARRAY ARRAY['gauges', 'counters', 'histograms', 'summaries'] LOOP
+        IF EXISTS (
+            SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = t AND column_name = 'id'
+        ) THEN
+            EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I', t, t || '_pkey');
+            EXECUTE format('ALTER TABLE %I RENAME COLUMN id TO name', t);
+            EXECUTE format('ALTER TABLE %I ADD COLUMN labels jsonb NOT NULL DEFAULT ''{}''', t);
+            EXECUTE format('ALTER TABLE %I ADD PRIMARY KEY (name, labels)', t);
+        END IF;
+    END LOOP;
+END
+$$;
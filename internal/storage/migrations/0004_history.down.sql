@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS rollups;
+DROP TABLE IF EXISTS samples;
@@ -0,0 +1,30 @@
+-- история значений gauge и counter (только добавление)
+CREATE TABLE IF NOT EXISTS samples
+(
+    mtype character varying(16) NOT NULL,
+    name character varying(64) NOT NULL,
+    labels jsonb NOT NULL DEFAULT '{}',
+    ts timestamp with time zone NOT NULL,
+    val double precision NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS samples_series_ts ON samples (mtype, name, labels, ts);
+
+-- по времени удаляется устаревшая история
+CREATE INDEX IF NOT EXISTS samples_ts ON samples (ts);
+
+-- агрегаты истории, resolution - разрешение в миллисекундах, ts - конец интервала
+CREATE TABLE IF NOT EXISTS rollups
+(
+    mtype character varying(16) NOT NULL,
+    name character varying(64) NOT NULL,
+    labels jsonb NOT NULL DEFAULT '{}',
+    resolution bigint NOT NULL,
+    ts timestamp with time zone NOT NULL,
+    cnt bigint NOT NULL,
+    sum double precision NOT NULL,
+    min double precision NOT NULL,
+    max double precision NOT NULL,
+    last double precision NOT NULL,
+    PRIMARY KEY (mtype, name, labels, resolution, ts)
+);
@@ -1,16 +1,25 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
 )
 
 // Тестирование на реальной базе Postgresql
@@ -19,32 +28,10 @@ func TestPostgres(t *testing.T) {
 
 	ctx := context.Background()
 
-	dbname := "users"
-	user := "user"
-	password := "password"
-
-	// 1. Start the postgres container
-	container, err := postgres.RunContainer(
-		ctx,
-		testcontainers.WithImage("docker.io/postgres:16-alpine"),
-		postgres.WithDatabase(dbname),
-		postgres.WithUsername(user),
-		postgres.WithPassword(password),
-		testcontainers.WithWaitStrategy(
-			wait.ForLog("database system is ready to accept connections").
-				WithOccurrence(2).
-				WithStartupTimeout(5*time.Second)),
-	)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	dsn, err := container.ConnectionString(ctx)
-	if err != nil {
-		t.Fatal(err)
-	}
+	dsn, terminate := runPostgres(ctx, t)
+	defer terminate()
 
-	pgs, err := NewPostgresqlStorage(dsn)
+	pgs, err := NewPostgresqlStorage(dsn, PgPoolConfig{StatementTimeout: time.Minute})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -96,6 +83,43 @@ func TestPostgres(t *testing.T) {
 
 	})
 
+	t.Run("Test PostgresqlIncrementCounter", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.ClearDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for j := 0; j < 50; j++ {
+					_ = pgs.IncrementCounter(ctx, "PollCount", 1)
+				}
+				_ = pgs.IncrementCounters(ctx, map[string]int64{"PollCount": 2, `PollCount{host="a"}`: 1})
+			}()
+		}
+		wg.Wait()
+
+		val, err2 := pgs.GetCounter(ctx, "PollCount")
+		assert.NoError(t, err2)
+		assert.Equal(t, int64(20*50+20*2), val)
+
+		val, err2 = pgs.GetCounter(ctx, `PollCount{host="a"}`)
+		assert.NoError(t, err2)
+		assert.Equal(t, int64(20), val)
+
+		// приращения из журнала при восстановлении
+		err = pgs.RestoreFromDump(ctx, strings.NewReader(`{"op":"set","type":"counter","name":"PollCount","value":1}`+"\n"+`{"op":"add","type":"counter","name":"PollCount","value":2}`))
+		assert.NoError(t, err)
+		val, err2 = pgs.GetCounter(ctx, "PollCount")
+		assert.NoError(t, err2)
+		assert.Equal(t, int64(3), val)
+	})
+
 	t.Run("Test PostgresqlSetBatch", func(t *testing.T) {
 		err = pgs.CreateDatabaseTables(ctx)
 		assert.NoError(t, err)
@@ -122,6 +146,343 @@ func TestPostgres(t *testing.T) {
 		assert.Equal(t, int64(62), valCounter)
 	})
 
+	t.Run("Test PostgresqlHistogram", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.ClearDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		h := NewHistogram([]float64{0.1, 1})
+		h.Observe(0.5)
+		err = pgs.AddHistogram(ctx, "Latency", h)
+		assert.NoError(t, err)
+
+		batch := `[{"id":"Latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,0,1],"sum":2.05,"count":2}}]`
+		err = pgs.SetBatch(ctx, []byte(batch))
+		assert.NoError(t, err)
+
+		val, err2 := pgs.GetHistogram(ctx, "Latency")
+		assert.NoError(t, err2)
+		assert.Equal(t, []uint64{1, 1, 1}, val.Counts)
+		assert.Equal(t, uint64(3), val.Count)
+		assert.InDelta(t, 2.55, val.Sum, 1e-9)
+
+		// другие границы корзин
+		err = pgs.AddHistogram(ctx, "Latency", NewHistogram([]float64{1, 2}))
+		assert.ErrorIs(t, err, ErrBadHistogram)
+
+		// пакет применяется целиком или не применяется вовсе: gauge не сохраняется вместе с ошибочной гистограммой
+		batch = `[{"id":"Partial","type":"gauge","value":1},{"id":"Latency","type":"histogram","histogram":{"bounds":[1,2],"counts":[0,0,0],"sum":0,"count":0}}]`
+		err = pgs.SetBatch(ctx, []byte(batch))
+		assert.ErrorIs(t, err, ErrBadHistogram)
+
+		_, err = pgs.GetGauge(ctx, "Partial")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		var dump bytes.Buffer
+		err = pgs.WriteDump(ctx, &dump)
+		assert.NoError(t, err)
+
+		pgs.ClearDatabaseTables(ctx)
+		err = pgs.RestoreFromDump(ctx, &dump)
+		assert.NoError(t, err)
+
+		histograms, err2 := pgs.GetAllHistograms(ctx)
+		assert.NoError(t, err2)
+		assert.Equal(t, uint64(3), histograms["Latency"].Count)
+	})
+
+	t.Run("Test PostgresqlSummary", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.ClearDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		sm := NewSummary(0)
+		sm.Observe(1)
+		err = pgs.AddSummary(ctx, "Latency", sm)
+		assert.NoError(t, err)
+
+		batch := `[{"id":"Latency","type":"summary","value":3},{"id":"Latency","type":"summary","value":2}]`
+		err = pgs.SetBatch(ctx, []byte(batch))
+		assert.NoError(t, err)
+
+		val, err2 := pgs.GetSummary(ctx, "Latency")
+		assert.NoError(t, err2)
+		assert.Equal(t, uint64(3), val.Count)
+		assert.InEpsilon(t, 2, val.Quantile(0.5), 0.01)
+
+		err = pgs.AddSummary(ctx, "Latency", NewSummary(0.05))
+		assert.ErrorIs(t, err, ErrBadSummary)
+
+		var dump bytes.Buffer
+		err = pgs.WriteDump(ctx, &dump)
+		assert.NoError(t, err)
+
+		pgs.ClearDatabaseTables(ctx)
+		err = pgs.RestoreFromDump(ctx, &dump)
+		assert.NoError(t, err)
+
+		summaries, err2 := pgs.GetAllSummaries(ctx)
+		assert.NoError(t, err2)
+		assert.Equal(t, uint64(3), summaries["Latency"].Count)
+	})
+
+	t.Run("Test PostgresqlLabels", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.ClearDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		batch := `[{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"a","env":"prod"}},{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"b"}},{"id":"PollCount","type":"counter","delta":3,"labels":{"host":"a"}},{"id":"PollCount","type":"counter","delta":4,"labels":{"host":"a"}}]`
+		err = pgs.SetBatch(ctx, []byte(batch))
+		assert.NoError(t, err)
+
+		err = pgs.SetGauge(ctx, "Alloc", 5)
+		assert.NoError(t, err)
+
+		val, err2 := pgs.GetGauge(ctx, `Alloc{env="prod",host="a"}`)
+		assert.NoError(t, err2)
+		assert.Equal(t, float64(1), val)
+
+		cnt, err2 := pgs.GetCounter(ctx, `PollCount{host="a"}`)
+		assert.NoError(t, err2)
+		assert.Equal(t, int64(7), cnt)
+
+		gauges, counters, err2 := pgs.GetAll(ctx)
+		assert.NoError(t, err2)
+		assert.Equal(t, map[string]float64{`Alloc{env="prod",host="a"}`: 1, `Alloc{host="b"}`: 2, "Alloc": 5}, gauges)
+		assert.Equal(t, map[string]int64{`PollCount{host="a"}`: 7}, counters)
+
+		var dump bytes.Buffer
+		err = pgs.WriteDump(ctx, &dump)
+		assert.NoError(t, err)
+
+		pgs.ClearDatabaseTables(ctx)
+		err = pgs.RestoreFromDump(ctx, &dump)
+		assert.NoError(t, err)
+
+		val, err2 = pgs.GetGauge(ctx, `Alloc{host="b"}`)
+		assert.NoError(t, err2)
+		assert.Equal(t, float64(2), val)
+	})
+
+	t.Run("Test PostgresqlQueryRange", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.ClearDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		from := time.Now().Add(-time.Minute)
+
+		err = pgs.SetGauge(ctx, "Alloc", 1)
+		assert.NoError(t, err)
+		err = pgs.SetGauge(ctx, "Alloc", 2)
+		assert.NoError(t, err)
+		err = pgs.SetCounter(ctx, `PollCount{host="a"}`, 3)
+		assert.NoError(t, err)
+		err = pgs.SetBatch(ctx, []byte(`[{"id":"PollCount","type":"counter","delta":4,"labels":{"host":"a"}},{"id":"Alloc","type":"gauge","value":5}]`))
+		assert.NoError(t, err)
+
+		to := time.Now().Add(time.Minute)
+
+		samples, err2 := pgs.QueryRange(ctx, constants.Gauge, "Alloc", from, to)
+		assert.NoError(t, err2)
+		assert.Len(t, samples, 3)
+		assert.Equal(t, float64(5), samples[2].Value)
+
+		// в историю попадает итоговое значение счетчика
+		samples, err2 = pgs.QueryRange(ctx, constants.Counter, `PollCount{host="a"}`, from, to)
+		assert.NoError(t, err2)
+		assert.Len(t, samples, 2)
+		assert.Equal(t, float64(7), samples[1].Value)
+
+		samples, err2 = pgs.QueryRange(ctx, constants.Gauge, "Alloc", to, to.Add(time.Minute))
+		assert.NoError(t, err2)
+		assert.Empty(t, samples)
+
+		// значения с заданным временем, текущим становится самое позднее
+		err = pgs.SetGaugeSamples(ctx, map[string][]Sample{`Free{host="a"}`: {{Timestamp: 2000, Value: 2}, {Timestamp: 1000, Value: 1}}})
+		assert.NoError(t, err)
+		samples, err2 = pgs.QueryRange(ctx, constants.Gauge, `Free{host="a"}`, time.UnixMilli(0), to)
+		assert.NoError(t, err2)
+		assert.Equal(t, []Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}, samples)
+		gauge, err2 := pgs.GetGauge(ctx, `Free{host="a"}`)
+		assert.NoError(t, err2)
+		assert.Equal(t, float64(2), gauge)
+	})
+
+	t.Run("Test PostgresqlRollups", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.ClearDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.AddRollups(ctx, time.Minute, constants.Gauge, `Alloc{host="a"}`, []Rollup{{Timestamp: 60000, Count: 2, Sum: 3, Min: 1, Max: 2, Last: 2}, {Timestamp: 120000, Count: 1, Sum: 5, Min: 5, Max: 5, Last: 5}})
+		assert.NoError(t, err)
+		err = pgs.AddRollups(ctx, time.Minute, constants.Gauge, `Alloc{host="a"}`, []Rollup{{Timestamp: 120000, Count: 2, Sum: 9, Min: 4, Max: 5, Last: 4}})
+		assert.NoError(t, err)
+
+		rollups, err2 := pgs.QueryRollups(ctx, time.Minute, constants.Gauge, `Alloc{host="a"}`, time.UnixMilli(0), time.UnixMilli(200000))
+		assert.NoError(t, err2)
+		assert.Equal(t, []Rollup{{Timestamp: 60000, Count: 2, Sum: 3, Min: 1, Max: 2, Last: 2}, {Timestamp: 120000, Count: 2, Sum: 9, Min: 4, Max: 5, Last: 4}}, rollups)
+
+		err = pgs.PruneHistory(ctx, time.Minute, time.UnixMilli(100000))
+		assert.NoError(t, err)
+
+		rollups, err2 = pgs.QueryRollups(ctx, time.Minute, constants.Gauge, `Alloc{host="a"}`, time.UnixMilli(0), time.UnixMilli(200000))
+		assert.NoError(t, err2)
+		assert.Len(t, rollups, 1)
+
+		err = pgs.SetGauge(ctx, "Alloc", 1)
+		assert.NoError(t, err)
+		err = pgs.PruneHistory(ctx, 0, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		samples, err2 := pgs.QueryRange(ctx, constants.Gauge, "Alloc", time.UnixMilli(0), time.Now().Add(time.Minute))
+		assert.NoError(t, err2)
+		assert.Empty(t, samples)
+	})
+
+	t.Run("Test PostgresqlDeleteSeries", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.ClearDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		err = pgs.SetGauge(ctx, `Alloc{host="a"}`, 1)
+		assert.NoError(t, err)
+		err = pgs.SetCounter(ctx, "PollCount", 2)
+		assert.NoError(t, err)
+
+		series, err2 := pgs.ListSeries(ctx)
+		assert.NoError(t, err2)
+		assert.Len(t, series, 2)
+
+		deleted, err2 := pgs.DeleteSeries(ctx, constants.Gauge, `Alloc{host="a"}`, time.Now().Add(-time.Hour))
+		assert.NoError(t, err2)
+		assert.False(t, deleted)
+
+		deleted, err2 = pgs.DeleteSeries(ctx, constants.Gauge, `Alloc{host="a"}`, time.Now().Add(time.Hour))
+		assert.NoError(t, err2)
+		assert.True(t, deleted)
+
+		series, err2 = pgs.ListSeries(ctx)
+		assert.NoError(t, err2)
+		assert.Len(t, series, 1)
+		assert.Equal(t, "PollCount", series[0].Name)
+	})
+
+	t.Run("Test PostgresqlMigrateSeriesKey", func(t *testing.T) {
+		err = pgs.DropDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		// таблица в формате до появления меток
+		err = pgs.retryExec(ctx, `CREATE TABLE gauges (id character varying(64) PRIMARY KEY, val double precision NOT NULL, updated_at timestamp with time zone NOT NULL)`)
+		assert.NoError(t, err)
+		err = pgs.retryExec(ctx, `INSERT INTO gauges (id, val, updated_at) VALUES ('Alloc', 1, now())`)
+		assert.NoError(t, err)
+
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		val, err2 := pgs.GetGauge(ctx, "Alloc")
+		assert.NoError(t, err2)
+		assert.Equal(t, float64(1), val)
+
+		err = pgs.SetGauge(ctx, `Alloc{host="a"}`, 2)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Test PostgresqlMigrations", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
+		err = pgs.DropDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		migrations, err2 := Migrations()
+		assert.NoError(t, err2)
+		latest := migrations[len(migrations)-1].Version
+
+		version, err2 := pgs.SchemaVersion(ctx)
+		assert.NoError(t, err2)
+		assert.Equal(t, 0, version)
+
+		// исходная схема без меток
+		err = pgs.MigrateTo(ctx, 1)
+		assert.NoError(t, err)
+		err = pgs.retryExec(ctx, `INSERT INTO gauges (id, val, updated_at) VALUES ('Alloc', 1, now())`)
+		assert.NoError(t, err)
+
+		err = pgs.MigrateTo(ctx, latest+1)
+		assert.ErrorIs(t, err, ErrBadMigration)
+
+		// параллельно запущенные серверы применяют миграции по очереди
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- pgs.MigrateUp(ctx)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for e := range errs {
+			assert.NoError(t, e)
+		}
+
+		version, err2 = pgs.SchemaVersion(ctx)
+		assert.NoError(t, err2)
+		assert.Equal(t, latest, version)
+
+		status, err2 := pgs.MigrationsStatus(ctx)
+		assert.NoError(t, err2)
+		assert.Len(t, status, len(migrations))
+		for _, st := range status {
+			assert.True(t, st.Applied())
+		}
+
+		val, err2 := pgs.GetGauge(ctx, "Alloc")
+		assert.NoError(t, err2)
+		assert.Equal(t, float64(1), val)
+
+		err = pgs.SetGauge(ctx, `Alloc{host="a"}`, 2)
+		assert.NoError(t, err)
+
+		// откат к схеме без меток удаляет серии с метками
+		err = pgs.MigrateTo(ctx, 2)
+		assert.NoError(t, err)
+		var count int
+		err = pgs.db.QueryRowContext(ctx, `SELECT count(*) FROM gauges WHERE id = 'Alloc'`).Scan(&count)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		err = pgs.MigrateDown(ctx, 1)
+		assert.NoError(t, err)
+		version, err2 = pgs.SchemaVersion(ctx)
+		assert.NoError(t, err2)
+		assert.Equal(t, 1, version)
+
+		err = pgs.MigrateTo(ctx, 0)
+		assert.NoError(t, err)
+		_, err2 = pgs.GetGauge(ctx, "Alloc")
+		assert.Error(t, err2)
+
+		// повторное применение всех миграций
+		err = pgs.MigrateUp(ctx)
+		assert.NoError(t, err)
+		err = pgs.SetGauge(ctx, `Alloc{host="a"}`, 2)
+		assert.NoError(t, err)
+	})
+
 	t.Run("Test PostgresqlGetAll", func(t *testing.T) {
 		err = pgs.CreateDatabaseTables(ctx)
 		assert.NoError(t, err)
@@ -167,27 +528,30 @@ func TestPostgres(t *testing.T) {
 
 	})
 
-	t.Run("Test PostgresqlGetDump", func(t *testing.T) {
+	t.Run("Test PostgresqlWriteDump", func(t *testing.T) {
 		err = pgs.CreateDatabaseTables(ctx)
 		assert.NoError(t, err)
 
 		pgs.ClearDatabaseTables(ctx)
 
-		dump, err2 := pgs.GetDump(ctx)
-		assert.NoError(t, err2)
-		assert.Equal(t, `{"gauges":{},"counters":{}}`, dump)
+		var dump bytes.Buffer
+		err = pgs.WriteDump(ctx, &dump)
+		assert.NoError(t, err)
+		assert.Empty(t, dump.String())
 
 		var testVal = 123.456
 		pgs.SetGauge(ctx, "test245", testVal)
-		dump, err = pgs.GetDump(ctx)
+		dump.Reset()
+		err = pgs.WriteDump(ctx, &dump)
 		assert.NoError(t, err)
-		assert.Equal(t, `{"gauges":{"test245":123.456},"counters":{}}`, dump)
+		assert.Equal(t, `{"op":"set","type":"gauge","name":"test245","value":123.456}`+"\n", dump.String())
 
 		var testCounter int64 = 123
 		_ = pgs.SetCounter(ctx, "test245", testCounter)
-		dump, err = pgs.GetDump(ctx)
+		dump.Reset()
+		err = pgs.WriteDump(ctx, &dump)
 		assert.NoError(t, err)
-		assert.Equal(t, `{"gauges":{"test245":123.456},"counters":{"test245":123}}`, dump)
+		assert.Equal(t, `{"op":"set","type":"gauge","name":"test245","value":123.456}`+"\n"+`{"op":"set","type":"counter","name":"test245","value":123}`+"\n", dump.String())
 
 	})
 
@@ -197,10 +561,15 @@ func TestPostgres(t *testing.T) {
 
 		pgs.ClearDatabaseTables(ctx)
 
-		dump := `{"gauges":{"test245":123.456},"counters":{"test245":123}}`
-		err2 := pgs.RestoreFromDump(ctx, dump)
+		dump := `{"op":"set","type":"gauge","name":"test245","value":123.456}` + "\n" + `{"op":"set","type":"counter","name":"test245","value":123}` + "\n" +
+			`{"op":"set","type":"gauge","name":"deleted","value":1}` + "\n" + `{"op":"delete","type":"gauge","name":"deleted"}` + "\n" +
+			`{"op":"extra","type":"silences","value":{}}` + "\n"
+		err2 := pgs.RestoreFromDump(ctx, strings.NewReader(dump))
 		assert.NoError(t, err2)
 
+		_, err3 := pgs.GetGauge(ctx, "deleted")
+		assert.Error(t, err3)
+
 		valGauge, err3 := pgs.GetGauge(ctx, "test245")
 		assert.NoError(t, err3)
 		assert.Equal(t, 123.456, valGauge)
@@ -218,16 +587,92 @@ func TestPostgres(t *testing.T) {
 		pgs.ClearDatabaseTables(ctx)
 
 		// некорректный json
-		dump := `{"gauges":{"test245":123.456},"counters":{"test245":123}`
-		err2 := pgs.RestoreFromDump(ctx, dump)
-		var e *json.SyntaxError
-		assert.ErrorAs(t, err2, &e)
+		dump := `{"op":"set","type":"gauge","name":"test245","value":123.456`
+		err2 := pgs.RestoreFromDump(ctx, strings.NewReader(dump))
+		assert.ErrorIs(t, err2, ErrBadDump)
+
+		dump = `{"op":"set","type":"counter","name":"test245","value":123.123}`
+		err = pgs.RestoreFromDump(ctx, strings.NewReader(dump))
+		assert.ErrorIs(t, err, ErrBadDump)
+
+	})
+
+	t.Run("Test PostgresqlSetBatchPool", func(t *testing.T) {
+		err = pgs.CreateDatabaseTables(ctx)
+		assert.NoError(t, err)
 
-		dump = `{"gauges":{"test245":123.5},"counters":{"test245":123.123}}`
-		err = pgs.RestoreFromDump(ctx, dump)
-		var eUnmarshal *json.UnmarshalTypeError
-		assert.ErrorAs(t, err, &eUnmarshal)
+		batch := `[{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"a"}},{"id":"PollCount","type":"counter","delta":2},` +
+			`{"id":"PollCount","type":"counter","delta":3},{"id":"Latency","type":"histogram","histogram":{"bounds":[1],"counts":[1,0],"sum":0.5,"count":1}}]`
+
+		// конвейер pgx.Batch и COPY во временные таблицы дают одинаковый результат
+		for _, threshold := range []int{constants.DBCopyThreshold, 1} {
+			err = pgs.ClearDatabaseTables(ctx)
+			assert.NoError(t, err)
+			pgs.copyThreshold = threshold
+
+			// второй пакет проверяет, что временные таблицы очищены после фиксации первого
+			for i := 0; i < 2; i++ {
+				err = pgs.SetBatch(ctx, []byte(batch))
+				assert.NoError(t, err)
+			}
+
+			valGauge, err2 := pgs.GetGauge(ctx, SeriesKey("Alloc", Labels{"host": "a"}))
+			assert.NoError(t, err2)
+			assert.Equal(t, float64(1), valGauge)
+
+			valCounter, err2 := pgs.GetCounter(ctx, "PollCount")
+			assert.NoError(t, err2)
+			assert.Equal(t, int64(10), valCounter)
+
+			samples, err2 := pgs.QueryRange(ctx, constants.Counter, "PollCount", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+			assert.NoError(t, err2)
+			assert.Len(t, samples, 2)
+
+			h, err2 := pgs.GetHistogram(ctx, "Latency")
+			assert.NoError(t, err2)
+			assert.Equal(t, uint64(2), h.Count)
+
+			// пакет применяется целиком или не применяется вовсе
+			err = pgs.SetBatch(ctx, []byte(`[{"id":"Partial","type":"gauge","value":1},`+
+				`{"id":"Latency","type":"histogram","histogram":{"bounds":[2],"counts":[0,0],"sum":0,"count":0}}]`))
+			assert.ErrorIs(t, err, ErrBadHistogram)
+
+			_, err = pgs.GetGauge(ctx, "Partial")
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+		}
+		pgs.copyThreshold = constants.DBCopyThreshold
+	})
 
+	t.Run("Test PostgresqlCacheNotify", func(t *testing.T) {
+		err = pgs.ClearDatabaseTables(ctx)
+		assert.NoError(t, err)
+
+		// второй сервер с той же БД
+		pgs2, err2 := OpenPostgresqlStorage(dsn, PgPoolConfig{})
+		require.NoError(t, err2)
+		defer pgs2.Close()
+
+		cfg := CacheConfig{Size: 100, TTL: time.Minute}
+		a := NewCachedStorage(pgs, cfg, pgs)
+		defer a.Close()
+		b := NewCachedStorage(pgs2, cfg, pgs2)
+		defer b.Close()
+		waitLive(t, a)
+		waitLive(t, b)
+
+		err = a.SetGauge(ctx, "Alloc", 1)
+		require.NoError(t, err)
+		val, err2 := b.GetGauge(ctx, "Alloc")
+		require.NoError(t, err2)
+		assert.Equal(t, float64(1), val)
+
+		// уведомление доставляется асинхронно
+		err = a.SetGauge(ctx, "Alloc", 2)
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			val, err2 = b.GetGauge(ctx, "Alloc")
+			return err2 == nil && val == 2
+		}, 5*time.Second, 10*time.Millisecond)
 	})
 
 	t.Run("Test PostgresqlRDatabasePing", func(t *testing.T) {
@@ -243,8 +688,156 @@ func TestPostgres(t *testing.T) {
 
 	})
 
-	if err = container.Terminate(ctx); err != nil {
-		t.Fatalf("failed to terminate container: %s", err)
+}
+
+// runPostgres запуск контейнера Postgresql, возвращает DSN и функцию остановки контейнера
+func runPostgres(ctx context.Context, tb testing.TB) (string, func()) {
+	dbname := "users"
+	user := "user"
+	password := "password"
+
+	// 1. Start the postgres container
+	container, err := postgres.RunContainer(
+		ctx,
+		testcontainers.WithImage("docker.io/postgres:16-alpine"),
+		postgres.WithDatabase(dbname),
+		postgres.WithUsername(user),
+		postgres.WithPassword(password),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(5*time.Second)),
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	dsn, err := container.ConnectionString(ctx)
+	if err != nil {
+		tb.Fatal(err)
 	}
 
+	return dsn, func() {
+		if err = container.Terminate(ctx); err != nil {
+			tb.Fatalf("failed to terminate container: %s", err)
+		}
+	}
+}
+
+// benchBatch пакет из size метрик, поровну gauge и counter
+func benchBatch(size int) []byte {
+	metrics := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		if i%2 == 0 {
+			metrics = append(metrics, fmt.Sprintf(`{"id":"Gauge%d","type":"gauge","value":%d.5}`, i, i))
+		} else {
+			metrics = append(metrics, fmt.Sprintf(`{"id":"Counter%d","type":"counter","delta":%d}`, i, i))
+		}
+	}
+
+	return []byte("[" + strings.Join(metrics, ",") + "]")
+}
+
+// setBatchSQL прежняя запись пакета gauge и counter через database/sql: один INSERT со множеством VALUES
+// на таблицу в одной транзакции. Оставлена только для сравнения в BenchmarkPostgresSetBatch
+func setBatchSQL(ctx context.Context, p *PgStorage, batch []byte) error {
+	var metrics []Metrics
+
+	err := json.Unmarshal(batch, &metrics)
+	if err != nil {
+		return err
+	}
+
+	gauges := make(map[string]Metrics)
+	counters := make(map[string]int64)
+	for _, mt := range metrics {
+		switch mt.MType {
+		case constants.Gauge:
+			gauges[mt.Key()] = mt
+		case constants.Counter:
+			counters[mt.Key()] += *mt.Delta
+		}
+	}
+
+	var (
+		gaugesKeyVal   []any
+		gaugeTemplates []string
+	)
+
+	for _, key := range seriesKeys(gauges) {
+		mt := gauges[key]
+		n := len(gaugesKeyVal)
+		gaugeTemplates = append(gaugeTemplates, fmt.Sprintf("($%d, $%d, $%d, now())", n+1, n+2, n+3))
+		gaugesKeyVal = append(gaugesKeyVal, mt.ID, labelsJSON(mt.Labels), mt.Value)
+	}
+
+	return p.retryTx(ctx, func(tx *sql.Tx) error {
+		if len(gaugeTemplates) > 0 {
+			_, errG := tx.ExecContext(ctx, `WITH upserted AS (
+				INSERT INTO gauges (name, labels, val, updated_at)
+				VALUES `+strings.Join(gaugeTemplates, ",")+`
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = EXCLUDED.val, updated_at = now()
+				RETURNING name, labels, val, updated_at
+			)
+			INSERT INTO samples (mtype, name, labels, ts, val)
+			SELECT 'gauge', name, labels, updated_at, val FROM upserted`, gaugesKeyVal...)
+			if errG != nil {
+				return fmt.Errorf("Upsert gauge: %w", errG)
+			}
+		}
+
+		if len(counters) > 0 {
+			query, args := incrementCountersQuery(counters)
+
+			_, errC := tx.ExecContext(ctx, query, args...)
+			if errC != nil {
+				return fmt.Errorf("Upsert counter: %w", errC)
+			}
+		}
+
+		return nil
+	})
+}
+
+// BenchmarkPostgresSetBatch сравнение записи пакетов: прежний INSERT со множеством VALUES через database/sql,
+// конвейер pgx.Batch и COPY во временные таблицы через пул pgx.
+// Запуск (нужен docker): go test -run '^$' -bench PostgresSetBatch ./internal/storage/
+func BenchmarkPostgresSetBatch(b *testing.B) {
+	ctx := context.Background()
+
+	dsn, terminate := runPostgres(ctx, b)
+	defer terminate()
+
+	pgs, err := NewPostgresqlStorage(dsn, PgPoolConfig{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pgs.Close()
+
+	for _, size := range []int{10, 100, 5000} {
+		batch := benchBatch(size)
+
+		for _, bc := range []struct {
+			name      string
+			threshold int
+			write     func() error
+		}{
+			{"sql", 0, func() error { return setBatchSQL(ctx, pgs, batch) }},
+			{"batch", math.MaxInt, func() error { return pgs.SetBatch(ctx, batch) }},
+			{"copy", 0, func() error { return pgs.SetBatch(ctx, batch) }},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", bc.name, size), func(b *testing.B) {
+				pgs.copyThreshold = bc.threshold
+
+				for i := 0; i < b.N; i++ {
+					err = bc.write()
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
 }
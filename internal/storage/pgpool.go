@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PgPoolConfig параметры пула соединений с БД, нулевые значения - по умолчанию
+type PgPoolConfig struct {
+	MaxConns         int           // размер пула, 0 - pool_max_conns из DSN или значение pgx по умолчанию
+	StatementTimeout time.Duration // statement_timeout сессий, 0 - без ограничения
+}
+
+// pgPool пул соединений pgx: транзакции записи пакетов и соединения для подписок
+type pgPool interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Acquire(ctx context.Context) (*pgxpool.Conn, error)
+	Close()
+}
+
+// newPool пул соединений pgx с параметрами cfg
+func newPool(dsn string, cfg PgPoolConfig) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxConns > 0 {
+		poolCfg.MaxConns = int32(cfg.MaxConns)
+	}
+
+	if cfg.StatementTimeout > 0 {
+		poolCfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+
+	// соединения устанавливаются при первом запросе
+	return pgxpool.NewWithConfig(context.Background(), poolCfg)
+}
+
+// rowScanner строка результата database/sql или pgx
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// txExecutor запросы внутри транзакции database/sql или pgx
+type txExecutor interface {
+	exec(ctx context.Context, query string, args ...any) error
+	queryRow(ctx context.Context, query string, args ...any) rowScanner
+}
+
+type sqlTx struct {
+	tx *sql.Tx
+}
+
+func (t sqlTx) exec(ctx context.Context, query string, args ...any) error {
+	_, err := t.tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (t sqlTx) queryRow(ctx context.Context, query string, args ...any) rowScanner {
+	return t.tx.QueryRowContext(ctx, query, args...)
+}
+
+type pgxTx struct {
+	tx pgx.Tx
+}
+
+func (t pgxTx) exec(ctx context.Context, query string, args ...any) error {
+	_, err := t.tx.Exec(ctx, query, args...)
+	return err
+}
+
+func (t pgxTx) queryRow(ctx context.Context, query string, args ...any) rowScanner {
+	return t.tx.QueryRow(ctx, query, args...)
+}
+
+// inPoolTx выполнение f в транзакции pgx
+func (p *PgStorage) inPoolTx(ctx context.Context, f func(tx pgx.Tx) error) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Begin: %w", err)
+	}
+
+	err = f(tx)
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errCommit, err)
+	}
+
+	return nil
+}
+
+// Запросы записи одной серии для конвейера pgx.Batch.
+// Подготовленные запросы кешируются соединением, разбор SQL выполняется один раз.
+const (
+	upsertGaugeQuery = `WITH upserted AS (
+				INSERT INTO gauges (name, labels, val, updated_at)
+				VALUES ($1, $2, $3, now())
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = EXCLUDED.val, updated_at = now()
+				RETURNING name, labels, val, updated_at
+			)
+			INSERT INTO samples (mtype, name, labels, ts, val)
+			SELECT 'gauge', name, labels, updated_at, val FROM upserted`
+
+	incrementCounterQuery = `WITH upserted AS (
+				INSERT INTO counters (name, labels, val, updated_at)
+				VALUES ($1, $2, $3, now())
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = counters.val + EXCLUDED.val, updated_at = now()
+				RETURNING name, labels, val, updated_at
+			)
+			INSERT INTO samples (mtype, name, labels, ts, val)
+			SELECT 'counter', name, labels, updated_at, val FROM upserted`
+)
+
+// Загрузка больших пакетов: COPY во временные таблицы и слияние одним запросом на таблицу.
+// Временные таблицы живут в сессии соединения пула и очищаются при фиксации транзакции.
+const (
+	stagingTablesQuery = `CREATE TEMP TABLE IF NOT EXISTS staging_gauges
+				(name text NOT NULL, labels jsonb NOT NULL, val double precision NOT NULL) ON COMMIT DELETE ROWS;
+			CREATE TEMP TABLE IF NOT EXISTS staging_counters
+				(name text NOT NULL, labels jsonb NOT NULL, val bigint NOT NULL) ON COMMIT DELETE ROWS`
+
+	mergeGaugesQuery = `WITH upserted AS (
+				INSERT INTO gauges (name, labels, val, updated_at)
+				SELECT name, labels, val, now() FROM staging_gauges ORDER BY name, labels
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = EXCLUDED.val, updated_at = now()
+				RETURNING name, labels, val, updated_at
+			)
+			INSERT INTO samples (mtype, name, labels, ts, val)
+			SELECT 'gauge', name, labels, updated_at, val FROM upserted`
+
+	mergeCountersQuery = `WITH upserted AS (
+				INSERT INTO counters (name, labels, val, updated_at)
+				SELECT name, labels, val, now() FROM staging_counters ORDER BY name, labels
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = counters.val + EXCLUDED.val, updated_at = now()
+				RETURNING name, labels, val, updated_at
+			)
+			INSERT INTO samples (mtype, name, labels, ts, val)
+			SELECT 'counter', name, labels, updated_at, val FROM upserted`
+)
+
+// setBatchPool запись подготовленного пакета через пул pgx в одной транзакции.
+// Пакеты от DBCopyThreshold строк загружаются через COPY, меньшие - конвейером запросов pgx.Batch
+func (p *PgStorage) setBatchPool(ctx context.Context, gauges map[string]Metrics, counters map[string]int64,
+	histograms map[string]Histogram, summaries map[string]Summary) error {
+	gaugeRows := make([][]any, 0, len(gauges))
+	for _, key := range seriesKeys(gauges) {
+		mt := gauges[key]
+		gaugeRows = append(gaugeRows, []any{mt.ID, labelsJSON(mt.Labels), mt.Value})
+	}
+
+	counterRows := make([][]any, 0, len(counters))
+	for _, key := range seriesKeys(counters) {
+		series, labels := seriesArgs(key)
+		counterRows = append(counterRows, []any{series, labels, counters[key]})
+	}
+
+	load := sendRows
+	if len(gaugeRows)+len(counterRows) >= p.copyThreshold {
+		load = copyRows
+	}
+
+	histogramKeys := seriesKeys(histograms)
+	summaryKeys := seriesKeys(summaries)
+
+	return p.retry(ctx, func() error {
+		return p.inPoolTx(ctx, func(tx pgx.Tx) error {
+			err := load(ctx, tx, gaugeRows, counterRows)
+			if err != nil {
+				return err
+			}
+
+			for _, name := range histogramKeys {
+				err = p.addHistogramTx(ctx, pgxTx{tx}, name, histograms[name])
+				if err != nil {
+					return fmt.Errorf("Upsert histogram: %w", err)
+				}
+			}
+
+			for _, name := range summaryKeys {
+				err = p.addSummaryTx(ctx, pgxTx{tx}, name, summaries[name])
+				if err != nil {
+					return fmt.Errorf("Upsert summary: %w", err)
+				}
+			}
+
+			return nil
+		})
+	})
+}
+
+// sendRows запись строк gauges и counters конвейером: все запросы уходят на сервер за один обмен
+func sendRows(ctx context.Context, tx pgx.Tx, gaugeRows, counterRows [][]any) error {
+	if len(gaugeRows)+len(counterRows) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, row := range gaugeRows {
+		batch.Queue(upsertGaugeQuery, row...)
+	}
+	for _, row := range counterRows {
+		batch.Queue(incrementCounterQuery, row...)
+	}
+
+	err := tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("SendBatch: %w", err)
+	}
+
+	return nil
+}
+
+// copyRows загрузка строк gauges и counters через COPY во временные таблицы и слияние с основными
+func copyRows(ctx context.Context, tx pgx.Tx, gaugeRows, counterRows [][]any) error {
+	_, err := tx.Exec(ctx, stagingTablesQuery)
+	if err != nil {
+		return fmt.Errorf("staging tables: %w", err)
+	}
+
+	for _, stage := range []struct {
+		table string
+		rows  [][]any
+		merge string
+	}{
+		{"staging_gauges", gaugeRows, mergeGaugesQuery},
+		{"staging_counters", counterRows, mergeCountersQuery},
+	} {
+		if len(stage.rows) == 0 {
+			continue
+		}
+
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{stage.table}, []string{"name", "labels", "val"}, pgx.CopyFromRows(stage.rows))
+		if err != nil {
+			return fmt.Errorf("CopyFrom %s: %w", stage.table, err)
+		}
+
+		_, err = tx.Exec(ctx, stage.merge)
+		if err != nil {
+			return fmt.Errorf("merge %s: %w", stage.table, err)
+		}
+	}
+
+	return nil
+}
+
+// Notify отправка уведомления payload в канал channel (NOTIFY)
+func (p *PgStorage) Notify(ctx context.Context, channel string, payload string) error {
+	_, err := p.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	if err != nil {
+		return fmt.Errorf("PgStorage | Notify: %w", err)
+	}
+
+	return nil
+}
+
+// Listen подписка на канал channel (LISTEN) отдельным соединением пула: после подписки вызывается ready,
+// на каждое уведомление - handle. Возвращает ошибку при потере соединения или отмене ctx
+func (p *PgStorage) Listen(ctx context.Context, channel string, ready func(), handle func(payload string)) error {
+	if p.pool == nil {
+		return errors.New("PgStorage | Listen: no connection pool")
+	}
+
+	pooled, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("PgStorage | Listen: %w", err)
+	}
+
+	// соединение с подпиской не возвращается в пул
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	if err != nil {
+		return fmt.Errorf("PgStorage | Listen: %w", err)
+	}
+	ready()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("PgStorage | Listen: %w", err)
+		}
+		handle(n.Payload)
+	}
+}
+
+// isNoRows пустой результат выборки database/sql или pgx
+func isNoRows(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || errors.Is(err, pgx.ErrNoRows)
+}
@@ -3,105 +3,157 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/stdlib"
 
 	"github.com/dnsoftware/go-metrics/internal/constants"
 	"github.com/dnsoftware/go-metrics/internal/logger"
 )
 
-// PgStorage работает с Postgresql базой данных
+// PgStorage работает с Postgresql базой данных.
+// Запросы идут через database/sql поверх пула pgx, пакеты метрик пишутся через пул напрямую
 type PgStorage struct {
-	db *sql.DB
+	db             *sql.DB
+	pool           pgPool          // пул pgx: запись пакетов и подписки на уведомления
+	copyThreshold  int             // с этого кол-ва строк пакет загружается через COPY
+	txRetryPeriods []time.Duration // паузы перед повторами транзакции
 }
 
-// Gauge для получения метрики counter из БД
-type Gauge struct {
-	name  string
-	value float64
-}
-
-// Counter для получения метрики counter из БД
-type Counter struct {
-	name  string
-	value int64
-}
-
-// DumpData карты gauges и counters для получения дампа БД
-type DumpData struct {
-	Gauges   map[string]float64 `json:"gauges"`
-	Counters map[string]int64   `json:"counters"`
-}
-
-func NewPostgresqlStorage(dsn string) (*PgStorage, error) {
+func NewPostgresqlStorage(dsn string, poolCfg PgPoolConfig) (*PgStorage, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), constants.DBContextTimeout)
 	defer cancel()
 
-	db, err := sql.Open("pgx", dsn)
+	ps, err := OpenPostgresqlStorage(dsn, poolCfg)
 	if err != nil {
-		logger.Log().Error(err.Error())
 		return nil, err
 	}
 
-	ps := &PgStorage{
-		db: db,
-	}
-
-	// создание таблиц, если не существуют
+	// создание таблиц и обновление схемы, если нужно
 	err = ps.CreateDatabaseTables(ctx)
 	if err != nil {
+		ps.Close()
 		return nil, err
 	}
 
 	return ps, nil
 }
 
-// CreateDatabaseTables формирование структуры БД
+// OpenPostgresqlStorage подключение к БД без изменения схемы (для управления миграциями)
+func OpenPostgresqlStorage(dsn string, poolCfg PgPoolConfig) (*PgStorage, error) {
+	pool, err := newPool(dsn, poolCfg)
+	if err != nil {
+		logger.Log().Error(err.Error())
+		return nil, err
+	}
+
+	return &PgStorage{
+		db:             stdlib.OpenDBFromPool(pool),
+		pool:           pool,
+		copyThreshold:  constants.DBCopyThreshold,
+		txRetryPeriods: parsePeriods(constants.DBAttemtPeriods),
+	}, nil
+}
+
+// parsePeriods разбор периодов повтора вида "1s,2s,5s", некорректные периоды пропускаются
+func parsePeriods(s string) []time.Duration {
+	var periods []time.Duration
+
+	for _, item := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(item))
+		if err == nil {
+			periods = append(periods, d)
+		}
+	}
+
+	return periods
+}
+
+// Close закрытие подключения к БД
+func (p *PgStorage) Close() error {
+	err := p.db.Close()
+	if p.pool != nil {
+		p.pool.Close()
+	}
+
+	return err
+}
+
+// CreateDatabaseTables формирование структуры БД применением всех непримененных миграций
 func (p *PgStorage) CreateDatabaseTables(ctx context.Context) error {
-	var query string
+	return p.MigrateUp(ctx)
+}
 
-	// gauges
-	query = `CREATE TABLE IF NOT EXISTS gauges
-			(
-			    id character varying(64) PRIMARY KEY,
-			    val double precision NOT NULL,
-			    updated_at timestamp with time zone NOT NULL
-			)`
+// seriesArgs название метрики и метки в виде json из ключа серии для подстановки в запрос
+func seriesArgs(key string) (string, string) {
+	name, labels := SplitSeriesKey(key)
 
-	err := p.retryExec(ctx, query)
+	return name, labelsJSON(labels)
+}
+
+// labelsJSON метки в виде json для колонки labels
+func labelsJSON(labels Labels) string {
+	if len(labels) == 0 {
+		return "{}"
+	}
+
+	// ключи карты json.Marshal сортирует, ошибки для карты строк быть не может
+	data, _ := json.Marshal(labels)
+
+	return string(data)
+}
+
+// seriesKey ключ серии из колонок name и labels
+func seriesKey(name string, labels []byte) (string, error) {
+	var l Labels
+
+	err := json.Unmarshal(labels, &l)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("labels: %w", err)
 	}
 
-	// counters
-	query = `CREATE TABLE IF NOT EXISTS counters
-			(
-			    id character varying(64) PRIMARY KEY,
-			    val bigint NOT NULL,
-			    updated_at timestamp with time zone NOT NULL
-			)`
+	return SeriesKey(name, l), nil
+}
 
-	err = p.retryExec(ctx, query)
+// DropDatabaseTables удаление таблиц из базы вместе с версиями схемы в одной транзакции,
+// отсутствующие таблицы пропускаются. Таблицы будут созданы миграциями заново
+func (p *PgStorage) DropDatabaseTables(ctx context.Context) error {
+	err := p.retryTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DROP TABLE IF EXISTS gauges, counters, histograms, summaries, samples, rollups`)
+		if err != nil {
+			return fmt.Errorf("Drop tables: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `DROP TABLE IF EXISTS schema_migrations`)
+		if err != nil {
+			return fmt.Errorf("Drop schema_migrations: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("PgStorage | DropDatabaseTables: %w", err)
 	}
 
 	return nil
 }
 
-// DropDatabaseTables удаление таблиц из базы
-func (p *PgStorage) DropDatabaseTables(ctx context.Context) error {
+// ClearDatabaseTables очистка таблиц базы.
+func (p *PgStorage) ClearDatabaseTables(ctx context.Context) error {
 	var query string
 
 	// gauges
-	query = `DROP TABLE gauges`
+	query = `TRUNCATE TABLE gauges`
 
 	err := p.retryExec(ctx, query)
 	if err != nil {
@@ -109,30 +161,39 @@ func (p *PgStorage) DropDatabaseTables(ctx context.Context) error {
 	}
 
 	// counters
-	query = `DROP TABLE counters`
+	query = `TRUNCATE TABLE counters`
 
 	err = p.retryExec(ctx, query)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	// histograms
+	query = `TRUNCATE TABLE histograms`
 
-// ClearDatabaseTables очистка таблиц базы.
-func (p *PgStorage) ClearDatabaseTables(ctx context.Context) error {
-	var query string
+	err = p.retryExec(ctx, query)
+	if err != nil {
+		return err
+	}
 
-	// gauges
-	query = `TRUNCATE TABLE gauges`
+	// summaries
+	query = `TRUNCATE TABLE summaries`
 
-	err := p.retryExec(ctx, query)
+	err = p.retryExec(ctx, query)
 	if err != nil {
 		return err
 	}
 
-	// counters
-	query = `TRUNCATE TABLE counters`
+	// samples
+	query = `TRUNCATE TABLE samples`
+
+	err = p.retryExec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	// rollups
+	query = `TRUNCATE TABLE rollups`
 
 	err = p.retryExec(ctx, query)
 	if err != nil {
@@ -175,13 +236,25 @@ func (p *PgStorage) retryExec(ctx context.Context, query string, args ...any) er
 // SetGauge сохранение метрики типа gauge в хранилище.
 // Параметры: name - название метрики, value - ее значение.
 func (p *PgStorage) SetGauge(ctx context.Context, name string, value float64) error {
-	query := `INSERT INTO gauges (id, val, updated_at)
-			VALUES ($1, $2, now())
-			ON CONFLICT (id)
-			DO UPDATE
-			SET id = $1, val = $2`
+	query := `WITH upserted AS (
+				INSERT INTO gauges (name, labels, val, updated_at)
+				VALUES ($1, $2, $3, now())
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = $3, updated_at = now()
+				RETURNING name, labels, val, updated_at
+			)
+			INSERT INTO samples (mtype, name, labels, ts, val)
+			SELECT 'gauge', name, labels, updated_at, val FROM upserted`
+
+	err := validateGauge(value)
+	if err != nil {
+		return fmt.Errorf("PgStorage | SetGauge: %w", err)
+	}
+
+	series, labels := seriesArgs(name)
 
-	err := p.retryExec(ctx, query, name, value)
+	err = p.retryExec(ctx, query, series, labels, value)
 	if err != nil {
 		return fmt.Errorf("PgStorage | SetGauge: %w", err)
 	}
@@ -189,11 +262,85 @@ func (p *PgStorage) SetGauge(ctx context.Context, name string, value float64) er
 	return nil
 }
 
+// SetGaugeSamples сохранение значений метрик типа gauge с заданным временем по сериям в одной транзакции:
+// все значения добавляются в историю, значение с наибольшим временем становится текущим.
+// Параметры: series - значения по ключам серий.
+func (p *PgStorage) SetGaugeSamples(ctx context.Context, series map[string][]Sample) error {
+	err := validateSamples(series)
+	if err != nil {
+		return fmt.Errorf("PgStorage | SetGaugeSamples: %w", err)
+	}
+
+	// серии пишутся в одном порядке, чтобы параллельные транзакции не блокировали друг друга
+	keys := make([]string, 0, len(series))
+	for key, samples := range series {
+		if len(samples) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+
+	err = p.retryTx(ctx, func(tx *sql.Tx) error {
+		for _, key := range keys {
+			err := setGaugeSamplesTx(ctx, tx, key, sortSamples(series[key]))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("PgStorage | SetGaugeSamples: %w", err)
+	}
+
+	return nil
+}
+
+// setGaugeSamplesTx сохранение упорядоченных по времени значений серии в транзакции
+func setGaugeSamplesTx(ctx context.Context, tx *sql.Tx, key string, samples []Sample) error {
+	series, labels := seriesArgs(key)
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO gauges (name, labels, val, updated_at)
+			VALUES ($1, $2, $3, now())
+			ON CONFLICT (name, labels)
+			DO UPDATE
+			SET val = $3, updated_at = now()`, series, labels, samples[len(samples)-1].Value)
+	if err != nil {
+		return fmt.Errorf("Upsert gauge %s: %w", key, err)
+	}
+
+	for start := 0; start < len(samples); start += constants.DBSamplesPerInsert {
+		chunk := samples[start:min(start+constants.DBSamplesPerInsert, len(samples))]
+
+		// $1-$2 общие для всех строк, далее по два значения на элемент истории
+		args := []any{series, labels}
+		templates := make([]string, 0, len(chunk))
+		for _, sm := range chunk {
+			n := len(args)
+			templates = append(templates, fmt.Sprintf("('gauge', $1, $2, $%d, $%d)", n+1, n+2))
+			args = append(args, time.UnixMilli(sm.Timestamp), sm.Value)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO samples (mtype, name, labels, ts, val)
+			VALUES `+strings.Join(templates, ","), args...)
+		if err != nil {
+			return fmt.Errorf("Insert samples %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
 // GetGauge получение значения метрики типа gauge из хранилища.
 // Параметры: name - название метрики.
 func (p *PgStorage) GetGauge(ctx context.Context, name string) (float64, error) {
-	query := `SELECT val FROM gauges WHERE id = $1`
-	row := p.db.QueryRowContext(ctx, query, name)
+	query := `SELECT val FROM gauges WHERE name = $1 AND labels = $2`
+	series, labels := seriesArgs(name)
+	row := p.db.QueryRowContext(ctx, query, series, labels)
 
 	var val float64
 
@@ -208,13 +355,20 @@ func (p *PgStorage) GetGauge(ctx context.Context, name string) (float64, error)
 // SetCounter сохранение метрики типа counter в хранилище.
 // Параметры: name - название метрики, value - ее значение.
 func (p *PgStorage) SetCounter(ctx context.Context, name string, value int64) error {
-	query := `INSERT INTO counters (id, val, updated_at)
-			VALUES ($1, $2, now())
-			ON CONFLICT (id)
-			DO UPDATE
-			SET id = $1, val = $2`
-
-	err := p.retryExec(ctx, query, name, value)
+	query := `WITH upserted AS (
+				INSERT INTO counters (name, labels, val, updated_at)
+				VALUES ($1, $2, $3, now())
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = $3, updated_at = now()
+				RETURNING name, labels, val, updated_at
+			)
+			INSERT INTO samples (mtype, name, labels, ts, val)
+			SELECT 'counter', name, labels, updated_at, val FROM upserted`
+
+	series, labels := seriesArgs(name)
+
+	err := p.retryExec(ctx, query, series, labels, value)
 	if err != nil {
 		return fmt.Errorf("PgStorage | SetCounter: %w", err)
 	}
@@ -222,11 +376,71 @@ func (p *PgStorage) SetCounter(ctx context.Context, name string, value int64) er
 	return nil
 }
 
+// IncrementCounter атомарное прибавление delta к метрике типа counter.
+// Если метрики еще нет - она создается со значением delta
+func (p *PgStorage) IncrementCounter(ctx context.Context, name string, delta int64) error {
+	err := p.IncrementCounters(ctx, map[string]int64{name: delta})
+	if err != nil {
+		return fmt.Errorf("PgStorage | IncrementCounter: %w", err)
+	}
+
+	return nil
+}
+
+// IncrementCounters атомарное прибавление приращений к нескольким метрикам типа counter одним запросом.
+// Строки обновляются в порядке ключей, чтобы параллельные пакеты не блокировали друг друга взаимно
+func (p *PgStorage) IncrementCounters(ctx context.Context, deltas map[string]int64) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	query, args := incrementCountersQuery(deltas)
+
+	err := p.retryExec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("PgStorage | IncrementCounters: %w", err)
+	}
+
+	return nil
+}
+
+// incrementCountersQuery запрос прибавления приращений к счетчикам с записью итоговых значений в историю
+func incrementCountersQuery(deltas map[string]int64) (string, []any) {
+	names := make([]string, 0, len(deltas))
+	for name := range deltas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	templates := make([]string, 0, len(names))
+	args := make([]any, 0, 3*len(names))
+	for i, name := range names {
+		series, labels := seriesArgs(name)
+
+		templates = append(templates, fmt.Sprintf("($%d, $%d, $%d, now())", 3*i+1, 3*i+2, 3*i+3))
+		args = append(args, series, labels, deltas[name])
+	}
+
+	query := `WITH upserted AS (
+				INSERT INTO counters (name, labels, val, updated_at)
+				VALUES ` + strings.Join(templates, ",") + `
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = counters.val + EXCLUDED.val, updated_at = now()
+				RETURNING name, labels, val, updated_at
+			)
+			INSERT INTO samples (mtype, name, labels, ts, val)
+			SELECT 'counter', name, labels, updated_at, val FROM upserted`
+
+	return query, args
+}
+
 // GetCounter получение значения метрики типа counter из хранилища.
 // Параметры: name - название метрики.
 func (p *PgStorage) GetCounter(ctx context.Context, name string) (int64, error) {
-	query := `SELECT val FROM counters WHERE id = $1`
-	row := p.db.QueryRowContext(ctx, query, name)
+	query := `SELECT val FROM counters WHERE name = $1 AND labels = $2`
+	series, labels := seriesArgs(name)
+	row := p.db.QueryRowContext(ctx, query, series, labels)
 
 	var val int64
 
@@ -238,6 +452,212 @@ func (p *PgStorage) GetCounter(ctx context.Context, name string) (int64, error)
 	return val, nil
 }
 
+// QueryRange история значений серии типа mType с from по to включительно в порядке времени.
+// Параметры: name - ключ серии.
+func (p *PgStorage) QueryRange(ctx context.Context, mType string, name string, from, to time.Time) ([]Sample, error) {
+	query := `SELECT ts, val FROM samples
+			WHERE mtype = $1 AND name = $2 AND labels = $3 AND ts >= $4 AND ts <= $5
+			ORDER BY ts`
+
+	series, labels := seriesArgs(name)
+
+	rows, err := p.db.QueryContext(ctx, query, mType, series, labels, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | QueryRange: %w", err)
+	}
+	defer rows.Close()
+
+	samples := make([]Sample, 0)
+	for rows.Next() {
+		var (
+			ts  time.Time
+			val float64
+		)
+
+		err = rows.Scan(&ts, &val)
+		if err != nil {
+			return nil, fmt.Errorf("PgStorage | QueryRange | rows.Scan: %w", err)
+		}
+
+		samples = append(samples, Sample{Timestamp: ts.UnixMilli(), Value: val})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | QueryRange | rows.Err: %w", err)
+	}
+
+	return samples, nil
+}
+
+// AddRollups сохранение агрегатов истории серии с разрешением resolution.
+// Агрегаты с тем же концом интервала перезаписываются
+func (p *PgStorage) AddRollups(ctx context.Context, resolution time.Duration, mType string, name string, rollups []Rollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	series, labels := seriesArgs(name)
+
+	// $1-$4 общие для всех строк, далее по шесть значений на агрегат
+	args := []any{mType, series, labels, resolution.Milliseconds()}
+	templates := make([]string, 0, len(rollups))
+	for _, r := range rollups {
+		n := len(args)
+		templates = append(templates, fmt.Sprintf("($1, $2, $3, $4, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+		args = append(args, time.UnixMilli(r.Timestamp), r.Count, r.Sum, r.Min, r.Max, r.Last)
+	}
+
+	query := `INSERT INTO rollups (mtype, name, labels, resolution, ts, cnt, sum, min, max, last)
+			VALUES ` + strings.Join(templates, ",") + `
+			ON CONFLICT (mtype, name, labels, resolution, ts)
+			DO UPDATE
+			SET cnt = EXCLUDED.cnt, sum = EXCLUDED.sum, min = EXCLUDED.min, max = EXCLUDED.max, last = EXCLUDED.last`
+
+	err := p.retryExec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("PgStorage | AddRollups: %w", err)
+	}
+
+	return nil
+}
+
+// QueryRollups агрегаты истории серии с разрешением resolution с from по to включительно в порядке времени
+func (p *PgStorage) QueryRollups(ctx context.Context, resolution time.Duration, mType string, name string, from, to time.Time) ([]Rollup, error) {
+	query := `SELECT ts, cnt, sum, min, max, last FROM rollups
+			WHERE mtype = $1 AND name = $2 AND labels = $3 AND resolution = $4 AND ts >= $5 AND ts <= $6
+			ORDER BY ts`
+
+	series, labels := seriesArgs(name)
+
+	rows, err := p.db.QueryContext(ctx, query, mType, series, labels, resolution.Milliseconds(), from, to)
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | QueryRollups: %w", err)
+	}
+	defer rows.Close()
+
+	rollups := make([]Rollup, 0)
+	for rows.Next() {
+		var (
+			ts time.Time
+			r  Rollup
+		)
+
+		err = rows.Scan(&ts, &r.Count, &r.Sum, &r.Min, &r.Max, &r.Last)
+		if err != nil {
+			return nil, fmt.Errorf("PgStorage | QueryRollups | rows.Scan: %w", err)
+		}
+		r.Timestamp = ts.UnixMilli()
+
+		rollups = append(rollups, r)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | QueryRollups | rows.Err: %w", err)
+	}
+
+	return rollups, nil
+}
+
+// PruneHistory удаление истории старше before: исходных значений (resolution 0) или агрегатов с разрешением resolution
+func (p *PgStorage) PruneHistory(ctx context.Context, resolution time.Duration, before time.Time) error {
+	var err error
+
+	if resolution == 0 {
+		err = p.retryExec(ctx, `DELETE FROM samples WHERE ts < $1`, before)
+	} else {
+		err = p.retryExec(ctx, `DELETE FROM rollups WHERE resolution = $1 AND ts < $2`, resolution.Milliseconds(), before)
+	}
+
+	if err != nil {
+		return fmt.Errorf("PgStorage | PruneHistory: %w", err)
+	}
+
+	return nil
+}
+
+// ListSeries все серии и время их последнего обновления
+func (p *PgStorage) ListSeries(ctx context.Context) ([]SeriesInfo, error) {
+	query := `SELECT 'gauge', name, labels, updated_at FROM gauges
+			UNION ALL SELECT 'counter', name, labels, updated_at FROM counters
+			UNION ALL SELECT 'histogram', name, labels, updated_at FROM histograms
+			UNION ALL SELECT 'summary', name, labels, updated_at FROM summaries`
+
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | ListSeries: %w", err)
+	}
+	defer rows.Close()
+
+	series := make([]SeriesInfo, 0)
+	for rows.Next() {
+		var (
+			info   SeriesInfo
+			labels []byte
+		)
+
+		err = rows.Scan(&info.MType, &info.Name, &labels, &info.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("PgStorage | ListSeries | rows.Scan: %w", err)
+		}
+
+		info.Name, err = seriesKey(info.Name, labels)
+		if err != nil {
+			return nil, fmt.Errorf("PgStorage | ListSeries | seriesKey: %w", err)
+		}
+
+		series = append(series, info)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | ListSeries | rows.Err: %w", err)
+	}
+
+	return series, nil
+}
+
+// DeleteSeries удаление серии типа mType, если она не обновлялась с момента updatedBefore.
+// Возвращает, была ли серия удалена. История значений серии удаляется по срокам хранения уровней
+func (p *PgStorage) DeleteSeries(ctx context.Context, mType string, name string, updatedBefore time.Time) (bool, error) {
+	table, err := seriesTable(mType)
+	if err != nil {
+		return false, fmt.Errorf("PgStorage | DeleteSeries: %w", err)
+	}
+
+	series, labels := seriesArgs(name)
+
+	res, err := p.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE name = $1 AND labels = $2 AND updated_at < $3`,
+		series, labels, updatedBefore)
+	if err != nil {
+		return false, fmt.Errorf("PgStorage | DeleteSeries: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("PgStorage | DeleteSeries | RowsAffected: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+// seriesTable таблица метрик типа mType
+func seriesTable(mType string) (string, error) {
+	switch mType {
+	case constants.Gauge:
+		return "gauges", nil
+	case constants.Counter:
+		return "counters", nil
+	case constants.Histogram:
+		return "histograms", nil
+	case constants.Summary:
+		return "summaries", nil
+	}
+
+	return "", errors.New("bad metric type")
+}
+
 // SetBatch сохраняет метрики в базу пакетом из нескольких штук
 func (p *PgStorage) SetBatch(ctx context.Context, batch []byte) error {
 	var metrics []Metrics
@@ -247,278 +667,638 @@ func (p *PgStorage) SetBatch(ctx context.Context, batch []byte) error {
 		return fmt.Errorf("PgStorage | SetBatch | json.Unmarshal: %w", err)
 	}
 
-	/* Логика нижеследующего кода (реализация одного запроса INSERT со множеством значений сразу):
-
-	Используется SQL запрос вида:
-		INSERT INTO gauges (id, val, updated_at)
-		VALUES ($1, $2, now()), ($3, $4, now()),...
-		ON CONFLICT (id)
-		DO UPDATE
-		SET val = EXCLUDED.val, updated_at = now()
-
-	параметры для подстановки имеют нумерацию по принципу:
-		$1, $2 - название метрики, значение метрики для первой записи
-		$3, $4 - название метрики, значение метрики для второй записи
-		...
+	// gauge одного пакета сводятся к последнему значению, counter - к сумме приращений:
+	// слияние из временной таблицы, как и INSERT со множеством VALUES, не может обновить строку дважды
+	// (ON CONFLICT DO UPDATE command cannot affect row a second time)
 
-	поэтому формируем срезы таких данных:
-		gaugesKeyVal - для gauges
-		countersKeyVal - для counters
-	для дальнейшей генерации SQL запроса:
-		p.db.ExecContext(ctx, query, gaugesKeyVal...)
-		p.db.ExecContext(ctx, query, countersKeyVal...)
-
-	далее вылез еще один момент:
-		при обновлении в одном пакете одной и той же записи в базе, код
-			ON CONFLICT (id)
-			DO UPDATE
-			SET val = EXCLUDED.val, updated_at = now()
-		дает ошибку
-			ERROR: ON CONFLICT DO UPDATE command cannot affect row a second time (SQLSTATE 21000)
-		то есть второй раз не может повлиять на запись
-		из-за этого не проходит автотест, с данными вида:
-			[{"id":"CounterBatchZip146","type":"counter","delta":35154714},
-			 {"id":"GaugeBatchZip188","type":"gauge","value":18032.255593532198},
-			 {"id":"CounterBatchZip146","type":"counter","delta":1872525169},
-			 {"id":"GaugeBatchZip188","type":"gauge","value":37453.22976261069}]
-
-	поэтому предварительно формируется карты метрик (data), куда записываются:
-		- последнее значение gauge метрики, так как она перезаписывает текущую
-		- сумма значений counter метрик, так как они прибавляются к текущему
-		что дает нам однократное обновление уникальных записей в запросе
-
-	может быть есть более изящное решение, но я его не придумал))
-	доклад закончил!)))
-	*/
-
-	// карта предварительно подготовленных метрик
+	// карта предварительно подготовленных метрик gauge и сумм приращений counter
 	data := make(map[string]Metrics)
+	counters := make(map[string]int64)
 
+	// гистограммы и summary одного пакета сливаются заранее, с сохраненными - в транзакции
+	histograms := make(map[string]Histogram)
+	summaries := make(map[string]Summary)
 	for _, mt := range metrics {
-		if mt.MType == constants.Gauge {
-			data[mt.ID] = mt
+		err = ValidateSeries(mt.ID, mt.Labels)
+		if err != nil {
+			return fmt.Errorf("PgStorage | SetBatch | metric %s: %w", mt.ID, err)
 		}
 
-		if mt.MType == constants.Counter {
-			if v, ok := data[mt.ID]; ok {
-				vd := *v.Delta + *mt.Delta
-				v.Delta = &vd
-				data[mt.ID] = v
+		switch mt.MType {
+		case constants.Histogram:
+			h, ok := histograms[mt.Key()]
 
-				continue
+			h, err = mergeHistogram(h, ok, mt)
+			if err != nil {
+				return fmt.Errorf("PgStorage | SetBatch: %w", err)
 			}
+			histograms[mt.Key()] = h
 
-			data[mt.ID] = mt
+		case constants.Summary:
+			sm, ok := summaries[mt.Key()]
+
+			sm, err = mergeSummary(sm, ok, mt)
+			if err != nil {
+				return fmt.Errorf("PgStorage | SetBatch: %w", err)
+			}
+			summaries[mt.Key()] = sm
 		}
 	}
 
-	var (
-		gaugesKeyVal     []any    // срез пар значений для подстановки в SQL запрос вставки/обновления gauges
-		countersKeyVal   []any    // срез пар значений для подстановки в SQL запрос вставки/обновления counters
-		gaugeTemplates   []string // срез для формирования фрагмента множественной вставки gauges
-		counterTemplates []string // срез для формирования фрагмента множественной вставки counters
-		g                int64    // счетчик цикла gauges
-		c                int64    // счетчик цикла counters
-	)
-
-	// формирование данных для генерации запроса множественной вставки
-	for _, mt := range data {
+	for _, mt := range metrics {
 		if mt.MType == constants.Gauge {
-			g++
-			val1 := g
-			g++
-			val2 := g
-			gaugeTemplates = append(gaugeTemplates, fmt.Sprintf("($%d, $%d, now())", val1, val2))
-			gaugesKeyVal = append(gaugesKeyVal, mt.ID, mt.Value)
+			data[mt.Key()] = mt
 		}
 
 		if mt.MType == constants.Counter {
-			c++
-			val1 := c
-			c++
-			val2 := c
-			counterTemplates = append(counterTemplates, fmt.Sprintf("($%d, $%d, now())", val1, val2))
-			countersKeyVal = append(countersKeyVal, mt.ID, mt.Delta)
+			counters[mt.Key()] += *mt.Delta
 		}
 	}
 
-	// старт транзакции
-	tx, err := p.db.Begin()
+	// весь пакет пишется в одной транзакции конвейером запросов или через COPY
+	err = p.setBatchPool(ctx, data, counters, histograms, summaries)
 	if err != nil {
-		return fmt.Errorf("PgStorage | SetBatch | p.db.Begin(): %w", err)
+		return fmt.Errorf("PgStorage | SetBatch: %w", err)
 	}
 
-	if len(gaugeTemplates) > 0 {
-		query := `INSERT INTO gauges (id, val, updated_at)
-			VALUES ` + strings.Join(gaugeTemplates, ",") + `
-			ON CONFLICT (id)
-			DO UPDATE
-			SET val = EXCLUDED.val, updated_at = now()`
+	return nil
+}
 
-		errR := p.retryExec(ctx, query, gaugesKeyVal...)
-		if errR != nil {
-			tx.Rollback()
-			return fmt.Errorf("PgStorage | SetBatch | Upsert gauge: %w", errR)
-		}
+// seriesKeys ключи серий карты по возрастанию
+func seriesKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	if len(counterTemplates) > 0 {
-		query := `INSERT INTO counters (id, val, updated_at)
-			VALUES ` + strings.Join(counterTemplates, ",") + `
-			ON CONFLICT (id)
-			DO UPDATE
-			SET val = counters.val + EXCLUDED.val, updated_at = now()`
+	return keys
+}
 
-		errR := p.retryExec(ctx, query, countersKeyVal...)
-		if errR != nil {
-			tx.Rollback()
-			return fmt.Errorf("PgStorage | SetBatch | Upsert counter: %w", errR)
-		}
+// AddHistogram прибавление гистограммы к сохраненной.
+// Если гистограммы еще нет - сохраняется переданная.
+func (p *PgStorage) AddHistogram(ctx context.Context, name string, value Histogram) error {
+	err := value.Validate()
+	if err != nil {
+		return fmt.Errorf("PgStorage | AddHistogram: %w", err)
 	}
 
-	// завершаем транзакцию
-	err = tx.Commit()
+	err = p.retryTx(ctx, func(tx *sql.Tx) error {
+		return p.addHistogramTx(ctx, sqlTx{tx}, name, value)
+	})
 	if err != nil {
-		return fmt.Errorf("PgStorage | SetBatch | Commit: %w", err)
+		return fmt.Errorf("PgStorage | AddHistogram: %w", err)
 	}
 
 	return nil
 }
 
-// GetAll возврат всех метрик (карт gauge и counters)
-func (p *PgStorage) GetAll(ctx context.Context) (map[string]float64, map[string]int64, error) {
-	// gauges
-	gRows, err := p.db.QueryContext(ctx, `SELECT id, val FROM gauges`)
+// SetHistogram сохранение гистограммы целиком вместо сохраненной
+func (p *PgStorage) SetHistogram(ctx context.Context, name string, value Histogram) error {
+	err := value.Validate()
+	if err == nil {
+		err = p.setJSON(ctx, "histograms", name, value)
+	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("PgStorage | GetAll | gauges: %w", err)
+		return fmt.Errorf("PgStorage | SetHistogram: %w", err)
 	}
-	defer gRows.Close()
 
-	dump := DumpData{
-		Gauges:   make(map[string]float64),
-		Counters: make(map[string]int64),
-	}
+	return nil
+}
+
+// addHistogramTx слияние гистограммы с сохраненной внутри транзакции
+func (p *PgStorage) addHistogramTx(ctx context.Context, tx txExecutor, name string, value Histogram) error {
+	return p.mergeJSONTx(ctx, tx, "histograms", name, func(saved []byte) (any, error) {
+		if saved == nil {
+			return value, nil
+		}
 
-	for gRows.Next() {
-		v := Gauge{}
+		var h Histogram
 
-		err = gRows.Scan(&v.name, &v.value)
+		err := json.Unmarshal(saved, &h)
 		if err != nil {
-			return nil, nil, fmt.Errorf("PgStorage | GetAll | gauges Next: %w", err)
+			return nil, err
 		}
 
-		dump.Gauges[v.name] = v.value
+		err = h.Merge(value)
+		if err != nil {
+			return nil, fmt.Errorf("histogram %s: %w", name, err)
+		}
+
+		return h, nil
+	})
+}
+
+// GetHistogram получение метрики типа histogram из хранилища.
+// Параметры: name - название метрики.
+func (p *PgStorage) GetHistogram(ctx context.Context, name string) (Histogram, error) {
+	var h Histogram
+
+	err := p.getJSON(ctx, "histograms", name, &h)
+	if err != nil {
+		return Histogram{}, fmt.Errorf("PgStorage | GetHistogram: %w", err)
+	}
+
+	return h, nil
+}
+
+// GetAllHistograms возврат карты всех гистограмм
+func (p *PgStorage) GetAllHistograms(ctx context.Context) (map[string]Histogram, error) {
+	histograms := make(map[string]Histogram)
+
+	err := p.getAllJSON(ctx, "histograms", func(name string, data []byte) error {
+		var h Histogram
+
+		err := json.Unmarshal(data, &h)
+		histograms[name] = h
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PgStorage | GetAllHistograms: %w", err)
 	}
 
-	err = gRows.Err()
+	return histograms, nil
+}
+
+// AddSummary прибавление скетча summary к сохраненному.
+// Если метрики еще нет - сохраняется переданный скетч.
+func (p *PgStorage) AddSummary(ctx context.Context, name string, value Summary) error {
+	err := value.Validate()
 	if err != nil {
-		return nil, nil, fmt.Errorf("PgStorage | GetAll | gauges Next during iteration: %w", err)
+		return fmt.Errorf("PgStorage | AddSummary: %w", err)
 	}
 
-	// counters
-	cRows, err := p.db.QueryContext(ctx, `SELECT id, val FROM counters`)
+	err = p.retryTx(ctx, func(tx *sql.Tx) error {
+		return p.addSummaryTx(ctx, sqlTx{tx}, name, value)
+	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("PgStorage | GetAll | counters: %w", err)
+		return fmt.Errorf("PgStorage | AddSummary: %w", err)
 	}
-	defer cRows.Close()
 
-	for cRows.Next() {
-		v := Counter{}
+	return nil
+}
+
+// SetSummary сохранение скетча summary целиком вместо сохраненного
+func (p *PgStorage) SetSummary(ctx context.Context, name string, value Summary) error {
+	err := value.Validate()
+	if err == nil {
+		err = p.setJSON(ctx, "summaries", name, value)
+	}
+	if err != nil {
+		return fmt.Errorf("PgStorage | SetSummary: %w", err)
+	}
 
-		err = cRows.Scan(&v.name, &v.value)
+	return nil
+}
+
+// addSummaryTx слияние скетча summary с сохраненным внутри транзакции
+func (p *PgStorage) addSummaryTx(ctx context.Context, tx txExecutor, name string, value Summary) error {
+	return p.mergeJSONTx(ctx, tx, "summaries", name, func(saved []byte) (any, error) {
+		if saved == nil {
+			return value, nil
+		}
+
+		var sm Summary
+
+		err := json.Unmarshal(saved, &sm)
 		if err != nil {
-			return nil, nil, fmt.Errorf("PgStorage | GetAll | counters Next: %w", err)
+			return nil, err
 		}
 
-		dump.Counters[v.name] = v.value
+		err = sm.Merge(value)
+		if err != nil {
+			return nil, fmt.Errorf("summary %s: %w", name, err)
+		}
+
+		return sm, nil
+	})
+}
+
+// GetSummary получение скетча метрики типа summary из хранилища.
+// Параметры: name - название метрики.
+func (p *PgStorage) GetSummary(ctx context.Context, name string) (Summary, error) {
+	var sm Summary
+
+	err := p.getJSON(ctx, "summaries", name, &sm)
+	if err != nil {
+		return Summary{}, fmt.Errorf("PgStorage | GetSummary: %w", err)
 	}
 
-	err = cRows.Err()
+	return sm, nil
+}
+
+// GetAllSummaries возврат карты всех скетчей summary
+func (p *PgStorage) GetAllSummaries(ctx context.Context) (map[string]Summary, error) {
+	summaries := make(map[string]Summary)
+
+	err := p.getAllJSON(ctx, "summaries", func(name string, data []byte) error {
+		var sm Summary
+
+		err := json.Unmarshal(data, &sm)
+		summaries[name] = sm
+
+		return err
+	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("PgStorage | GetAll | counters Next during iteration: %w", err)
+		return nil, fmt.Errorf("PgStorage | GetAllSummaries: %w", err)
 	}
 
-	return dump.Gauges, dump.Counters, nil
+	return summaries, nil
 }
 
-// GetDump получение json дампа БД
-func (p *PgStorage) GetDump(ctx context.Context) (string, error) {
-	dump := DumpData{}
+// inTx выполнение f в транзакции
+func (p *PgStorage) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Begin: %w", err)
+	}
 
-	var err error
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	dump.Gauges, dump.Counters, err = p.GetAll(ctx)
+	err = tx.Commit()
 	if err != nil {
-		return "", fmt.Errorf("PgStorage | GetDump | GetAll: %w", err)
+		return fmt.Errorf("%w: %w", errCommit, err)
+	}
+
+	return nil
+}
+
+// errCommit ошибка фиксации транзакции: неизвестно, применилась ли она, поэтому повторять ее нельзя
+var errCommit = errors.New("Commit")
+
+// retryTx выполнение f в транзакции, при ошибке сериализации или потере соединения
+// транзакция откатывается и выполняется заново целиком после паузы из txRetryPeriods
+func (p *PgStorage) retryTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	return p.retry(ctx, func() error {
+		return p.inTx(ctx, f)
+	})
+}
+
+// retry выполнение транзакции attempt с повторами после пауз из txRetryPeriods, пока ошибка допускает повтор
+func (p *PgStorage) retry(ctx context.Context, attempt func() error) error {
+	err := attempt()
+
+	for _, d := range p.txRetryPeriods {
+		if !retriableTxError(err) {
+			break
+		}
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return fmt.Errorf("retryTx: %w (%w)", ctx.Err(), err)
+		}
+
+		err = attempt()
 	}
 
-	data, err := json.Marshal(dump)
 	if err != nil {
-		return "", fmt.Errorf("PgStorage | GetDump | json.Marshal: %w", err)
+		return fmt.Errorf("retryTx: %w", err)
+	}
+
+	return nil
+}
+
+// retriableTxError можно ли повторить транзакцию, завершившуюся ошибкой err
+func retriableTxError(err error) bool {
+	if err == nil || errors.Is(err, errCommit) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgerrcode.SerializationFailure ||
+			pgErr.Code == pgerrcode.DeadlockDetected ||
+			pgerrcode.IsConnectionException(pgErr.Code)
 	}
 
-	return string(data), nil
+	var netErr net.Error
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.SafeToRetry(err) ||
+		errors.As(err, &netErr)
 }
 
-// RestoreFromDump восстановление БД из json дампа
-func (p *PgStorage) RestoreFromDump(ctx context.Context, dump string) error {
-	data := DumpData{}
+// mergeJSONTx слияние значения, хранящегося в jsonb колонке val таблицы table, внутри транзакции.
+// merge получает сохраненное значение (nil, если записи нет) и возвращает новое.
+// Строка блокируется на время слияния, чтобы параллельные обновления не потерялись.
+func (p *PgStorage) mergeJSONTx(ctx context.Context, tx txExecutor, table string, name string, merge func(saved []byte) (any, error)) error {
+	var saved []byte
+
+	series, labels := seriesArgs(name)
 
-	err := json.Unmarshal([]byte(dump), &data)
+	err := tx.queryRow(ctx, `SELECT val FROM `+table+` WHERE name = $1 AND labels = $2 FOR UPDATE`, series, labels).Scan(&saved)
+	if err != nil && !isNoRows(err) {
+		return fmt.Errorf("select %s: %w", table, err)
+	}
+
+	value, err := merge(saved)
 	if err != nil {
-		return fmt.Errorf("PgStorage | RestoreFromDump | json.Unmarshal: %w", err)
+		return err
 	}
 
-	// старт транзакции
-	tx, err := p.db.Begin()
+	data, err := json.Marshal(value)
 	if err != nil {
-		return fmt.Errorf("PgStorage | RestoreFromDump | Tx begin: %w", err)
+		return fmt.Errorf("json.Marshal: %w", err)
 	}
 
-	queryDel := `TRUNCATE gauges, counters`
+	err = tx.exec(ctx, `INSERT INTO `+table+` (name, labels, val, updated_at)
+			VALUES ($1, $2, $3, now())
+			ON CONFLICT (name, labels)
+			DO UPDATE
+			SET val = EXCLUDED.val, updated_at = now()`, series, labels, string(data))
+	if err != nil {
+		return fmt.Errorf("upsert %s: %w", table, err)
+	}
 
-	err = p.retryExec(ctx, queryDel)
+	return nil
+}
+
+// setJSON запись значения в jsonb колонку val таблицы table вместо сохраненного
+func (p *PgStorage) setJSON(ctx context.Context, table string, name string, value any) error {
+	data, err := json.Marshal(value)
 	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("PgStorage | RestoreFromDump | Truncate: %w", err)
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	series, labels := seriesArgs(name)
+
+	return p.retryExec(ctx, `INSERT INTO `+table+` (name, labels, val, updated_at)
+			VALUES ($1, $2, $3, now())
+			ON CONFLICT (name, labels)
+			DO UPDATE
+			SET val = EXCLUDED.val, updated_at = now()`, series, labels, string(data))
+}
+
+// getJSON получение значения из jsonb колонки val таблицы table
+func (p *PgStorage) getJSON(ctx context.Context, table string, name string, dest any) error {
+	var data []byte
+
+	series, labels := seriesArgs(name)
+
+	err := p.db.QueryRowContext(ctx, `SELECT val FROM `+table+` WHERE name = $1 AND labels = $2`, series, labels).Scan(&data)
+	if err != nil {
+		return err
 	}
 
-	stmt, err := p.db.Prepare(`INSERT INTO gauges (id, val, updated_at)
-			VALUES ($1, $2, now())`)
+	err = json.Unmarshal(data, dest)
 	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("PgStorage | RestoreFromDump | Prepare gauges: %w", err)
+		return fmt.Errorf("json.Unmarshal: %w", err)
 	}
-	defer stmt.Close()
 
-	for name, val := range data.Gauges {
-		_, err = stmt.ExecContext(ctx, name, val)
+	return nil
+}
+
+// getAllJSON обход всех записей таблицы table с jsonb колонкой val
+func (p *PgStorage) getAllJSON(ctx context.Context, table string, f func(name string, data []byte) error) error {
+	var data []byte
+
+	return p.scanAll(ctx, table, &data, func(name string) error {
+		return f(name, data)
+	})
+}
+
+// scanAll обход всех записей таблицы table: значение колонки val считывается в val, затем вызывается f
+func (p *PgStorage) scanAll(ctx context.Context, table string, val any, f func(name string) error) error {
+	rows, err := p.db.QueryContext(ctx, `SELECT name, labels, val FROM `+table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name   string
+			labels []byte
+		)
+
+		err = rows.Scan(&name, &labels, val)
 		if err != nil {
-			tx.Rollback()
-			return fmt.Errorf("PgStorage | RestoreFromDump | Insert gauge: %w", err)
+			return fmt.Errorf("Next: %w", err)
+		}
+
+		name, err = seriesKey(name, labels)
+		if err != nil {
+			return fmt.Errorf("Next: %w", err)
+		}
+
+		err = f(name)
+		if err != nil {
+			return fmt.Errorf("series %s: %w", name, err)
 		}
 	}
 
-	stmt, err = p.db.Prepare(`INSERT INTO counters (id, val, updated_at)
-			VALUES ($1, $2, now())`)
+	err = rows.Err()
 	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("PgStorage | RestoreFromDump | Prepare counters: %w", err)
+		return fmt.Errorf("Next during iteration: %w", err)
 	}
-	defer stmt.Close()
 
-	for name, val := range data.Counters {
-		_, err = stmt.ExecContext(ctx, name, val)
+	return nil
+}
+
+// GetAll возврат всех метрик (карт gauge и counters)
+func (p *PgStorage) GetAll(ctx context.Context) (map[string]float64, map[string]int64, error) {
+	var (
+		gauge   float64
+		counter int64
+	)
+
+	gauges := make(map[string]float64)
+
+	err := p.scanAll(ctx, "gauges", &gauge, func(name string) error {
+		gauges[name] = gauge
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("PgStorage | GetAll | gauges: %w", err)
+	}
+
+	counters := make(map[string]int64)
+
+	err = p.scanAll(ctx, "counters", &counter, func(name string) error {
+		counters[name] = counter
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("PgStorage | GetAll | counters: %w", err)
+	}
+
+	return gauges, counters, nil
+}
+
+// WriteDump запись дампа БД в w в формате NDJSON. Таблицы читаются построчно, без загрузки в память целиком
+func (p *PgStorage) WriteDump(ctx context.Context, w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	for _, mType := range []string{constants.Gauge, constants.Counter, constants.Histogram, constants.Summary} {
+		table, err := seriesTable(mType)
 		if err != nil {
-			tx.Rollback()
-			return fmt.Errorf("PgStorage | RestoreFromDump | Insert counter: %w", err)
+			return fmt.Errorf("PgStorage | WriteDump: %w", err)
+		}
+
+		// значение val в текстовом виде - json число или jsonb документ
+		err = p.getAllJSON(ctx, table, func(name string, data []byte) error {
+			return enc.Encode(DumpRecord{Op: OpSet, MType: mType, Name: name, Value: data})
+		})
+		if err != nil {
+			return fmt.Errorf("PgStorage | WriteDump | %s: %w", table, err)
 		}
 	}
 
-	// завершаем транзакцию
-	err = tx.Commit()
+	return nil
+}
+
+// RestoreFromDump восстановление БД из дампа в формате NDJSON в одной транзакции.
+// Транзакция повторяется, только если дамп можно перечитать с начала (r реализует io.Seeker)
+func (p *PgStorage) RestoreFromDump(ctx context.Context, r io.Reader) error {
+	run := p.inTx
+	if _, ok := r.(io.Seeker); ok {
+		run = p.retryTx
+	}
+
+	err := run(ctx, func(tx *sql.Tx) error {
+		if seeker, ok := r.(io.Seeker); ok {
+			_, err := seeker.Seek(0, io.SeekStart)
+			if err != nil {
+				return fmt.Errorf("Seek: %w", err)
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `TRUNCATE gauges, counters, histograms, summaries`)
+		if err != nil {
+			return fmt.Errorf("Truncate: %w", err)
+		}
+
+		// подготовленные запросы по таблицам, создаются при первой записи
+		upserts := make(map[string]*sql.Stmt)
+		deletes := make(map[string]*sql.Stmt)
+		adds := make(map[string]*sql.Stmt)
+		defer func() {
+			for _, stmts := range []map[string]*sql.Stmt{upserts, deletes, adds} {
+				for _, stmt := range stmts {
+					stmt.Close()
+				}
+			}
+		}()
+
+		prepare := func(stmts map[string]*sql.Stmt, table string, query string) (*sql.Stmt, error) {
+			if stmt, ok := stmts[table]; ok {
+				return stmt, nil
+			}
+
+			stmt, err := tx.PrepareContext(ctx, query)
+			if err != nil {
+				return nil, fmt.Errorf("Prepare %s: %w", table, err)
+			}
+			stmts[table] = stmt
+
+			return stmt, nil
+		}
+
+		return DecodeDump(r, func(rec DumpRecord) error {
+			if rec.Op == OpExtra {
+				return nil
+			}
+
+			table, err := seriesTable(rec.MType)
+			if err != nil {
+				return fmt.Errorf("%w: bad metric type %q", ErrBadDump, rec.MType)
+			}
+
+			series, labels := seriesArgs(rec.Name)
+
+			switch rec.Op {
+			case OpDelete:
+				stmt, err := prepare(deletes, table, `DELETE FROM `+table+` WHERE name = $1 AND labels = $2`)
+				if err != nil {
+					return err
+				}
+
+				_, err = stmt.ExecContext(ctx, series, labels)
+				if err != nil {
+					return fmt.Errorf("Delete %s: %w", rec.MType, err)
+				}
+
+				return nil
+			case OpAdd:
+				if rec.MType != constants.Counter {
+					return fmt.Errorf("%w: bad operation %q for %s", ErrBadDump, rec.Op, rec.MType)
+				}
+
+				var delta int64
+
+				err = json.Unmarshal(rec.Value, &delta)
+				if err != nil {
+					return fmt.Errorf("%w: %s %s: %s", ErrBadDump, rec.MType, rec.Name, err.Error())
+				}
+
+				stmt, err := prepare(adds, table, `INSERT INTO counters (name, labels, val, updated_at)
+					VALUES ($1, $2, $3, now())
+					ON CONFLICT (name, labels)
+					DO UPDATE
+					SET val = counters.val + $3, updated_at = now()`)
+				if err != nil {
+					return err
+				}
+
+				_, err = stmt.ExecContext(ctx, series, labels, delta)
+				if err != nil {
+					return fmt.Errorf("Add %s: %w", rec.MType, err)
+				}
+
+				return nil
+			case OpSet:
+			default:
+				return fmt.Errorf("%w: bad operation %q", ErrBadDump, rec.Op)
+			}
+
+			var val any
+
+			switch rec.MType {
+			case constants.Gauge:
+				var v float64
+				err = json.Unmarshal(rec.Value, &v)
+				val = v
+			case constants.Counter:
+				var v int64
+				err = json.Unmarshal(rec.Value, &v)
+				val = v
+			case constants.Histogram:
+				err = json.Unmarshal(rec.Value, &Histogram{})
+				val = string(rec.Value)
+			case constants.Summary:
+				err = json.Unmarshal(rec.Value, &Summary{})
+				val = string(rec.Value)
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %s %s: %s", ErrBadDump, rec.MType, rec.Name, err.Error())
+			}
+
+			stmt, err := prepare(upserts, table, `INSERT INTO `+table+` (name, labels, val, updated_at)
+				VALUES ($1, $2, $3, now())
+				ON CONFLICT (name, labels)
+				DO UPDATE
+				SET val = $3, updated_at = now()`)
+			if err != nil {
+				return err
+			}
+
+			_, err = stmt.ExecContext(ctx, series, labels, val)
+			if err != nil {
+				return fmt.Errorf("Insert %s: %w", rec.MType, err)
+			}
+
+			return nil
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("PgStorage | RestoreFromDump | Commit: %w", err)
+		return fmt.Errorf("PgStorage | RestoreFromDump: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dnsoftware/go-metrics/internal/constants"
+)
+
+// ErrBadSummary некорректный скетч или несовпадение точности при слиянии
+var ErrBadSummary = errors.New("bad summary")
+
+// Summary скетч для оценки квантилей (DDSketch).
+// Наблюдение v > 0 попадает в корзину с индексом ceil(log_gamma(v)), gamma = (1+Alpha)/(1-Alpha),
+// отрицательные - в такую же корзину по модулю в Negative, ноль - в Zero.
+// Оценка любого квантиля отличается от точного значения не более чем на Alpha (относительно).
+// Скетчи с одинаковой точностью сливаются сложением корзин.
+type Summary struct {
+	Alpha    float64        `json:"alpha"`              // относительная точность
+	Positive map[int]uint64 `json:"positive,omitempty"` // корзины положительных наблюдений
+	Negative map[int]uint64 `json:"negative,omitempty"` // корзины отрицательных наблюдений (по модулю)
+	Zero     uint64         `json:"zero,omitempty"`     // количество нулевых наблюдений
+	Sum      float64        `json:"sum"`                // сумма наблюдений
+	Count    uint64         `json:"count"`              // количество наблюдений
+	Min      float64        `json:"min"`                // минимальное наблюдение
+	Max      float64        `json:"max"`                // максимальное наблюдение
+}
+
+// SummaryStats значения метрики типа summary для ответа на запрос
+type SummaryStats struct {
+	Count     uint64             `json:"count"`
+	Sum       float64            `json:"sum"`
+	Min       float64            `json:"min"`
+	Max       float64            `json:"max"`
+	Quantiles map[string]float64 `json:"quantiles"` // ключ - квантиль, например "0.99"
+}
+
+// NewSummary пустой скетч с относительной точностью alpha (0 - точность по умолчанию)
+func NewSummary(alpha float64) Summary {
+	if alpha == 0 {
+		alpha = constants.SummaryAccuracy
+	}
+
+	return Summary{
+		Alpha:    alpha,
+		Positive: make(map[int]uint64),
+		Negative: make(map[int]uint64),
+	}
+}
+
+// ParseQuantiles разбор списка квантилей из строки вида "0.5,0.9,0.99"
+func ParseQuantiles(s string) ([]float64, error) {
+	var qs []float64
+
+	for _, q := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(q), 64)
+		if err != nil || v < 0 || v > 1 {
+			return nil, fmt.Errorf("%w: bad quantile %s", ErrBadSummary, q)
+		}
+
+		qs = append(qs, v)
+	}
+
+	return qs, nil
+}
+
+// Validate проверка корректности скетча
+func (s Summary) Validate() error {
+	if s.Alpha <= 0 || s.Alpha >= 1 {
+		return fmt.Errorf("%w: accuracy must be between 0 and 1", ErrBadSummary)
+	}
+
+	count := s.Zero
+	for _, c := range s.Positive {
+		count += c
+	}
+	for _, c := range s.Negative {
+		count += c
+	}
+
+	if count != s.Count {
+		return fmt.Errorf("%w: count %d differs from buckets total %d", ErrBadSummary, s.Count, count)
+	}
+
+	if s.Count > 0 && s.Min > s.Max {
+		return fmt.Errorf("%w: min greater than max", ErrBadSummary)
+	}
+
+	return nil
+}
+
+// Observe добавление одного наблюдения
+func (s *Summary) Observe(value float64) {
+	if s.Positive == nil {
+		s.Positive = make(map[int]uint64)
+	}
+	if s.Negative == nil {
+		s.Negative = make(map[int]uint64)
+	}
+
+	switch {
+	case value > 0:
+		s.Positive[s.index(value)]++
+	case value < 0:
+		s.Negative[s.index(-value)]++
+	default:
+		s.Zero++
+	}
+
+	if s.Count == 0 || value < s.Min {
+		s.Min = value
+	}
+	if s.Count == 0 || value > s.Max {
+		s.Max = value
+	}
+
+	s.Sum += value
+	s.Count++
+
+	s.collapse()
+}
+
+// Merge прибавление к скетчу другого скетча с такой же точностью
+func (s *Summary) Merge(other Summary) error {
+	if s.Alpha != other.Alpha {
+		return fmt.Errorf("%w: accuracy mismatch", ErrBadSummary)
+	}
+
+	if other.Count == 0 {
+		return nil
+	}
+
+	if s.Positive == nil {
+		s.Positive = make(map[int]uint64)
+	}
+	if s.Negative == nil {
+		s.Negative = make(map[int]uint64)
+	}
+
+	for i, c := range other.Positive {
+		s.Positive[i] += c
+	}
+	for i, c := range other.Negative {
+		s.Negative[i] += c
+	}
+	s.Zero += other.Zero
+
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+
+	s.Sum += other.Sum
+	s.Count += other.Count
+
+	s.collapse()
+
+	return nil
+}
+
+// Quantile оценка квантиля q (0 <= q <= 1). Для пустого скетча - 0
+func (s Summary) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+
+	if q <= 0 {
+		return s.Min
+	}
+	if q >= 1 {
+		return s.Max
+	}
+
+	rank := uint64(q * float64(s.Count-1))
+
+	var seen uint64
+
+	// отрицательные по возрастанию значения - индексы по модулю по убыванию
+	for _, i := range sortedKeys(s.Negative, true) {
+		seen += s.Negative[i]
+		if seen > rank {
+			return s.clamp(-s.value(i))
+		}
+	}
+
+	seen += s.Zero
+	if seen > rank {
+		return s.clamp(0)
+	}
+
+	for _, i := range sortedKeys(s.Positive, false) {
+		seen += s.Positive[i]
+		if seen > rank {
+			return s.clamp(s.value(i))
+		}
+	}
+
+	return s.Max
+}
+
+// Stats количество, сумма, минимум, максимум и оценки квантилей qs
+func (s Summary) Stats(qs []float64) SummaryStats {
+	stats := SummaryStats{
+		Count:     s.Count,
+		Sum:       s.Sum,
+		Min:       s.Min,
+		Max:       s.Max,
+		Quantiles: make(map[string]float64, len(qs)),
+	}
+
+	for _, q := range qs {
+		stats.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = s.Quantile(q)
+	}
+
+	return stats
+}
+
+// Clone копия скетча, не разделяющая с исходным карты корзин
+func (s Summary) Clone() Summary {
+	c := s
+	c.Positive = make(map[int]uint64, len(s.Positive))
+	c.Negative = make(map[int]uint64, len(s.Negative))
+
+	for i, v := range s.Positive {
+		c.Positive[i] = v
+	}
+	for i, v := range s.Negative {
+		c.Negative[i] = v
+	}
+
+	return c
+}
+
+// gamma основание логарифма для вычисления индекса корзины
+func (s Summary) gamma() float64 {
+	return (1 + s.Alpha) / (1 - s.Alpha)
+}
+
+// index индекс корзины для положительного значения
+func (s Summary) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / math.Log(s.gamma())))
+}
+
+// value оценка значения корзины с индексом i
+func (s Summary) value(i int) float64 {
+	g := s.gamma()
+
+	return 2 * math.Pow(g, float64(i)) / (g + 1)
+}
+
+// clamp ограничение оценки фактическими минимумом и максимумом
+func (s Summary) clamp(v float64) float64 {
+	return math.Max(s.Min, math.Min(s.Max, v))
+}
+
+// collapse ограничение количества корзин: самые малые по модулю корзины сливаются в одну.
+// Точность страдает только для значений, близких к нулю.
+func (s *Summary) collapse() {
+	for _, bins := range []map[int]uint64{s.Positive, s.Negative} {
+		if len(bins) <= constants.SummaryMaxBins {
+			continue
+		}
+
+		keys := sortedKeys(bins, false)
+		excess := keys[:len(keys)-constants.SummaryMaxBins+1]
+		target := excess[len(excess)-1]
+
+		for _, i := range excess[:len(excess)-1] {
+			bins[target] += bins[i]
+			delete(bins, i)
+		}
+	}
+}
+
+// sortedKeys индексы корзин по возрастанию (или по убыванию, если desc)
+func sortedKeys(bins map[int]uint64, desc bool) []int {
+	keys := make([]int, 0, len(bins))
+	for i := range bins {
+		keys = append(keys, i)
+	}
+
+	if desc {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+
+	return keys
+}
+
+// mergeSummary прибавление скетча метрики mt из пакета к s.
+// exists - false, если s еще нет. Если скетч не передан - значение value считается одиночным наблюдением
+func mergeSummary(s Summary, exists bool, mt Metrics) (Summary, error) {
+	delta := NewSummary(s.Alpha)
+
+	switch {
+	case mt.Summary != nil:
+		err := mt.Summary.Validate()
+		if err != nil {
+			return Summary{}, fmt.Errorf("summary %s: %w", mt.ID, err)
+		}
+		delta = *mt.Summary
+	case mt.Value != nil:
+		delta.Observe(*mt.Value)
+	default:
+		return Summary{}, fmt.Errorf("%w: summary %s: no data", ErrBadSummary, mt.ID)
+	}
+
+	if !exists {
+		return delta.Clone(), nil
+	}
+
+	err := s.Merge(delta)
+	if err != nil {
+		return Summary{}, fmt.Errorf("summary %s: %w", mt.ID, err)
+	}
+
+	return s, nil
+}
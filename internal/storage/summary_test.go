@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryQuantiles(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	s := NewSummary(0)
+	values := make([]float64, 0, 10000)
+
+	for i := 0; i < 10000; i++ {
+		v := rnd.ExpFloat64() * 100
+		values = append(values, v)
+		s.Observe(v)
+	}
+	sort.Float64s(values)
+
+	require.NoError(t, s.Validate())
+	assert.Equal(t, uint64(10000), s.Count)
+	assert.Equal(t, values[0], s.Min)
+	assert.Equal(t, values[len(values)-1], s.Max)
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		exact := values[int(q*float64(len(values)-1))]
+		assert.InEpsilon(t, exact, s.Quantile(q), s.Alpha*1.01, "quantile %v", q)
+	}
+}
+
+func TestSummaryMerge(t *testing.T) {
+	a := NewSummary(0)
+	b := NewSummary(0)
+	all := NewSummary(0)
+
+	for i := -50; i <= 100; i++ {
+		v := float64(i)
+		if i%2 == 0 {
+			a.Observe(v)
+		} else {
+			b.Observe(v)
+		}
+		all.Observe(v)
+	}
+
+	require.NoError(t, a.Merge(b))
+	assert.Equal(t, all.Count, a.Count)
+	assert.Equal(t, all.Sum, a.Sum)
+	assert.Equal(t, float64(-50), a.Min)
+	assert.Equal(t, float64(100), a.Max)
+	assert.Equal(t, all.Quantile(0.5), a.Quantile(0.5))
+	assert.InDelta(t, 25, a.Quantile(0.5), 0.5)
+	assert.InDelta(t, -35, a.Quantile(0.1), 0.5)
+
+	err := a.Merge(NewSummary(0.05))
+	assert.ErrorIs(t, err, ErrBadSummary)
+}
+
+func TestSummaryCollapse(t *testing.T) {
+	s := NewSummary(0)
+
+	// значения на много порядков - корзин больше, чем допустимо,
+	// сливаются корзины самых малых значений, большие квантили остаются точными
+	for i := 0; i < 5000; i++ {
+		s.Observe(math.Pow(1.05, float64(i)) * 1e-100)
+	}
+
+	assert.LessOrEqual(t, len(s.Positive), 2048)
+	assert.NoError(t, s.Validate())
+
+	exact := math.Pow(1.05, 4949) * 1e-100 // 0.99 * (5000 - 1)
+	assert.InEpsilon(t, exact, s.Quantile(0.99), s.Alpha*1.01)
+}
+
+func TestSummaryValidate(t *testing.T) {
+	assert.ErrorIs(t, Summary{}.Validate(), ErrBadSummary)
+	assert.ErrorIs(t, Summary{Alpha: 0.01, Count: 1}.Validate(), ErrBadSummary)
+	assert.NoError(t, NewSummary(0).Validate())
+
+	qs, err := ParseQuantiles("0.5, 0.99")
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0.5, 0.99}, qs)
+
+	_, err = ParseQuantiles("1.5")
+	assert.Error(t, err)
+
+	stats := NewSummary(0).Stats(qs)
+	assert.Equal(t, map[string]float64{"0.5": 0, "0.99": 0}, stats.Quantiles)
+}
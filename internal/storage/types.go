@@ -1,9 +1,58 @@
 package storage
 
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrBadValue некорректное значение метрики. NaN и бесконечность не сохраняются:
+// они не представимы в json дампе и ответах сервера
+var ErrBadValue = errors.New("bad value")
+
 // Metrics структура для получения json данных от агента
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string     `json:"id"`                  // имя метрики
+	MType     string     `json:"type"`                // параметр, принимающий значение gauge, counter, histogram или summary
+	Labels    Labels     `json:"labels,omitempty"`    // метки, вместе с именем определяют серию
+	Delta     *int64     `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64   `json:"value,omitempty"`     // значение метрики в случае передачи gauge (для summary - одиночное наблюдение)
+	Histogram *Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *Summary   `json:"summary,omitempty"`   // скетч в случае передачи summary
+}
+
+// Key ключ серии метрики (имя и метки)
+func (mt Metrics) Key() string {
+	return SeriesKey(mt.ID, mt.Labels)
+}
+
+// validateGauge проверка значения gauge
+func validateGauge(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%w: %v", ErrBadValue, value)
+	}
+
+	return nil
+}
+
+// validateSamples проверка значений истории gauge
+func validateSamples(series map[string][]Sample) error {
+	for _, samples := range series {
+		for _, sm := range samples {
+			err := validateGauge(sm.Value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SeriesInfo серия и время ее последнего обновления
+type SeriesInfo struct {
+	MType     string    // тип метрики
+	Name      string    // ключ серии
+	UpdatedAt time.Time // время последнего обновления
 }